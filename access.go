@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const accessListCmd string = "list"
+
+type iamBinding struct {
+	Role    string   `json:"role"`
+	Members []string `json:"members"`
+}
+
+type iamPolicy struct {
+	Bindings []iamBinding `json:"bindings"`
+}
+
+// cryptoRoles are the predefined roles that can encrypt or decrypt with a
+// key.
+var cryptoRoles = map[string]string{
+	"roles/cloudkms.cryptoKeyEncrypterDecrypter":              "encrypt, decrypt",
+	"roles/cloudkms.cryptoKeyEncrypter":                       "encrypt",
+	"roles/cloudkms.cryptoKeyDecrypter":                       "decrypt",
+	"roles/cloudkms.cryptoKeyEncrypterDecrypterViaDelegation": "encrypt, decrypt (via delegation)",
+	"roles/cloudkms.cryptoKeyEncrypterViaDelegation":          "encrypt (via delegation)",
+	"roles/cloudkms.cryptoKeyDecrypterViaDelegation":          "decrypt (via delegation)",
+}
+
+type keyAccess struct {
+	Member    string `json:"member"`
+	Access    string `json:"access"`
+	Role      string `json:"role"`
+	GrantedOn string `json:"grantedOn"`
+}
+
+// roleAccess describes what a role allows with a key, or "" when it allows
+// neither encrypting nor decrypting. Custom roles are listed as they may.
+func roleAccess(role string) string {
+	if access, ok := cryptoRoles[role]; ok {
+		return access
+	}
+	if strings.HasPrefix(role, "projects/") || strings.HasPrefix(role, "organizations/") {
+		return "unknown (custom role)"
+	}
+	return ""
+}
+
+func keyAccessList(keyName string) ([]keyAccess, error) {
+	client, err := kmsClient()
+	if err != nil {
+		return nil, err
+	}
+	keyPolicy, err := client.keyIAMPolicy(keyName)
+	if err != nil {
+		return nil, explainKmsError(keyName, err)
+	}
+	keyRingPolicy, err := client.keyRingIAMPolicy(keyName)
+	if err != nil {
+		return nil, explainKmsError(keyName, err)
+	}
+	access := []keyAccess{}
+	for _, policy := range []struct {
+		grantedOn string
+		policy    *iamPolicy
+	}{{"key", keyPolicy}, {"key ring", keyRingPolicy}} {
+		for _, binding := range policy.policy.Bindings {
+			description := roleAccess(binding.Role)
+			if description == "" {
+				continue
+			}
+			for _, member := range binding.Members {
+				access = append(access, keyAccess{member, description, binding.Role, policy.grantedOn})
+			}
+		}
+	}
+	sort.SliceStable(access, func(i, j int) bool { return access[i].Member < access[j].Member })
+	return access, nil
+}
+
+// accessList prints who can encrypt or decrypt with each key. Roles granted
+// on the project or organization also apply and aren't listed.
+func accessList(keyNames []string) error {
+	all := map[string][]keyAccess{}
+	for _, keyName := range keyNames {
+		access, err := keyAccessList(keyName)
+		if err != nil {
+			return err
+		}
+		all[keyName] = access
+	}
+	if ciMode {
+		data, err := json.Marshal(all)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for _, keyName := range keyNames {
+		fmt.Printf("Key %s:\n", keyName)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MEMBER\tACCESS\tROLE\tGRANTED ON")
+		for _, a := range all[keyName] {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Member, a.Access, a.Role, a.GrantedOn)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	errPrintln("Warning: roles granted on the project or organization (e.g. owner) also give access and are not listed")
+	return nil
+}
+
+// accessKeys are the keys of files, or the project key.
+func accessKeys(files []string) []string {
+	if len(files) == 0 {
+		return []string{key}
+	}
+	keyNames := []string{}
+	seen := map[string]struct{}{}
+	for _, file := range files {
+		keyName := fileKey(file)
+		if strings.HasSuffix(file, ".enc") {
+			if e := readEnvelope(file); e != nil && e.Key != "" {
+				keyName = e.Key
+			}
+		}
+		if _, ok := seen[keyName]; !ok {
+			seen[keyName] = ignore
+			keyNames = append(keyNames, keyName)
+		}
+	}
+	return keyNames
+}
+
+func access(subCmd string, files []string) error {
+	if subCmd != accessListCmd {
+		return fmt.Errorf("unknown access command %q, expecting %s", subCmd, accessListCmd)
+	}
+	return accessList(accessKeys(files))
+}
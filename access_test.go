@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// policyBackend is the fake backend with IAM policies on the key and its key
+// ring.
+type policyBackend struct {
+	fakeBackend
+	key, keyRing *iamPolicy
+}
+
+func (p *policyBackend) keyIAMPolicy(keyName string) (*iamPolicy, error) {
+	return p.key, nil
+}
+
+func (p *policyBackend) keyRingIAMPolicy(keyName string) (*iamPolicy, error) {
+	return p.keyRing, nil
+}
+
+func TestRoleAccess(t *testing.T) {
+	for _, test := range []struct {
+		role   string
+		access string
+	}{
+		{"roles/cloudkms.cryptoKeyEncrypterDecrypter", "encrypt, decrypt"},
+		{"roles/cloudkms.cryptoKeyDecrypter", "decrypt"},
+		{"roles/cloudkms.viewer", ""},
+		{"projects/p/roles/kmsUser", "unknown (custom role)"},
+		{"organizations/1/roles/kmsUser", "unknown (custom role)"},
+	} {
+		if access := roleAccess(test.role); access != test.access {
+			t.Errorf("%s: expecting %q, got %q", test.role, test.access, access)
+		}
+	}
+}
+
+func TestKeyAccessList(t *testing.T) {
+	useFakeBackend(t)
+	kmsSelected = &policyBackend{
+		key: &iamPolicy{Bindings: []iamBinding{
+			{Role: "roles/cloudkms.cryptoKeyDecrypter", Members: []string{"user:b@example.com", "user:a@example.com"}},
+			{Role: "roles/cloudkms.viewer", Members: []string{"user:c@example.com"}},
+		}},
+		keyRing: &iamPolicy{Bindings: []iamBinding{
+			{Role: "roles/cloudkms.cryptoKeyEncrypterDecrypter", Members: []string{"group:ops@example.com"}},
+		}},
+	}
+	access, err := keyAccessList(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []keyAccess{
+		{"group:ops@example.com", "encrypt, decrypt", "roles/cloudkms.cryptoKeyEncrypterDecrypter", "key ring"},
+		{"user:a@example.com", "decrypt", "roles/cloudkms.cryptoKeyDecrypter", "key"},
+		{"user:b@example.com", "decrypt", "roles/cloudkms.cryptoKeyDecrypter", "key"},
+	}
+	if !reflect.DeepEqual(access, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, access)
+	}
+}
+
+func TestAccessKeys(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{".": "key: root-key\n"})
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	keyNames := accessKeys([]string{plaintextFile + ".enc", plaintextFile, filepath.Join(root, "other.yaml")})
+	if expected := []string{testKey, "root-key"}; !reflect.DeepEqual(keyNames, expected) {
+		t.Errorf("expecting %q, got %q", expected, keyNames)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// An access policy in .secrets.yaml restricts who may open paths to the
+// members of Google groups, above whatever access to keys they have, e.g.
+//
+//	access-policy:
+//	  - path: prod/**
+//	    group: prod-admins@example.com
+//
+// Membership, direct or through nested groups, is checked with the Cloud
+// Identity API, once per group and run. As for dual control, the nearest
+// configuration with a matching rule applies and configuration errors are
+// not ignored.
+
+type accessRule struct {
+	Pattern string
+	Groups  []string
+}
+
+var membershipMutex sync.Mutex
+var memberships = map[string]bool{}
+
+func parseAccessRules(file string, node *yamlNode) ([]accessRule, error) {
+	if node.kind != yamlSequence {
+		return nil, fmt.Errorf("%s: access-policy must be a list", file)
+	}
+	rules := []accessRule{}
+	for i, item := range node.values {
+		rule := accessRule{Pattern: item.get("path").value(), Groups: item.get("group").strings()}
+		if groups := item.get("groups"); groups != nil {
+			rule.Groups = append(rule.Groups, groups.strings()...)
+		}
+		if rule.Pattern == "" || len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("%s: access-policy %d needs a path and a group", file, i+1)
+		}
+		for _, group := range rule.Groups {
+			if !strings.Contains(group, "@") {
+				return nil, fmt.Errorf("%s: access-policy %d: group %q should be the group's email address", file, i+1, group)
+			}
+		}
+		if _, err := path.Match(strings.ReplaceAll(rule.Pattern, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("%s: access-policy %d: invalid path %q: %w", file, i+1, rule.Pattern, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// accessRuleFor finds the access rule for file from the nearest
+// configuration with a matching rule.
+func (c *secretsConfig) accessRuleFor(file string) (accessRule, *secretsConfig, bool) {
+	for config := c; config != nil; config = config.parent {
+		relativePath, err := filepath.Rel(config.dir, file)
+		if err != nil {
+			continue
+		}
+		for _, rule := range config.AccessPolicy {
+			if matchGlob(rule.Pattern, filepath.ToSlash(relativePath)) {
+				return rule, config, true
+			}
+		}
+	}
+	return accessRule{}, nil, false
+}
+
+// checkAccessPolicy refuses to open file unless the caller is a member of
+// one of the groups its access policy allows, if it has one.
+func checkAccessPolicy(file string) error {
+	absolutePath, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	config, err := configFor(filepath.Dir(absolutePath))
+	if err != nil {
+		return err
+	}
+	rule, source, ok := config.accessRuleFor(strings.TrimSuffix(absolutePath, ".enc"))
+	if !ok {
+		return nil
+	}
+	caller := callerProvenance().By
+	if caller == "" {
+		return fmt.Errorf("access to %s is restricted to members of %s, but who is calling could not be found out", rule.Pattern, strings.Join(rule.Groups, ", "))
+	}
+	for _, group := range rule.Groups {
+		member, err := isGroupMember(group, caller)
+		if err != nil {
+			return fmt.Errorf("could not check that %s is a member of %s: %w", caller, group, err)
+		}
+		if member {
+			printDebugln("%s may open %s as a member of %s", caller, file, group)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a member of %s, which access to %s is restricted to by %s", caller, strings.Join(rule.Groups, " or "), rule.Pattern, displayPath(source.file))
+}
+
+// isGroupMember checks with Cloud Identity whether member is in group,
+// directly or through nested groups.
+func isGroupMember(group string, member string) (bool, error) {
+	membershipMutex.Lock()
+	defer membershipMutex.Unlock()
+	cacheKey := group + "\x00" + member
+	if isMember, ok := memberships[cacheKey]; ok {
+		return isMember, nil
+	}
+	token, _, err := googleCredentials()
+	if err != nil {
+		return false, err
+	}
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	endpoint := googleAPIEndpoint("cloudidentity", "v1")
+	var lookup struct {
+		Name string `json:"name"`
+	}
+	if err := jsonRequest("GET", endpoint+"groups:lookup?groupKey.id="+url.QueryEscape(group), headers, nil, &lookup); err != nil {
+		return false, err
+	}
+	var check struct {
+		HasMembership bool `json:"hasMembership"`
+	}
+	query := url.QueryEscape(fmt.Sprintf("member_key_id == '%s'", member))
+	if err := jsonRequest("GET", endpoint+lookup.Name+"/memberships:checkTransitiveMembership?query="+query, headers, nil, &check); err != nil {
+		return false, err
+	}
+	memberships[cacheKey] = check.HasMembership
+	return check.HasMembership, nil
+}
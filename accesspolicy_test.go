@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAccessRules(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{"access-policy: prod/**\n", "access-policy must be a list"},
+		{"access-policy:\n  - path: prod/**\n", "access-policy 1 needs a path and a group"},
+		{"access-policy:\n  - path: prod/**\n    group: prod-admins\n", `group "prod-admins" should be the group's email address`},
+		{"access-policy:\n  - path: '[prod'\n    group: prod-admins@example.com\n", "access-policy 1: invalid path"},
+	} {
+		if err := parseConfig(configFileName, []byte(test.config), &secretsConfig{}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expecting an error with %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestCheckAccessPolicy(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":    "access-policy:\n  - path: prod/**\n    group: prod-admins@example.com\n    groups: [sre@example.com]\n",
+		"prod": "access-policy:\n  - path: payments/**\n    group: payments@example.com\n",
+	})
+	provenanceOnce.Do(func() {})
+	currentProvenance = provenance{By: "dev@example.com"}
+	memberships = map[string]bool{
+		"prod-admins@example.com\x00dev@example.com": false,
+		"sre@example.com\x00dev@example.com":         true,
+		"payments@example.com\x00dev@example.com":    false,
+	}
+	defer func() { currentProvenance, memberships = provenance{}, map[string]bool{} }()
+	for _, test := range []struct {
+		file string
+		err  string
+	}{
+		{"staging/secret.yaml.enc", ""},
+		{"prod/secret.yaml.enc", ""},
+		{"prod/payments/secret.yaml.enc", "dev@example.com is not a member of payments@example.com, which access to payments/** is restricted to by prod/.secrets.yaml"},
+	} {
+		err := checkAccessPolicy(filepath.Join(root, test.file))
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("%s: expecting %q, got %v", test.file, test.err, err)
+		}
+	}
+	currentProvenance = provenance{}
+	if err := checkAccessPolicy(filepath.Join(root, "prod", "secret.yaml.enc")); err == nil || !strings.Contains(err.Error(), "who is calling could not be found out") {
+		t.Errorf("expecting an unknown caller refused, got %v", err)
+	}
+}
+
+func TestIsGroupMember(t *testing.T) {
+	useFakeBackend(t)
+	kmsSelected = &nativeBackend{token: &cachedToken{fetch: func() (string, time.Duration, error) {
+		return "ya29.test", time.Hour, nil
+	}}}
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case r.URL.Path == "/v1/groups:lookup" && r.URL.Query().Get("groupKey.id") == "sre@example.com":
+			w.Write([]byte(`{"name": "groups/abc"}`))
+		case r.URL.Path == "/v1/groups/abc/memberships:checkTransitiveMembership":
+			fmt.Fprintf(w, `{"hasMembership": %t}`, r.URL.Query().Get("query") == "member_key_id == 'dev@example.com'")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_CLOUDIDENTITY", server.URL)
+	defer func() { memberships = map[string]bool{} }()
+	for _, test := range []struct {
+		member string
+		ok     bool
+	}{
+		{"dev@example.com", true},
+		{"other@example.com", false},
+		{"dev@example.com", true},
+	} {
+		if member, err := isGroupMember("sre@example.com", test.member); err != nil || member != test.ok {
+			t.Errorf("%s: expecting member %v, got %v (%v)", test.member, test.ok, member, err)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("expecting membership checked once per member, got %d calls", calls)
+	}
+}
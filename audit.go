@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The audit section of the root .secrets.yaml sends an event for every file
+// sealed, opened or otherwise decrypted to one or more sinks: a JSON lines
+// file, Cloud Logging, a BigQuery table or a webhook, e.g.
+//
+//	audit:
+//	  batch-size: 100
+//	  sinks:
+//	    - type: cloud-logging
+//	      log: secrets-audit
+//	    - type: webhook
+//	      url: https://siem.example.com/events
+//	      headers:
+//	        Authorization: Bearer ${SIEM_TOKEN}
+//
+// Events are sent in batches, when a batch is full and when the run exits,
+// and retried with backoff. Events a sink still refuses are kept in the
+// user's cache folder and sent first by the next run.
+
+type auditEvent struct {
+	ID      string `json:"id"`
+	Time    string `json:"time"`
+	Command string `json:"command"`
+	File    string `json:"file"`
+	Repo    string `json:"repo,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	Actor   string `json:"actor,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+type auditSink struct {
+	Type    string
+	Path    string
+	Project string
+	Log     string
+	Table   string
+	URL     string
+	Headers map[string]string
+}
+
+type auditSettings struct {
+	BatchSize int
+	Sinks     []auditSink
+}
+
+const defaultAuditBatchSize int = 100
+const auditAttempts int = 3
+
+// commandName is the command being run, with its subcommand.
+var commandName string
+
+var auditMutex sync.Mutex
+var auditOnce sync.Once
+var auditConfig *auditSettings
+var auditEvents []auditEvent
+
+func parseAuditSettings(file string, node *yamlNode) (*auditSettings, error) {
+	if node.kind != yamlMapping {
+		return nil, fmt.Errorf("%s: audit must be a mapping", file)
+	}
+	settings := &auditSettings{BatchSize: defaultAuditBatchSize}
+	if size := node.get("batch-size"); size != nil {
+		if _, err := fmt.Sscanf(size.value(), "%d", &settings.BatchSize); err != nil || settings.BatchSize < 1 {
+			return nil, fmt.Errorf("%s: audit batch-size must be a positive number", file)
+		}
+	}
+	sinks := node.get("sinks")
+	if sinks == nil || sinks.kind != yamlSequence {
+		return nil, fmt.Errorf("%s: audit needs a list of sinks", file)
+	}
+	for i, item := range sinks.values {
+		sink := auditSink{
+			Type:    strings.TrimSpace(item.get("type").value()),
+			Path:    strings.TrimSpace(item.get("path").value()),
+			Project: strings.TrimSpace(item.get("project").value()),
+			Log:     strings.TrimSpace(item.get("log").value()),
+			Table:   strings.TrimSpace(item.get("table").value()),
+			URL:     strings.TrimSpace(item.get("url").value()),
+			Headers: map[string]string{},
+		}
+		if headers := item.get("headers"); headers != nil && headers.kind == yamlMapping {
+			for j, name := range headers.keys {
+				sink.Headers[yamlUnquote(name)] = headers.values[j].value()
+			}
+		}
+		var missing string
+		switch sink.Type {
+		case "file":
+			if sink.Path == "" {
+				missing = "path"
+			}
+		case "cloud-logging":
+			if sink.Log == "" {
+				sink.Log = "secrets-audit"
+			}
+		case "bigquery":
+			if strings.Count(sink.Table, ".") < 1 {
+				missing = "table as dataset.table or project.dataset.table"
+			}
+		case "webhook":
+			if sink.URL == "" {
+				missing = "url"
+			}
+		default:
+			return nil, fmt.Errorf("%s: audit sink %d: unknown type %q, expecting file, cloud-logging, bigquery or webhook", file, i+1, sink.Type)
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("%s: audit sink %d needs a %s", file, i+1, missing)
+		}
+		settings.Sinks = append(settings.Sinks, sink)
+	}
+	return settings, nil
+}
+
+// loadAuditSettings is the audit section of the root config, or nil.
+func loadAuditSettings() *auditSettings {
+	auditOnce.Do(func() {
+		if projectRoot == "" {
+			return
+		}
+		config, err := configFor(projectRoot)
+		if err != nil {
+			return
+		}
+		auditConfig = config.Audit
+	})
+	return auditConfig
+}
+
+// recordAudit records that command accessed file, successfully unless err
+// is set.
+func recordAudit(command string, file string, err error) {
+	settings := loadAuditSettings()
+	if settings == nil || dryRun {
+		return
+	}
+	p := callerProvenance()
+	event := auditEvent{
+		ID:      randomID(16),
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Command: command,
+		File:    displayPath(file),
+		Repo:    getKeyName(projectRoot),
+		Key:     fileKey(file),
+		Outcome: "ok",
+		Actor:   p.By,
+		Host:    p.Host,
+		Commit:  p.Commit,
+	}
+	if err != nil {
+		event.Outcome, event.Error = "error", err.Error()
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditEvents = append(auditEvents, event)
+	if len(auditEvents) >= settings.BatchSize {
+		sendAuditEvents(settings)
+	}
+}
+
+// flushAudit sends the events left when the run exits.
+func flushAudit() {
+	settings := loadAuditSettings()
+	if settings == nil {
+		return
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	sendAuditEvents(settings)
+}
+
+// auditSpoolPath is where the events a sink refused are kept.
+func auditSpoolPath(sink auditSink) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(strings.Join([]string{sink.Type, sink.Path, sink.Project, sink.Log, sink.Table, sink.URL}, "\x00")))
+	return filepath.Join(dir, "secrets", "audit-spool-"+hex.EncodeToString(digest[:6])+".jsonl"), nil
+}
+
+// sendAuditEvents sends the pending events to every sink, after the events
+// that sink refused before, called with auditMutex held.
+func sendAuditEvents(settings *auditSettings) {
+	events := auditEvents
+	auditEvents = nil
+	for _, sink := range settings.Sinks {
+		spool, err := auditSpoolPath(sink)
+		if err != nil {
+			errPrintln("Warning: could not send audit events to %s: %s", sink.Type, err)
+			continue
+		}
+		pending, err := readAuditEvents(spool)
+		if err != nil {
+			errPrintln("Warning: could not read unsent audit events: %s", err)
+		}
+		pending = append(pending, events...)
+		if len(pending) == 0 {
+			continue
+		}
+		failed := []auditEvent{}
+		for start := 0; start < len(pending); start += settings.BatchSize {
+			end := start + settings.BatchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			batch := pending[start:end]
+			if err := sendAuditBatch(sink, batch); err != nil {
+				errPrintln("Warning: could not send %d audit event(s) to %s, keeping them for the next run: %s", len(batch), sink.Type, err)
+				failed = append(failed, batch...)
+			}
+		}
+		if err := writeAuditEvents(spool, failed); err != nil {
+			errPrintln("Warning: could not keep unsent audit events: %s", err)
+		}
+	}
+}
+
+func readAuditEvents(file string) ([]auditEvent, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	events := []auditEvent{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		event := auditEvent{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return events, fmt.Errorf("%s: %w", file, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func writeAuditEvents(file string, events []auditEvent) error {
+	if len(events) == 0 {
+		err := os.Remove(file)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(file, []byte(b.String()), 0600)
+}
+
+// sendAuditBatch delivers a batch, retrying failures that may be transient.
+func sendAuditBatch(sink auditSink, events []auditEvent) error {
+	var err error
+	for attempt := 0; attempt < auditAttempts; attempt++ {
+		if attempt > 0 {
+			delay := time.Duration(1<<attempt) * 500 * time.Millisecond
+			printDebugln("retrying audit events to %s in %s: %s", sink.Type, delay, err)
+			time.Sleep(delay)
+		}
+		err = deliverAudit(sink, events)
+		var httpErr *httpError
+		if err == nil || (errors.As(err, &httpErr) && httpErr.StatusCode < 500 && httpErr.StatusCode != 429) {
+			return err
+		}
+	}
+	return err
+}
+
+func deliverAudit(sink auditSink, events []auditEvent) error {
+	switch sink.Type {
+	case "file":
+		return appendAuditFile(sink.Path, events)
+	case "webhook":
+		headers := map[string]string{}
+		for name, value := range sink.Headers {
+			headers[name] = os.ExpandEnv(value)
+		}
+		return jsonRequest("POST", sink.URL, headers, map[string]interface{}{"events": events}, nil)
+	}
+	token, project, err := googleCredentials()
+	if err != nil {
+		return err
+	}
+	if sink.Project != "" {
+		project = sink.Project
+	}
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	if sink.Type == "cloud-logging" {
+		entries := make([]map[string]interface{}, 0, len(events))
+		for _, event := range events {
+			severity := "NOTICE"
+			if event.Outcome != "ok" {
+				severity = "WARNING"
+			}
+			entries = append(entries, map[string]interface{}{
+				"insertId":    event.ID,
+				"timestamp":   event.Time,
+				"severity":    severity,
+				"jsonPayload": event,
+			})
+		}
+		body := map[string]interface{}{
+			"logName":  fmt.Sprintf("projects/%s/logs/%s", project, url.PathEscape(sink.Log)),
+			"resource": map[string]string{"type": "global"},
+			"entries":  entries,
+		}
+		return jsonRequest("POST", googleAPIEndpoint("logging", "v2")+"entries:write", headers, body, nil)
+	}
+	parts := strings.Split(sink.Table, ".")
+	if len(parts) == 3 {
+		project, parts = parts[0], parts[1:]
+	}
+	rows := make([]map[string]interface{}, 0, len(events))
+	for _, event := range events {
+		rows = append(rows, map[string]interface{}{"insertId": event.ID, "json": event})
+	}
+	var response struct {
+		InsertErrors []struct {
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	insertURL := fmt.Sprintf("%sprojects/%s/datasets/%s/tables/%s/insertAll", googleAPIEndpoint("bigquery", "bigquery/v2"), project, parts[0], parts[1])
+	if err := jsonRequest("POST", insertURL, headers, map[string]interface{}{"rows": rows}, &response); err != nil {
+		return err
+	}
+	if len(response.InsertErrors) > 0 && len(response.InsertErrors[0].Errors) > 0 {
+		return &httpError{StatusCode: 400, Status: "rows rejected", Body: response.InsertErrors[0].Errors[0].Message}
+	}
+	return nil
+}
+
+// appendAuditFile appends events as JSON lines, to a path relative to the
+// project root unless it's absolute.
+func appendAuditFile(path string, events []auditEvent) error {
+	path = expandHome(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectRoot, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseAuditSettings(t *testing.T) {
+	settings := &secretsConfig{}
+	if err := parseConfig(configFileName, []byte("audit:\n  sinks:\n    - type: cloud-logging\n    - type: webhook\n      url: https://siem.example.com/events\n      headers:\n        Authorization: Bearer ${SIEM_TOKEN}\n"), settings); err != nil {
+		t.Fatal(err)
+	}
+	expected := &auditSettings{BatchSize: defaultAuditBatchSize, Sinks: []auditSink{
+		{Type: "cloud-logging", Log: "secrets-audit", Headers: map[string]string{}},
+		{Type: "webhook", URL: "https://siem.example.com/events", Headers: map[string]string{"Authorization": "Bearer ${SIEM_TOKEN}"}},
+	}}
+	if !reflect.DeepEqual(settings.Audit, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, settings.Audit)
+	}
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{"audit: yes\n", "audit must be a mapping"},
+		{"audit:\n  batch-size: 0\n  sinks:\n    - type: file\n      path: audit.jsonl\n", "batch-size must be a positive number"},
+		{"audit:\n  batch-size: 10\n", "audit needs a list of sinks"},
+		{"audit:\n  sinks:\n    - type: syslog\n", `audit sink 1: unknown type "syslog"`},
+		{"audit:\n  sinks:\n    - type: file\n", "audit sink 1 needs a path"},
+		{"audit:\n  sinks:\n    - type: webhook\n      url: https://siem.example.com\n    - type: bigquery\n      table: events\n", "audit sink 2 needs a table"},
+	} {
+		if err := parseConfig(configFileName, []byte(test.config), &secretsConfig{}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expecting an error with %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+// useAudit sends audit events to sinks in batches of batchSize.
+func useAudit(t *testing.T, batchSize int, sinks ...auditSink) {
+	t.Helper()
+	auditOnce.Do(func() {})
+	provenanceOnce.Do(func() {})
+	auditConfig, auditEvents = &auditSettings{BatchSize: batchSize, Sinks: sinks}, nil
+	currentProvenance = provenance{"dev@example.com", "laptop", "def"}
+	t.Cleanup(func() {
+		auditConfig, auditEvents, currentProvenance = nil, nil, provenance{}
+	})
+}
+
+func TestAuditFileSink(t *testing.T) {
+	root := useFakeBackend(t)
+	useAudit(t, 2, auditSink{Type: "file", Path: "logs/audit.jsonl"})
+	file := filepath.Join(root, "logs", "audit.jsonl")
+	recordAudit("open", filepath.Join(root, "a-secret.yaml.enc"), nil)
+	if events, _ := readAuditEvents(file); len(events) != 0 {
+		t.Errorf("expecting events held until the batch is full, got %d", len(events))
+	}
+	recordAudit("open", filepath.Join(root, "b-secret.yaml.enc"), ErrNotEncFile)
+	recordAudit("seal", filepath.Join(root, "c-secret.yaml"), nil)
+	if events, _ := readAuditEvents(file); len(events) != 2 {
+		t.Errorf("expecting a full batch sent, got %d events", len(events))
+	}
+	flushAudit()
+	events, err := readAuditEvents(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expecting 3 events, got %d", len(events))
+	}
+	event := events[1]
+	if event.Command != "open" || event.File != "b-secret.yaml.enc" || event.Outcome != "error" || event.Error != ErrNotEncFile.Error() || event.Actor != "dev@example.com" || event.Host != "laptop" || event.Commit != "def" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestAuditWebhookKeepsRefusedEvents(t *testing.T) {
+	root := useFakeBackend(t)
+	t.Setenv("SIEM_TOKEN", "s3cret")
+	var mutex sync.Mutex
+	refuse := true
+	received := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if refuse || r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "refused", http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			Events []auditEvent `json:"events"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, event := range body.Events {
+			received = append(received, event.File)
+		}
+	}))
+	defer server.Close()
+	sink := auditSink{Type: "webhook", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer ${SIEM_TOKEN}"}}
+	useAudit(t, 10, sink)
+	recordAudit("open", filepath.Join(root, "a-secret.yaml.enc"), nil)
+	warnings := captureStderr(t, flushAudit)
+	if !strings.Contains(warnings, "keeping them for the next run") {
+		t.Errorf("expecting the refused events reported, got %q", warnings)
+	}
+	spool, err := auditSpoolPath(sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events, _ := readAuditEvents(spool); len(events) != 1 {
+		t.Errorf("expecting the refused event kept, got %d", len(events))
+	}
+	mutex.Lock()
+	refuse = false
+	mutex.Unlock()
+	recordAudit("open", filepath.Join(root, "b-secret.yaml.enc"), nil)
+	flushAudit()
+	if expected := []string{"a-secret.yaml.enc", "b-secret.yaml.enc"}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("expecting %q sent, got %q", expected, received)
+	}
+	if fileExists(spool) {
+		t.Error("expecting nothing kept once sent")
+	}
+}
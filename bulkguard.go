@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Sealing without files seals whatever discovery finds. When that is more
+// files, or bigger ones, than a project of secrets usually has, a pattern
+// probably matched fixtures or data by mistake, so the files are listed and
+// sealing them has to be confirmed.
+
+const (
+	maxDiscoveredFiles    int   = 50
+	maxDiscoveredFileSize int64 = 1 << 20
+)
+
+// confirmDiscoveredFiles asks before sealing unexpectedly many or large
+// discovered files.
+func confirmDiscoveredFiles(files []string) error {
+	large := []string{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxDiscoveredFileSize {
+			large = append(large, fmt.Sprintf("%s (%d KiB)", displayPath(file), info.Size()>>10))
+		}
+	}
+	if len(files) <= maxDiscoveredFiles && len(large) == 0 {
+		return nil
+	}
+	if len(files) > maxDiscoveredFiles {
+		errPrintln("Warning: found %d files to seal, more than the %d expected:", len(files), maxDiscoveredFiles)
+		for _, file := range files {
+			errPrintln("  %s", displayPath(file))
+		}
+	}
+	if len(large) > 0 {
+		errPrintln("Warning: found %d file(s) to seal larger than %d KiB:", len(large), maxDiscoveredFileSize>>10)
+		for _, file := range large {
+			errPrintln("  %s", file)
+		}
+	}
+	if !confirm(fmt.Sprintf("Seal all %d files?", len(files))) {
+		return fmt.Errorf("not sealing %d discovered files: name the files to seal, exclude the others in .secrets.yaml, or pass --yes", len(files))
+	}
+	return nil
+}
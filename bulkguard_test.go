@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfirmDiscoveredFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	many := []string{}
+	for i := 0; i <= maxDiscoveredFiles; i++ {
+		file := filepath.Join(root, fmt.Sprintf("app-%02d-secret.yaml", i))
+		writeTestFile(t, file, []byte("token: abc\n"), 0600)
+		many = append(many, file)
+	}
+	large := filepath.Join(root, "dump-secret.yaml")
+	writeTestFile(t, large, nil, 0600)
+	if err := os.Truncate(large, maxDiscoveredFileSize+1); err != nil {
+		t.Fatal(err)
+	}
+	ciMode = true
+	defer func() { ciMode, assumeYes = false, false }()
+	for _, test := range []struct {
+		name      string
+		files     []string
+		assumeYes bool
+		warning   string
+		err       string
+	}{
+		{"few small files", many[:3], false, "", ""},
+		{"many files", many, false, "found 51 files to seal, more than the 50 expected", "not sealing 51 discovered files"},
+		{"large file", []string{many[0], large}, false, "found 1 file(s) to seal larger than 1024 KiB:\n  dump-secret.yaml (1024 KiB)", "not sealing 2 discovered files"},
+		{"confirmed", many, true, "found 51 files to seal", ""},
+	} {
+		assumeYes = test.assumeYes
+		var err error
+		output := captureStderr(t, func() {
+			err = confirmDiscoveredFiles(test.files)
+		})
+		if !strings.Contains(output, test.warning) || (test.warning == "" && output != "") {
+			t.Errorf("%s: expecting a warning with %q, got %q", test.name, test.warning, output)
+		}
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expecting an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
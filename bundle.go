@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A bundle seals several files together into one .sbundle, for handing the
+// secrets of a whole environment over as one artifact. Its plaintext is a
+// manifest of the files, with their paths relative to the project root,
+// modes and SHA-256, and their content. It is an envelope like a .enc, so
+// it is sealed with the key, second key and signing key its path gets from
+// .secrets.yaml.
+
+const bundleVersion int = 1
+
+// bundleSuffix ends the names of bundles, which import takes instead of a
+// store.
+const bundleSuffix string = ".sbundle"
+
+// exportBundle is the bundle export --bundle writes, to move sealed files to
+// another repository.
+var exportBundle string
+
+type bundleManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"createdAt"`
+	Files     []bundleFile `json:"files"`
+}
+
+type bundleFile struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	SHA256  string      `json:"sha256"`
+	Content []byte      `json:"content"`
+}
+
+// openSealedFile decrypts a .enc, or puts a split one together, once its
+// access policy and the policy allow opening it.
+func openSealedFile(ciphertextFile string) ([]byte, *envelope, error) {
+	if err := checkAccessPolicy(ciphertextFile); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	if err := checkPolicy("open", ciphertextFile); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	plaintext, e, err := readSealedFile(fileKey(ciphertextFile), ciphertextFile)
+	recordAudit(commandName, ciphertextFile, err)
+	return plaintext, e, err
+}
+
+// readBundleFile reads a file to bundle: a plaintext, or the .enc given or
+// standing for a plaintext that isn't there, decrypted in memory.
+func readBundleFile(file string) (bundleFile, error) {
+	plaintextFile := strings.TrimSuffix(file, ".enc")
+	path := projectPath(plaintextFile)
+	if projectRoot == "" || path == "" || strings.HasPrefix(path, "..") {
+		return bundleFile{}, fmt.Errorf("%s is outside the project", file)
+	}
+	if file == plaintextFile && fileExists(file) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return bundleFile{}, err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return bundleFile{}, err
+		}
+		return bundleFile{path, info.Mode().Perm(), sha256Hex(content), content}, nil
+	}
+	ciphertextFile := plaintextFile + ".enc"
+	if !fileExists(ciphertextFile) && !isFragmented(ciphertextFile) {
+		return bundleFile{}, fmt.Errorf("%s: no such file, nor a .enc of it", file)
+	}
+	content, e, err := openSealedFile(ciphertextFile)
+	if err != nil {
+		return bundleFile{}, err
+	}
+	mode := os.FileMode(0600)
+	if e != nil && e.Mode != 0 {
+		mode = plaintextMode(ciphertextFile, e)
+	}
+	return bundleFile{path, mode, sha256Hex(content), content}, nil
+}
+
+// sealBundle seals files, or else the .enc files discovery finds, into the
+// bundle bundlePath.
+func sealBundle(bundlePath string, files []string) error {
+	if bundlePath == "" {
+		return errors.New("expecting the bundle to write with --out")
+	}
+	if len(files) == 0 {
+		found, err := findEncryptedFiles(projectRoot)
+		if err != nil {
+			return err
+		}
+		files = foldFragments(found)
+	}
+	if len(files) == 0 {
+		return errors.New("no files to bundle")
+	}
+	manifest := bundleManifest{Version: bundleVersion, CreatedAt: time.Now().UTC(), Files: []bundleFile{}}
+	seen := map[string]bool{}
+	for _, file := range files {
+		f, err := readBundleFile(file)
+		if err != nil {
+			return err
+		}
+		if seen[f.Path] {
+			continue
+		}
+		seen[f.Path] = true
+		printProgress("bundling %s", f.Path)
+		manifest.Files = append(manifest.Files, f)
+	}
+	if dryRun {
+		return nil
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeBundle(bundlePath, data); err != nil {
+		return err
+	}
+	printProgress("sealed %d file(s) into %s", len(manifest.Files), bundlePath)
+	return nil
+}
+
+func writeBundle(bundlePath string, plaintext []byte) error {
+	headers := plaintextHeaders{Mode: 0600, ModifiedAt: time.Now()}
+	if path := projectPath(bundlePath); !strings.HasPrefix(path, "..") {
+		headers.Path = path
+	}
+	e, err := sealBytes(bundlePath, fileKey(bundlePath), plaintext, headers, nil)
+	if err != nil {
+		return err
+	}
+	if err := signFor(bundlePath, e); err != nil {
+		return err
+	}
+	return writeFileAtomically(bundlePath, e.marshal(), 0600)
+}
+
+// readBundle opens a bundle and checks the files in it against their
+// hashes.
+func readBundle(bundlePath string) (*bundleManifest, error) {
+	plaintext, _, err := openSealedFile(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &bundleManifest{}
+	if err := json.Unmarshal(plaintext, manifest); err != nil {
+		return nil, fmt.Errorf("%s: not a bundle: %w", bundlePath, err)
+	}
+	if manifest.Version != bundleVersion {
+		return nil, fmt.Errorf("%s: unsupported bundle version %d, expecting %d", bundlePath, manifest.Version, bundleVersion)
+	}
+	for _, f := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			return nil, fmt.Errorf("%s: refusing path %q outside the destination", bundlePath, f.Path)
+		}
+		if sha256Hex(f.Content) != f.SHA256 {
+			return nil, fmt.Errorf("%s: %s doesn't match its SHA-256 in the manifest", bundlePath, f.Path)
+		}
+	}
+	return manifest, nil
+}
+
+// unbundle writes the files of a bundle under --out-dir, or else the project
+// root, and keeps those in the project out of git.
+func unbundle(files []string) error {
+	if len(files) != 1 {
+		return fmt.Errorf("expecting the bundle to restore, got %d files", len(files))
+	}
+	manifest, err := readBundle(files[0])
+	if err != nil {
+		return err
+	}
+	root := outDir
+	if root == "" {
+		root = projectRoot
+	}
+	if root == "" {
+		if root, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	changed := []string{}
+	for _, f := range manifest.Files {
+		target := filepath.Join(root, filepath.FromSlash(f.Path))
+		if current, err := os.ReadFile(target); err == nil && sha256Hex(current) != f.SHA256 {
+			changed = append(changed, target)
+		}
+	}
+	if dryRun {
+		for _, f := range manifest.Files {
+			printProgress("would write %s (%d bytes)", filepath.Join(root, filepath.FromSlash(f.Path)), len(f.Content))
+		}
+		return nil
+	}
+	if len(changed) > 0 {
+		printPruneList(root, "Files the bundle would overwrite with other content:", changed)
+		if !confirm(fmt.Sprintf("Overwrite %d file(s)?", len(changed))) {
+			return errors.New("unbundle cancelled")
+		}
+	}
+	for _, f := range manifest.Files {
+		target := filepath.Join(root, filepath.FromSlash(f.Path))
+		printProgress("writing %s", target)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomically(target, f.Content, f.Mode); err != nil {
+			return err
+		}
+		if path := projectPath(target); projectRoot == "" || strings.HasPrefix(path, "..") {
+			continue
+		}
+		if err := addGitIgnore(projectRoot, target); err != nil && !errors.Is(err, ErrPlaintextTracked) {
+			return err
+		}
+	}
+	printProgress("restored %d file(s) from %s", len(manifest.Files), files[0])
+	return nil
+}
+
+// importBundle seals the files of a bundle, exported from another
+// repository, at their paths under the project root, or --out-dir, with the
+// keys those paths get here. No plaintext is written.
+func importBundle(bundlePath string, files []string) error {
+	if len(files) > 0 {
+		return fmt.Errorf("import of a bundle takes no files, got %d", len(files))
+	}
+	root := outDir
+	if root == "" {
+		root = projectRoot
+	}
+	if root == "" {
+		return errors.New("import of a bundle needs a project root to seal files into, use --root or --out-dir")
+	}
+	bundlePath, err := filepath.Abs(bundlePath)
+	if err != nil {
+		return err
+	}
+	manifest, err := readBundle(bundlePath)
+	if err != nil {
+		return err
+	}
+	changed := []string{}
+	for _, f := range manifest.Files {
+		plaintextFile := filepath.Join(root, filepath.FromSlash(f.Path))
+		if err := checkPolicy("seal", plaintextFile); err != nil {
+			return fmt.Errorf("%s: %w", plaintextFile, err)
+		}
+		ciphertextFile := plaintextFile + ".enc"
+		if isFragmented(ciphertextFile) && !fragmentsMatch(ciphertextFile, f.Content) {
+			changed = append(changed, ciphertextFile)
+		} else if previous := readEnvelope(ciphertextFile); previous != nil && !sealedFileHolds(ciphertextFile, f.Content) {
+			changed = append(changed, ciphertextFile)
+		}
+	}
+	if dryRun {
+		for _, f := range manifest.Files {
+			printProgress("would seal %s", filepath.Join(root, filepath.FromSlash(f.Path))+".enc")
+		}
+		return nil
+	}
+	if len(changed) > 0 {
+		printPruneList(root, "Sealed files the bundle would replace with other content:", changed)
+		if !confirm(fmt.Sprintf("Replace %d file(s)?", len(changed))) {
+			return errors.New("import cancelled")
+		}
+	}
+	for _, f := range manifest.Files {
+		plaintextFile := filepath.Join(root, filepath.FromSlash(f.Path))
+		err := importBundleFile(plaintextFile, f)
+		recordAudit(commandName, plaintextFile+".enc", err)
+		if err != nil {
+			return fmt.Errorf("%s: %w", plaintextFile, err)
+		}
+	}
+	printProgress("imported %d file(s) from %s", len(manifest.Files), bundlePath)
+	return nil
+}
+
+// importBundleFile seals a file of a bundle into the .enc of plaintextFile,
+// or its fragments when it is split, unless it already holds the same
+// content under the same key.
+func importBundleFile(plaintextFile string, f bundleFile) error {
+	ciphertextFile := plaintextFile + ".enc"
+	keyName := fileKey(ciphertextFile)
+	headers := plaintextHeaders{Path: projectPath(plaintextFile), Mode: f.Mode, ModifiedAt: time.Now()}
+	if isSplitFile(plaintextFile) {
+		if err := sealFragments(keyName, plaintextFile, ciphertextFile, f.Content, headers); err != nil {
+			return err
+		}
+	} else if err := importWholeFile(keyName, ciphertextFile, f.Content, headers); err != nil {
+		return err
+	}
+	if path := projectPath(plaintextFile); projectRoot == "" || strings.HasPrefix(path, "..") {
+		return nil
+	}
+	if err := addGitIgnore(projectRoot, plaintextFile); err != nil && !errors.Is(err, ErrPlaintextTracked) {
+		return err
+	}
+	return nil
+}
+
+func importWholeFile(keyName string, ciphertextFile string, plaintext []byte, headers plaintextHeaders) error {
+	previous := readEnvelope(ciphertextFile)
+	if !force && previous != nil && previous.PlaintextHash == "" && isSameKey(previous.Key, keyName) && sealedFileHolds(ciphertextFile, plaintext) {
+		printProgress("%s is already up to date", ciphertextFile)
+		return nil
+	}
+	printProgress("sealing %s", ciphertextFile)
+	if err := os.MkdirAll(filepath.Dir(ciphertextFile), 0755); err != nil {
+		return err
+	}
+	e, err := sealBytes(strings.TrimSuffix(ciphertextFile, ".enc"), keyName, plaintext, headers, previous)
+	if err != nil {
+		return err
+	}
+	if err := writeEnvelope(ciphertextFile, e); err != nil {
+		return err
+	}
+	return removeFragments(ciphertextFile)
+}
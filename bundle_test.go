@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportBundleFileSealsSplitFilesAsFragments(t *testing.T) {
+	root := useFakeBackend(t)
+	writeTestFile(t, filepath.Join(root, ".secrets.yaml"), []byte("split:\n  - secret.yaml\n"), 0644)
+	content := []byte("database: hunter2\napi_token: abc\n")
+	f := bundleFile{"secret.yaml", 0600, sha256Hex(content), content}
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	if err := importBundleFile(plaintextFile, f); err != nil {
+		t.Fatal(err)
+	}
+	ciphertextFile := plaintextFile + ".enc"
+	if fileExists(ciphertextFile) {
+		t.Error("imported the split file as a whole .enc")
+	}
+	if !fragmentsMatch(ciphertextFile, content) {
+		t.Fatal("the fragments don't hold the imported file")
+	}
+	fragment := filepath.Join(fragmentsDir(ciphertextFile), "database.enc")
+	before, err := os.ReadFile(fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, plaintextFile, content, 0600)
+	if err := encrypt(fileKey(plaintextFile), plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(fragment); string(after) != string(before) {
+		t.Error("sealing the imported file again rewrote its fragments")
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	root := useFakeBackend(t)
+	writeTestFile(t, filepath.Join(root, "app-secret.yaml"), []byte("token: abc\n"), 0640)
+	if err := os.MkdirAll(filepath.Join(root, "db"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sealedFile := filepath.Join(root, "db", "secret.yaml")
+	writeTestFile(t, sealedFile, []byte("password: hunter2\n"), 0600)
+	if err := encrypt(testKey, sealedFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(sealedFile); err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := filepath.Join(root, "prod"+bundleSuffix)
+	captureStdout(t, func() {
+		if err := sealBundle(bundlePath, []string{filepath.Join(root, "app-secret.yaml"), sealedFile + ".enc", sealedFile}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("expecting the bundle to be sealed")
+	}
+	manifest, err := readBundle(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 2 || manifest.Files[0].Path != "app-secret.yaml" || manifest.Files[1].Path != "db/secret.yaml" {
+		t.Fatalf("expecting both files bundled once, got %+v", manifest.Files)
+	}
+	outDir = t.TempDir()
+	defer func() { outDir = "" }()
+	captureStdout(t, func() {
+		if err := unbundle([]string{bundlePath}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, test := range []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{"app-secret.yaml", "token: abc\n", 0640},
+		{"db/secret.yaml", "password: hunter2\n", 0600},
+	} {
+		file := filepath.Join(outDir, filepath.FromSlash(test.path))
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.content || info.Mode().Perm() != test.mode {
+			t.Errorf("%s: expecting %q with mode %04o, got %q with %04o", test.path, test.content, test.mode, data, info.Mode().Perm())
+		}
+	}
+}
+
+func TestReadBundleRejects(t *testing.T) {
+	root := useFakeBackend(t)
+	content := []byte("token: abc\n")
+	for _, test := range []struct {
+		manifest bundleManifest
+		err      string
+	}{
+		{bundleManifest{Version: 2}, "unsupported bundle version 2, expecting 1"},
+		{bundleManifest{Version: bundleVersion, Files: []bundleFile{{"../secret.yaml", 0600, sha256Hex(content), content}}}, `refusing path "../secret.yaml" outside the destination`},
+		{bundleManifest{Version: bundleVersion, Files: []bundleFile{{"secret.yaml", 0600, sha256Hex([]byte("other")), content}}}, "secret.yaml doesn't match its SHA-256 in the manifest"},
+	} {
+		test.manifest.CreatedAt = time.Now()
+		data, err := json.Marshal(test.manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundlePath := filepath.Join(root, "test"+bundleSuffix)
+		if err := writeBundle(bundlePath, data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readBundle(bundlePath); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("expecting an error with %q, got %v", test.err, err)
+		}
+	}
+	if err := sealBundle("", nil); err == nil || err.Error() != "expecting the bundle to write with --out" {
+		t.Errorf("expecting --out required, got %v", err)
+	}
+}
+
+func TestMoveFilesBetweenRepositories(t *testing.T) {
+	source := useFakeBackend(t)
+	plaintextFile := filepath.Join(source, "config", "secret.yaml")
+	if err := os.MkdirAll(filepath.Dir(plaintextFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, plaintextFile, []byte("password: hunter2\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportSecrets("", nil); err == nil || err.Error() != "expecting the store to export to, or --bundle" {
+		t.Errorf("expecting a store or --bundle required, got %v", err)
+	}
+	exportBundle = filepath.Join(t.TempDir(), "move"+bundleSuffix)
+	defer func() { exportBundle = "" }()
+	captureStdout(t, func() {
+		if err := exportSecrets(plaintextFile+".enc", nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	destination := useFakeBackend(t)
+	writeConfigs(t, destination, map[string]string{".": "key: " + testKey + "\n"})
+	captureStdout(t, func() {
+		if err := importSecrets(exportBundle, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	imported := filepath.Join(destination, "config", "secret.yaml")
+	if fileExists(imported) {
+		t.Error("expecting no plaintext written by import")
+	}
+	if !sealedFileHolds(imported+".enc", []byte("password: hunter2\n")) {
+		t.Error("expecting the .enc to hold the exported file")
+	}
+
+	writeTestFile(t, imported, []byte("password: changed\n"), 0600)
+	if err := encrypt(testKey, imported); err != nil {
+		t.Fatal(err)
+	}
+	ciMode = true
+	defer func() { ciMode = false }()
+	var err error
+	captureStderr(t, func() {
+		captureStdout(t, func() {
+			err = importSecrets(exportBundle, nil)
+		})
+	})
+	if err == nil || err.Error() != "import cancelled" {
+		t.Errorf("expecting replacing other content to need confirming, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In CI mode stdout is reserved for the JSON summary printed at the end of
+// a run, so progress goes to stderr.
+
+type fileResult struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+type runSummary struct {
+	Command     string       `json:"command"`
+	DryRun      bool         `json:"dryRun"`
+	Interrupted bool         `json:"interrupted,omitempty"`
+	Succeeded   []string     `json:"succeeded"`
+	Failed      []fileResult `json:"failed"`
+	Unfinished  []string     `json:"unfinished,omitempty"`
+}
+
+func printProgress(format string, a ...interface{}) {
+	if ciMode || toStdout {
+		errPrintln(format, a...)
+		return
+	}
+	fmt.Printf(format+"\n", a...)
+}
+
+func colorEnabled() bool {
+	if ciMode || colorMode == colorNever {
+		return false
+	}
+	if colorMode == colorAlways {
+		return true
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(message string) string {
+	if !colorEnabled() {
+		return message
+	}
+	for prefix, color := range map[string]string{"Error:": "31", "Warning:": "33"} {
+		if strings.HasPrefix(message, prefix) {
+			return "\033[" + color + "m" + prefix + "\033[0m" + strings.TrimPrefix(message, prefix)
+		}
+	}
+	return message
+}
+
+func printSummary(summary *runSummary) {
+	if !ciMode {
+		return
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		errPrintln("Error: %s", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// forEachFile runs fn over files using up to --jobs workers. After the
+// first failure no new files are started unless --keep-going was given.
+// The command's pre hooks run first and its post hooks after, with the files
+// that succeeded. An interrupt stops it, listing the files it didn't finish.
+func forEachFile(command string, verb string, files []string, fn func(string) error) error {
+	if err := runHooks("pre", command, files); err != nil {
+		return err
+	}
+	summary := &runSummary{
+		Command:   command,
+		DryRun:    dryRun,
+		Succeeded: []string{},
+		Failed:    []fileResult{},
+	}
+	stopHandlingInterrupts := handleInterrupts()
+	defer stopHandlingInterrupts()
+	workers := jobs
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, len(files))
+	done := make([]bool, len(files))
+	var mutex sync.Mutex
+	failed := false
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				mutex.Lock()
+				skip := failed && !keepGoing
+				mutex.Unlock()
+				if skip || isInterrupted() {
+					continue
+				}
+				printProgress("%s %s", verb, files[i])
+				start := time.Now()
+				err := fn(files[i])
+				printDebugln("%s %s took %s", verb, files[i], formatDuration(time.Since(start)))
+				recordAudit(command, files[i], err)
+				mutex.Lock()
+				errs[i], done[i] = err, true
+				if err != nil {
+					failed = true
+					if keepGoing {
+						errPrintln("Error: %s", err)
+					}
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i := range files {
+		mutex.Lock()
+		stop := (failed && !keepGoing) || isInterrupted()
+		mutex.Unlock()
+		if stop {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var firstErr error
+	interrupted := isInterrupted()
+	summary.Interrupted = interrupted
+	for i, file := range files {
+		if interrupted && (!done[i] || errs[i] != nil) {
+			summary.Unfinished = append(summary.Unfinished, file)
+			continue
+		}
+		if !done[i] {
+			continue
+		}
+		if errs[i] == nil {
+			summary.Succeeded = append(summary.Succeeded, file)
+			continue
+		}
+		summary.Failed = append(summary.Failed, fileResult{file, errs[i].Error()})
+		if firstErr == nil {
+			firstErr = errs[i]
+		}
+	}
+	printSummary(summary)
+	printTimings()
+	if interrupted {
+		printInterrupted(verb, summary)
+		return errInterrupted
+	}
+	var hookErr error
+	if len(summary.Succeeded) > 0 {
+		hookErr = runHooks("post", command, summary.Succeeded)
+	}
+	if !keepGoing && firstErr != nil {
+		return firstErr
+	}
+	if len(summary.Failed) > 0 {
+		return fmt.Errorf("%d of %d files failed", len(summary.Failed), len(files))
+	}
+	return hookErr
+}
+
+// printInterrupted lists the files an interrupted run finished and those it
+// didn't.
+func printInterrupted(verb string, summary *runSummary) {
+	errPrintln("Interrupted %s after %d of %d files", verb, len(summary.Succeeded)+len(summary.Failed), len(summary.Succeeded)+len(summary.Failed)+len(summary.Unfinished))
+	for _, file := range summary.Succeeded {
+		errPrintln("  done:     %s", file)
+	}
+	for _, failed := range summary.Failed {
+		errPrintln("  failed:   %s", failed.File)
+	}
+	for _, file := range summary.Unfinished {
+		errPrintln("  not done: %s", file)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestForEachFileStopsAtFirstFailure(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		keepGoing bool
+		processed []string
+		err       string
+	}{
+		{"stop", false, []string{"a", "b"}, "b failed"},
+		{"keep going", true, []string{"a", "b", "c"}, "1 of 3 files failed"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeBackend(t)
+			keepGoing, jobs = test.keepGoing, 1
+			defer func() { keepGoing, jobs = false, 1 }()
+			processed := []string{}
+			err := forEachFile(encryptCmd, "testing", []string{"a", "b", "c"}, func(file string) error {
+				processed = append(processed, file)
+				if file == "b" {
+					return errors.New("b failed")
+				}
+				return nil
+			})
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expecting %q, got %v", test.err, err)
+			}
+			if !reflect.DeepEqual(processed, test.processed) {
+				t.Errorf("expecting %v processed, got %v", test.processed, processed)
+			}
+		})
+	}
+}
+
+func TestCiModeDisablesColor(t *testing.T) {
+	colorMode, ciMode = colorAlways, true
+	defer func() { colorMode, ciMode = colorAuto, false }()
+	if message := colorize("Error: failed"); message != "Error: failed" {
+		t.Errorf("expecting no color in CI mode, got %q", message)
+	}
+	ciMode = false
+	if message := colorize("Error: failed"); message != "\033[31mError:\033[0m failed" {
+		t.Errorf("expecting a red Error:, got %q", message)
+	}
+}
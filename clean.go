@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// isSealed reports whether plaintextFile holds exactly what its .enc holds.
+// Files whose .enc wasn't sealed or opened on this machine can't be checked
+// without decrypting them and count as changed.
+func isSealed(plaintextFile string) bool {
+	plaintext, err := os.ReadFile(plaintextFile)
+	if err != nil {
+		return false
+	}
+	if isFragmented(plaintextFile + ".enc") {
+		return fragmentsMatch(plaintextFile+".enc", plaintext)
+	}
+	return sealedFileHolds(plaintextFile+".enc", plaintext)
+}
+
+// findOpenedFiles returns the plaintext counterparts of the .enc files under
+// projectRoot, split into those that match their .enc and those changed
+// since they were sealed.
+func findOpenedFiles(projectRoot string) ([]string, []string, error) {
+	files, err := findEncryptedFiles(projectRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	files = foldFragments(files)
+	sealed := make([]string, 0)
+	changed := make([]string, 0)
+	for _, file := range files {
+		plaintextFile := strings.TrimSuffix(file, ".enc")
+		if !fileExists(plaintextFile) {
+			continue
+		}
+		if isSealed(plaintextFile) {
+			sealed = append(sealed, plaintextFile)
+		} else {
+			changed = append(changed, plaintextFile)
+		}
+	}
+	return sealed, changed, nil
+}
+
+// clean removes the decrypted plaintext in the project, keeping files with
+// changes that were never sealed.
+func clean(projectRoot string) error {
+	sealed, changed, err := findOpenedFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+
+	printPruneList(projectRoot, "Plaintext files to remove:", sealed)
+	printPruneList(projectRoot, "Plaintext files changed since they were sealed (seal them, or delete them yourself):", changed)
+
+	if len(sealed) == 0 {
+		fmt.Println("Nothing to clean")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	if !confirm(fmt.Sprintf("Delete %d plaintext file(s)?", len(sealed))) {
+		return nil
+	}
+	for _, file := range sealed {
+		printDebugln("removing %s", file)
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		dryRun  bool
+		removed []string
+	}{
+		{"dry run", true, []string{}},
+		{"clean", false, []string{"sealed-secret.yaml"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			for _, file := range []string{"sealed-secret.yaml", "changed-secret.yaml", "closed-secret.yaml"} {
+				plaintextFile := filepath.Join(root, file)
+				writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+				if err := encrypt(fileKey(plaintextFile), plaintextFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeTestFile(t, filepath.Join(root, "changed-secret.yaml"), []byte("token: def\n"), 0600)
+			sealedContents = nil
+			if err := os.Remove(filepath.Join(root, "closed-secret.yaml")); err != nil {
+				t.Fatal(err)
+			}
+			assumeYes, dryRun = true, test.dryRun
+			defer func() { assumeYes, dryRun = false, false }()
+			if err := clean(root); err != nil {
+				t.Fatal(err)
+			}
+			removed := []string{}
+			for _, file := range []string{"sealed-secret.yaml", "changed-secret.yaml"} {
+				if !fileExists(filepath.Join(root, file)) {
+					removed = append(removed, file)
+				}
+				if !fileExists(filepath.Join(root, file+".enc")) {
+					t.Errorf("clean removed %s.enc", file)
+				}
+			}
+			if !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("expecting %q removed, got %q", test.removed, removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cloudStore keeps values as the environment variables of a Cloud Run
+// service or of a Cloud Function, named like the variables of env-file.
+// Changing them deploys a new revision, which is waited for, so that running
+// instances pick up rotated values with one command. Variables the files
+// don't have, including those taken from Secret Manager, are left alone.
+// Calls are made with the credentials KMS calls are made with.
+type cloudStore struct {
+	api        string
+	collection string
+	token      string
+	project    string
+}
+
+var syncService string
+var syncRegion string
+
+type cloudOperation struct {
+	Name  string `json:"name"`
+	Done  bool   `json:"done"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// googleAPIEndpoint is the URL of version of a Google API, honoring the
+// endpoint overrides gcloud uses.
+func googleAPIEndpoint(api string, version string) string {
+	if override := os.Getenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_" + strings.ToUpper(api)); override != "" {
+		return strings.TrimSuffix(override, "/") + "/" + version + "/"
+	}
+	return "https://" + api + ".googleapis.com/" + version + "/"
+}
+
+func (c *cloudStore) endpoint() string {
+	return googleAPIEndpoint(c.api, "v2")
+}
+
+// googleCredentials are the access token and project of the credentials
+// KMS calls are made with.
+func googleCredentials() (string, string, error) {
+	client, err := kmsClient()
+	if err != nil {
+		return "", "", err
+	}
+	switch backend := client.(type) {
+	case *gcloudBackend:
+		token, _, err := backend.accessToken()
+		if err != nil {
+			return "", "", err
+		}
+		project, err := backend.run(nil, "config", "get-value", "project")
+		return token, strings.TrimSpace(project), err
+	case *nativeBackend:
+		token, err := backend.token.token()
+		return token, backend.project, err
+	}
+	return "", "", errors.New("calling Google Cloud APIs needs the gcloud or native KMS transport")
+}
+
+// resourceName is the full name of a service or function given by name with
+// --region, or by full name like keys.
+func (c *cloudStore) resourceName(name string) (string, error) {
+	if strings.HasPrefix(name, "projects/") {
+		return name, nil
+	}
+	if syncRegion == "" {
+		return "", fmt.Errorf("--region is needed for %s, or pass its full resource name", name)
+	}
+	if c.project == "" {
+		return "", fmt.Errorf("no project for %s, run `gcloud config set project <project>` or pass its full resource name", name)
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/%s/%s", c.project, syncRegion, c.collection, name), nil
+}
+
+func (c *cloudStore) request(method string, path string, body interface{}, result interface{}) error {
+	return jsonRequest(method, c.endpoint()+path, map[string]string{"Authorization": "Bearer " + c.token}, body, result)
+}
+
+// get is the service or function named name, with its full resource name.
+func (c *cloudStore) get(name string) (map[string]interface{}, string, error) {
+	var err error
+	c.token, c.project, err = googleCredentials()
+	if err != nil {
+		return nil, "", err
+	}
+	resource, err := c.resourceName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	result := map[string]interface{}{}
+	if err := c.request("GET", resource, nil, &result); err != nil {
+		return nil, "", err
+	}
+	return result, resource, nil
+}
+
+// containerEnv is the env list of the first container of a service.
+func containerEnv(service map[string]interface{}) (map[string]interface{}, []interface{}, error) {
+	template, _ := service["template"].(map[string]interface{})
+	containers, _ := template["containers"].([]interface{})
+	if len(containers) == 0 {
+		return nil, nil, errors.New("the service has no container")
+	}
+	container, _ := containers[0].(map[string]interface{})
+	env, _ := container["env"].([]interface{})
+	return container, env, nil
+}
+
+// functionEnv is the environment variables of a function.
+func functionEnv(function map[string]interface{}) map[string]interface{} {
+	config, _ := function["serviceConfig"].(map[string]interface{})
+	env, _ := config["environmentVariables"].(map[string]interface{})
+	if env == nil {
+		env = map[string]interface{}{}
+	}
+	return env
+}
+
+func (c *cloudStore) read(name string) ([]secretField, error) {
+	resource, _, err := c.get(name)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	if c.collection == "services" {
+		_, env, err := containerEnv(resource)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range env {
+			variable, _ := item.(map[string]interface{})
+			variableName, _ := variable["name"].(string)
+			if value, ok := variable["value"].(string); ok {
+				values[variableName] = value
+			} else {
+				printDebugln("skipping %s, not a plain value", variableName)
+			}
+		}
+	} else {
+		for variableName, value := range functionEnv(resource) {
+			values[variableName], _ = value.(string)
+		}
+	}
+	names := make([]string, 0, len(values))
+	for variableName := range values {
+		names = append(names, variableName)
+	}
+	sort.Strings(names)
+	fields := []secretField{}
+	for _, variableName := range names {
+		fields = append(fields, secretField{[]string{variableName}, values[variableName]})
+	}
+	return fields, nil
+}
+
+func (c *cloudStore) write(name string, fields []secretField) error {
+	resource, resourceName, err := c.get(name)
+	if err != nil {
+		return err
+	}
+	operation := &cloudOperation{}
+	if c.collection == "services" {
+		container, env, err := containerEnv(resource)
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			env = setEnv(env, envName(field.Path), field.Value)
+		}
+		container["env"] = env
+		// A new revision gets a new name, the old one is taken.
+		if template, ok := resource["template"].(map[string]interface{}); ok {
+			delete(template, "revision")
+		}
+		err = c.request("PATCH", resourceName, resource, operation)
+		if err != nil {
+			return err
+		}
+	} else {
+		env := functionEnv(resource)
+		for _, field := range fields {
+			env[envName(field.Path)] = field.Value
+		}
+		body := map[string]interface{}{"serviceConfig": map[string]interface{}{"environmentVariables": env}}
+		err = c.request("PATCH", resourceName+"?updateMask=serviceConfig.environmentVariables", body, operation)
+		if err != nil {
+			return err
+		}
+	}
+	printProgress("Deploying %s...", resourceName)
+	return c.wait(operation)
+}
+
+// setEnv sets a variable of a Cloud Run env list, replacing a reference to
+// Secret Manager of the same name.
+func setEnv(env []interface{}, name string, value string) []interface{} {
+	for i, item := range env {
+		if variable, _ := item.(map[string]interface{}); variable["name"] == name {
+			env[i] = map[string]interface{}{"name": name, "value": value}
+			return env
+		}
+	}
+	return append(env, map[string]interface{}{"name": name, "value": value})
+}
+
+// wait polls a long-running operation until the new revision serves or
+// failed to.
+func (c *cloudStore) wait(operation *cloudOperation) error {
+	deadline := time.Now().Add(15 * time.Minute)
+	for !operation.Done {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is still running, gave up waiting", operation.Name)
+		}
+		time.Sleep(2 * time.Second)
+		if err := c.request("GET", operation.Name, nil, operation); err != nil {
+			return err
+		}
+	}
+	if operation.Error != nil {
+		return fmt.Errorf("deploying failed: %s", operation.Error.Message)
+	}
+	return nil
+}
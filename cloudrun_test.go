@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoogleAPIEndpoint(t *testing.T) {
+	if endpoint := googleAPIEndpoint("run", "v2"); endpoint != "https://run.googleapis.com/v2/" {
+		t.Errorf("unexpected endpoint %s", endpoint)
+	}
+	t.Setenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_RUN", "http://localhost:8080/")
+	if endpoint := googleAPIEndpoint("run", "v2"); endpoint != "http://localhost:8080/v2/" {
+		t.Errorf("expecting the override, got %s", endpoint)
+	}
+}
+
+func TestCloudResourceName(t *testing.T) {
+	defer func() { syncRegion = "" }()
+	for _, test := range []struct {
+		project string
+		region  string
+		name    string
+		full    string
+	}{
+		{"app", "europe-west1", "api", "projects/app/locations/europe-west1/services/api"},
+		{"", "", "projects/other/locations/us-central1/services/api", "projects/other/locations/us-central1/services/api"},
+		{"app", "", "api", ""},
+		{"", "europe-west1", "api", ""},
+	} {
+		syncRegion = test.region
+		c := &cloudStore{api: "run", collection: "services", project: test.project}
+		full, err := c.resourceName(test.name)
+		if full != test.full || (err == nil) != (test.full != "") {
+			t.Errorf("%s in %q with --region %q: expecting %q, got %q (%v)", test.name, test.project, test.region, test.full, full, err)
+		}
+	}
+}
+
+func TestSetEnv(t *testing.T) {
+	env := []interface{}{
+		map[string]interface{}{"name": "API_KEY", "valueSource": map[string]interface{}{"secretKeyRef": "api-key"}},
+		map[string]interface{}{"name": "PORT", "value": "8080"},
+	}
+	env = setEnv(env, "API_KEY", "abc")
+	env = setEnv(env, "DATABASE_PASSWORD", "hunter2")
+	expected := []interface{}{
+		map[string]interface{}{"name": "API_KEY", "value": "abc"},
+		map[string]interface{}{"name": "PORT", "value": "8080"},
+		map[string]interface{}{"name": "DATABASE_PASSWORD", "value": "hunter2"},
+	}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expecting %v, got %v", expected, env)
+	}
+}
+
+// useCloudServer serves resource as the one service or function of the
+// endpoint of api, done deploying at once, and returns the PATCH requests.
+func useCloudServer(t *testing.T, api string, resource string) *[]string {
+	t.Helper()
+	useFakeBackend(t)
+	kmsSelected = &nativeBackend{project: "app", token: &cachedToken{fetch: func() (string, time.Duration, error) {
+		return "ya29.test", time.Hour, nil
+	}}}
+	patches := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ya29.test" {
+			http.Error(w, "{}", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "PATCH" {
+			data, _ := io.ReadAll(r.Body)
+			patches = append(patches, r.URL.RequestURI()+" "+string(data))
+			w.Write([]byte(`{"name":"operations/1","done":true}`))
+			return
+		}
+		w.Write([]byte(resource))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_"+strings.ToUpper(api), server.URL)
+	return &patches
+}
+
+func TestCloudRunStore(t *testing.T) {
+	patches := useCloudServer(t, "run", `{"template":{"revision":"api-00001","containers":[{"image":"api","env":[{"name":"PORT","value":"8080"},{"name":"API_KEY","valueSource":{}}]}]}}`)
+	syncRegion = "europe-west1"
+	defer func() { syncRegion = "" }()
+	c := &cloudStore{api: "run", collection: "services"}
+	read, err := c.read("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []secretField{{[]string{"PORT"}, "8080"}}; !reflect.DeepEqual(read, expected) {
+		t.Errorf("expecting %q, got %q", expected, read)
+	}
+	if err := c.write("api", []secretField{{[]string{"api-key"}, "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*patches) != 1 {
+		t.Fatalf("expecting one PATCH, got %q", *patches)
+	}
+	uri, body, _ := strings.Cut((*patches)[0], " ")
+	if uri != "/v2/projects/app/locations/europe-west1/services/api" {
+		t.Errorf("unexpected PATCH of %s", uri)
+	}
+	expected := `{"template":{"containers":[{"env":[{"name":"PORT","value":"8080"},{"name":"API_KEY","value":"abc"}],"image":"api"}]}}`
+	if body != expected {
+		t.Errorf("expecting %s, got %s", expected, body)
+	}
+}
+
+func TestCloudFunctionsStore(t *testing.T) {
+	patches := useCloudServer(t, "cloudfunctions", `{"serviceConfig":{"environmentVariables":{"PORT":"8080"}}}`)
+	c := &cloudStore{api: "cloudfunctions", collection: "functions"}
+	name := "projects/app/locations/europe-west1/functions/hook"
+	if err := c.write(name, []secretField{{[]string{"api-key"}, "abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*patches) != 1 {
+		t.Fatalf("expecting one PATCH, got %q", *patches)
+	}
+	uri, body, _ := strings.Cut((*patches)[0], " ")
+	if uri != "/v2/"+name+"?updateMask=serviceConfig.environmentVariables" {
+		t.Errorf("unexpected PATCH of %s", uri)
+	}
+	patch := map[string]map[string]map[string]string{}
+	if err := json.Unmarshal([]byte(body), &patch); err != nil {
+		t.Fatal(err)
+	}
+	if env := patch["serviceConfig"]["environmentVariables"]; !reflect.DeepEqual(env, map[string]string{"PORT": "8080", "API_KEY": "abc"}) {
+		t.Errorf("unexpected environment variables %v", env)
+	}
+}
+
+func TestCloudStoreWaitReportsFailures(t *testing.T) {
+	operation := &cloudOperation{Name: "operations/1", Done: true}
+	operation.Error = &struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{9, "revision api-00002 is not ready"}
+	if err := (&cloudStore{}).wait(operation); err == nil || !strings.Contains(err.Error(), "revision api-00002 is not ready") {
+		t.Errorf("expecting the failed deploy reported, got %v", err)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const configFileName string = ".secrets.yaml"
+
+// keyRule maps files matching a glob, relative to the folder of the
+// .secrets.yaml declaring it, to a key.
+type keyRule struct {
+	Pattern string
+	Key     string
+}
+
+// secretsConfig is the .secrets.yaml of a folder, if it has one, linked to
+// the configuration of the folder above it up to the project root.
+type secretsConfig struct {
+	dir   string
+	file  string
+	Key   string
+	Rules []keyRule
+	// DualControl maps paths to a second key needed to open them.
+	DualControl []keyRule
+	// AccessPolicy restricts opening paths to members of Google groups.
+	AccessPolicy []accessRule
+	// SigningKey is an asymmetric key that .enc files are signed with.
+	SigningKey string
+	// KeyProject is the project of keys given by name, when it isn't the
+	// caller's, such as a project owned by the security team.
+	KeyProject string
+	// Excludes are globs of paths that discovery skips.
+	Excludes []string
+	// Split are globs of YAML files sealed as one .enc per top-level key.
+	Split []string
+	// ignores are the lines of the folder's .secretsignore.
+	ignores []ignorePattern
+	// KeyCreation is how keys are created, read from the root config only.
+	KeyCreation keyCreationSettings
+	// Location and SecondaryLocation are where keys are, read from the root
+	// config only.
+	Location          string
+	SecondaryLocation string
+	// Audit is where access events are sent, read from the root config only.
+	Audit *auditSettings
+	// Policy is the Rego policy operations are checked against, read from
+	// the root config only.
+	Policy *policySettings
+	// Hooks are commands run around operations, read from the root config
+	// only.
+	Hooks map[string][]string
+	// KeyResolver is a command naming the project's key, read from the root
+	// config only.
+	KeyResolver string
+	// KeyFallback is how the project's key is named without a remote to
+	// name it after, and FallbackKey the key name for config-value, read
+	// from the root config only.
+	KeyFallback string
+	FallbackKey string
+	// Remote is the git remote the project is named after, read from the
+	// root config only.
+	Remote string
+	// GitHosts are the hosts of repositories projects are named after, such
+	// as a GitHub Enterprise server, read from the root config only.
+	GitHosts []string
+	// Jobs and ImpersonateServiceAccount override the user config for the
+	// project, read from the root config only.
+	Jobs                      int
+	ImpersonateServiceAccount string
+	parent                    *secretsConfig
+}
+
+var configMutex sync.Mutex
+var configs = map[string]*secretsConfig{}
+
+// keyExplicit is set when --key was given, which overrides any config.
+var keyExplicit bool
+
+func parseKeyRules(file string, setting string, node *yamlNode) ([]keyRule, error) {
+	if node.kind != yamlSequence {
+		return nil, fmt.Errorf("%s: %s must be a list", file, setting)
+	}
+	rules := []keyRule{}
+	for i, item := range node.values {
+		rule := keyRule{Pattern: item.get("path").value(), Key: item.get("key").value()}
+		if rule.Pattern == "" || rule.Key == "" {
+			return nil, fmt.Errorf("%s: %s %d needs a path and a key", file, setting, i+1)
+		}
+		keyName, err := normalizeKeyName(rule.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s %d: %w", file, setting, i+1, err)
+		}
+		rule.Key = keyName
+		if _, err := path.Match(strings.ReplaceAll(rule.Pattern, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("%s: %s %d: invalid path %q: %w", file, setting, i+1, rule.Pattern, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func readConfigFile(file string, config *secretsConfig) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return parseConfig(file, data, config)
+}
+
+// parseConfig reads the settings of a .secrets.yaml from its content.
+func parseConfig(file string, data []byte, config *secretsConfig) error {
+	document, err := parseYAML(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	config.file = file
+	if document == nil {
+		return nil
+	}
+	if document.kind != yamlMapping {
+		return fmt.Errorf("%s: expecting a mapping of settings", file)
+	}
+	if k := document.get("key"); k != nil {
+		config.Key, err = normalizeKeyName(k.value())
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	if rules := document.get("rules"); rules != nil {
+		config.Rules, err = parseKeyRules(file, "rules", rules)
+		if err != nil {
+			return err
+		}
+	}
+	if patterns := document.get("exclude"); patterns != nil {
+		config.Excludes, err = parseExcludes(file, patterns)
+		if err != nil {
+			return err
+		}
+	}
+	if patterns := document.get("split"); patterns != nil {
+		config.Split, err = parseExcludes(file, patterns)
+		if err != nil {
+			return err
+		}
+	}
+	if hosts := document.get("git-hosts"); hosts != nil {
+		config.GitHosts = hosts.strings()
+	}
+	if j := document.get("jobs"); j != nil {
+		value := strings.TrimSpace(j.value())
+		if err := checkOption("jobs", value); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		config.Jobs, _ = strconv.Atoi(value)
+	}
+	if a := document.get("impersonate-service-account"); a != nil {
+		config.ImpersonateServiceAccount = strings.TrimSpace(a.value())
+	}
+	if r := document.get("remote"); r != nil {
+		config.Remote = strings.TrimSpace(r.value())
+	}
+	if r := document.get("key-resolver"); r != nil {
+		config.KeyResolver = strings.TrimSpace(r.value())
+	}
+	if f := document.get("key-fallback"); f != nil {
+		config.KeyFallback = strings.TrimSpace(f.value())
+		if !isKeyFallback(config.KeyFallback) {
+			return fmt.Errorf("%s: unknown key-fallback %q, expecting %s", file, config.KeyFallback, strings.Join(keyFallbacks, ", "))
+		}
+	}
+	if k := document.get("fallback-key"); k != nil {
+		config.FallbackKey, err = normalizeKeyName(k.value())
+		if err != nil {
+			return fmt.Errorf("%s: fallback-key: %w", file, err)
+		}
+	}
+	if config.KeyFallback == keyFallbackConfigValue && config.FallbackKey == "" {
+		return fmt.Errorf("%s: key-fallback %s needs a fallback-key", file, keyFallbackConfigValue)
+	}
+	if k := document.get("signing-key"); k != nil {
+		config.SigningKey = strings.TrimSpace(k.value())
+	}
+	if p := document.get("key-project"); p != nil {
+		config.KeyProject = strings.TrimSpace(p.value())
+	}
+	if l := document.get("location"); l != nil {
+		config.Location = strings.TrimSpace(l.value())
+	}
+	if l := document.get("secondary-location"); l != nil {
+		config.SecondaryLocation = strings.TrimSpace(l.value())
+	}
+	if settings := document.get("key-creation"); settings != nil {
+		config.KeyCreation, err = parseKeyCreationSettings(file, settings)
+		if err != nil {
+			return err
+		}
+	}
+	if settings := document.get("audit"); settings != nil {
+		config.Audit, err = parseAuditSettings(file, settings)
+		if err != nil {
+			return err
+		}
+	}
+	if settings := document.get("policy"); settings != nil {
+		config.Policy, err = parsePolicySettings(file, settings)
+		if err != nil {
+			return err
+		}
+	}
+	if hooks := document.get("hooks"); hooks != nil {
+		config.Hooks, err = parseHooks(file, hooks)
+		if err != nil {
+			return err
+		}
+	}
+	if rules := document.get("dual-control"); rules != nil {
+		config.DualControl, err = parseKeyRules(file, "dual-control", rules)
+		if err != nil {
+			return err
+		}
+	}
+	if rules := document.get("access-policy"); rules != nil {
+		config.AccessPolicy, err = parseAccessRules(file, rules)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFor returns the configuration that applies to files in dir.
+func configFor(dir string) (*secretsConfig, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	configMutex.Lock()
+	config, ok := configs[dir]
+	configMutex.Unlock()
+	if ok {
+		return config, nil
+	}
+
+	config = &secretsConfig{dir: dir}
+	parent := filepath.Dir(dir)
+	root, _ := filepath.Abs(projectRoot)
+	if dir != root && parent != dir && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		config.parent, err = configFor(parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file := filepath.Join(dir, configFileName)
+	if fileExists(file) {
+		if err := readConfigFile(file, config); err != nil {
+			return nil, err
+		}
+	}
+	if ignoreFile := filepath.Join(dir, secretsIgnoreFileName); fileExists(ignoreFile) {
+		config.ignores, err = parseSecretsIgnore(ignoreFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	configMutex.Lock()
+	configs[dir] = config
+	configMutex.Unlock()
+	return config, nil
+}
+
+// matchGlob matches a slash separated path against a pattern where ** stands
+// for any number of folders and the rest is as in path.Match.
+func matchGlob(pattern string, name string) bool {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobParts(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func (c *secretsConfig) matchRule(rules []keyRule, file string) (keyRule, bool) {
+	relativePath, err := filepath.Rel(c.dir, file)
+	if err != nil {
+		return keyRule{}, false
+	}
+	for _, rule := range rules {
+		if matchGlob(rule.Pattern, filepath.ToSlash(relativePath)) {
+			return rule, true
+		}
+	}
+	return keyRule{}, false
+}
+
+// keyFor finds the key for file from the nearest configuration that has a
+// matching rule or a key, and describes where it came from.
+func (c *secretsConfig) keyFor(file string) (string, string) {
+	for config := c; config != nil; config = config.parent {
+		if rule, ok := config.matchRule(config.Rules, file); ok {
+			return rule.Key, fmt.Sprintf("%s rule %s", config.file, rule.Pattern)
+		}
+		if config.Key != "" {
+			return config.Key, config.file
+		}
+	}
+	return "", ""
+}
+
+// keyProjectFor finds the key project from the nearest configuration that
+// sets one.
+func (c *secretsConfig) keyProjectFor() string {
+	for config := c; config != nil; config = config.parent {
+		if config.KeyProject != "" {
+			return config.KeyProject
+		}
+	}
+	return ""
+}
+
+// secondKeyFor finds the dual-control key for file from the nearest
+// configuration with a matching rule.
+func (c *secretsConfig) secondKeyFor(file string) string {
+	for config := c; config != nil; config = config.parent {
+		if rule, ok := config.matchRule(config.DualControl, file); ok {
+			return rule.Key
+		}
+	}
+	return ""
+}
+
+// projectKey is the default key of a project: the one set in its root
+// .secrets.yaml, or else the one its key resolver or repository names.
+func projectKey(root string) (string, error) {
+	config, err := configFor(root)
+	if err != nil {
+		return "", err
+	}
+	if config.Key != "" {
+		return config.Key, nil
+	}
+	return projectKeyName(root)
+}
+
+// fileKey is the key to seal file with: --key if given, else the key from
+// the nearest .secrets.yaml rule or key, else the project's key. Keys given
+// by name are in the key project when there is one.
+func fileKey(file string) string {
+	absolutePath, err := filepath.Abs(file)
+	if err != nil {
+		return qualifyKey(key, keyProjectFlag)
+	}
+	config, err := configFor(filepath.Dir(absolutePath))
+	if err != nil {
+		errPrintln("Warning: ignoring configuration: %s", err)
+		return qualifyKey(key, keyProjectFlag)
+	}
+	keyProject := keyProjectFlag
+	if keyProject == "" {
+		keyProject = config.keyProjectFor()
+	}
+	if keyExplicit {
+		return qualifyKey(key, keyProject)
+	}
+	// A .enc gets the key its plaintext would get.
+	if keyName, source := config.keyFor(strings.TrimSuffix(absolutePath, ".enc")); keyName != "" {
+		printDebugln("key for %s: %s (from %s)", file, keyName, source)
+		return qualifyKey(keyName, keyProject)
+	}
+	return qualifyKey(key, keyProject)
+}
+
+// qualifyKey is the resource name of a key given by name in keyProject, or
+// the key as is without a key project.
+func qualifyKey(keyName string, keyProject string) string {
+	if keyProject == "" || isKeyResourceName(keyName) {
+		return keyName
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", keyProject, location, keyRing, keyName)
+}
+
+// fileSecondKey is the second key needed to open file under dual control,
+// or "". Unlike for keys, configuration errors are not ignored, so a broken
+// .secrets.yaml can't silently drop dual control.
+func fileSecondKey(file string) (string, error) {
+	absolutePath, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	config, err := configFor(filepath.Dir(absolutePath))
+	if err != nil {
+		return "", err
+	}
+	return config.secondKeyFor(strings.TrimSuffix(absolutePath, ".enc")), nil
+}
+
+// fileSigningKey is the key to sign file with, --signing-key or that of the
+// nearest .secrets.yaml setting one, or "" when files aren't signed.
+// Configuration errors are not ignored, as for dual control.
+func fileSigningKey(file string) (string, error) {
+	if signingKey != "" {
+		return signingKey, nil
+	}
+	absolutePath, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	config, err := configFor(filepath.Dir(absolutePath))
+	if err != nil {
+		return "", err
+	}
+	for ; config != nil; config = config.parent {
+		if config.SigningKey != "" {
+			return config.SigningKey, nil
+		}
+	}
+	return "", nil
+}
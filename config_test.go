@@ -0,0 +1,148 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigs writes .secrets.yaml files, by the folder they are in.
+func writeConfigs(t *testing.T, root string, configs map[string]string) {
+	t.Helper()
+	for dir, content := range configs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(root, dir, configFileName), []byte(content), 0644)
+	}
+}
+
+func TestFileKeyFromNearestConfig(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":          "key: root-key\n",
+		"team":       "key: team-key\n",
+		"team/empty": "",
+	})
+	for _, test := range []struct {
+		file string
+		key  string
+	}{
+		{"secret.yaml", "root-key"},
+		{"other/secret.yaml", "root-key"},
+		{"team/secret.yaml", "team-key"},
+		{"team/secret.yaml.enc", "team-key"},
+		{"team/empty/deep/secret.yaml", "team-key"},
+	} {
+		if keyName := fileKey(filepath.Join(root, test.file)); keyName != test.key {
+			t.Errorf("%s: expecting %s, got %s", test.file, test.key, keyName)
+		}
+	}
+	previousKey := key
+	keyExplicit, key = true, "explicit-key"
+	defer func() { keyExplicit, key = false, previousKey }()
+	if keyName := fileKey(filepath.Join(root, "team", "secret.yaml")); keyName != "explicit-key" {
+		t.Errorf("expecting --key to override the config, got %s", keyName)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"secret.yaml", "secret.yaml", true},
+		{"*.yaml", "secret.yaml", true},
+		{"*.yaml", "prod/secret.yaml", false},
+		{"prod/**", "prod/a/b/secret.yaml", true},
+		{"prod/**", "staging/secret.yaml", false},
+		{"**/secret.yaml", "secret.yaml", true},
+		{"**/secret.yaml", "a/b/secret.yaml", true},
+		{"a/**/secret.yaml", "a/secret.yaml", true},
+		{"a/**/secret.yaml", "a/b/other.yaml", false},
+	} {
+		if matches := matchGlob(test.pattern, test.name); matches != test.matches {
+			t.Errorf("%q against %q: expecting %t, got %t", test.pattern, test.name, test.matches, matches)
+		}
+	}
+}
+
+func TestFileKeyFromRules(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":             "key: root-key\nrules:\n  - path: prod/**\n    key: prod-key\n  - path: '*.env'\n    key: env-key\n",
+		"prod/payments": "rules:\n  - path: stripe-secret.yaml\n    key: payments-key\n",
+	})
+	for _, test := range []struct {
+		file string
+		key  string
+	}{
+		{"secret.yaml", "root-key"},
+		{"app.env", "env-key"},
+		{"sub/app.env", "root-key"},
+		{"prod/secret.yaml", "prod-key"},
+		{"prod/payments/secret.yaml", "prod-key"},
+		{"prod/payments/stripe-secret.yaml", "payments-key"},
+	} {
+		if keyName := fileKey(filepath.Join(root, test.file)); keyName != test.key {
+			t.Errorf("%s: expecting %s, got %s", test.file, test.key, keyName)
+		}
+	}
+}
+
+func TestParseKeyRulesErrors(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{"rules: prod\n", "rules must be a list"},
+		{"rules:\n  - path: prod/**\n", "rules 1 needs a path and a key"},
+		{"rules:\n  - path: '[prod'\n    key: prod-key\n", "rules 1: invalid path"},
+	} {
+		err := parseConfig(configFileName, []byte(test.config), &secretsConfig{})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expecting an error with %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestFileKeyInKeyProject(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":    "key: app\nkey-project: security\n",
+		"team": "key: team\nkey-project: team-keys\n",
+		"full": "key: projects/other/locations/global/keyRings/secrets/cryptoKeys/full\n",
+	})
+	for _, test := range []struct {
+		flag string
+		file string
+		key  string
+	}{
+		{"", "secret.yaml", "projects/security/locations/global/keyRings/" + defaultKeyRing + "/cryptoKeys/app"},
+		{"", "team/secret.yaml", "projects/team-keys/locations/global/keyRings/" + defaultKeyRing + "/cryptoKeys/team"},
+		{"", "full/secret.yaml", "projects/other/locations/global/keyRings/secrets/cryptoKeys/full"},
+		{"flag", "team/secret.yaml", "projects/flag/locations/global/keyRings/" + defaultKeyRing + "/cryptoKeys/team"},
+	} {
+		keyProjectFlag = test.flag
+		if keyName := fileKey(filepath.Join(root, test.file)); keyName != test.key {
+			t.Errorf("%s with --key-project %q: expecting %s, got %s", test.file, test.flag, test.key, keyName)
+		}
+	}
+	keyProjectFlag = ""
+}
+
+func TestProjectOf(t *testing.T) {
+	for _, test := range []struct {
+		keyName string
+		project string
+	}{
+		{testKey, "fake-project"},
+		{"projects/security", "security"},
+	} {
+		if project := projectOf(test.keyName); project != test.project {
+			t.Errorf("%s: expecting %s, got %s", test.keyName, test.project, project)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// `secrets config` shows the settings in effect for a folder or file and
+// where each comes from, sets simple settings in a .secrets.yaml keeping its
+// comments, and checks every .secrets.yaml of the project.
+
+const (
+	configShowCmd     string = "show"
+	configSetCmd      string = "set"
+	configValidateCmd string = "validate"
+	configEditCmd     string = "edit"
+)
+
+// rootOnlySettings are read from the root .secrets.yaml only, the others
+// apply to the folder of their .secrets.yaml and below.
+var rootOnlySettings = []string{"location", "secondary-location", "key-creation", "audit", "policy", "hooks", "key-resolver", "key-fallback", "fallback-key", "remote", "git-hosts", "jobs", "impersonate-service-account"}
+
+var folderSettings = []string{"key", "rules", "exclude", "split", "signing-key", "key-project", "dual-control", "access-policy"}
+
+// settableSettings are the ones config set writes, the rest are rules or
+// mappings to edit in .secrets.yaml. listSettings take comma-separated
+// values.
+var settableSettings = []string{"key", "key-project", "signing-key", "exclude", "split", "location", "secondary-location", "key-resolver", "key-fallback", "fallback-key", "remote", "git-hosts", "jobs", "impersonate-service-account"}
+var listSettings = []string{"exclude", "split", "git-hosts"}
+
+// configSetting is a setting in effect and where it comes from: a
+// .secrets.yaml, a flag, or the default.
+type configSetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+const configSourceDefault string = "default"
+
+func configCommand(subCmd string, args []string) error {
+	switch subCmd {
+	case configShowCmd:
+		if len(args) > 1 {
+			return fmt.Errorf("config show takes at most one folder or file, got %d", len(args))
+		}
+		target := "."
+		if len(args) == 1 {
+			target = args[0]
+		}
+		return showConfig(target)
+	case configSetCmd:
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("expecting config set <setting> <value> [<folder>]")
+		}
+		dir := projectRoot
+		if len(args) == 3 {
+			dir = args[2]
+		}
+		return setConfig(args[0], args[1], dir)
+	case configValidateCmd:
+		if len(args) > 0 {
+			return fmt.Errorf("config validate checks the whole project and takes no arguments")
+		}
+		return validateConfig(projectRoot)
+	case configEditCmd:
+		if len(args) > 1 {
+			return fmt.Errorf("config edit takes at most one folder, got %d", len(args))
+		}
+		dir := projectRoot
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		return editConfig(dir)
+	}
+	return fmt.Errorf("unknown config command %q, expecting %s, %s, %s or %s", subCmd, configShowCmd, configSetCmd, configValidateCmd, configEditCmd)
+}
+
+// effectiveConfig lists the settings that apply to target, a folder or a
+// file, in the order they take precedence.
+func effectiveConfig(target string) ([]configSetting, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	dir := target
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		dir = filepath.Dir(target)
+	}
+	if err := applyProjectOptions(projectRoot); err != nil {
+		return nil, err
+	}
+	if err := applyProfile(); err != nil {
+		return nil, err
+	}
+	if err := applyLocations(projectRoot); err != nil {
+		return nil, err
+	}
+	config, err := configFor(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := configFor(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	rootFile := displayPath(filepath.Join(projectRoot, configFileName))
+	settings := []configSetting{}
+	add := func(name string, value string, source string) {
+		settings = append(settings, configSetting{name, value, source})
+	}
+	// set adds a root-only setting from its flag, the root config or its
+	// default, skipping it when none gives a value.
+	set := func(name string, flagValue string, configValue string, defaultValue string) {
+		switch {
+		case flagValue != "":
+			add(name, flagValue, optionSource(name))
+		case configValue != "":
+			add(name, configValue, rootFile)
+		case defaultValue != "":
+			add(name, defaultValue, configSourceDefault)
+		}
+	}
+
+	if err := addKeySetting(add, config, root, target, dir); err != nil {
+		return nil, err
+	}
+	for c := config; c != nil; c = c.parent {
+		for _, rule := range c.Rules {
+			add("rules", fmt.Sprintf("%s: %s", rule.Pattern, rule.Key), displayPath(c.file))
+		}
+	}
+	keyProject, keyProjectSource := keyProjectFlag, optionSource("key-project")
+	signingKeyName, signingKeySource := signingKey, optionSource("signing-key")
+	for c := config; c != nil; c = c.parent {
+		if keyProject == "" && c.KeyProject != "" {
+			keyProject, keyProjectSource = c.KeyProject, displayPath(c.file)
+		}
+		if signingKeyName == "" && c.SigningKey != "" {
+			signingKeyName, signingKeySource = c.SigningKey, displayPath(c.file)
+		}
+	}
+	if keyProject != "" {
+		add("key-project", keyProject, keyProjectSource)
+	}
+	if signingKeyName != "" {
+		add("signing-key", signingKeyName, signingKeySource)
+	}
+	for c := config; c != nil; c = c.parent {
+		for _, rule := range c.DualControl {
+			add("dual-control", fmt.Sprintf("%s: %s", rule.Pattern, rule.Key), displayPath(c.file))
+		}
+	}
+	for c := config; c != nil; c = c.parent {
+		for _, rule := range c.AccessPolicy {
+			add("access-policy", fmt.Sprintf("%s: %s", rule.Pattern, strings.Join(rule.Groups, ", ")), displayPath(c.file))
+		}
+	}
+	for _, pattern := range excludes {
+		add("exclude", pattern, "--exclude")
+	}
+	for c := config; c != nil; c = c.parent {
+		for _, pattern := range c.Excludes {
+			add("exclude", pattern, displayPath(c.file))
+		}
+	}
+	for c := config; c != nil; c = c.parent {
+		for _, pattern := range c.Split {
+			add("split", pattern, displayPath(c.file))
+		}
+	}
+
+	add("key-ring", keyRing, optionSource("key-ring"))
+	set("location", locationFlag, root.Location, defaultLocation)
+	set("secondary-location", secondaryLocationFlag, root.SecondaryLocation, "")
+	set("rotation-period", keyCreationFlags.RotationPeriod, root.KeyCreation.RotationPeriod, fmt.Sprintf("%dd", int(defaultRotationPeriod.Hours()/24)))
+	set("next-rotation-time", keyCreationFlags.NextRotationTime, root.KeyCreation.NextRotationTime, "")
+	set("protection-level", keyCreationFlags.ProtectionLevel, root.KeyCreation.ProtectionLevel, protectionSoftware)
+	labels := []configSetting{}
+	for name, value := range root.KeyCreation.Labels {
+		if _, ok := keyCreationFlags.Labels[name]; !ok {
+			labels = append(labels, configSetting{"label", name + "=" + value, rootFile})
+		}
+	}
+	for name, value := range keyCreationFlags.Labels {
+		labels = append(labels, configSetting{"label", name + "=" + value, "--label"})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Value < labels[j].Value })
+	settings = append(settings, labels...)
+	set("key-resolver", "", root.KeyResolver, "")
+	set("key-fallback", "", root.KeyFallback, keyFallbackRepoName)
+	set("fallback-key", "", root.FallbackKey, "")
+	set("remote", "", root.Remote, defaultRemote)
+	set("git-hosts", "", strings.Join(root.GitHosts, ", "), expectedRepoHost)
+	if root.Audit != nil {
+		for _, sink := range root.Audit.Sinks {
+			add("audit", auditSinkDescription(sink), rootFile)
+		}
+	}
+	if root.Policy != nil {
+		policy := root.Policy.Rego
+		if policy == "" {
+			policy = root.Policy.URL
+		}
+		add("policy", fmt.Sprintf("%s (%s)", policy, root.Policy.Query), rootFile)
+	}
+	hookNames := []string{}
+	for name := range root.Hooks {
+		hookNames = append(hookNames, name)
+	}
+	sort.Strings(hookNames)
+	for _, name := range hookNames {
+		for _, command := range root.Hooks[name] {
+			add("hooks", fmt.Sprintf("%s: %s", name, command), rootFile)
+		}
+	}
+	if profile != "" {
+		source := "--profile"
+		if !flagsGiven["profile"] {
+			source = "$SECRETS_PROFILE"
+		}
+		add("profile", profile, source)
+	}
+	add("kms-transport", kmsTransport, optionSource("kms-transport"))
+	if credentialsConfig != "" {
+		add("credentials-config", credentialsConfig, optionSource("credentials-config"))
+	}
+	if configuration := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME"); configuration != "" {
+		source, ok := optionSources["gcloud-configuration"]
+		if !ok {
+			source = "$CLOUDSDK_ACTIVE_CONFIG_NAME"
+		}
+		add("gcloud-configuration", configuration, source)
+	}
+	add("jobs", fmt.Sprint(jobs), optionSource("jobs"))
+	if impersonateServiceAccount != "" {
+		add("impersonate-service-account", impersonateServiceAccount, optionSource("impersonate-service-account"))
+	}
+	add("color", colorMode, optionSource("color"))
+	editorName, editorSource := editorCommand()
+	add("editor", editorName, editorSource)
+	return settings, nil
+}
+
+// addKeySetting adds the key that target is sealed with and where it comes
+// from, as fileKey finds it.
+func addKeySetting(add func(string, string, string), config *secretsConfig, root *secretsConfig, target string, dir string) error {
+	if key != "" {
+		add("key", key, "--key")
+		return nil
+	}
+	if target != dir {
+		if keyName, source := config.keyFor(strings.TrimSuffix(target, ".enc")); keyName != "" {
+			add("key", keyName, displayPath(source))
+			return nil
+		}
+	} else {
+		for c := config; c != nil; c = c.parent {
+			if c.Key != "" {
+				add("key", c.Key, displayPath(c.file))
+				return nil
+			}
+		}
+	}
+	keyName, err := projectKeyName(projectRoot)
+	if err != nil {
+		return err
+	}
+	source := "key-fallback " + keyFallbackRepoName
+	switch {
+	case root.KeyResolver != "":
+		source = "key-resolver"
+	case root.KeyFallback != "":
+		source = "key-fallback " + root.KeyFallback
+	}
+	if root.KeyResolver == "" && !isGitless() {
+		if _, err := getProjectRepo(projectRoot); err == nil {
+			source = "git remote"
+		}
+	}
+	add("key", keyName, source)
+	return nil
+}
+
+func auditSinkDescription(sink auditSink) string {
+	for _, target := range []string{sink.Path, sink.Log, sink.Table, sink.URL} {
+		if target != "" {
+			return sink.Type + " " + target
+		}
+	}
+	return sink.Type
+}
+
+// showConfig prints the settings in effect for target, as a table or, with
+// --ci, as JSON.
+func showConfig(target string) error {
+	settings, err := effectiveConfig(target)
+	if err != nil {
+		return err
+	}
+	if ciMode {
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Name, setting.Value, setting.Source)
+	}
+	return w.Flush()
+}
+
+// setConfig sets a setting in the .secrets.yaml of dir, creating it if
+// needed, or removes the setting when value is empty. The file is checked
+// before it's written.
+func setConfig(name string, value string, dir string) error {
+	if !containsString(settableSettings, name) {
+		if containsString(rootOnlySettings, name) || containsString(folderSettings, name) {
+			return fmt.Errorf("%s can't be set with config set, edit %s instead", name, configFileName)
+		}
+		return fmt.Errorf("unknown setting %q, config set can set %s", name, strings.Join(settableSettings, ", "))
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return err
+	}
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside the project root %s", dir, root)
+	}
+	if dir != root && containsString(rootOnlySettings, name) {
+		return fmt.Errorf("%s is read from the root %s only, set it without a folder", name, configFileName)
+	}
+	file := filepath.Join(dir, configFileName)
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	document, err := parseYAML(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", displayPath(file), err)
+	}
+	if document == nil {
+		document = &yamlNode{kind: yamlMapping}
+	}
+	if document.kind != yamlMapping {
+		return fmt.Errorf("%s: expecting a mapping of settings", displayPath(file))
+	}
+	if value == "" {
+		if !document.delete(name) {
+			printProgress("%s isn't set in %s", name, displayPath(file))
+			return nil
+		}
+	} else if containsString(listSettings, name) {
+		list := &yamlNode{kind: yamlSequence}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list.values = append(list.values, newYAMLScalar(item))
+				list.comments = append(list.comments, nil)
+			}
+		}
+		document.set(name, list)
+	} else {
+		document.set(name, newYAMLScalar(value))
+	}
+	data = []byte(document.String())
+	if err := parseConfig(displayPath(file), data, &secretsConfig{}); err != nil {
+		return err
+	}
+	if dryRun {
+		printProgress("Would write %s:\n%s", displayPath(file), strings.TrimRight(string(data), "\n"))
+		return nil
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	if value == "" {
+		printProgress("Removed %s from %s", name, displayPath(file))
+	} else {
+		printProgress("Set %s to %s in %s", name, value, displayPath(file))
+	}
+	return nil
+}
+
+// configProblems checks a .secrets.yaml or .secretsignore, including for
+// settings it doesn't know and root-only settings outside the root, which
+// are otherwise ignored.
+func configProblems(file string, isRoot bool) []string {
+	name := displayPath(file)
+	if filepath.Base(file) == secretsIgnoreFileName {
+		if _, err := parseSecretsIgnore(file); err != nil {
+			return []string{err.Error()}
+		}
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if err := parseConfig(name, data, &secretsConfig{}); err != nil {
+		return []string{err.Error()}
+	}
+	document, _ := parseYAML(data)
+	if document == nil {
+		return nil
+	}
+	problems := []string{}
+	for _, setting := range document.keys {
+		setting = yamlUnquote(setting)
+		if containsString(rootOnlySettings, setting) && !isRoot {
+			problems = append(problems, fmt.Sprintf("%s: %s is read from the root %s only and has no effect here", name, setting, configFileName))
+		} else if !containsString(rootOnlySettings, setting) && !containsString(folderSettings, setting) {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting %q", name, setting))
+		}
+	}
+	return problems
+}
+
+// validateConfig checks every .secrets.yaml and .secretsignore under root and
+// the user config, then the root settings that depend on each other or on
+// flags.
+func validateConfig(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	files, err := findFiles(root, *regexp.MustCompile(`/(\.secrets\.yaml|\.secretsignore)$`))
+	if err != nil {
+		return err
+	}
+	userConfig := userConfigPath()
+	if userConfig != "" && fileExists(userConfig) {
+		files = append(files, userConfig)
+	}
+	if len(files) == 0 {
+		printProgress("No %s files in %s", configFileName, root)
+		return nil
+	}
+	problems := []string{}
+	for _, file := range files {
+		if file == userConfig {
+			if _, err := readUserConfig(file); err != nil {
+				problems = append(problems, err.Error())
+			}
+			continue
+		}
+		problems = append(problems, configProblems(file, filepath.Dir(file) == root)...)
+	}
+	if len(problems) == 0 {
+		if err := applyLocations(root); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, err := keyCreationParams(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, problem := range problems {
+		errPrintln("%s", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) in the configuration", len(problems))
+	}
+	printProgress("%d configuration file(s) are valid", len(files))
+	return nil
+}
+
+// editConfig opens the .secrets.yaml of dir in the editor, then checks it.
+func editConfig(dir string) error {
+	file := filepath.Join(dir, configFileName)
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	editorName, _ := editorCommand()
+	// The editor may have arguments, such as code --wait.
+	cmd := exec.Command("sh", "-c", editorName+` "$1"`, "sh", file)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %q failed: %w", editorName, err)
+	}
+	if !fileExists(file) {
+		return nil
+	}
+	root, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return err
+	}
+	problems := configProblems(file, filepath.Dir(file) == root)
+	for _, problem := range problems {
+		errPrintln("%s", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) in %s, run secrets config edit again to fix them", len(problems), displayPath(file))
+	}
+	return nil
+}
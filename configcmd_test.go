@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetConfig(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":    "key: app\n# Signs what CI seals\nsigning-key: ci-signing # asymmetric\nexclude:\n  - vendor/**\n",
+		"team": "",
+	})
+	for _, test := range []struct {
+		name  string
+		value string
+		dir   string
+	}{
+		{"key-project", "security", "."},
+		{"exclude", "fixtures/**, testdata/**", "."},
+		{"key", "", "."},
+		{"key", "team-key", "team"},
+	} {
+		captureStdout(t, func() {
+			if err := setConfig(test.name, test.value, filepath.Join(root, test.dir)); err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+		})
+	}
+	for file, expected := range map[string]string{
+		configFileName:                        "# Signs what CI seals\nsigning-key: ci-signing # asymmetric\nexclude:\n  - fixtures/**\n  - testdata/**\nkey-project: security\n",
+		filepath.Join("team", configFileName): "key: team-key\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expecting %q, got %q", file, expected, data)
+		}
+	}
+}
+
+func TestSetConfigRejects(t *testing.T) {
+	root := useFakeBackend(t)
+	for _, test := range []struct {
+		name  string
+		value string
+		dir   string
+		err   string
+	}{
+		{"rules", "x", root, "rules can't be set with config set, edit .secrets.yaml instead"},
+		{"colour", "x", root, `unknown setting "colour"`},
+		{"remote", "upstream", filepath.Join(root, "team"), "remote is read from the root .secrets.yaml only"},
+		{"key", "app", t.TempDir(), "is outside the project root"},
+		{"key-fallback", "guess", root, `unknown key-fallback "guess"`},
+	} {
+		err := setConfig(test.name, test.value, test.dir)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expecting an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+	if fileExists(filepath.Join(root, configFileName)) {
+		t.Error("expecting no .secrets.yaml written for invalid settings")
+	}
+}
+
+func TestConfigProblems(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":        "key: app\nremote: upstream\n",
+		"team":     "key: team-key\nremote: origin\nkeys: typo\n",
+		"invalid":  "key-fallback: guess\n",
+		"disabled": "",
+	})
+	for _, test := range []struct {
+		dir      string
+		isRoot   bool
+		problems []string
+	}{
+		{".", true, nil},
+		{"team", false, []string{
+			"team/.secrets.yaml: remote is read from the root .secrets.yaml only and has no effect here",
+			`team/.secrets.yaml: unknown setting "keys"`,
+		}},
+		{"invalid", false, []string{`invalid/.secrets.yaml: unknown key-fallback "guess", expecting repo-name, path-hash, config-value, error`}},
+		{"disabled", false, nil},
+	} {
+		problems := configProblems(filepath.Join(root, test.dir, configFileName), test.isRoot)
+		if strings.Join(problems, "\n") != strings.Join(test.problems, "\n") {
+			t.Errorf("%s: expecting %q, got %q", test.dir, test.problems, problems)
+		}
+	}
+	var err error
+	captureStderr(t, func() {
+		captureStdout(t, func() {
+			err = validateConfig(root)
+		})
+	})
+	if err == nil || err.Error() != "3 problem(s) in the configuration" {
+		t.Errorf("expecting 3 problems, got %v", err)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{
+		".":    "key: app\nkey-project: security\nremote: upstream\n",
+		"team": "key: team-key\nrules:\n  - path: db-*\n    key: db-key\n",
+	})
+	writeTestFile(t, filepath.Join(root, "team", "db-secret.yaml"), []byte("password: hunter2\n"), 0600)
+	for _, test := range []struct {
+		target   string
+		expected []configSetting
+	}{
+		{".", []configSetting{
+			{"key", "app", ".secrets.yaml"},
+			{"key-project", "security", ".secrets.yaml"},
+			{"location", defaultLocation, configSourceDefault},
+			{"remote", "upstream", ".secrets.yaml"},
+		}},
+		{"team", []configSetting{
+			{"key", "team-key", "team/.secrets.yaml"},
+			{"rules", "db-*: db-key", "team/.secrets.yaml"},
+		}},
+		{"team/db-secret.yaml", []configSetting{
+			{"key", "db-key", "team/.secrets.yaml rule db-*"},
+		}},
+	} {
+		settings, err := effectiveConfig(filepath.Join(root, test.target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range test.expected {
+			found := false
+			for _, setting := range settings {
+				found = found || setting == expected
+			}
+			if !found {
+				t.Errorf("%s: expecting %+v in %+v", test.target, expected, settings)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kmsScope string = "https://www.googleapis.com/auth/cloudkms"
+const googleTokenURL string = "https://oauth2.googleapis.com/token"
+
+// credentialsScope is the scope of the tokens of the credentials, which need
+// more than KMS to impersonate a service account.
+func credentialsScope() string {
+	if impersonateServiceAccount != "" {
+		return cloudPlatformScope
+	}
+	return kmsScope
+}
+
+var errNoDefaultCredentials = errors.New("no Application Default Credentials found, " +
+	"run `gcloud auth application-default login` or set GOOGLE_APPLICATION_CREDENTIALS, " +
+	"or run on Google Cloud with a service account attached")
+
+// credentialsFile is an Application Default Credentials file, as written by
+// `gcloud auth application-default login` or downloaded for a service
+// account.
+type credentialsFile struct {
+	Type           string `json:"type"`
+	ProjectID      string `json:"project_id"`
+	QuotaProjectID string `json:"quota_project_id"`
+	ClientID       string `json:"client_id"`
+	ClientSecret   string `json:"client_secret"`
+	RefreshToken   string `json:"refresh_token"`
+	ClientEmail    string `json:"client_email"`
+	PrivateKey     string `json:"private_key"`
+	PrivateKeyID   string `json:"private_key_id"`
+	TokenURI       string `json:"token_uri"`
+}
+
+func gcloudConfigDir() string {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return dir
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud")
+}
+
+// defaultCredentialsPath is --credentials-config or
+// GOOGLE_APPLICATION_CREDENTIALS, or else the file
+// `gcloud auth application-default login` writes, or "" when neither exists.
+func defaultCredentialsPath() string {
+	if credentialsConfig != "" {
+		return credentialsConfig
+	}
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return path
+	}
+	path := filepath.Join(gcloudConfigDir(), "application_default_credentials.json")
+	if fileExists(path) {
+		return path
+	}
+	return ""
+}
+
+func hasDefaultCredentials() bool {
+	return defaultCredentialsPath() != "" || onMetadataServer()
+}
+
+// cachedToken hands out an access token until shortly before it expires.
+type cachedToken struct {
+	mutex  sync.Mutex
+	fetch  func() (string, time.Duration, error)
+	value  string
+	expiry time.Time
+}
+
+func (c *cachedToken) token() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.value != "" && time.Now().Before(c.expiry.Add(-time.Minute)) {
+		return c.value, nil
+	}
+	value, lifetime, err := c.fetch()
+	if err != nil {
+		return "", err
+	}
+	c.value, c.expiry = value, time.Now().Add(lifetime)
+	return c.value, nil
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func requestToken(tokenURL string, form url.Values) (string, time.Duration, error) {
+	response, err := httpClient.PostForm(tokenURL, form)
+	if err != nil {
+		return "", 0, err
+	}
+	defer response.Body.Close()
+	var token tokenResponse
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", 0, fmt.Errorf("could not read the access token response: %w", err)
+	}
+	if token.Error != "" || token.AccessToken == "" {
+		return "", 0, fmt.Errorf("could not get an access token: %s %s", token.Error, token.ErrorDescription)
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid service account private key")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	k, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account private key is not an RSA key")
+	}
+	return k, nil
+}
+
+// serviceAccountAssertion is the signed JWT a service account exchanges for
+// an access token.
+func serviceAccountAssertion(creds *credentialsFile, tokenURL string) (string, error) {
+	privateKey, err := parsePrivateKey(creds.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": creds.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": credentialsScope(),
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// loadDefaultCredentials finds Application Default Credentials: a
+// credentials file, or else the metadata server.
+func loadDefaultCredentials() (*credentialsFile, *cachedToken, error) {
+	path := defaultCredentialsPath()
+	if path == "" && onMetadataServer() {
+		return metadataCredentials()
+	}
+	if path == "" {
+		return nil, nil, errNoDefaultCredentials
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds := &credentialsFile{}
+	if err := json.Unmarshal(data, creds); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	printDebugln("using %s credentials from %s", creds.Type, path)
+	tokenURL := googleTokenURL
+	if creds.TokenURI != "" {
+		tokenURL = creds.TokenURI
+	}
+	switch creds.Type {
+	case "authorized_user":
+		return creds, &cachedToken{fetch: func() (string, time.Duration, error) {
+			return requestToken(tokenURL, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {creds.ClientID},
+				"client_secret": {creds.ClientSecret},
+				"refresh_token": {creds.RefreshToken},
+			})
+		}}, nil
+	case "service_account":
+		return creds, &cachedToken{fetch: func() (string, time.Duration, error) {
+			assertion, err := serviceAccountAssertion(creds, tokenURL)
+			if err != nil {
+				return "", 0, err
+			}
+			return requestToken(tokenURL, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}}, nil
+	case "external_account":
+		account := &externalAccount{}
+		if err := json.Unmarshal(data, account); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if account.Audience == "" {
+			return nil, nil, fmt.Errorf("%s: no audience, expecting a workload identity pool provider", path)
+		}
+		creds.ClientEmail = impersonatedAccount(account.ServiceAccountImpersonationURL)
+		return creds, &cachedToken{fetch: account.token}, nil
+	}
+	return nil, nil, fmt.Errorf("%s: unsupported credentials type %q", path, creds.Type)
+}
+
+// gcloudConfigProject reads the project of the active gcloud configuration
+// without running gcloud.
+func gcloudConfigProject() string {
+	dir := gcloudConfigDir()
+	name := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if data, err := os.ReadFile(filepath.Join(dir, "active_config")); err == nil && name == "" {
+		name = strings.TrimSpace(string(data))
+	}
+	if name == "" {
+		name = "default"
+	}
+	f, err := os.Open(filepath.Join(dir, "configurations", "config_"+name))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		option, value, ok := strings.Cut(line, "=")
+		if ok && section == "core" && strings.TrimSpace(option) == "project" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// defaultProject is the project keys given by name are looked up in.
+func defaultProject(creds *credentialsFile) string {
+	for _, project := range []string{
+		os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		os.Getenv("CLOUDSDK_CORE_PROJECT"),
+		creds.ProjectID,
+		creds.QuotaProjectID,
+		gcloudConfigProject(),
+	} {
+		if project != "" {
+			return project
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Cloud KMS encrypts at most 64 KiB of plaintext directly. Larger files are
+// encrypted locally with a random AES-256-GCM data key, and only the data
+// key is encrypted with KMS and stored next to the ciphertext.
+const maxKmsPlaintext int = 64 * 1024
+const dataKeySize int = 32
+
+func newDataKeyCipher(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWithDataKey returns the plaintext encrypted under a new data key,
+// prefixed with its nonce, and the data key itself. additionalData is
+// authenticated along with it, and has to be given again to open it.
+func sealWithDataKey(plaintext []byte, additionalData []byte) ([]byte, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newDataKeyCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), dataKey, nil
+}
+
+func openWithDataKey(dataKey []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(dataKey) != dataKeySize {
+		return nil, fmt.Errorf("corrupted envelope: wrapped key decrypted to %d bytes, expecting %d", len(dataKey), dataKeySize)
+	}
+	aead, err := newDataKeyCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("corrupted envelope: ciphertext shorter than its nonce")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted envelope: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// With --deterministic, files are always encrypted with a data key, and the
+// nonce is derived from the data key and the plaintext instead of being
+// random. The data key of the previous envelope is reused as long as the
+// key's primary version hasn't changed, so sealing the same content again
+// produces the same .enc byte for byte.
+//
+// The tradeoff is that anyone with access to the history of a .enc can tell
+// whether its content changed between commits, and a data key is used for
+// as long as its key version stays primary.
+
+// readEnvelope returns the envelope of an existing .enc file, or nil.
+func readEnvelope(ciphertextFile string) *envelope {
+	data, err := os.ReadFile(ciphertextFile)
+	if err != nil {
+		return nil
+	}
+	e, err := parseEnvelope(data)
+	if err != nil {
+		return nil
+	}
+	return e
+}
+
+// syntheticNonce derives the nonce from the additional data as well, so that
+// a data key never seals with the same nonce under different headers.
+func syntheticNonce(dataKey []byte, plaintext []byte, additionalData []byte, size int) []byte {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(additionalData))))
+	mac.Write(additionalData)
+	mac.Write(plaintext)
+	return mac.Sum(nil)[:size]
+}
+
+func encryptDeterministic(keyName string, plaintext []byte, additionalData []byte, previous *envelope) (*envelope, error) {
+	k, err := describeKey(keyName)
+	if err != nil {
+		return nil, err
+	}
+	e := &envelope{Key: k.Name, KeyVersion: k.Primary.Name, SealedAt: time.Now()}
+	var dataKey []byte
+	if previous != nil && len(previous.WrappedKey) > 0 && previous.Key == k.Name && previous.KeyVersion == k.Primary.Name {
+		unwrapped, err := callKms("decrypt", keyName, previous.WrappedKey)
+		if err != nil {
+			return nil, err
+		}
+		dataKey, e.WrappedKey = []byte(unwrapped), previous.WrappedKey
+	} else {
+		dataKey = make([]byte, dataKeySize)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, err
+		}
+		wrapped, err := callKms("encrypt", keyName, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		e.WrappedKey = []byte(wrapped)
+	}
+
+	aead, err := newDataKeyCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := syntheticNonce(dataKey, plaintext, additionalData, aead.NonceSize())
+	e.Ciphertext = aead.Seal(nonce, nonce, plaintext, additionalData)
+
+	if previous != nil && bytes.Equal(previous.Ciphertext, e.Ciphertext) {
+		e.SealedAt = previous.SealedAt
+	}
+	e.contentHash, err = plaintextHash(plaintext)
+	return e, err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDeterministic(t *testing.T) {
+	useFakeBackend(t)
+	first, err := encryptDeterministic(testKey, []byte("token: abc\n"), []byte("Path: secret.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name           string
+		plaintext      string
+		additionalData string
+		previous       *envelope
+		same           bool
+	}{
+		{"same content", "token: abc\n", "Path: secret.yaml", first, true},
+		{"changed content", "token: def\n", "Path: secret.yaml", first, false},
+		{"changed headers", "token: abc\n", "Path: other.yaml", first, false},
+		{"no previous data key", "token: abc\n", "Path: secret.yaml", nil, false},
+		{"rotated key", "token: abc\n", "Path: secret.yaml", &envelope{Key: testKey, KeyVersion: testKey + "/cryptoKeyVersions/0", WrappedKey: first.WrappedKey}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			e, err := encryptDeterministic(testKey, []byte(test.plaintext), []byte(test.additionalData), test.previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same := bytes.Equal(e.Ciphertext, first.Ciphertext); same != test.same {
+				t.Errorf("expecting the same ciphertext %t, got %t", test.same, same)
+			}
+			if test.same && !e.SealedAt.Equal(first.SealedAt) {
+				t.Errorf("expecting Sealed-At kept at %s, got %s", first.SealedAt, e.SealedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+type envVar struct {
+	Name  string
+	Value string
+}
+
+// parseDotenv reads KEY=VALUE lines, allowing comments, blank lines, an
+// optional "export " prefix and single or double quoted values.
+func parseDotenv(data []byte) []envVar {
+	vars := make([]envVar, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		case strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) > 1:
+			value = value[1 : len(value)-1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		vars = append(vars, envVar{name, value})
+	}
+	return vars
+}
+
+func isDotenvFile(filePath string) bool {
+	base := strings.TrimSuffix(filePath, ".enc")
+	return strings.HasSuffix(base, ".env") || strings.Contains(base, ".env.")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// Files under a dual-control path are encrypted with a data key split into
+// two random shares that XOR to it. One share is encrypted with the file's
+// key and the other with a second key, typically held by another team or in
+// a restricted project, so opening the file takes decrypt access to both.
+
+func xorBytes(a []byte, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
+
+func encryptDualControl(keyName string, secondKey string, plaintext []byte, additionalData []byte) (*envelope, error) {
+	hash, err := plaintextHash(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, dataKey, err := sealWithDataKey(plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	share := make([]byte, len(dataKey))
+	if _, err := rand.Read(share); err != nil {
+		return nil, err
+	}
+	wrapped, err := callKms("encrypt", keyName, share)
+	if err != nil {
+		return nil, err
+	}
+	secondWrapped, err := callKmsKey("encrypt", secondKey, xorBytes(dataKey, share), false)
+	if err != nil {
+		return nil, fmt.Errorf("dual control key %s: %w", secondKey, err)
+	}
+	k, err := describeKey(keyName)
+	if err != nil {
+		return nil, err
+	}
+	second, err := describeKey(secondKey)
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{
+		Key:              k.Name,
+		KeyVersion:       k.Primary.Name,
+		SealedAt:         time.Now(),
+		contentHash:      hash,
+		WrappedKey:       []byte(wrapped),
+		SecondKey:        second.Name,
+		SecondWrappedKey: []byte(secondWrapped),
+		Ciphertext:       ciphertext,
+	}, nil
+}
+
+func decryptDualControl(keyName string, e *envelope) ([]byte, error) {
+	share, err := callKmsKey("decrypt", keyName, e.WrappedKey, false)
+	if err != nil {
+		return nil, err
+	}
+	secondShare, err := callKmsKey("decrypt", e.SecondKey, e.SecondWrappedKey, false)
+	if err != nil {
+		return nil, fmt.Errorf("this file is under dual control and also needs decrypt access to %s: %w", e.SecondKey, err)
+	}
+	if len(share) != len(secondShare) {
+		return nil, fmt.Errorf("corrupted envelope: key shares of different lengths")
+	}
+	return openWithDataKey(xorBytes([]byte(share), []byte(secondShare)), e.Ciphertext, e.additionalData())
+}
+
+// sealBytes encrypts the content of plaintextFile, described by headers,
+// under dual control when its path requires it or the file was already
+// under dual control, and otherwise for both locations when there is a
+// secondary location. Envelopes sealed with a data key authenticate headers.
+func sealBytes(plaintextFile string, keyName string, plaintext []byte, headers plaintextHeaders, previous *envelope) (*envelope, error) {
+	secondKey, err := fileSecondKey(plaintextFile)
+	if err != nil {
+		return nil, err
+	}
+	if secondKey == "" && previous != nil {
+		secondKey = previous.SecondKey
+	}
+	if err := checkProtectionLevel(keyName); err != nil {
+		return nil, err
+	}
+	additionalData := headers.additionalData()
+	var e *envelope
+	if secondKey == "" && secondaryLocation != "" {
+		if deterministic {
+			printDebugln("%s is sealed for two locations, which is never deterministic", plaintextFile)
+		}
+		e, err = encryptWithFallback(plaintextFile, keyName, plaintext, additionalData)
+	} else if secondKey == "" {
+		e, err = encryptBytes(keyName, plaintext, headers, previous)
+	} else {
+		if deterministic {
+			printDebugln("%s is under dual control, which is never deterministic", plaintextFile)
+		}
+		e, err = encryptDualControl(keyName, secondKey, plaintext, additionalData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Path, e.Mode, e.ModifiedAt, e.Fragment = headers.Path, headers.Mode, headers.ModifiedAt, headers.Fragment
+	e.HeadersAuthenticated = len(e.WrappedKey) > 0
+	recordProvenance(e, previous)
+	return e, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// deniedKeyBackend is the fake backend without decrypt access to one key.
+type deniedKeyBackend struct {
+	fakeBackend
+	denied string
+}
+
+func (d *deniedKeyBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	if d.denied != "" && strings.HasSuffix(keyName, d.denied) {
+		return nil, &kmsAPIError{Status: "PERMISSION_DENIED", Message: "Permission 'cloudkms.cryptoKeyVersions.useToDecrypt' denied"}
+	}
+	return d.fakeBackend.decrypt(keyName, ciphertext)
+}
+
+func TestDualControl(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		denied string
+		err    string
+	}{
+		{"both keys", "", ""},
+		{"without the second key", "/cryptoKeys/second", "also needs decrypt access to"},
+		{"without the file key", "/cryptoKeys/test", "denied"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeConfigs(t, root, map[string]string{".": "dual-control:\n  - path: prod/**\n    key: " + testKey[:strings.LastIndex(testKey, "/")] + "/second\n"})
+			plaintextFile := filepath.Join(root, "prod", "secret.yaml")
+			if err := os.MkdirAll(filepath.Dir(plaintextFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+			if err := encrypt(testKey, plaintextFile); err != nil {
+				t.Fatal(err)
+			}
+			e := readEnvelope(plaintextFile + ".enc")
+			if e == nil || !strings.HasSuffix(e.SecondKey, "/cryptoKeys/second") || len(e.SecondWrappedKey) == 0 {
+				t.Fatalf("expecting the data key split with the second key, got %+v", e)
+			}
+			kmsSelected = &deniedKeyBackend{denied: test.denied}
+			plaintext, _, err := decryptBytes(testKey, e.marshal())
+			if test.err == "" {
+				if err != nil || string(plaintext) != "token: abc\n" {
+					t.Errorf("expecting the file opened, got %q (%v)", plaintext, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envelopeType string = "SECRETS ENVELOPE"
+
+// authenticatedHeaders is the value of the Authenticated header, naming the
+// headers that the data key authenticates. Fragments also authenticate
+// their place among the fragments of their file.
+const (
+	authenticatedHeaders         string = "Path, Mode, Modified-At"
+	authenticatedFragmentHeaders string = "Path, Mode, Modified-At, Fragment"
+)
+
+var errNotEnvelope = errors.New("not an envelope")
+var errEmptyCiphertext = errors.New("empty encrypted file")
+var errMergeConflictMarkers = errors.New("this file has an unresolved merge conflict. " +
+	"Pick a side with `git checkout --ours/--theirs <file>`, " +
+	"and run `secrets gitattributes` and `secrets git-config` so future conflicts are merged for you")
+
+var conflictMarkers = regexp.MustCompile(`(?m)^(<<<<<<<|>>>>>>>) `)
+
+// envelope wraps a KMS ciphertext with metadata about how it was produced.
+// It is stored as a PEM block so the .enc file stays diffable text. When
+// WrappedKey is set, Ciphertext was encrypted locally with a data key and
+// WrappedKey is that data key encrypted with KMS. Mode and ModifiedAt are
+// the plaintext's permission bits and modification time, restored on open.
+// PlaintextHash is the salted hash of the plaintext that older versions
+// recorded in the clear, kept only so that their signatures still verify;
+// it is never written for new seals. Under dual
+// control the data key is split in two shares, WrappedKey encrypted with Key
+// and SecondWrappedKey with SecondKey. With a secondary location the data
+// key is also wrapped with FallbackKey, the same key in that location, as
+// FallbackWrappedKey. Signature is made with the asymmetric key version
+// SigningKey over the envelope without the signature. SealedBy, SealedHost
+// and SealedCommit record who sealed the file, where and at which commit.
+// HeadersAuthenticated is set when the data key authenticated Path, Mode and
+// ModifiedAt along with the plaintext, so that they can't be changed without
+// the file failing to open. Fragment is "<n>/<count>" for the fragments of a
+// split file, and always authenticated.
+type envelope struct {
+	Path                 string
+	Fragment             string
+	Key                  string
+	KeyVersion           string
+	SealedAt             time.Time
+	SealedBy             string
+	SealedHost           string
+	SealedCommit         string
+	Mode                 os.FileMode
+	ModifiedAt           time.Time
+	PlaintextHash        string
+	WrappedKey           []byte
+	SecondKey            string
+	SecondWrappedKey     []byte
+	FallbackKey          string
+	FallbackWrappedKey   []byte
+	SigningKey           string
+	Signature            []byte
+	HeadersAuthenticated bool
+	Ciphertext           []byte
+
+	// contentHash is the salted hash of the plaintext just sealed, which
+	// writeEnvelope remembers on this machine only.
+	contentHash string
+}
+
+func (e *envelope) marshal() []byte {
+	headers := map[string]string{}
+	if e.Path != "" {
+		headers["Path"] = e.Path
+	}
+	if e.Fragment != "" {
+		headers["Fragment"] = e.Fragment
+	}
+	if e.Key != "" {
+		headers["Key"] = e.Key
+	}
+	if e.KeyVersion != "" {
+		headers["Key-Version"] = e.KeyVersion
+	}
+	if !e.SealedAt.IsZero() {
+		headers["Sealed-At"] = e.SealedAt.UTC().Format(time.RFC3339)
+	}
+	if e.SealedBy != "" {
+		headers["Sealed-By"] = e.SealedBy
+	}
+	if e.SealedHost != "" {
+		headers["Sealed-Host"] = e.SealedHost
+	}
+	if e.SealedCommit != "" {
+		headers["Sealed-Commit"] = e.SealedCommit
+	}
+	if e.Mode != 0 {
+		headers["Mode"] = fmt.Sprintf("%04o", e.Mode.Perm())
+	}
+	if !e.ModifiedAt.IsZero() {
+		headers["Modified-At"] = e.ModifiedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if e.PlaintextHash != "" {
+		headers["Plaintext-Hash"] = e.PlaintextHash
+	}
+	if len(e.WrappedKey) > 0 {
+		headers["Wrapped-Key"] = base64.StdEncoding.EncodeToString(e.WrappedKey)
+	}
+	if e.SecondKey != "" {
+		headers["Second-Key"] = e.SecondKey
+		headers["Second-Wrapped-Key"] = base64.StdEncoding.EncodeToString(e.SecondWrappedKey)
+	}
+	if e.FallbackKey != "" {
+		headers["Fallback-Key"] = e.FallbackKey
+		headers["Fallback-Wrapped-Key"] = base64.StdEncoding.EncodeToString(e.FallbackWrappedKey)
+	}
+	if e.SigningKey != "" {
+		headers["Signing-Key"] = e.SigningKey
+	}
+	if len(e.Signature) > 0 {
+		headers["Signature"] = base64.StdEncoding.EncodeToString(e.Signature)
+	}
+	if e.HeadersAuthenticated {
+		headers["Authenticated"] = e.plaintextHeaders().authenticatedHeaders()
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    envelopeType,
+		Headers: headers,
+		Bytes:   e.Ciphertext,
+	})
+}
+
+// checkCiphertext catches files that can't possibly decrypt, so they
+// aren't sent to KMS only to fail with an opaque error.
+func checkCiphertext(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return errEmptyCiphertext
+	}
+	if conflictMarkers.Match(data) {
+		return errMergeConflictMarkers
+	}
+	return nil
+}
+
+// parseEnvelope returns errNotEnvelope for files written before envelopes
+// were introduced, which contain the raw KMS ciphertext.
+func parseEnvelope(data []byte) (*envelope, error) {
+	begin := []byte("-----BEGIN " + envelopeType + "-----")
+	if !bytes.HasPrefix(bytes.TrimSpace(data), begin) {
+		if bytes.Contains(data, begin) {
+			return nil, errors.New("corrupted envelope: unexpected data before the envelope")
+		}
+		return nil, errNotEnvelope
+	}
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != envelopeType {
+		return nil, errors.New("corrupted envelope: invalid framing or base64 payload")
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return nil, errors.New("corrupted envelope: unexpected data after the envelope")
+	}
+	if len(block.Bytes) == 0 {
+		return nil, errors.New("corrupted envelope: no ciphertext")
+	}
+	e := &envelope{
+		Path:          block.Headers["Path"],
+		Fragment:      block.Headers["Fragment"],
+		Key:           block.Headers["Key"],
+		KeyVersion:    block.Headers["Key-Version"],
+		SealedBy:      block.Headers["Sealed-By"],
+		SealedHost:    block.Headers["Sealed-Host"],
+		SealedCommit:  block.Headers["Sealed-Commit"],
+		PlaintextHash: block.Headers["Plaintext-Hash"],
+		Ciphertext:    block.Bytes,
+	}
+	if sealedAt, ok := block.Headers["Sealed-At"]; ok {
+		t, err := time.Parse(time.RFC3339, sealedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.SealedAt = t
+	}
+	if mode, ok := block.Headers["Mode"]; ok {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0777 {
+			return nil, fmt.Errorf("corrupted envelope: invalid Mode header %q", mode)
+		}
+		e.Mode = os.FileMode(perm)
+	}
+	if modifiedAt, ok := block.Headers["Modified-At"]; ok {
+		t, err := time.Parse(time.RFC3339Nano, modifiedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.ModifiedAt = t
+	}
+	if wrappedKey, ok := block.Headers["Wrapped-Key"]; ok {
+		data, err := base64.StdEncoding.DecodeString(wrappedKey)
+		if err != nil || len(data) == 0 {
+			return nil, errors.New("corrupted envelope: invalid Wrapped-Key header")
+		}
+		e.WrappedKey = data
+	}
+	if secondKey, ok := block.Headers["Second-Key"]; ok {
+		data, err := base64.StdEncoding.DecodeString(block.Headers["Second-Wrapped-Key"])
+		if err != nil || len(data) == 0 || len(e.WrappedKey) == 0 {
+			return nil, errors.New("corrupted envelope: invalid Second-Wrapped-Key header")
+		}
+		e.SecondKey, e.SecondWrappedKey = secondKey, data
+	}
+	if fallbackKey, ok := block.Headers["Fallback-Key"]; ok {
+		data, err := base64.StdEncoding.DecodeString(block.Headers["Fallback-Wrapped-Key"])
+		if err != nil || len(data) == 0 || len(e.WrappedKey) == 0 {
+			return nil, errors.New("corrupted envelope: invalid Fallback-Wrapped-Key header")
+		}
+		e.FallbackKey, e.FallbackWrappedKey = fallbackKey, data
+	}
+	if signature, ok := block.Headers["Signature"]; ok {
+		data, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil || len(data) == 0 || block.Headers["Signing-Key"] == "" {
+			return nil, errors.New("corrupted envelope: invalid Signature header")
+		}
+		e.SigningKey, e.Signature = block.Headers["Signing-Key"], data
+	}
+	if authenticated, ok := block.Headers["Authenticated"]; ok {
+		if authenticated != e.plaintextHeaders().authenticatedHeaders() || len(e.WrappedKey) == 0 {
+			return nil, fmt.Errorf("corrupted envelope: invalid Authenticated header %q", authenticated)
+		}
+		e.HeadersAuthenticated = true
+	}
+	if e.Fragment != "" && !e.HeadersAuthenticated {
+		return nil, errors.New("corrupted envelope: unauthenticated Fragment header")
+	}
+	return e, nil
+}
+
+// plaintextHeaders are the headers of an envelope that describe its
+// plaintext, and are restored on open.
+type plaintextHeaders struct {
+	Path       string
+	Mode       os.FileMode
+	ModifiedAt time.Time
+	Fragment   string
+}
+
+func (h plaintextHeaders) authenticatedHeaders() string {
+	if h.Fragment != "" {
+		return authenticatedFragmentHeaders
+	}
+	return authenticatedHeaders
+}
+
+// additionalData is the canonical form of the headers that envelopes sealed
+// with a data key authenticate, formatted as they are in the envelope.
+func (h plaintextHeaders) additionalData() []byte {
+	mode, modifiedAt := "", ""
+	if h.Mode != 0 {
+		mode = fmt.Sprintf("%04o", h.Mode.Perm())
+	}
+	if !h.ModifiedAt.IsZero() {
+		modifiedAt = h.ModifiedAt.UTC().Format(time.RFC3339Nano)
+	}
+	fields := []string{envelopeType, h.authenticatedHeaders(), h.Path, mode, modifiedAt}
+	if h.Fragment != "" {
+		fields = append(fields, h.Fragment)
+	}
+	data, _ := json.Marshal(fields)
+	return data
+}
+
+func (e *envelope) plaintextHeaders() plaintextHeaders {
+	return plaintextHeaders{e.Path, e.Mode, e.ModifiedAt, e.Fragment}
+}
+
+// additionalData is what opening the ciphertext of e with its data key
+// authenticates.
+func (e *envelope) additionalData() []byte {
+	if !e.HeadersAuthenticated {
+		return nil
+	}
+	return e.plaintextHeaders().additionalData()
+}
+
+// isAuthenticated reports whether the headers of e can be trusted: the data
+// key authenticated them, or the file has to be signed, which checkSignature
+// enforces before it is opened.
+func isAuthenticated(ciphertextFile string, e *envelope) bool {
+	if e.HeadersAuthenticated {
+		return true
+	}
+	signingKeyName, err := fileSigningKey(ciphertextFile)
+	return err == nil && signingKeyName != ""
+}
+
+// checkPath rejects an envelope sealed as another file than ciphertextFile,
+// such as a .enc copied over one sealed with the same key. Fragments are
+// sealed as the file they were split from. Bundles, files outside the
+// project and envelopes without a Path are not checked.
+func checkPath(ciphertextFile string, e *envelope) error {
+	if e == nil || e.Path == "" || projectRoot == "" || !strings.HasSuffix(ciphertextFile, ".enc") {
+		return nil
+	}
+	expected := projectPath(strings.TrimSuffix(sealedFileOf(ciphertextFile), ".enc"))
+	if expected == "" || strings.HasPrefix(expected, "..") {
+		return nil
+	}
+	if e.Path != expected {
+		return fmt.Errorf("sealed as %s, expecting %s, use `secrets mv` to move a secret", e.Path, expected)
+	}
+	return nil
+}
+
+// plaintextMode is the mode to open the plaintext of ciphertextFile with.
+// Unless the headers of e are authenticated, group and other permissions
+// are dropped, as anyone who can edit the .enc could set them.
+func plaintextMode(ciphertextFile string, e *envelope) os.FileMode {
+	mode := e.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if !isAuthenticated(ciphertextFile, e) {
+		mode &= 0700
+	}
+	return mode
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	e := &envelope{
+		Path:                 "config/secret.yaml",
+		Fragment:             "2/3",
+		Key:                  testKey,
+		KeyVersion:           testKey + "/cryptoKeyVersions/3",
+		SealedAt:             time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		SealedBy:             "dev@example.com",
+		SealedHost:           "laptop",
+		SealedCommit:         "0123456789abcdef",
+		Mode:                 0750,
+		ModifiedAt:           time.Date(2024, 4, 30, 8, 15, 0, 123456789, time.UTC),
+		WrappedKey:           []byte("wrapped"),
+		SecondKey:            testKey + "2",
+		SecondWrappedKey:     []byte("second"),
+		FallbackKey:          testKey + "3",
+		FallbackWrappedKey:   []byte("fallback"),
+		SigningKey:           testKey + "/cryptoKeyVersions/1",
+		Signature:            []byte("signature"),
+		HeadersAuthenticated: true,
+		Ciphertext:           bytes.Repeat([]byte{0, 1, 2, 255}, 100),
+	}
+	parsed, err := parseEnvelope(e.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, e) {
+		t.Errorf("expecting %+v, got %+v", e, parsed)
+	}
+	if !bytes.Equal(parsed.marshal(), e.marshal()) {
+		t.Error("marshaling a parsed envelope changed it")
+	}
+}
+
+func TestParseEnvelopeErrors(t *testing.T) {
+	valid := string((&envelope{Key: testKey, Mode: 0600, WrappedKey: []byte("k"), Ciphertext: []byte("ciphertext")}).marshal())
+	for _, test := range []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"raw ciphertext", "\x0a\x24binary", ""},
+		{"data before", "garbage\n" + valid, "unexpected data before the envelope"},
+		{"data after", valid + "garbage\n", "unexpected data after the envelope"},
+		{"bad mode", strings.Replace(valid, "Mode: 0600", "Mode: 1777", 1), "invalid Mode header"},
+		{"bad wrapped key", strings.Replace(valid, "Wrapped-Key: aw==", "Wrapped-Key: !", 1), "invalid Wrapped-Key header"},
+		{"bad authenticated", strings.Replace(valid, "Key: "+testKey, "Authenticated: Path\nKey: "+testKey, 1), "invalid Authenticated header"},
+		{"unauthenticated fragment", strings.Replace(valid, "Key: "+testKey, "Fragment: 1/2\nKey: "+testKey, 1), "unauthenticated Fragment header"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseEnvelope([]byte(test.data))
+			if test.err == "" {
+				if !errors.Is(err, errNotEnvelope) {
+					t.Errorf("expecting errNotEnvelope, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestAdditionalDataCoversHeaders(t *testing.T) {
+	headers := plaintextHeaders{"secret.yaml", 0600, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), ""}
+	data := headers.additionalData()
+	for _, changed := range []plaintextHeaders{
+		{"other.yaml", headers.Mode, headers.ModifiedAt, ""},
+		{headers.Path, 0644, headers.ModifiedAt, ""},
+		{headers.Path, headers.Mode, headers.ModifiedAt.Add(time.Second), ""},
+		{headers.Path, headers.Mode, headers.ModifiedAt, "1/2"},
+	} {
+		if bytes.Equal(changed.additionalData(), data) {
+			t.Errorf("%+v authenticates as %+v", changed, headers)
+		}
+	}
+	e := &envelope{Path: headers.Path, Mode: headers.Mode, ModifiedAt: headers.ModifiedAt}
+	if e.additionalData() != nil {
+		t.Error("expecting no additional data for an envelope without authenticated headers")
+	}
+	e.HeadersAuthenticated = true
+	if !bytes.Equal(e.additionalData(), data) {
+		t.Error("expecting the additional data of the headers")
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	root := useFakeBackend(t)
+	for _, test := range []struct {
+		name   string
+		file   string
+		path   string
+		reject bool
+	}{
+		{"same path", "config/secret.yaml.enc", "config/secret.yaml", false},
+		{"other path", "config/secret.yaml.enc", "config/other.yaml", true},
+		{"no path", "config/secret.yaml.enc", "", false},
+		{"fragment", "config/secret.yaml.enc.d/database.enc", "config/secret.yaml", false},
+		{"fragment of another file", "config/secret.yaml.enc.d/database.enc", "config/other.yaml", true},
+		{"bundle", "backup.sbundle", "old/backup.sbundle", false},
+		{"outside the project", "../elsewhere.yaml.enc", "elsewhere.yaml", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkPath(filepath.Join(root, filepath.FromSlash(test.file)), &envelope{Path: test.path})
+			if (err != nil) != test.reject {
+				t.Errorf("expecting rejected %t, got %v", test.reject, err)
+			}
+		})
+	}
+}
+
+func TestDecryptBytesRejectsBrokenFilesBeforeKms(t *testing.T) {
+	valid := string((&envelope{Key: testKey, Ciphertext: []byte("ciphertext")}).marshal())
+	for _, test := range []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"empty", "", errEmptyCiphertext},
+		{"blank", " \n\n", errEmptyCiphertext},
+		{"conflict markers", "<<<<<<< ours\n" + valid + "=======\n" + valid + ">>>>>>> theirs\n", errMergeConflictMarkers},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			backend := &missingKeyBackend{}
+			useFakeBackend(t)
+			kmsSelected = backend
+			if _, _, err := decryptBytes(testKey, []byte(test.data)); !errors.Is(err, test.err) {
+				t.Errorf("expecting %v, got %v", test.err, err)
+			}
+			if backend.calls > 0 {
+				t.Errorf("expecting no KMS call, got %d", backend.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// env-file turns sealed files into a docker-compose env_file, so local
+// container stacks get project secrets without plaintext in the repository.
+// Dotenv files keep their variables, Kubernetes Secrets give one variable per
+// key, and other YAML files one per scalar, named after its path in the file.
+
+var envNameCharacters = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+func envName(parts []string) string {
+	name := strings.ToUpper(envNameCharacters.ReplaceAllString(strings.Join(parts, "_"), "_"))
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// envVars are the variables a decrypted file stands for.
+func envVars(filePath string, plaintext []byte) ([]envVar, error) {
+	fields, err := secretFields(filePath, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	vars := make([]envVar, 0, len(fields))
+	for _, field := range fields {
+		vars = append(vars, envVar{envName(field.Path), field.Value})
+	}
+	return vars, nil
+}
+
+// quoteEnvValue writes a value so that docker compose reads it back as is:
+// single quoted when that suffices, as nothing is interpolated in those, and
+// otherwise double quoted with escapes.
+func quoteEnvValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\r'\"#$\\=`") {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "$", "$$")
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func envFile(files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no sealed files to turn into an env_file")
+	}
+	names := map[string]string{}
+	var b strings.Builder
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		plaintext, _, err := openBytes(file, data)
+		recordAudit(commandName, file, err)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		vars, err := envVars(file, plaintext)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, v := range vars {
+			if previous, ok := names[v.Name]; ok && previous != file {
+				errPrintln("Warning: %s from %s is set again by %s, which wins", v.Name, previous, file)
+			}
+			names[v.Name] = file
+			fmt.Fprintf(&b, "%s=%s\n", v.Name, quoteEnvValue(v.Value))
+		}
+	}
+	if dryRun {
+		printProgress("would write %d variable(s) to %s", len(names), valueOrNone(outPath))
+		return nil
+	}
+	if outPath == "" {
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, []byte(b.String()), 0600)
+}
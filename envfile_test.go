@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	for _, test := range []struct {
+		parts []string
+		name  string
+	}{
+		{[]string{"database", "password"}, "DATABASE_PASSWORD"},
+		{[]string{"api-key"}, "API_KEY"},
+		{[]string{"smtp.host", "0"}, "SMTP_HOST_0"},
+		{[]string{"3scale", "token"}, "_3SCALE_TOKEN"},
+	} {
+		if name := envName(test.parts); name != test.name {
+			t.Errorf("%q: expecting %s, got %s", test.parts, test.name, name)
+		}
+	}
+}
+
+func TestQuoteEnvValue(t *testing.T) {
+	for value, quoted := range map[string]string{
+		"hunter2":        "hunter2",
+		"":               "''",
+		"with space":     "'with space'",
+		"$HOME#x":        "'$HOME#x'",
+		"it's":           `"it's"`,
+		"two\nlines $x":  `"two\nlines $$x"`,
+		`it's "quoted"\`: `"it's \"quoted\"\\"`,
+	} {
+		if got := quoteEnvValue(value); got != quoted {
+			t.Errorf("%q: expecting %s, got %s", value, quoted, got)
+		}
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	root := useFakeBackend(t)
+	files := []string{}
+	for _, test := range []struct {
+		name    string
+		content string
+	}{
+		{"app.env", "API_KEY=abc\nDATABASE_PASSWORD=old\n"},
+		{"db-secret.yaml", "kind: Secret\ndata:\n  database-password: aHVudGVyIDI=\nstringData:\n  user: app\n"},
+		{"smtp-secret.yaml", "smtp:\n  host: mail.example.com\n"},
+	} {
+		file := filepath.Join(root, test.name)
+		writeTestFile(t, file, []byte(test.content), 0600)
+		if err := encrypt(testKey, file); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file+".enc")
+	}
+	outPath = filepath.Join(t.TempDir(), "compose", ".env")
+	defer func() { outPath = "" }()
+	warnings := captureStderr(t, func() {
+		if err := envFile(files); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(warnings, "DATABASE_PASSWORD from "+files[0]+" is set again by "+files[1]) {
+		t.Errorf("expecting a warning about DATABASE_PASSWORD, got %q", warnings)
+	}
+	expected := "API_KEY=abc\nDATABASE_PASSWORD=old\nDATABASE_PASSWORD='hunter 2'\nUSER=app\nSMTP_HOST=mail.example.com\n"
+	if data, err := os.ReadFile(outPath); err != nil || string(data) != expected {
+		t.Errorf("expecting %q, got %q (%v)", expected, data, err)
+	}
+	if info, err := os.Stat(outPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expecting the env_file with mode 0600, got %v (%v)", info.Mode().Perm(), err)
+	}
+}
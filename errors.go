@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+)
+
+// Errors callers branch on with errors.Is. KMS errors of gcloud and of the
+// REST API match ErrKeyNotFound and ErrPermissionDenied by their status, so
+// nothing needs to look for NOT_FOUND in messages.
+
+var ErrKeyNotFound = errors.New("key not found")
+var ErrPermissionDenied = errors.New("permission denied")
+var ErrNotEncFile = errors.New("not a .enc file")
+var ErrPlaintextTracked = errors.New("plaintext file is tracked by git")
+
+// kmsStatusErrors are the errors KMS statuses match.
+var kmsStatusErrors = map[string]error{
+	"NOT_FOUND":         ErrKeyNotFound,
+	"PERMISSION_DENIED": ErrPermissionDenied,
+}
+
+// gcloudStatusPattern matches the line gcloud reports an API error with,
+// such as "ERROR: (gcloud.kms.decrypt) NOT_FOUND: CryptoKey ... not found.".
+var gcloudStatusPattern = regexp.MustCompile(`(?m)^ERROR: \([^)]*\) ([A-Z]+(?:_[A-Z]+)*):`)
+
+// status is the status of the API error gcloud failed with, if any.
+func (e *gcloudError) status() string {
+	if match := gcloudStatusPattern.FindStringSubmatch(e.stdErr); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+func (e *gcloudError) Is(target error) bool {
+	err, ok := kmsStatusErrors[e.status()]
+	return ok && target == err
+}
+
+func (e *kmsAPIError) Is(target error) bool {
+	err, ok := kmsStatusErrors[e.Status]
+	return ok && target == err
+}
+
+func (e *permissionDeniedError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestKmsErrorsMatchByStatus(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		err      error
+		notFound bool
+		denied   bool
+	}{
+		{"gcloud not found", &gcloudError{stdErr: "ERROR: (gcloud.kms.encrypt) NOT_FOUND: CryptoKey projects/p/locations/global/keyRings/r/cryptoKeys/k not found.\n"}, true, false},
+		{"gcloud denied", &gcloudError{stdErr: "ERROR: (gcloud.kms.decrypt) PERMISSION_DENIED: Permission 'cloudkms.cryptoKeyVersions.useToDecrypt' denied on resource 'k' (or it may not exist).\n"}, false, true},
+		{"gcloud status in a message", &gcloudError{stdErr: "ERROR: (gcloud.kms.decrypt) INVALID_ARGUMENT: key NOT_FOUND: PERMISSION_DENIED\n"}, false, false},
+		{"gcloud without status", &gcloudError{stdErr: "ERROR: gcloud crashed (NOT_FOUND): oops\n"}, false, false},
+		{"API not found", &kmsAPIError{Status: "NOT_FOUND"}, true, false},
+		{"API denied", fmt.Errorf("decrypting: %w", &kmsAPIError{Status: "PERMISSION_DENIED"}), false, true},
+		{"API unavailable", &kmsAPIError{Status: "UNAVAILABLE"}, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if notFound := errors.Is(test.err, ErrKeyNotFound); notFound != test.notFound {
+				t.Errorf("expecting errors.Is(err, ErrKeyNotFound) %v, got %v", test.notFound, notFound)
+			}
+			if denied := errors.Is(test.err, ErrPermissionDenied); denied != test.denied {
+				t.Errorf("expecting errors.Is(err, ErrPermissionDenied) %v, got %v", test.denied, denied)
+			}
+		})
+	}
+}
+
+func TestRecordedErrorKeepsGcloudStatus(t *testing.T) {
+	recorded := recordedError(&gcloudError{stdErr: "ERROR: (gcloud.kms.keys.describe) NOT_FOUND: Key not found.\n"})
+	if recorded.Status != "NOT_FOUND" {
+		t.Errorf("expecting NOT_FOUND, got %q", recorded.Status)
+	}
+}
+
+// TestDecryptReturnsErrNotEncFile checks that decrypt returns, rather than
+// exits, on a file without the .enc suffix.
+func TestDecryptReturnsErrNotEncFile(t *testing.T) {
+	root := useFakeBackend(t)
+	if err := decrypt(testKey, filepath.Join(root, "secret.yaml")); !errors.Is(err, ErrNotEncFile) {
+		t.Errorf("expecting ErrNotEncFile, got %v", err)
+	}
+}
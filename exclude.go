@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// excludeFlag collects repeated --exclude globs, relative to the project
+// root.
+type excludeFlag []string
+
+var excludes excludeFlag
+
+func (e *excludeFlag) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *excludeFlag) Set(value string) error {
+	if err := checkExclude(value); err != nil {
+		return err
+	}
+	*e = append(*e, value)
+	return nil
+}
+
+func checkExclude(pattern string) error {
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return fmt.Errorf("invalid exclude %q: %w", pattern, err)
+	}
+	return nil
+}
+
+func parseExcludes(file string, node *yamlNode) ([]string, error) {
+	patterns := node.strings()
+	for _, pattern := range patterns {
+		if err := checkExclude(pattern); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return patterns, nil
+}
+
+// matchExclude matches a file or folder against an exclude. Excludes without
+// a slash match names at any depth, as in .gitignore, and the others match
+// the path relative to where they are declared.
+func matchExclude(pattern string, relativePath string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relativePath))
+		return ok
+	}
+	return matchGlob(strings.TrimPrefix(pattern, "/"), relativePath)
+}
+
+func matchExcludes(patterns []string, dir string, file string) (string, bool) {
+	relativePath, err := filepath.Rel(dir, file)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return "", false
+	}
+	for _, pattern := range patterns {
+		if matchExclude(pattern, filepath.ToSlash(relativePath)) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// excludedBy is the --exclude, .secrets.yaml exclude or .secretsignore that
+// keeps discovery away from file, if any.
+func excludedBy(file string, isDir bool) (string, bool) {
+	root, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return "", false
+	}
+	if pattern, ok := matchExcludes(excludes, root, file); ok {
+		return "--exclude " + pattern, true
+	}
+	config, err := configFor(filepath.Dir(file))
+	if err != nil {
+		return "", false
+	}
+	for ; config != nil; config = config.parent {
+		if pattern, ok := matchExcludes(config.Excludes, config.dir, file); ok {
+			return fmt.Sprintf("%s exclude %s", config.file, pattern), true
+		}
+	}
+	return secretsIgnored(file, isDir)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMatchExclude(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"fixtures", "fixtures", true},
+		{"fixtures", "app/fixtures", true},
+		{"fixtures/", "app/fixtures", true},
+		{"*.example.yaml", "app/secret.example.yaml", true},
+		{"/fixtures", "app/fixtures", false},
+		{"app/fixtures", "app/fixtures", true},
+		{"app/fixtures", "other/app/fixtures", false},
+		{"app/**/secret.yaml", "app/a/b/secret.yaml", true},
+		{"app/**/secret.yaml", "lib/a/secret.yaml", false},
+	} {
+		if match := matchExclude(test.pattern, test.path); match != test.match {
+			t.Errorf("%q on %q: expecting %v, got %v", test.pattern, test.path, test.match, match)
+		}
+	}
+}
+
+func TestExcludeFlagRejectsInvalidGlobs(t *testing.T) {
+	e := excludeFlag{}
+	if err := e.Set("[fixtures"); err == nil || !strings.Contains(err.Error(), "invalid exclude") {
+		t.Errorf("expecting an invalid exclude error, got %v", err)
+	}
+	if err := parseConfig(configFileName, []byte("exclude:\n  - '[fixtures'\n"), &secretsConfig{}); err == nil || !strings.Contains(err.Error(), "invalid exclude") {
+		t.Errorf("expecting an invalid exclude error from the configuration, got %v", err)
+	}
+}
+
+func TestFindFilesSkipsExcludes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		excludes excludeFlag
+		configs  map[string]string
+		found    []string
+	}{
+		{"nothing excluded", nil, nil, []string{"app/fixtures/secret.yaml", "app/secret.yaml", "fixtures/secret.yaml", "lib/secret.yaml"}},
+		{"--exclude", excludeFlag{"fixtures"}, nil, []string{"app/secret.yaml", "lib/secret.yaml"}},
+		{"--exclude path", excludeFlag{"lib/secret.yaml"}, nil, []string{"app/fixtures/secret.yaml", "app/secret.yaml", "fixtures/secret.yaml"}},
+		{"configuration", nil, map[string]string{"app": "exclude:\n  - fixtures\n"}, []string{"app/secret.yaml", "fixtures/secret.yaml", "lib/secret.yaml"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			makeTree(t, root, "app/secret.yaml", "app/fixtures/secret.yaml", "fixtures/secret.yaml", "lib/secret.yaml")
+			writeConfigs(t, root, test.configs)
+			excludes = test.excludes
+			defer func() { excludes = nil }()
+			found, err := findFiles(root, *regexp.MustCompile(`secret\.yaml$`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if relative := relativePaths(t, root, found); !reflect.DeepEqual(relative, test.found) {
+				t.Errorf("expecting %q, got %q", test.found, relative)
+			}
+		})
+	}
+}
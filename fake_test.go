@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKey string = "projects/fake-project/locations/global/keyRings/secrets/cryptoKeys/test"
+
+// useFakeBackend points KMS calls at the fake backend, and the project root
+// and caches at a temporary directory, for the duration of the test.
+func useFakeBackend(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	root := filepath.Join(dir, "project")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	kmsOnce.Do(func() {})
+	previousBackend, previousRoot, previousKeyRing := kmsSelected, projectRoot, keyRing
+	kmsSelected, kmsSelectErr = &fakeBackend{}, nil
+	projectRoot, keyRing, sealedContents = root, defaultKeyRing, nil
+	describedKeys, listedKeyVersions = map[string]*kmsKey{}, map[string][]kmsKeyVersion{}
+	t.Cleanup(func() {
+		kmsSelected, projectRoot, keyRing, sealedContents = previousBackend, previousRoot, previousKeyRing, nil
+	})
+	return root
+}
+
+func writeTestFile(t *testing.T, file string, content []byte, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(file, content, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// initGitRepo makes root a git work tree, for the tests of what git reports.
+func initGitRepo(t *testing.T, root string) {
+	t.Helper()
+	if output, err := exec.Command("git", "-C", root, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %s", output)
+	}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content []byte
+	}{
+		{"small", []byte("password: hunter2\n")},
+		{"data key", bytes.Repeat([]byte("0123456789abcdef"), 8192)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			plaintextFile := filepath.Join(root, "secret.yaml")
+			writeTestFile(t, plaintextFile, test.content, 0600)
+			if err := encrypt(testKey, plaintextFile); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(plaintextFile + ".enc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, test.content[:16]) {
+				t.Fatal("the .enc holds the plaintext")
+			}
+			e, err := parseEnvelope(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Path != "secret.yaml" || e.Mode != 0600 || e.Key != testKey || e.PlaintextHash != "" {
+				t.Errorf("unexpected envelope headers %+v", e)
+			}
+			if err := os.Remove(plaintextFile); err != nil {
+				t.Fatal(err)
+			}
+			if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+				t.Fatal(err)
+			}
+			opened, err := os.ReadFile(plaintextFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, test.content) {
+				t.Error("the opened file differs from the sealed one")
+			}
+			if info, err := os.Stat(plaintextFile); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("expecting the opened file with mode 0600, got %v (%v)", info.Mode().Perm(), err)
+			}
+		})
+	}
+}
+
+func TestSealSkipsUpToDateFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(plaintextFile + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if resealed, _ := os.ReadFile(plaintextFile + ".enc"); !bytes.Equal(sealed, resealed) {
+		t.Error("sealing an unchanged file rewrote its .enc")
+	}
+	writeTestFile(t, plaintextFile, []byte("token: def\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if resealed, _ := os.ReadFile(plaintextFile + ".enc"); bytes.Equal(sealed, resealed) {
+		t.Error("sealing a changed file kept its .enc")
+	}
+}
+
+func TestResealKeepsContentAndHeaders(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "deploy.sh")
+	content := bytes.Repeat([]byte("echo secret\n"), 10000)
+	writeTestFile(t, plaintextFile, content, 0750)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	ciphertextFile := plaintextFile + ".enc"
+	sealed := readEnvelope(ciphertextFile)
+	force = true
+	defer func() { force = false }()
+	if err := reseal(testKey, ciphertextFile); err != nil {
+		t.Fatal(err)
+	}
+	resealed := readEnvelope(ciphertextFile)
+	if bytes.Equal(sealed.WrappedKey, resealed.WrappedKey) {
+		t.Error("reseal kept the data key")
+	}
+	if resealed.Path != sealed.Path || resealed.Mode != sealed.Mode || !resealed.ModifiedAt.Equal(sealed.ModifiedAt) {
+		t.Errorf("reseal changed the headers from %+v to %+v", sealed, resealed)
+	}
+	if err := os.Remove(plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(testKey, ciphertextFile); err != nil {
+		t.Fatal(err)
+	}
+	if opened, _ := os.ReadFile(plaintextFile); !bytes.Equal(opened, content) {
+		t.Error("the resealed file opens to other content")
+	}
+}
+
+func TestOpenRejectsEditedHeaders(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, bytes.Repeat([]byte("a"), 100000), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	ciphertextFile := plaintextFile + ".enc"
+	data, err := os.ReadFile(ciphertextFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, ciphertextFile, []byte(strings.Replace(string(data), "Mode: 0600", "Mode: 0666", 1)), 0644)
+	if err := decrypt(testKey, ciphertextFile); err == nil {
+		t.Error("opened a file whose Mode header was edited")
+	}
+}
+
+func TestUnauthenticatedModeKeepsOwnerBits(t *testing.T) {
+	useFakeBackend(t)
+	e := &envelope{Mode: 0666}
+	if mode := plaintextMode("secret.yaml.enc", e); mode != 0600 {
+		t.Errorf("expecting 0600, got %04o", mode)
+	}
+	e.HeadersAuthenticated = true
+	if mode := plaintextMode("secret.yaml.enc", e); mode != 0666 {
+		t.Errorf("expecting 0666, got %04o", mode)
+	}
+}
+
+func TestFakeBackendRejectsOtherKeys(t *testing.T) {
+	f := &fakeBackend{}
+	ciphertext, err := f.encrypt("one", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := f.decrypt("one", ciphertext); err != nil || string(plaintext) != "secret" {
+		t.Errorf("expecting secret, got %q (%v)", plaintext, err)
+	}
+	if _, err := f.decrypt("two", ciphertext); err == nil {
+		t.Error("decrypted with another key")
+	}
+}
+
+func TestOpenRejectsFilesSealedAsAnother(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content []byte
+	}{
+		{"small", []byte("password: hunter2\n")},
+		{"data key", bytes.Repeat([]byte("0123456789abcdef"), 8192)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			one, two := filepath.Join(root, "one.yaml"), filepath.Join(root, "two.yaml")
+			writeTestFile(t, one, test.content, 0600)
+			writeTestFile(t, two, []byte("other: value\n"), 0600)
+			for _, file := range []string{one, two} {
+				if err := encrypt(testKey, file); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data, err := os.ReadFile(one + ".enc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, two+".enc", data, 0644)
+			err = decrypt(testKey, two+".enc")
+			if err == nil || !strings.Contains(err.Error(), "sealed as one.yaml") {
+				t.Errorf("expecting the copy of one.yaml.enc rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSealOutDirSealsAsTheOutputPath(t *testing.T) {
+	root := useFakeBackend(t)
+	outDir = filepath.Join(root, "out")
+	defer func() { outDir = "" }()
+	plaintextFile := filepath.Join(root, "config", "secret.yaml")
+	if err := os.MkdirAll(filepath.Dir(plaintextFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	outDir = ""
+	ciphertextFile := filepath.Join(root, "out", "config", "secret.yaml.enc")
+	if e := readEnvelope(ciphertextFile); e == nil || e.Path != "out/config/secret.yaml" {
+		t.Fatalf("expecting the .enc sealed as out/config/secret.yaml, got %+v", e)
+	}
+	if err := decrypt(testKey, ciphertextFile); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestForceSealsUpToDateFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("password: hunter2\n"), 0600)
+	if err := sealFile(plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { force = false }()
+	for _, test := range []struct {
+		force    bool
+		resealed bool
+	}{
+		{false, false},
+		{true, true},
+	} {
+		force = test.force
+		p, err := planSeal([]string{plaintextFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if planned := len(p.Files) == 1; planned != test.resealed {
+			t.Errorf("force %t: expecting sealing planned %t, got %+v", test.force, test.resealed, p.Files)
+		}
+		output := captureStdout(t, func() {
+			if err := sealFile(plaintextFile); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if resealed := !strings.Contains(output, "already up to date"); resealed != test.resealed {
+			t.Errorf("force %t: expecting it sealed again %t, got %q", test.force, test.resealed, output)
+		}
+	}
+}
+
+func TestOpenBackup(t *testing.T) {
+	root := useGitProject(t, "origin", "git@github.com:jobbatical/app.git")
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("password: hunter2\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	backup = true
+	defer func() { backup = false }()
+	for _, test := range []struct {
+		content string
+		backups int
+	}{
+		{"password: hunter2\n", 0},
+		{"password: changed\n", 1},
+	} {
+		writeTestFile(t, plaintextFile, []byte(test.content), 0640)
+		captureStdout(t, func() {
+			if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+				t.Fatal(err)
+			}
+		})
+		backups, err := filepath.Glob(plaintextFile + ".bak.*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != test.backups {
+			t.Fatalf("%q: expecting %d backups, got %q", test.content, test.backups, backups)
+		}
+		for _, backupFile := range backups {
+			data, err := os.ReadFile(backupFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(backupFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test.content || info.Mode().Perm() != 0640 {
+				t.Errorf("expecting the backup to hold %q with mode 0640, got %q with %04o", test.content, data, info.Mode().Perm())
+			}
+		}
+	}
+	gitIgnore, err := os.ReadFile(filepath.Join(root, ".gitignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(gitIgnore), "\n*.bak.[0-9]*T[0-9]*Z\n") {
+		t.Errorf("expecting backups kept out of git, got %q", gitIgnore)
+	}
+}
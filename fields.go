@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// secretField is one value of a secret file and where it is in the file,
+// the common ground between sealed files and the places secrets are copied
+// to or from: environment variables, password managers and CI settings.
+type secretField struct {
+	Path  []string
+	Value string
+}
+
+func (f secretField) name(separator string) string {
+	return strings.Join(f.Path, separator)
+}
+
+func collectFields(n *yamlNode, path []string, fields []secretField) []secretField {
+	switch {
+	case n == nil:
+		return fields
+	case n.kind == yamlScalar && strings.HasPrefix(strings.TrimSpace(n.raw), "["):
+		for i, value := range n.strings() {
+			fields = append(fields, secretField{append(path[:len(path):len(path)], strconv.Itoa(i)), value})
+		}
+	case n.kind == yamlScalar:
+		return append(fields, secretField{path, n.value()})
+	case n.kind == yamlMapping:
+		for i, key := range n.keys {
+			fields = collectFields(n.values[i], append(path[:len(path):len(path)], yamlUnquote(key)), fields)
+		}
+	default:
+		for i, value := range n.values {
+			fields = collectFields(value, append(path[:len(path):len(path)], strconv.Itoa(i)), fields)
+		}
+	}
+	return fields
+}
+
+// secretFields are the values of a decrypted file: the variables of a dotenv
+// file, the keys of a Kubernetes Secret, or every value of another YAML file.
+func secretFields(filePath string, plaintext []byte) ([]secretField, error) {
+	if isDotenvFile(filePath) {
+		fields := []secretField{}
+		for _, v := range parseDotenv(plaintext) {
+			fields = append(fields, secretField{[]string{v.Name}, v.Value})
+		}
+		return fields, nil
+	}
+	if !regexp.MustCompile(`\.(yaml|yml)(\.enc)?$`).MatchString(filePath) {
+		return nil, fmt.Errorf("can't split %s into values, expecting a dotenv or YAML file", filepath.Base(filePath))
+	}
+	documents, err := parseYAMLDocuments(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	fields := []secretField{}
+	for _, document := range documents {
+		if document.get("kind").value() != "Secret" {
+			fields = collectFields(document, nil, fields)
+			continue
+		}
+		if data := document.get("data"); data != nil {
+			for i, key := range data.keys {
+				decoded, err := base64.StdEncoding.DecodeString(data.values[i].value())
+				if err != nil {
+					return nil, fmt.Errorf("data %s is not base64: %w", yamlUnquote(key), err)
+				}
+				fields = append(fields, secretField{[]string{yamlUnquote(key)}, string(decoded)})
+			}
+		}
+		if stringData := document.get("stringData"); stringData != nil {
+			for i, key := range stringData.keys {
+				fields = append(fields, secretField{[]string{yamlUnquote(key)}, stringData.values[i].value()})
+			}
+		}
+	}
+	for _, field := range fields {
+		if len(field.Path) == 0 {
+			return nil, fmt.Errorf("expecting named values, not a single value")
+		}
+	}
+	return fields, nil
+}
+
+// fieldsYAML writes fields as a YAML file, nesting them by path.
+func fieldsYAML(fields []secretField) []byte {
+	root := &yamlNode{kind: yamlMapping}
+	for _, field := range fields {
+		n := root
+		for _, part := range field.Path[:len(field.Path)-1] {
+			child := n.get(part)
+			if child == nil || child.kind != yamlMapping {
+				child = &yamlNode{kind: yamlMapping}
+				n.set(yamlQuote(part), child)
+			}
+			n = child
+		}
+		n.set(yamlQuote(field.Path[len(field.Path)-1]), newYAMLScalar(field.Value))
+	}
+	return []byte(root.String())
+}
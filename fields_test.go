@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSecretFields(t *testing.T) {
+	for _, test := range []struct {
+		file      string
+		plaintext string
+		fields    []secretField
+	}{
+		{"app.env", "API_KEY=abc\n", []secretField{{[]string{"API_KEY"}, "abc"}}},
+		{"db-secret.yaml", "kind: Secret\ndata:\n  password: aHVudGVyMg==\nstringData:\n  user: app\n", []secretField{{[]string{"password"}, "hunter2"}, {[]string{"user"}, "app"}}},
+		{"app-secret.yaml.enc", "smtp:\n  host: mail\n  ports: [25, 587]\n", []secretField{{[]string{"smtp", "host"}, "mail"}, {[]string{"smtp", "ports", "0"}, "25"}, {[]string{"smtp", "ports", "1"}, "587"}}},
+	} {
+		fields, err := secretFields(test.file, []byte(test.plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%s: expecting %q, got %q", test.file, test.fields, fields)
+		}
+	}
+}
+
+func TestSecretFieldsErrors(t *testing.T) {
+	for _, test := range []struct {
+		file      string
+		plaintext string
+		err       string
+	}{
+		{"cert.pem", "-----BEGIN", "expecting a dotenv or YAML file"},
+		{"db-secret.yaml", "kind: Secret\ndata:\n  password: not base64!\n", "data password is not base64"},
+		{"token-secret.yaml", "just a value\n", "expecting named values"},
+	} {
+		if _, err := secretFields(test.file, []byte(test.plaintext)); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expecting an error with %q, got %v", test.file, test.err, err)
+		}
+	}
+}
+
+func TestFieldsYAMLRoundTrip(t *testing.T) {
+	fields := []secretField{
+		{[]string{"database", "password"}, "hunter 2"},
+		{[]string{"database", "user"}, "app"},
+		{[]string{"api-key"}, "it's: #1"},
+	}
+	data := fieldsYAML(fields)
+	parsed, err := secretFields("app-secret.yaml", data)
+	if err != nil {
+		t.Fatalf("%q: %s", data, err)
+	}
+	if !reflect.DeepEqual(parsed, fields) {
+		t.Errorf("%q: expecting %q back, got %q", data, fields, parsed)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var findEncrypted bool
+var findPlaintext bool
+var findPattern string
+
+// find lists the files secrets would pick without being given any, so that
+// scripts use the same discovery as seal and open: .enc files as open and
+// status see them, and plaintext as seal sees it. --pattern replaces the
+// secret file names with a regular expression matched against paths.
+func find(root string) error {
+	if !findEncrypted && !findPlaintext {
+		findEncrypted, findPlaintext = true, true
+	}
+	files := []string{}
+	if findPattern != "" {
+		re, err := regexp.Compile(findPattern)
+		if err != nil {
+			return fmt.Errorf("invalid --pattern: %w", err)
+		}
+		found, err := findFiles(root, *re)
+		if err != nil {
+			return err
+		}
+		for _, file := range found {
+			if strings.HasSuffix(file, ".enc") && findEncrypted || !strings.HasSuffix(file, ".enc") && findPlaintext {
+				files = append(files, file)
+			}
+		}
+		return printPaths(files)
+	}
+	if findEncrypted {
+		found, err := findEncryptedFiles(root)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	if findPlaintext {
+		found, err := findUnencryptedFiles(root)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	sort.Strings(files)
+	return printPaths(files)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		encrypted bool
+		plaintext bool
+		pattern   string
+		output    string
+	}{
+		{"both by default", false, false, "", "app/secret.yaml\napp/secret.yaml.enc\nsecret.yml.enc\n"},
+		{"encrypted", true, false, "", "app/secret.yaml.enc\nsecret.yml.enc\n"},
+		{"plaintext", false, true, "", "app/secret.yaml\n"},
+		{"pattern", false, false, `\.json(\.enc)?$`, "config.json\nconfig.json.enc\n"},
+		{"encrypted pattern", true, false, `\.json(\.enc)?$`, "config.json.enc\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			makeTree(t, root, "app/secret.yaml", "app/secret.yaml.enc", "secret.yml.enc", "config.json", "config.json.enc", "notes.txt")
+			cwd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(root); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(cwd)
+			findEncrypted, findPlaintext, findPattern = test.encrypted, test.plaintext, test.pattern
+			defer func() {
+				findEncrypted, findPlaintext, findPattern = false, false, ""
+			}()
+			output := captureStdout(t, func() {
+				if err := find(root); err != nil {
+					t.Error(err)
+				}
+			})
+			if output != test.output {
+				t.Errorf("expecting %q, got %q", test.output, output)
+			}
+		})
+	}
+}
+
+func TestFindRejectsInvalidPattern(t *testing.T) {
+	root := useFakeBackend(t)
+	findPattern = "[secret"
+	defer func() {
+		findEncrypted, findPlaintext, findPattern = false, false, ""
+	}()
+	if err := find(root); err == nil || !strings.Contains(err.Error(), "invalid --pattern") {
+		t.Errorf("expecting an invalid --pattern error, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type gcloudError struct {
+	err    error
+	stdErr string
+}
+
+func (e *gcloudError) Error() string {
+	return fmt.Sprintf("gcloud command failed: %s", strings.TrimSpace(e.stdErr))
+}
+
+func (e *gcloudError) Unwrap() error {
+	return e.err
+}
+
+// gcloudBackend runs one gcloud process per call, using whatever account
+// gcloud is logged in with.
+type gcloudBackend struct {
+	projectOnce sync.Once
+	project     string
+	projectErr  error
+}
+
+func keyFlags(keyName string) []string {
+	if isKeyResourceName(keyName) {
+		return []string{"--key", keyName}
+	}
+	return []string{"--location", location, "--keyring", keyRing, "--key", keyName}
+}
+
+func (g *gcloudBackend) run(input []byte, args ...string) (string, error) {
+	if impersonateServiceAccount != "" {
+		args = append(args, "--impersonate-service-account", impersonateServiceAccount)
+	}
+	_, stdOut, stdErr, err := runCommandWithInput(input, "gcloud", args...)
+	if err != nil {
+		return "", &gcloudError{err, stdErr}
+	}
+	return stdOut, nil
+}
+
+func (g *gcloudBackend) call(operation string, keyName string, input []byte) ([]byte, error) {
+	args := append([]string{"kms", operation}, keyFlags(keyName)...)
+	args = append(args, "--plaintext-file", "-", "--ciphertext-file", "-")
+	output, err := g.run(input, args...)
+	return []byte(output), err
+}
+
+func (g *gcloudBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	return g.call("encrypt", keyName, plaintext)
+}
+
+func (g *gcloudBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	return g.call("decrypt", keyName, ciphertext)
+}
+
+func (g *gcloudBackend) describeKey(keyName string) (*kmsKey, error) {
+	args := []string{"kms", "keys", "describe", keyName, "--format", "json"}
+	if !isKeyResourceName(keyName) {
+		args = append(args, "--location", location, "--keyring", keyRing)
+	}
+	output, err := g.run(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	k := &kmsKey{}
+	if err := json.Unmarshal([]byte(output), k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (g *gcloudBackend) listKeyVersions(keyName string) ([]kmsKeyVersion, error) {
+	args := append([]string{"kms", "keys", "versions", "list", "--format", "json"}, keyFlags(keyName)...)
+	output, err := g.run(nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	versions := []kmsKeyVersion{}
+	if err := json.Unmarshal([]byte(output), &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (g *gcloudBackend) createKey(keyName string, params *keyCreation) error {
+	args := []string{
+		"kms",
+		"keys",
+		"create", keyName,
+		"--purpose", "encryption",
+		"--protection-level", params.ProtectionLevel,
+	}
+	if params.RotationPeriod > 0 {
+		args = append(args,
+			"--rotation-period", fmt.Sprintf("%ds", int(params.RotationPeriod.Seconds())),
+			"--next-rotation-time", params.NextRotationTime.UTC().Format(time.RFC3339),
+		)
+	}
+	if len(params.Labels) > 0 {
+		args = append(args, "--labels", formatLabels(params.Labels))
+	}
+	if !isKeyResourceName(keyName) {
+		args = append(args, "--location", location, "--keyring", keyRing)
+	}
+	_, err := g.run(nil, args...)
+	return err
+}
+
+func (g *gcloudBackend) accessToken() (string, time.Duration, error) {
+	output, err := g.run(nil, "auth", "print-access-token")
+	return strings.TrimSpace(output), 10 * time.Minute, err
+}
+
+func (g *gcloudBackend) createKeyRing(keyRingName string) error {
+	_, err := g.run(nil, "kms", "keyrings", "create", keyRingName)
+	return err
+}
+
+func (g *gcloudBackend) getIAMPolicy(args ...string) (*iamPolicy, error) {
+	output, err := g.run(nil, append(args, "--format", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	policy := &iamPolicy{}
+	if err := json.Unmarshal([]byte(output), policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (g *gcloudBackend) keyIAMPolicy(keyName string) (*iamPolicy, error) {
+	args := []string{"kms", "keys", "get-iam-policy", keyName}
+	if !isKeyResourceName(keyName) {
+		args = append(args, "--location", location, "--keyring", keyRing)
+	}
+	return g.getIAMPolicy(args...)
+}
+
+func (g *gcloudBackend) keyRingIAMPolicy(keyName string) (*iamPolicy, error) {
+	if isKeyResourceName(keyName) {
+		return g.getIAMPolicy("kms", "keyrings", "get-iam-policy", keyRingOf(keyName))
+	}
+	return g.getIAMPolicy("kms", "keyrings", "get-iam-policy", keyRing, "--location", location)
+}
+
+func (g *gcloudBackend) asymmetricSign(versionName string, digestAlgorithm string, message []byte) ([]byte, error) {
+	output, err := g.run(message,
+		"kms", "asymmetric-sign",
+		"--version", versionName,
+		"--digest-algorithm", digestAlgorithm,
+		"--input-file", "-",
+		"--signature-file", "-",
+	)
+	return []byte(output), err
+}
+
+func (g *gcloudBackend) publicKey(versionName string) (*kmsPublicKey, error) {
+	output, err := g.run(nil, "kms", "keys", "versions", "describe", versionName, "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	k := &kmsPublicKey{}
+	if err := json.Unmarshal([]byte(output), k); err != nil {
+		return nil, err
+	}
+	k.Pem, err = g.run(nil, "kms", "keys", "versions", "get-public-key", versionName, "--output-file", "-")
+	return k, err
+}
+
+// identity is the account gcloud is logged in with.
+func (g *gcloudBackend) identity() (string, error) {
+	output, err := g.run(nil, "config", "get-value", "account")
+	return strings.TrimSpace(output), err
+}
+
+// resourceName resolves a key name in the project gcloud is configured with.
+func (g *gcloudBackend) resourceName(keyName string) (string, error) {
+	if isKeyResourceName(keyName) {
+		return keyName, nil
+	}
+	g.projectOnce.Do(func() {
+		var output string
+		output, g.projectErr = g.run(nil, "config", "get-value", "project")
+		g.project = strings.TrimSpace(output)
+	})
+	if g.projectErr != nil {
+		return "", g.projectErr
+	}
+	if g.project == "" {
+		return "", fmt.Errorf("no project for key %s, run `gcloud config set project <project>` or pass a full key resource name with --key", keyName)
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", g.project, location, keyRing, keyName), nil
+}
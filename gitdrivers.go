@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gitDriverName       string = "secrets"
+	lockMergeDriverName string = "secrets-lock"
+)
+
+var gitAttributesEntries = []string{
+	"*.enc diff=" + gitDriverName + " merge=" + gitDriverName,
+	"/" + lockFileName + " merge=" + lockMergeDriverName,
+}
+
+// gitDriverConfig is the local git config the .gitattributes entries rely
+// on. textconv output is deliberately not cached, as git would store the
+// plaintext in its notes.
+var gitDriverConfig = [][2]string{
+	{"diff." + gitDriverName + ".textconv", "secrets git-textconv"},
+	{"diff." + gitDriverName + ".cachetextconv", "false"},
+	{"merge." + gitDriverName + ".name", "secrets 3-way merge of encrypted files"},
+	{"merge." + gitDriverName + ".driver", "secrets git-merge %O %A %B %P"},
+	{"merge." + lockMergeDriverName + ".name", "secrets merge of " + lockFileName},
+	{"merge." + lockMergeDriverName + ".driver", "secrets git-merge-lock %O %A %B"},
+}
+
+func writeGitAttributes(projectRoot string) error {
+	gitAttributesPath := filepath.Join(projectRoot, ".gitattributes")
+	g, err := readManagedFile(gitAttributesPath)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, entry := range gitAttributesEntries {
+		if g.add(entry) {
+			printProgress("adding %s to %s", entry, gitAttributesPath)
+			changed = true
+		}
+	}
+	if !changed {
+		printProgress("%s is up to date", gitAttributesPath)
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	return g.write()
+}
+
+func configureGitDrivers(projectRoot string) error {
+	for _, setting := range gitDriverConfig {
+		printProgress("git config --local %s %q", setting[0], setting[1])
+		if dryRun {
+			continue
+		}
+		_, _, stdErr, err := runCommand("git", "-C", projectRoot, "config", "--local", setting[0], setting[1])
+		if err != nil {
+			return fmt.Errorf("git config failed: %s", strings.TrimSpace(stdErr))
+		}
+	}
+	return nil
+}
+
+// gitTextconv prints the plaintext of a .enc blob for `git diff`, once the
+// access policy and the policy allow opening the file it was sealed as. git
+// only gives the blob in a temporary file, so that is the path in its
+// envelope, if any.
+func gitTextconv(blobFile string) error {
+	data, err := os.ReadFile(blobFile)
+	if err != nil {
+		return err
+	}
+	file := blobFile
+	if e, err := parseEnvelope(data); err == nil && e.Path != "" && projectRoot != "" {
+		file = filepath.Join(projectRoot, filepath.FromSlash(e.Path)) + ".enc"
+	}
+	plaintext, _, err := openBytes(file, data)
+	recordAudit(commandName, file, err)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitTextconvChecksPolicyOfSealedPath(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "config", "secret.yaml")
+	if err := os.MkdirAll(filepath.Dir(plaintextFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, plaintextFile, []byte("password: hunter2\n"), 0600)
+	if err := encrypt(fileKey(plaintextFile), plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(plaintextFile + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobFile := filepath.Join(t.TempDir(), "blob")
+	writeTestFile(t, blobFile, data, 0600)
+	asked := usePolicyServer(t)
+	err = gitTextconv(blobFile)
+	if err == nil || !strings.Contains(err.Error(), "denied in tests") {
+		t.Errorf("expecting the policy to deny the diff, got %v", err)
+	}
+	if len(*asked) != 1 || (*asked)[0] != "config/secret.yaml" {
+		t.Errorf("expecting the policy asked about config/secret.yaml, got %v", *asked)
+	}
+}
+
+func TestWriteGitAttributes(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, ".gitattributes")
+	writeTestFile(t, file, []byte("*.png binary\n"), 0644)
+	expected := "*.png binary\n\n" + managedBlockStart + "\n" + strings.Join(gitAttributesEntries, "\n") + "\n" + managedBlockEnd + "\n"
+	for i := 0; i < 2; i++ {
+		if err := writeGitAttributes(root); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("run %d: expecting %q, got %q", i+1, expected, data)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Scripts that drive git with GIT_DIR and GIT_WORK_TREE, such as dotfiles
+// kept in a bare repository, get the work tree they point to as the project
+// root, as git would find it.
+
+var errBareRepository = errors.New("bare repositories have no files to seal or open: run secrets in a clone or worktree, or set GIT_WORK_TREE")
+
+// absoluteGitEnv makes GIT_DIR and GIT_WORK_TREE absolute, since git is run
+// with -C the project root, where relative ones would point elsewhere.
+func absoluteGitEnv() error {
+	for _, name := range []string{"GIT_DIR", "GIT_WORK_TREE"} {
+		value := os.Getenv(name)
+		if value == "" || filepath.IsAbs(value) {
+			continue
+		}
+		absolutePath, err := filepath.Abs(value)
+		if err != nil {
+			return err
+		}
+		if err := os.Setenv(name, absolutePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isGitEnv reports whether git is pointed at a repository by the environment.
+func isGitEnv() bool {
+	return os.Getenv("GIT_DIR") != "" || os.Getenv("GIT_WORK_TREE") != ""
+}
+
+// gitEnvWorkTree is the work tree that GIT_WORK_TREE or GIT_DIR point to, or
+// "" when neither is set.
+func gitEnvWorkTree() (string, error) {
+	if workTree := os.Getenv("GIT_WORK_TREE"); workTree != "" {
+		return filepath.Abs(workTree)
+	}
+	gitDir := os.Getenv("GIT_DIR")
+	if gitDir == "" {
+		return "", nil
+	}
+	_, stdOut, stdErr, err := runCommand("git", "rev-parse", "--is-bare-repository")
+	if err != nil {
+		return "", fmt.Errorf("GIT_DIR %s: %s", gitDir, strings.TrimSpace(stdErr))
+	}
+	if strings.TrimSpace(stdOut) == "true" {
+		return "", fmt.Errorf("GIT_DIR %s: %w", gitDir, errBareRepository)
+	}
+	_, stdOut, stdErr, err = runCommand("git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("GIT_DIR %s: %s", gitDir, strings.TrimSpace(stdErr))
+	}
+	return strings.TrimSpace(stdOut), nil
+}
+
+// isBareRepository reports whether path looks like a bare repository.
+func isBareRepository(path string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if !fileExists(filepath.Join(path, name)) {
+			return false
+		}
+	}
+	return !fileExists(filepath.Join(path, ".git"))
+}
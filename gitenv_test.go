@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestAbsoluteGitEnv(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_DIR", "dotfiles.git")
+	t.Setenv("GIT_WORK_TREE", "/home/dev")
+	if err := absoluteGitEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if gitDir := os.Getenv("GIT_DIR"); gitDir != filepath.Join(wd, "dotfiles.git") {
+		t.Errorf("expecting GIT_DIR made absolute, got %s", gitDir)
+	}
+	if workTree := os.Getenv("GIT_WORK_TREE"); workTree != "/home/dev" {
+		t.Errorf("expecting GIT_WORK_TREE kept, got %s", workTree)
+	}
+}
+
+func TestGitEnvWorkTree(t *testing.T) {
+	root := useFakeBackend(t)
+	bare := filepath.Join(root, "dotfiles.git")
+	if output, err := exec.Command("git", "init", "-q", "--bare", bare).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %s", output)
+	}
+	for _, name := range []string{"GIT_DIR", "GIT_WORK_TREE"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	if workTree, err := gitEnvWorkTree(); err != nil || workTree != "" {
+		t.Errorf("expecting no work tree without the environment, got %q, %v", workTree, err)
+	}
+	t.Setenv("GIT_DIR", bare)
+	if _, err := gitEnvWorkTree(); !errors.Is(err, errBareRepository) {
+		t.Errorf("expecting a bare repository error, got %v", err)
+	}
+	t.Setenv("GIT_WORK_TREE", root)
+	if workTree, err := gitEnvWorkTree(); err != nil || workTree != root {
+		t.Errorf("expecting the work tree %s, got %q, %v", root, workTree, err)
+	}
+}
+
+func TestFindProjectRoot(t *testing.T) {
+	root := useFakeBackend(t)
+	initGitRepo(t, root)
+	worktree := filepath.Join(t.TempDir(), "worktree")
+	if err := os.MkdirAll(worktree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(worktree, ".git"), []byte("gitdir: "+filepath.Join(root, ".git")+"\n"), 0644)
+	bare := filepath.Join(t.TempDir(), "dotfiles.git")
+	if output, err := exec.Command("git", "init", "-q", "--bare", bare).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %s", output)
+	}
+	for _, test := range []struct {
+		path string
+		root string
+		err  error
+	}{
+		{filepath.Join(root, "config"), root, nil},
+		{filepath.Join(root, ".git"), root, nil},
+		{filepath.Join(worktree, "config"), worktree, nil},
+		{filepath.Join(bare, "refs"), bare, errBareRepository},
+	} {
+		found, err := findProjectRoot(test.path)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expecting error %v, got %v", test.path, test.err, err)
+		}
+		if found != test.root {
+			t.Errorf("%s: expecting %s, got %s", test.path, test.root, found)
+		}
+	}
+}
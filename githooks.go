@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	postCheckoutHook string = "post-checkout"
+	postMergeHook    string = "post-merge"
+	prePushHook      string = "pre-push"
+	preCommitHook    string = "pre-commit"
+	nullRevision     string = "0000000000000000000000000000000000000000"
+)
+
+var gitHooks = []string{postCheckoutHook, postMergeHook, preCommitHook, prePushHook}
+
+// gitHookLine is what installGitHooks adds to each hook, inside the managed
+// block so that existing hooks keep working. Hooks are skipped on machines
+// without secrets installed, and only pre-commit and pre-push can fail.
+func gitHookLine(hook string) string {
+	if hook == preCommitHook || hook == prePushHook {
+		return fmt.Sprintf(`if command -v secrets >/dev/null 2>&1; then secrets git-hook %s "$@" || exit 1; fi`, hook)
+	}
+	return fmt.Sprintf(`if command -v secrets >/dev/null 2>&1; then secrets git-hook %s "$@" || true; fi`, hook)
+}
+
+// gitHooksDir honors core.hooksPath.
+func gitHooksDir(projectRoot string) (string, error) {
+	_, stdOut, stdErr, err := runCommand("git", "-C", projectRoot, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %s", strings.TrimSpace(stdErr))
+	}
+	dir := strings.TrimSpace(stdOut)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(projectRoot, dir)
+	}
+	return dir, nil
+}
+
+func installGitHooks(projectRoot string) error {
+	dir, err := gitHooksDir(projectRoot)
+	if err != nil {
+		return err
+	}
+	for _, hook := range gitHooks {
+		hookPath := filepath.Join(dir, hook)
+		g, err := readManagedFile(hookPath)
+		if err != nil {
+			return err
+		}
+		if len(g.before)+len(g.entries)+len(g.after) == 0 {
+			g.before = []string{"#!/bin/sh"}
+		}
+		g.entries = nil
+		g.add(gitHookLine(hook))
+		printProgress("installing %s hook in %s", hook, hookPath)
+		if dryRun {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := g.write(); err != nil {
+			return err
+		}
+		if err := os.Chmod(hookPath, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedEncryptedFiles lists the .enc files that differ between two
+// revisions, relative to the project root.
+func changedEncryptedFiles(projectRoot string, from string, to string) ([]string, error) {
+	_, stdOut, stdErr, err := runCommand("git", "-C", projectRoot, "diff", "--name-only", "-z", from, to, "--", "*.enc")
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %s", strings.TrimSpace(stdErr))
+	}
+	files := []string{}
+	for _, file := range strings.Split(stdOut, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// matchesRevision reports whether plaintextFile holds what the .enc held at
+// revision, so that replacing it loses nothing.
+func matchesRevision(projectRoot string, revision string, file string, plaintextFile string) bool {
+	plaintext, err := os.ReadFile(plaintextFile)
+	if err != nil {
+		return false
+	}
+	_, data, _, err := runCommand("git", "-C", projectRoot, "show", revision+":"+file)
+	if err != nil {
+		return false
+	}
+	if holdsPlaintext(filepath.Join(projectRoot, file), []byte(data), plaintext) {
+		return true
+	}
+	previous, _, err := decryptBytes(fileKey(plaintextFile), []byte(data))
+	return err == nil && string(previous) == string(plaintext)
+}
+
+// gitHook runs after a checkout or merge and opens the .enc files that it
+// changed, for those secrets that were already open. Plaintext edited
+// since it was opened is left alone. Before a commit or push it checks the
+// staged changes or the outgoing commits instead.
+func gitHook(projectRoot string, hook string, args []string) error {
+	var from string
+	switch hook {
+	case postCheckoutHook:
+		if len(args) != 3 {
+			return fmt.Errorf("%s hook expects the previous HEAD, the new HEAD and the checkout type from git", hook)
+		}
+		if args[2] != "1" || args[0] == args[1] || args[0] == nullRevision {
+			return nil
+		}
+		from = args[0]
+	case postMergeHook:
+		from = "ORIG_HEAD"
+	case preCommitHook:
+		return checkCommit(projectRoot)
+	case prePushHook:
+		return checkPush(projectRoot, os.Stdin)
+	default:
+		return fmt.Errorf("unknown git hook %q, expecting %s, %s, %s or %s", hook, postCheckoutHook, postMergeHook, preCommitHook, prePushHook)
+	}
+	changed, err := changedEncryptedFiles(projectRoot, from, "HEAD")
+	if err != nil {
+		return err
+	}
+	files := []string{}
+	for _, file := range changed {
+		ciphertextFile := filepath.Join(projectRoot, filepath.FromSlash(file))
+		plaintextFile := strings.TrimSuffix(ciphertextFile, ".enc")
+		if !fileExists(ciphertextFile) || !fileExists(plaintextFile) {
+			continue
+		}
+		if !matchesRevision(projectRoot, from, file, plaintextFile) {
+			errPrintln("Warning: not opening %s, its plaintext was changed since it was opened", file)
+			continue
+		}
+		files = append(files, ciphertextFile)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return forEachFile(decryptCmd, "decrypting", files, openFile)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitCommitAll commits every file under root and returns the commit.
+func gitCommitAll(t *testing.T, root string, message string) string {
+	t.Helper()
+	for _, args := range [][]string{
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", message},
+	} {
+		if output, err := exec.Command("git", append([]string{"-C", root}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %s", args[0], output)
+		}
+	}
+	output, err := exec.Command("git", "-C", root, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestInstallGitHooks(t *testing.T) {
+	root := useFakeBackend(t)
+	initGitRepo(t, root)
+	preCommit := filepath.Join(root, ".git", "hooks", preCommitHook)
+	writeTestFile(t, preCommit, []byte("#!/bin/sh\nmake lint\n"), 0755)
+	for i := 0; i < 2; i++ {
+		if err := installGitHooks(root); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, hook := range gitHooks {
+		data, err := os.ReadFile(filepath.Join(root, ".git", "hooks", hook))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := strings.Count(string(data), gitHookLine(hook)); count != 1 {
+			t.Errorf("%s: expecting the hook line once, got %d times in %q", hook, count, data)
+		}
+		if info, err := os.Stat(filepath.Join(root, ".git", "hooks", hook)); err != nil || info.Mode().Perm()&0100 == 0 {
+			t.Errorf("%s: expecting an executable hook, got %v (%v)", hook, info.Mode(), err)
+		}
+	}
+	if data, _ := os.ReadFile(preCommit); !strings.HasPrefix(string(data), "#!/bin/sh\nmake lint\n") {
+		t.Errorf("expecting the existing pre-commit hook kept, got %q", data)
+	}
+}
+
+func TestPostCheckoutOpensChangedFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	initGitRepo(t, root)
+	writeTestFile(t, filepath.Join(root, ".gitignore"), []byte("*secret.yaml\n"), 0644)
+	seal := func(file string, content string) {
+		writeTestFile(t, filepath.Join(root, file), []byte(content), 0600)
+		if err := sealFile(filepath.Join(root, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seal("opened-secret.yaml", "token: abc\n")
+	seal("edited-secret.yaml", "token: abc\n")
+	from := gitCommitAll(t, root, "first")
+	seal("opened-secret.yaml", "token: def\n")
+	seal("edited-secret.yaml", "token: def\n")
+	to := gitCommitAll(t, root, "second")
+	writeTestFile(t, filepath.Join(root, "opened-secret.yaml"), []byte("token: abc\n"), 0600)
+	writeTestFile(t, filepath.Join(root, "edited-secret.yaml"), []byte("token: edited\n"), 0600)
+	if err := gitHook(root, postCheckoutHook, []string{from, to, "1"}); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{
+		"opened-secret.yaml": "token: def\n",
+		"edited-secret.yaml": "token: edited\n",
+	} {
+		if data, _ := os.ReadFile(filepath.Join(root, file)); string(data) != content {
+			t.Errorf("%s: expecting %q, got %q", file, content, data)
+		}
+	}
+	if err := gitHook(root, postCheckoutHook, []string{from, to}); err == nil {
+		t.Error("expecting a post-checkout without its three arguments rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// githubStore writes values as GitHub Actions secrets of a repository, or of
+// one of its environments, named like environment variables. Secrets can't
+// be read back from GitHub, and secrets the files don't have are left alone.
+type githubStore struct {
+	environment string
+}
+
+func githubAPI() string {
+	if api := os.Getenv("GITHUB_API_URL"); api != "" {
+		return strings.TrimSuffix(api, "/")
+	}
+	return "https://api.github.com"
+}
+
+// githubToken is GITHUB_TOKEN or GH_TOKEN, or else the token of the gh CLI.
+func githubToken() (string, error) {
+	for _, name := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token, nil
+		}
+	}
+	_, stdOut, _, err := runCommand("gh", "auth", "token")
+	if err != nil || strings.TrimSpace(stdOut) == "" {
+		return "", errors.New("no GitHub token, set GITHUB_TOKEN or run gh auth login")
+	}
+	return strings.TrimSpace(stdOut), nil
+}
+
+func (g *githubStore) secretsURL(repo string) string {
+	if g.environment != "" {
+		return fmt.Sprintf("%s/repos/%s/environments/%s/secrets", githubAPI(), repo, url.PathEscape(g.environment))
+	}
+	return fmt.Sprintf("%s/repos/%s/actions/secrets", githubAPI(), repo)
+}
+
+func (g *githubStore) read(repo string) ([]secretField, error) {
+	return nil, errors.New("GitHub secrets can't be read back")
+}
+
+func (g *githubStore) write(repo string, fields []secretField) error {
+	token, err := githubToken()
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Authorization":        "Bearer " + token,
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+	var publicKey struct {
+		KeyID string `json:"key_id"`
+		Key   string `json:"key"`
+	}
+	if err := jsonRequest("GET", g.secretsURL(repo)+"/public-key", headers, nil, &publicKey); err != nil {
+		return err
+	}
+	recipientKey, err := base64.StdEncoding.DecodeString(publicKey.Key)
+	if err != nil {
+		return fmt.Errorf("bad public key from GitHub: %w", err)
+	}
+	for _, field := range fields {
+		name := envName(field.Path)
+		if strings.HasPrefix(name, "GITHUB_") {
+			return fmt.Errorf("%s can't be a GitHub secret, names can't start with GITHUB_", name)
+		}
+		sealed, err := sealBox([]byte(field.Value), recipientKey)
+		if err != nil {
+			return err
+		}
+		body := map[string]string{"encrypted_value": base64.StdEncoding.EncodeToString(sealed), "key_id": publicKey.KeyID}
+		if err := jsonRequest("PUT", g.secretsURL(repo)+"/"+name, headers, body, nil); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		printDebugln("set GitHub secret %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// nearestGitIgnore returns the closest existing .gitignore at or above the
+// file's directory, falling back to the one in the project root.
+func nearestGitIgnore(projectRoot string, filePath string) string {
+	dir := filepath.Dir(filePath)
+	for {
+		candidate := filepath.Join(dir, ".gitignore")
+		if fileExists(candidate) {
+			return candidate
+		}
+		if dir == projectRoot || !strings.HasPrefix(dir, projectRoot) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Join(projectRoot, ".gitignore")
+}
+
+var backupFilePattern = regexp.MustCompile(`\.bak\.[0-9]{8}T[0-9]{6}Z$`)
+
+// gitIgnorePattern is the pattern covering every file discovery would seal
+// alongside this one, or every backup of open --backup, or "" if the file
+// was named explicitly.
+func gitIgnorePattern(filePath string) string {
+	if backupFilePattern.MatchString(filePath) {
+		return "*.bak.[0-9]*T[0-9]*Z"
+	}
+	matches := regexp.MustCompile(`secret\.(yaml|yml)$`).FindStringSubmatch(filePath)
+	if matches == nil {
+		return ""
+	}
+	return "*secret." + matches[1]
+}
+
+func gitIgnoreEntry(gitIgnorePath string, filePath string) (string, error) {
+	relativePath, err := filepath.Rel(filepath.Dir(gitIgnorePath), filePath)
+	if err != nil {
+		return "", err
+	}
+	return "/" + escapeGitIgnore(filepath.ToSlash(relativePath)), nil
+}
+
+// plannedGitIgnoreEntry returns the .gitignore and entry that would keep
+// a file out of git, or empty strings if it is already ignored or there's
+// no git.
+func plannedGitIgnoreEntry(projectRoot string, fileToIgnore string) (string, string, error) {
+	if isGitless() {
+		return "", "", nil
+	}
+	relativePath, err := filepath.Rel(projectRoot, fileToIgnore)
+	if err != nil {
+		return "", "", err
+	}
+
+	isTracked, err := isGitTracked(projectRoot, relativePath)
+	if isTracked {
+		printDebugln("NOT appending %s to gitignore because it's already tracked", fileToIgnore)
+		return "", "", fmt.Errorf("%w: %s", ErrPlaintextTracked, displayPath(fileToIgnore))
+	}
+	isIgnored, err := isGitIgnored(projectRoot, fileToIgnore)
+	if isIgnored {
+		printDebugln("NOT appending %s to gitignore because it's already ignored", fileToIgnore)
+		return "", "", nil
+	}
+
+	gitIgnorePath := filepath.Join(projectRoot, ".gitignore")
+	entry := gitIgnorePattern(fileToIgnore)
+	if entry == "" {
+		gitIgnorePath = nearestGitIgnore(projectRoot, fileToIgnore)
+		entry, err = gitIgnoreEntry(gitIgnorePath, fileToIgnore)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	g, err := readManagedFile(gitIgnorePath)
+	if err != nil {
+		return "", "", err
+	}
+	if g.has(entry) {
+		return "", "", nil
+	}
+	return gitIgnorePath, entry, nil
+}
+
+var gitIgnoreMutex sync.Mutex
+
+func addGitIgnore(projectRoot string, fileToIgnore string) error {
+	gitIgnoreMutex.Lock()
+	defer gitIgnoreMutex.Unlock()
+	gitIgnorePath, entry, err := plannedGitIgnoreEntry(projectRoot, fileToIgnore)
+	if err != nil || entry == "" {
+		return err
+	}
+	printDebugln("adding %s to %s", entry, gitIgnorePath)
+	return updateManagedFile(gitIgnorePath, func(g *managedFile) bool {
+		return g.add(entry)
+	})
+}
+
+// removeGitIgnore drops the per-file entry for a file from its nearest
+// .gitignore. Pattern entries are left alone as they may cover other files.
+func removeGitIgnore(projectRoot string, ignoredFile string) error {
+	gitIgnoreMutex.Lock()
+	defer gitIgnoreMutex.Unlock()
+	gitIgnorePath := nearestGitIgnore(projectRoot, ignoredFile)
+	for {
+		entry, err := gitIgnoreEntry(gitIgnorePath, ignoredFile)
+		if err != nil {
+			return err
+		}
+		removed := false
+		err = updateManagedFile(gitIgnorePath, func(g *managedFile) bool {
+			removed = g.remove(entry)
+			return removed
+		})
+		if err != nil || removed {
+			return err
+		}
+		dir := filepath.Dir(filepath.Dir(gitIgnorePath))
+		if !strings.HasPrefix(dir, projectRoot) || gitIgnorePath == filepath.Join(projectRoot, ".gitignore") {
+			return nil
+		}
+		gitIgnorePath = nearestGitIgnore(projectRoot, filepath.Join(dir, ".gitignore"))
+	}
+}
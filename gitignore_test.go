@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitIgnorePattern(t *testing.T) {
+	for _, test := range []struct {
+		file    string
+		pattern string
+	}{
+		{"/project/config/app-secret.yaml", "*secret.yaml"},
+		{"/project/secret.yml", "*secret.yml"},
+		{"/project/config/app-secret.yaml.bak.20240102T030405Z", "*.bak.[0-9]*T[0-9]*Z"},
+		{"/project/credentials.json", ""},
+		{"/project/secret.yaml.orig", ""},
+	} {
+		if pattern := gitIgnorePattern(test.file); pattern != test.pattern {
+			t.Errorf("gitIgnorePattern(%q) = %q, expecting %q", test.file, pattern, test.pattern)
+		}
+	}
+}
+
+func TestGitIgnoreEntryInNearestGitIgnore(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "service", "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "service", ".gitignore"), []byte("build/\n"), 0644)
+	for _, test := range []struct {
+		file      string
+		gitIgnore string
+		entry     string
+	}{
+		{"credentials.json", ".gitignore", "/credentials.json"},
+		{"service/config/credentials.json", "service/.gitignore", "/config/credentials.json"},
+		{"service/#notes [draft].txt", "service/.gitignore", "/#notes \\[draft].txt"},
+	} {
+		file := filepath.Join(root, filepath.FromSlash(test.file))
+		gitIgnorePath := nearestGitIgnore(root, file)
+		if expected := filepath.Join(root, filepath.FromSlash(test.gitIgnore)); gitIgnorePath != expected {
+			t.Errorf("%s: expecting %s, got %s", test.file, expected, gitIgnorePath)
+		}
+		entry, err := gitIgnoreEntry(gitIgnorePath, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry != test.entry {
+			t.Errorf("%s: expecting the entry %q, got %q", test.file, test.entry, entry)
+		}
+	}
+}
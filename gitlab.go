@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// gitlabStore keeps values as CI/CD variables of a GitLab project or group,
+// named like environment variables, in one environment scope. --masked and
+// --protected pick the variables to mask in job logs and to only pass to
+// protected branches and tags. Variables the files don't have are left
+// alone.
+type gitlabStore struct {
+	kind        string
+	environment string
+}
+
+type gitlabVariable struct {
+	Key              string  `json:"key"`
+	Value            *string `json:"value"`
+	EnvironmentScope string  `json:"environment_scope"`
+}
+
+// variablePatterns collects repeated globs on variable names.
+type variablePatterns []string
+
+var maskedVariables variablePatterns
+var protectedVariables variablePatterns
+
+func (v *variablePatterns) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *variablePatterns) Set(value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return fmt.Errorf("invalid variable glob %q: %w", value, err)
+	}
+	*v = append(*v, value)
+	return nil
+}
+
+func (v variablePatterns) match(name string) bool {
+	for _, pattern := range v {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// gitlabAPI is the API of the instance a job runs on, GITLAB_HOST as for
+// glab, or gitlab.com.
+func gitlabAPI() string {
+	if api := os.Getenv("CI_API_V4_URL"); api != "" {
+		return strings.TrimSuffix(api, "/")
+	}
+	host := os.Getenv("GITLAB_HOST")
+	if host == "" {
+		host = "gitlab.com"
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return strings.TrimSuffix(host, "/") + "/api/v4"
+}
+
+func (g *gitlabStore) scope() string {
+	if g.environment == "" {
+		return "*"
+	}
+	return g.environment
+}
+
+func (g *gitlabStore) request(method string, ref string, suffix string, body interface{}, result interface{}) error {
+	token := os.Getenv("GITLAB_TOKEN")
+	if token == "" {
+		return errors.New("no GitLab token, set GITLAB_TOKEN to a token with the api scope")
+	}
+	requestURL := fmt.Sprintf("%s/%s/%s/variables%s", gitlabAPI(), g.kind, url.PathEscape(ref), suffix)
+	return jsonRequest(method, requestURL, map[string]string{"PRIVATE-TOKEN": token}, body, result)
+}
+
+// variables are the variables of ref in the store's environment scope.
+func (g *gitlabStore) variables(ref string) ([]gitlabVariable, error) {
+	const perPage = 100
+	variables := []gitlabVariable{}
+	for page := 1; ; page++ {
+		batch := []gitlabVariable{}
+		if err := g.request("GET", ref, fmt.Sprintf("?per_page=%d&page=%d", perPage, page), nil, &batch); err != nil {
+			return nil, err
+		}
+		for _, variable := range batch {
+			if variable.EnvironmentScope == g.scope() {
+				variables = append(variables, variable)
+			}
+		}
+		if len(batch) < perPage {
+			return variables, nil
+		}
+	}
+}
+
+func (g *gitlabStore) read(ref string) ([]secretField, error) {
+	variables, err := g.variables(ref)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Key < variables[j].Key })
+	fields := []secretField{}
+	for _, variable := range variables {
+		if variable.Value == nil {
+			printDebugln("skipping %s, its value is hidden", variable.Key)
+			continue
+		}
+		fields = append(fields, secretField{[]string{variable.Key}, *variable.Value})
+	}
+	return fields, nil
+}
+
+// write updates the variables that exist in the scope and creates the
+// others. Values are raw, so that GitLab doesn't expand $ in them.
+func (g *gitlabStore) write(ref string, fields []secretField) error {
+	variables, err := g.variables(ref)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, variable := range variables {
+		existing[variable.Key] = true
+	}
+	for _, field := range fields {
+		name := envName(field.Path)
+		body := map[string]interface{}{
+			"value":             field.Value,
+			"masked":            maskedVariables.match(name),
+			"protected":         protectedVariables.match(name),
+			"raw":               true,
+			"environment_scope": g.scope(),
+		}
+		if existing[name] {
+			err = g.request("PUT", ref, "/"+name+"?filter[environment_scope]="+url.QueryEscape(g.scope()), body, nil)
+		} else {
+			body["key"] = name
+			err = g.request("POST", ref, "", body, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		printDebugln("set GitLab variable %s", name)
+	}
+	return nil
+}
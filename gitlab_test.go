@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGitlabAPI(t *testing.T) {
+	for _, test := range []struct {
+		api  string
+		host string
+		url  string
+	}{
+		{"", "", "https://gitlab.com/api/v4"},
+		{"", "gitlab.example.com", "https://gitlab.example.com/api/v4"},
+		{"", "http://localhost:8080/", "http://localhost:8080/api/v4"},
+		{"https://ci.example.com/api/v4/", "gitlab.example.com", "https://ci.example.com/api/v4"},
+	} {
+		t.Setenv("CI_API_V4_URL", test.api)
+		t.Setenv("GITLAB_HOST", test.host)
+		if url := gitlabAPI(); url != test.url {
+			t.Errorf("CI_API_V4_URL %q and GITLAB_HOST %q: expecting %s, got %s", test.api, test.host, test.url, url)
+		}
+	}
+}
+
+func TestVariablePatterns(t *testing.T) {
+	v := variablePatterns{}
+	if err := v.Set("[TOKEN"); err == nil {
+		t.Error("expecting an invalid glob rejected")
+	}
+	for _, pattern := range []string{"*_TOKEN", "DATABASE_*"} {
+		if err := v.Set(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, match := range map[string]bool{"API_TOKEN": true, "DATABASE_PASSWORD": true, "API_URL": false} {
+		if v.match(name) != match {
+			t.Errorf("%s: expecting match %v", name, match)
+		}
+	}
+}
+
+// useGitlabServer serves the CI/CD variables of one project, recording the
+// variables set by method and key.
+func useGitlabServer(t *testing.T, variables []gitlabVariable) map[string]map[string]interface{} {
+	t.Helper()
+	set := map[string]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "glpat-test" || !strings.HasPrefix(r.URL.EscapedPath(), "/api/v4/projects/group%2Fapp/variables") {
+			http.Error(w, `{"message":"404 Not Found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(variables)
+			return
+		}
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, _ := body["key"].(string)
+		if r.Method == "PUT" {
+			key = strings.TrimPrefix(r.URL.Path, "/api/v4/projects/group/app/variables/")
+			body["filter"] = r.URL.Query().Get("filter[environment_scope]")
+		}
+		set[r.Method+" "+key] = body
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("CI_API_V4_URL", server.URL+"/api/v4")
+	t.Setenv("GITLAB_TOKEN", "glpat-test")
+	return set
+}
+
+func TestGitlabStore(t *testing.T) {
+	value := func(v string) *string { return &v }
+	set := useGitlabServer(t, []gitlabVariable{
+		{"DATABASE_PASSWORD", value("old"), "production"},
+		{"API_KEY", value("abc"), "production"},
+		{"FILE_ONLY", nil, "production"},
+		{"API_KEY", value("staging"), "*"},
+	})
+	g := &gitlabStore{kind: "projects", environment: "production"}
+	read, err := g.read("group/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []secretField{{[]string{"API_KEY"}, "abc"}, {[]string{"DATABASE_PASSWORD"}, "old"}}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("expecting %q, got %q", expected, read)
+	}
+	maskedVariables = variablePatterns{"*_PASSWORD"}
+	protectedVariables = variablePatterns{"DATABASE_*"}
+	defer func() { maskedVariables, protectedVariables = nil, nil }()
+	if err := g.write("group/app", []secretField{{[]string{"database", "password"}, "new$"}, {[]string{"smtp-host"}, "mail"}}); err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"POST SMTP_HOST", "PUT DATABASE_PASSWORD"}) {
+		t.Fatalf("expecting DATABASE_PASSWORD updated and SMTP_HOST created, got %q", keys)
+	}
+	if body := set["PUT DATABASE_PASSWORD"]; body["value"] != "new$" || body["masked"] != true || body["protected"] != true || body["raw"] != true || body["filter"] != "production" {
+		t.Errorf("unexpected update %v", body)
+	}
+	if body := set["POST SMTP_HOST"]; body["masked"] != false || body["protected"] != false || body["environment_scope"] != "production" {
+		t.Errorf("unexpected creation %v", body)
+	}
+}
+
+func TestSyncGitlabTarget(t *testing.T) {
+	defer func() { syncRepo, syncGroup = "", "" }()
+	for _, test := range []struct {
+		repo  string
+		group string
+		kind  string
+	}{
+		{"group/app", "", "projects"},
+		{"", "group", "groups"},
+		{"", "", ""},
+		{"group/app", "group", ""},
+	} {
+		syncRepo, syncGroup = test.repo, test.group
+		store, _, err := syncStore("gitlab")
+		if test.kind == "" {
+			if err == nil {
+				t.Errorf("--repo %q --group %q: expecting an error", test.repo, test.group)
+			}
+			continue
+		}
+		if err != nil || store.(*gitlabStore).kind != test.kind {
+			t.Errorf("--repo %q --group %q: expecting %s, got %v (%v)", test.repo, test.group, test.kind, store, err)
+		}
+	}
+}
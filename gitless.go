@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Without git, on build machines that only have the files, secrets runs
+// without invoking it at all: the project root is --root or the topmost
+// folder with a .secrets.yaml, the key is --key or that of the config, and
+// .gitignore files and commits aren't looked at. That's the case when git
+// isn't installed, when the project isn't a git repository, or with
+// --no-git.
+
+var noGit bool
+
+var gitOnce sync.Once
+var gitMissing bool
+
+var errGitless = errors.New("git isn't installed, the project isn't a git repository or --no-git was given")
+
+// isGitless reports whether git is not to be invoked.
+func isGitless() bool {
+	if noGit {
+		return true
+	}
+	gitOnce.Do(func() {
+		_, err := exec.LookPath("git")
+		gitMissing = err != nil
+	})
+	if gitMissing {
+		return true
+	}
+	return projectRoot != "" && !isProjectRoot(projectRoot) && !isGitEnv()
+}
+
+// findConfigRoot is the topmost folder from path up with a .secrets.yaml,
+// the project root of a project without git.
+func findConfigRoot(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	root := ""
+	for {
+		if fileExists(filepath.Join(path, configFileName)) {
+			root = path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	if root == "" {
+		return "", errors.New("not in a project: no git repository or .secrets.yaml found, run it inside a project folder or give its --root")
+	}
+	return root, nil
+}
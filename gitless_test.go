@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindConfigRoot(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{".": "", "team": "", "team/app/config": ""})
+	for _, dir := range []string{".", "team", "team/app/config", "team/app/config/deeper"} {
+		found, err := findConfigRoot(filepath.Join(root, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != root {
+			t.Errorf("%s: expecting the topmost config folder %s, got %s", dir, root, found)
+		}
+	}
+	if _, err := findConfigRoot(t.TempDir()); err == nil || !strings.Contains(err.Error(), "not in a project") {
+		t.Errorf("expecting no project outside of one, got %v", err)
+	}
+}
+
+func TestGitless(t *testing.T) {
+	root := useFakeBackend(t)
+	if !isGitless() {
+		t.Fatal("expecting a project without a repository to be gitless")
+	}
+	if _, _, _, err := runCommand("git", "-C", root, "status"); !errors.Is(err, errGitless) {
+		t.Errorf("expecting git not to run, got %v", err)
+	}
+	gitIgnorePath, entry, err := plannedGitIgnoreEntry(root, filepath.Join(root, "secret.yaml"))
+	if err != nil || gitIgnorePath != "" || entry != "" {
+		t.Errorf("expecting no .gitignore entry, got %q, %q, %v", gitIgnorePath, entry, err)
+	}
+	writeConfigs(t, root, map[string]string{".": "key-fallback: error\n"})
+	if _, err := projectKey(root); !errors.Is(err, errGitless) {
+		t.Errorf("expecting the key not to be named without git, got %v", err)
+	}
+
+	root = useGitProject(t, "origin", "git@github.com:jobbatical/app.git")
+	if isGitless() {
+		t.Fatal("expecting a repository not to be gitless")
+	}
+	noGit = true
+	defer func() { noGit = false }()
+	if !isGitless() {
+		t.Error("expecting --no-git to be gitless")
+	}
+	if keyName, err := projectKey(root); err != nil || keyName != "project" {
+		t.Errorf("expecting the key named after the folder with --no-git, got %q, %v", keyName, err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A .enc records nothing about its plaintext that can be checked without
+// KMS, as a hash next to the ciphertext would let anyone who can read the
+// repository confirm a guess of a secret. Instead, what each .enc sealed or
+// opened on this machine holds is remembered in the user's cache folder, as
+// the SHA-256 of the .enc and a salted hash of its plaintext, so seal and
+// clean still tell unchanged files apart without KMS. A .enc this machine
+// hasn't sealed or opened counts as changed.
+
+const plaintextHashSaltSize int = 16
+
+// plaintextHash is a salted SHA-256 of a plaintext, formatted as
+// <salt>:<digest> in hex. The salt keeps equal plaintexts from having equal
+// hashes across files.
+func plaintextHash(plaintext []byte) (string, error) {
+	salt := make([]byte, plaintextHashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return saltedHash(salt, plaintext), nil
+}
+
+func saltedHash(salt []byte, plaintext []byte) string {
+	digest := sha256.Sum256(append(append([]byte{}, salt...), plaintext...))
+	return hex.EncodeToString(salt) + ":" + hex.EncodeToString(digest[:])
+}
+
+// matchesPlaintextHash reports whether hash was computed from plaintext.
+func matchesPlaintextHash(hash string, plaintext []byte) bool {
+	encodedSalt, _, ok := strings.Cut(hash, ":")
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(saltedHash(salt, plaintext)), []byte(hash)) == 1
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sealedContent is what a .enc, identified by its SHA-256, holds.
+type sealedContent struct {
+	SHA256        string `json:"sha256"`
+	PlaintextHash string `json:"plaintextHash"`
+}
+
+var sealedContents map[string]sealedContent
+var sealedContentsMutex sync.Mutex
+
+func sealedContentsPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "secrets", "sealed.json"), nil
+}
+
+func readSealedContents() (map[string]sealedContent, error) {
+	path, err := sealedContentsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]sealedContent{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	contents := map[string]sealedContent{}
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return map[string]sealedContent{}, nil
+	}
+	return contents, nil
+}
+
+// rememberSealedContent records that the .enc ciphertextFile, as data, holds
+// a plaintext of the given hash. It is a cache, so failing to update it
+// only makes the file count as changed later.
+func rememberSealedContent(ciphertextFile string, data []byte, hash string) {
+	if err := writeSealedContent(ciphertextFile, sealedContent{sha256Hex(data), hash}); err != nil {
+		printDebugln("could not remember the content of %s: %s", ciphertextFile, err)
+	}
+}
+
+func writeSealedContent(ciphertextFile string, content sealedContent) error {
+	absolutePath, err := filepath.Abs(ciphertextFile)
+	if err != nil {
+		return err
+	}
+	path, err := sealedContentsPath()
+	if err != nil {
+		return err
+	}
+	sealedContentsMutex.Lock()
+	defer sealedContentsMutex.Unlock()
+	// Read again, for entries other runs added since.
+	contents, err := readSealedContents()
+	if err != nil {
+		return err
+	}
+	contents[absolutePath] = content
+	sealedContents = contents
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	encoded, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, encoded, 0600)
+}
+
+// holdsPlaintext reports whether the .enc ciphertextFile, as data, is known
+// to hold plaintext.
+func holdsPlaintext(ciphertextFile string, data []byte, plaintext []byte) bool {
+	absolutePath, err := filepath.Abs(ciphertextFile)
+	if err != nil {
+		return false
+	}
+	sealedContentsMutex.Lock()
+	if sealedContents == nil {
+		if sealedContents, err = readSealedContents(); err != nil {
+			printDebugln("could not read what sealed files hold: %s", err)
+			sealedContents = map[string]sealedContent{}
+		}
+	}
+	content, ok := sealedContents[absolutePath]
+	sealedContentsMutex.Unlock()
+	return ok && content.SHA256 == sha256Hex(data) && matchesPlaintextHash(content.PlaintextHash, plaintext)
+}
+
+// sealedFileHolds is holdsPlaintext for the .enc as it is on disk.
+func sealedFileHolds(ciphertextFile string, plaintext []byte) bool {
+	data, err := os.ReadFile(ciphertextFile)
+	return err == nil && holdsPlaintext(ciphertextFile, data, plaintext)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPlaintextHash(t *testing.T) {
+	one, err := plaintextHash([]byte("token: abc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := plaintextHash([]byte("token: abc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one == two {
+		t.Error("equal plaintexts have equal hashes")
+	}
+	for _, test := range []struct {
+		hash      string
+		plaintext string
+		matches   bool
+	}{
+		{one, "token: abc\n", true},
+		{two, "token: abc\n", true},
+		{one, "token: def\n", false},
+		{"not a hash", "token: abc\n", false},
+		{"zz:" + one[33:], "token: abc\n", false},
+	} {
+		if matches := matchesPlaintextHash(test.hash, []byte(test.plaintext)); matches != test.matches {
+			t.Errorf("%q with %q: expecting %t, got %t", test.hash, test.plaintext, test.matches, matches)
+		}
+	}
+}
+
+func TestHoldsPlaintext(t *testing.T) {
+	root := useFakeBackend(t)
+	ciphertextFile := filepath.Join(root, "secret.yaml.enc")
+	hash, err := plaintextHash([]byte("token: abc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rememberSealedContent(ciphertextFile, []byte("sealed"), hash)
+	sealedContents = nil
+	for _, test := range []struct {
+		file      string
+		data      string
+		plaintext string
+		holds     bool
+	}{
+		{ciphertextFile, "sealed", "token: abc\n", true},
+		{ciphertextFile, "resealed", "token: abc\n", false},
+		{ciphertextFile, "sealed", "token: def\n", false},
+		{filepath.Join(root, "other.yaml.enc"), "sealed", "token: abc\n", false},
+	} {
+		if holds := holdsPlaintext(test.file, []byte(test.data), []byte(test.plaintext)); holds != test.holds {
+			t.Errorf("%s as %q holding %q: expecting %t, got %t", test.file, test.data, test.plaintext, test.holds, holds)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The agent serves /healthz and /readyz next to its metrics, for the probes
+// of a Kubernetes sidecar. It's healthy while it keeps checking opened
+// files, and ready when its credentials work and the project's key can be
+// reached in KMS. Readiness is checked at most every readinessTTL, so that
+// probes don't turn into a stream of KMS calls.
+
+const readinessTTL time.Duration = 10 * time.Second
+
+type healthCheck struct {
+	name string
+	err  error
+}
+
+var healthMutex sync.Mutex
+var lastSweep = time.Now()
+var readinessMutex sync.Mutex
+var readiness []healthCheck
+var readinessCheckedAt time.Time
+
+func recordSweep() {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	lastSweep = time.Now()
+}
+
+// checkCredentials gets an access token, which fails when the credentials
+// expired or were revoked.
+func checkCredentials() error {
+	client, err := kmsClient()
+	if err != nil {
+		return err
+	}
+	switch client.(type) {
+	case *gcloudBackend, *nativeBackend:
+		_, _, err := googleCredentials()
+		return err
+	}
+	return nil
+}
+
+// checkKMS describes the project's key, bypassing the cache of key metadata.
+func checkKMS() error {
+	if key == "" {
+		return nil
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return err
+	}
+	keyProject := keyProjectFlag
+	if keyProject == "" && projectRoot != "" {
+		if config, err := configFor(projectRoot); err == nil {
+			keyProject = config.keyProjectFor()
+		}
+	}
+	_, err = client.describeKey(qualifyKey(key, keyProject))
+	return err
+}
+
+func checkReadiness() []healthCheck {
+	readinessMutex.Lock()
+	defer readinessMutex.Unlock()
+	if readiness != nil && time.Since(readinessCheckedAt) < readinessTTL {
+		return readiness
+	}
+	readiness = []healthCheck{{"credentials", checkCredentials()}}
+	if readiness[0].err == nil {
+		readiness = append(readiness, healthCheck{"kms", checkKMS()})
+	}
+	readinessCheckedAt = time.Now()
+	return readiness
+}
+
+func writeChecks(w http.ResponseWriter, checks []healthCheck) {
+	var b strings.Builder
+	status := http.StatusOK
+	for _, check := range checks {
+		if check.err != nil {
+			status = http.StatusServiceUnavailable
+			fmt.Fprintf(&b, "%s: %s\n", check.name, check.err)
+		} else {
+			fmt.Fprintf(&b, "%s: ok\n", check.name)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, b.String())
+}
+
+func handleHealth(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		healthMutex.Lock()
+		since := time.Since(lastSweep)
+		healthMutex.Unlock()
+		var err error
+		if since > 3*agentInterval {
+			err = fmt.Errorf("no check of opened files for %s", formatDuration(since))
+		}
+		writeChecks(w, []healthCheck{{"sweep", err}})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeChecks(w, checkReadiness())
+	})
+}
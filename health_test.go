@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// describeCountingBackend counts the keys it describes.
+type describeCountingBackend struct {
+	fakeBackend
+	describes int
+}
+
+func (d *describeCountingBackend) describeKey(keyName string) (*kmsKey, error) {
+	d.describes++
+	return d.fakeBackend.describeKey(keyName)
+}
+
+func probe(t *testing.T, server *httptest.Server, path string) (int, string) {
+	t.Helper()
+	response, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, string(body)
+}
+
+func TestHealthz(t *testing.T) {
+	mux := http.NewServeMux()
+	handleHealth(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	defer recordSweep()
+	for _, test := range []struct {
+		since  time.Duration
+		status int
+		body   string
+	}{
+		{time.Second, http.StatusOK, "sweep: ok\n"},
+		{4 * agentInterval, http.StatusServiceUnavailable, "sweep: no check of opened files for"},
+	} {
+		healthMutex.Lock()
+		lastSweep = time.Now().Add(-test.since)
+		healthMutex.Unlock()
+		if status, body := probe(t, server, "/healthz"); status != test.status || !strings.HasPrefix(body, test.body) {
+			t.Errorf("last sweep %s ago: expecting %d %q, got %d %q", test.since, test.status, test.body, status, body)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		backend kmsBackend
+		status  int
+		body    string
+	}{
+		{"ready", &fakeBackend{}, http.StatusOK, "credentials: ok\nkms: ok\n"},
+		{"missing key", &missingKeyBackend{}, http.StatusServiceUnavailable, "credentials: ok\nkms: "},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeBackend(t)
+			kmsSelected = test.backend
+			previousKey := key
+			key, readiness = testKey, nil
+			defer func() { key, readiness = previousKey, nil }()
+			mux := http.NewServeMux()
+			handleHealth(mux)
+			server := httptest.NewServer(mux)
+			defer server.Close()
+			if status, body := probe(t, server, "/readyz"); status != test.status || !strings.HasPrefix(body, test.body) {
+				t.Errorf("expecting %d %q, got %d %q", test.status, test.body, status, body)
+			}
+		})
+	}
+}
+
+func TestReadinessIsCached(t *testing.T) {
+	useFakeBackend(t)
+	backend := &describeCountingBackend{}
+	kmsSelected = backend
+	previousKey := key
+	key, readiness = testKey, nil
+	defer func() { key, readiness = previousKey, nil }()
+	checkReadiness()
+	checkReadiness()
+	if backend.describes != 1 {
+		t.Errorf("expecting the key described once within %s, got %d", readinessTTL, backend.describes)
+	}
+	readinessCheckedAt = time.Now().Add(-readinessTTL)
+	checkReadiness()
+	if backend.describes != 2 {
+		t.Errorf("expecting the key described again after %s, got %d", readinessTTL, backend.describes)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// `secrets help <command>` prints what a command does, its options with
+// their defaults, and examples. Options are described where they're defined,
+// with the flag package, and only listed here.
+
+type commandDoc struct {
+	name     string
+	synopsis []string
+	summary  string
+	details  string
+	flags    []string
+	examples []string
+}
+
+var commonFlags = []string{"root", "key", "key-project", "key-ring", "profile", "location", "secondary-location", "dry-run", "verbose", "yes", "ci", "no-git", "kms-transport", "kms-rate", "kms-record", "kms-replay", "credentials-config", "impersonate-service-account", "color"}
+
+var fileFlags = []string{"files-from", "exclude", "max-depth", "follow-symlinks", "jobs", "keep-going"}
+
+const keyResolutionHelp string = `Each file is sealed with --key if given, else the key of the nearest
+.secrets.yaml rule or key, else the key the key-resolver of the root
+.secrets.yaml names, else the key named after the repository origin points
+to, else the one its key-fallback gives (the project folder's name by
+default). Files are opened with the key recorded in their .enc.`
+
+var commandDocs = []commandDoc{
+	{
+		name:     encryptCmd,
+		synopsis: []string{"seal [<file path>...] [options]", "seal <file path> --out <path> [options]", "seal --stdout [<file path>] [options]"},
+		summary:  "Encrypt files into .enc files next to them",
+		details: `Without files, seal finds the files named like *secret.yaml or *secret.yml
+under the project root. Each one is encrypted with Cloud KMS into a .enc
+file, and added to .gitignore. Files whose .enc already holds the same
+content under the same key are skipped. When discovery finds more than 50
+files, or files over 1 MiB, they are listed and sealing them has to be
+confirmed, or --yes given.
+
+` + keyResolutionHelp,
+		flags:    append([]string{"interactive", "out", "out-dir", "stdout", "deterministic", "force", "signing-key", "create-keyring", "rotation-period", "next-rotation-time", "protection-level", "label"}, fileFlags...),
+		examples: []string{"secrets seal", "secrets seal config/db-secret.yaml --key payments", "cat app-secret.yaml | secrets seal --stdout > app-secret.yaml.enc"},
+	},
+	{
+		name:     decryptCmd,
+		synopsis: []string{"open [<file path>...] [options]", "open <file path> --out <path> [options]", "open <file path> --stdout [options]"},
+		summary:  "Decrypt .enc files into the files next to them",
+		details: `Without files, open finds the .enc files of files named like *secret.yaml
+or *secret.yml under the project root. With --open-all, it opens every .enc
+file instead, whatever its name. Files opened with --ttl are removed once
+it's over.
+
+` + keyResolutionHelp,
+		flags:    append([]string{"open-all", "interactive", "ttl", "backup", "out", "out-dir", "stdout", "signing-key"}, fileFlags...),
+		examples: []string{"secrets open", "secrets open --open-all", "secrets open config/db-secret.yaml.enc --ttl 30m", "secrets open app-secret.yaml.enc --stdout | kubectl apply -f -"},
+	},
+	{
+		name:     statusCmd,
+		synopsis: []string{"status [<file path>...] [options]", "ls [<file path>...] [options]"},
+		summary:  "List .enc files with their key, key version and who sealed them",
+		details:  "Files sealed under a retired key version, or longer ago than the key's\nrotation period, are flagged.",
+		flags:    append([]string{"open-all", "paths-only", "print0"}, fileFlags...),
+		examples: []string{"secrets status", "secrets ls --paths-only"},
+	},
+	{
+		name:     findCmd,
+		synopsis: []string{"find [--encrypted] [--plaintext] [--pattern <regexp>] [--print0] [options]"},
+		summary:  "List the files seal and open would pick",
+		flags:    append([]string{"encrypted", "plaintext", "pattern", "print0"}, fileFlags...),
+		examples: []string{"secrets find --plaintext", "secrets find --encrypted --print0 | xargs -0 ls -l"},
+	},
+	{
+		name:     verifyCmd,
+		synopsis: []string{"verify [<file path>...] [options]"},
+		summary:  "Check that every .enc still decrypts, without writing plaintext",
+		flags:    append([]string{"open-all", "signing-key"}, fileFlags...),
+		examples: []string{"secrets verify --ci"},
+	},
+	{
+		name:     resealAllCmd,
+		synopsis: []string{"reseal-all [<file path>...] [options]"},
+		summary:  "Re-encrypt .enc files under the current primary key version",
+		details:  "Files already sealed with the primary key version are skipped, unless --force is given.",
+		flags:    append([]string{"open-all", "deterministic", "force", "signing-key"}, fileFlags...),
+		examples: []string{"secrets reseal-all", "secrets reseal-all --dry-run"},
+	},
+	{
+		name:     planCmd,
+		synopsis: []string{"plan <seal|open|reseal-all|rotate> [<file path>...] [options]"},
+		summary:  "Preview what seal, open or reseal-all would change",
+		details:  "rotate is another name for reseal-all. Files already up to date aren't listed, and don't count as changes for --detailed-exitcode.",
+		flags:    append([]string{"detailed-exitcode", "open-all", "force"}, fileFlags...),
+		examples: []string{"secrets plan seal", "secrets plan rotate --detailed-exitcode"},
+	},
+	{
+		name:     cleanCmd,
+		synopsis: []string{"clean [options]"},
+		summary:  "Remove the plaintext of every .enc file that matches it",
+		details:  "Plaintext changed since it was sealed is listed and kept.",
+		flags:    []string{"open-all"},
+		examples: []string{"secrets clean"},
+	},
+	{
+		name:     pruneCmd,
+		synopsis: []string{"prune [options]"},
+		summary:  "Remove orphaned .enc files and stale .gitignore entries",
+		examples: []string{"secrets prune --dry-run"},
+	},
+	{
+		name:     lockCmd,
+		synopsis: []string{"lock [options]"},
+		summary:  "Rewrite secrets.lock from the .enc files in the project",
+		examples: []string{"secrets lock"},
+	},
+	{
+		name:     moveCmd,
+		synopsis: []string{"mv <from> <to> [options]"},
+		summary:  "Rename a secret, keeping its .enc, .gitignore entry and git index in step",
+		details:  "The .enc is sealed again for its new path, with the keys of that path, so it needs access to both.",
+		examples: []string{"secrets mv config/db-secret.yaml config/postgres-secret.yaml"},
+	},
+	{
+		name:     purgeHistoryCmd,
+		synopsis: []string{"purge-history <file path> [options]"},
+		summary:  "Remove a leaked plaintext file from the whole git history",
+		details:  "It needs git filter-repo, and rewrites every commit that has the file.",
+		examples: []string{"secrets purge-history config/db-secret.yaml"},
+	},
+	{
+		name:     maskCmd,
+		synopsis: []string{"mask [<file path>...] [options]"},
+		summary:  "Have the CI system redact secret values from job logs",
+		flags:    append([]string{"ci-system", "open-all"}, fileFlags...),
+		examples: []string{"secrets mask --ci"},
+	},
+	{
+		name:     uiCmd,
+		synopsis: []string{"ui [options]"},
+		summary:  "Pick files to seal or open from a list showing the status of each",
+		examples: []string{"secrets ui"},
+	},
+	{
+		name:     workspaceCmd,
+		synopsis: []string{"workspace <status|verify|reseal-all|clean|report> --root <folder> [options]"},
+		summary:  "Run a command in every git repository under a folder",
+		flags:    []string{"format"},
+		examples: []string{"secrets workspace status --root ~/src"},
+	},
+	{
+		name:     accessCmd,
+		synopsis: []string{"access list [<file path>...] [options]"},
+		summary:  "List who can encrypt or decrypt with the project key, or the keys of files",
+		examples: []string{"secrets access list"},
+	},
+	{
+		name:     keysCmd,
+		synopsis: []string{"keys report [<file path>...] [options]"},
+		summary:  "List which key versions .enc files use, flagging unexpected keys",
+		flags:    []string{"open-all"},
+		examples: []string{"secrets keys report"},
+	},
+	{
+		name:     reportCmd,
+		synopsis: []string{"report [<file path>...] [--format json|csv] [options]"},
+		summary:  "Export an inventory of encrypted files, for compliance evidence",
+		flags:    []string{"format", "open-all"},
+		examples: []string{"secrets report --format csv > inventory.csv"},
+	},
+	{
+		name:     kubectlCmd,
+		synopsis: []string{"kubectl <apply|delete|diff> <file path>... [options]"},
+		summary:  "Decrypt Kubernetes manifests in memory and pass them to kubectl",
+		flags:    []string{"namespace", "context"},
+		examples: []string{"secrets kubectl apply k8s/*.enc --context prod"},
+	},
+	{
+		name:     envFileCmd,
+		synopsis: []string{"env-file [<file path>...] [--out <path>] [options]"},
+		summary:  "Turn sealed files into an env_file for docker-compose",
+		flags:    []string{"out", "open-all"},
+		examples: []string{"secrets env-file --out .env"},
+	},
+	{
+		name:     systemdCredsCmd,
+		synopsis: []string{"systemd-creds [<file path>...] [--out <drop-in>] [--out-dir <credstore>] [options]"},
+		summary:  "Pass sealed files to a systemd service as credentials",
+		flags:    []string{"out", "out-dir"},
+		examples: []string{"secrets systemd-creds app-secret.yaml.enc --out /etc/systemd/system/app.service.d/secrets.conf"},
+	},
+	{
+		name:     importCmd,
+		synopsis: []string{"import <store> [<file path>] [options]", "import <bundle>.sbundle [--out-dir <dir>] [options]"},
+		summary:  "Seal the values kept in 1Password or pass, or the files of a bundle",
+		details: `Given a bundle written by export --bundle in another repository, import
+seals each of its files at its path under the project root, or --out-dir,
+with the key that path gets here, without writing the plaintext.`,
+		flags:    []string{"out-dir", "force"},
+		examples: []string{"secrets import op://vault/item config/app-secret.yaml.enc", "secrets import pass://team/api", "secrets import move.sbundle"},
+	},
+	{
+		name:     exportCmd,
+		synopsis: []string{"export <store> <file path>... [options]", "export [<file path>...] --bundle <path> [options]"},
+		summary:  "Copy sealed values to 1Password or pass, or files to a bundle for another repository",
+		details: `With --bundle, export seals the given files, or every sealed file, into a
+bundle like bundle does, for import into another repository.`,
+		flags:    []string{"bundle"},
+		examples: []string{"secrets export op://vault/item config/app-secret.yaml.enc", "secrets export --bundle move.sbundle"},
+	},
+	{
+		name:     bundleCmd,
+		synopsis: []string{"bundle [<file path>...] --out <path> [options]"},
+		summary:  "Seal several files into one bundle, with a manifest of them",
+		details: `Without files, bundle takes every sealed file under the project root.
+Plaintext files are read as they are and .enc files are opened in memory.
+The bundle is sealed and signed with the key its path gets.`,
+		flags:    []string{"out", "signing-key"},
+		examples: []string{"secrets bundle --out prod.sbundle", "secrets bundle config/db-secret.yaml config/app-secret.yaml.enc --out app.sbundle"},
+	},
+	{
+		name:     unbundleCmd,
+		synopsis: []string{"unbundle <bundle> [--out-dir <dir>] [options]"},
+		summary:  "Restore the files of a bundle",
+		details: `Files are checked against the manifest and written at their paths under the
+project root, or --out-dir. Files that would be overwritten with other
+content are listed and have to be confirmed.`,
+		flags:    []string{"out-dir", "signing-key"},
+		examples: []string{"secrets unbundle prod.sbundle", "secrets unbundle prod.sbundle --out-dir /run/secrets"},
+	},
+	{
+		name:     syncCmd,
+		synopsis: []string{"sync <vault|github|gitlab|cloudrun|cloudfunctions> [<file path>...] [options]"},
+		summary:  "Push the values of sealed files to where they're read at runtime, or pull them back",
+		flags:    []string{"pull", "mount", "path", "repo", "group", "env", "masked", "protected", "service", "region"},
+		examples: []string{"secrets sync github --repo acme/api --env prod", "secrets sync cloudrun --service api --region europe-west1"},
+	},
+	{
+		name:     configCmd,
+		synopsis: []string{"config show [<folder or file>] [options]", "config set <setting> <value> [<folder>] [options]", "config validate [options]", "config edit [<folder>] [options]"},
+		summary:  "Show, set, check and edit the settings of .secrets.yaml files",
+		details: `show lists the settings in effect for a folder, the current one by default,
+or a file, in order of precedence, with the .secrets.yaml, flag or default
+each comes from. With --ci it prints them as JSON.
+
+set writes a setting to the .secrets.yaml of a folder, the project root by
+default, keeping its comments; an empty value removes it. Lists such as
+exclude, split and git-hosts take comma-separated values. Rules, dual-control,
+access-policy and other nested settings are edited in the file.
+
+validate checks every .secrets.yaml and .secretsignore of the project,
+and the user config, including for unknown settings and root-only settings
+in other folders, which are otherwise ignored.
+
+edit opens the .secrets.yaml of a folder, the project root by default, in
+the editor of the user config, $VISUAL or $EDITOR, then checks it.`,
+		examples: []string{"secrets config show services/payments", "secrets config set key payments services/payments", "secrets config set git-hosts github.com,ghe.example.com", "secrets config validate"},
+	},
+	{
+		name:     whoamiCmd,
+		synopsis: []string{"whoami [options]"},
+		summary:  "Show which account, project and credentials KMS calls are made with",
+		examples: []string{"secrets whoami"},
+	},
+	{
+		name:     agentCmd,
+		synopsis: []string{"agent [--listen <address>] [options]"},
+		summary:  "Remove files opened with --ttl as soon as they expire",
+		flags:    []string{"listen"},
+		examples: []string{"secrets agent --listen 127.0.0.1:9464"},
+	},
+	{
+		name:     gitAttributesCmd,
+		synopsis: []string{"gitattributes [options]"},
+		summary:  "Add the diff and merge drivers of .enc files and secrets.lock to .gitattributes",
+		examples: []string{"secrets gitattributes"},
+	},
+	{
+		name:     gitConfigCmd,
+		synopsis: []string{"git-config [options]"},
+		summary:  "Set up the diff and merge drivers of .enc files and secrets.lock in the clone's git config",
+		examples: []string{"secrets git-config"},
+	},
+	{
+		name:     gitHooksCmd,
+		synopsis: []string{"git-hooks [options]"},
+		summary:  "Install hooks opening changed .enc files and checking commits and pushes",
+		examples: []string{"secrets git-hooks"},
+	},
+	{
+		name:     versionCmd,
+		synopsis: []string{"version"},
+		summary:  "Print the version, commit and build date",
+	},
+	{
+		name:     selfUpdateCmd,
+		synopsis: []string{"self-update [--yes]"},
+		summary:  "Download and install the latest release",
+		details: `The update is only installed when the release is newer, its SHA256SUMS is
+signed with the minisign key this binary was built with, and the binary
+matches it.`,
+	},
+}
+
+func findCommandDoc(name string) (commandDoc, bool) {
+	if name == listCmd {
+		name = statusCmd
+	}
+	for _, doc := range commandDocs {
+		if doc.name == name {
+			return doc, true
+		}
+	}
+	return commandDoc{}, false
+}
+
+// printHelp prints the commands, or the help of one.
+func printHelp(w io.Writer, command string) error {
+	if command == "" {
+		fmt.Fprintln(w, "Usage: secrets <command> [<file path>...] [options]")
+		fmt.Fprintln(w, "\nCommands:")
+		for _, doc := range commandDocs {
+			fmt.Fprintf(w, "  %-15s %s\n", doc.name, doc.summary)
+		}
+		fmt.Fprintln(w, "\nRun `secrets help <command>` for its options and examples.")
+		return nil
+	}
+	doc, ok := findCommandDoc(command)
+	if !ok {
+		return fmt.Errorf("no command %s, run `secrets help` for the list", command)
+	}
+	fmt.Fprintln(w, "Usage:")
+	for _, synopsis := range doc.synopsis {
+		fmt.Fprintf(w, "  secrets %s\n", synopsis)
+	}
+	fmt.Fprintf(w, "\n%s.\n", doc.summary)
+	if doc.details != "" {
+		fmt.Fprintf(w, "\n%s\n", doc.details)
+	}
+	if len(doc.flags) > 0 {
+		fmt.Fprintln(w, "\nOptions:")
+		printFlags(w, doc.flags)
+	}
+	fmt.Fprintln(w, "\nCommon options:")
+	printFlags(w, commonFlags)
+	if len(doc.examples) > 0 {
+		fmt.Fprintln(w, "\nExamples:")
+		for _, example := range doc.examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+	return nil
+}
+
+// printFlags describes flags the way the flag package does, with their
+// defaults.
+func printFlags(w io.Writer, names []string) {
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil {
+			continue
+		}
+		kind, usage := flag.UnquoteUsage(f)
+		line := "  --" + f.Name
+		if kind != "" {
+			line += " " + kind
+		}
+		switch f.DefValue {
+		case "", "false", "0", "0s", "[]":
+		default:
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		fmt.Fprintf(w, "%s\n    \t%s\n", line, strings.ReplaceAll(usage, "\n", "\n    \t"))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestPrintHelp(t *testing.T) {
+	for _, test := range []struct {
+		command  string
+		contains []string
+		err      string
+	}{
+		{"", []string{"Usage: secrets <command>", "  seal            Encrypt files into .enc files next to them\n", "secrets help <command>"}, ""},
+		{"seal", []string{"  secrets seal [<file path>...] [options]\n", "\nEncrypt files into .enc files next to them.\n", "key-resolver", "Examples:\n  secrets seal\n"}, ""},
+		{"ls", []string{"  secrets ls [<file path>...] [options]\n", "\nList .enc files"}, ""},
+		{"unseal", nil, "no command unseal, run `secrets help` for the list"},
+	} {
+		var output bytes.Buffer
+		err := printHelp(&output, test.command)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: expecting %q, got %v", test.command, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range test.contains {
+			if !strings.Contains(output.String(), expected) {
+				t.Errorf("%q: expecting %q in %q", test.command, expected, output.String())
+			}
+		}
+	}
+}
+
+func TestEveryCommandIsDocumented(t *testing.T) {
+	for _, command := range strings.Split(strings.Trim(strings.Fields(usage)[2], "<>"), "|") {
+		if _, ok := findCommandDoc(command); !ok && command != helpCmd {
+			t.Errorf("expecting help for %s", command)
+		}
+	}
+}
+
+func TestPrintFlags(t *testing.T) {
+	previous := flag.CommandLine
+	flag.CommandLine = flag.NewFlagSet("secrets", flag.ContinueOnError)
+	defer func() { flag.CommandLine = previous }()
+	flag.Bool("yes", false, "Don't ask for confirmation")
+	flag.Int("jobs", 4, "Number of files to process in parallel")
+	flag.String("out", "", "Write the `path` instead")
+	var output bytes.Buffer
+	printFlags(&output, []string{"yes", "jobs", "unknown", "out"})
+	expected := "  --yes\n    \tDon't ask for confirmation\n" +
+		"  --jobs int\n    \tNumber of files to process in parallel (default 4)\n" +
+		"  --out path\n    \tWrite the path instead\n"
+	if output.String() != expected {
+		t.Errorf("expecting %q, got %q", expected, output.String())
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Hooks in the root .secrets.yaml run commands before and after seal, open,
+// reseal-all and verify, e.g.
+//
+//	hooks:
+//	  pre-seal: ./lint-secrets.sh
+//	  post-open: make regenerate-config
+//
+// A hook is a shell command, or a list of them, run from the project root
+// with the files in SECRETS_FILES, one per line: all the files before, the
+// ones that succeeded after. A failing pre hook stops the command before any
+// file is touched, and a failing post hook fails it.
+
+var hookCommands = []string{encryptCmd, decryptCmd, resealAllCmd, verifyCmd}
+
+func parseHooks(file string, node *yamlNode) (map[string][]string, error) {
+	if node.kind != yamlMapping {
+		return nil, fmt.Errorf("%s: hooks must be a mapping", file)
+	}
+	hooks := map[string][]string{}
+	for i, name := range node.keys {
+		name = yamlUnquote(name)
+		if !isHookName(name) {
+			return nil, fmt.Errorf("%s: unknown hook %q, expecting pre- or post- followed by %s", file, name, strings.Join(hookCommands, ", "))
+		}
+		commands := []string{}
+		if node.values[i].kind == yamlSequence {
+			for _, item := range node.values[i].values {
+				commands = append(commands, item.value())
+			}
+		} else {
+			commands = append(commands, node.values[i].value())
+		}
+		for _, command := range commands {
+			if strings.TrimSpace(command) == "" {
+				return nil, fmt.Errorf("%s: hook %s has an empty command", file, name)
+			}
+		}
+		hooks[name] = commands
+	}
+	return hooks, nil
+}
+
+func isHookName(name string) bool {
+	for _, command := range hookCommands {
+		if name == "pre-"+command || name == "post-"+command {
+			return true
+		}
+	}
+	return false
+}
+
+// runHooks runs the hook commands named stage-command, "pre" or "post", with
+// files.
+func runHooks(stage string, command string, files []string) error {
+	if projectRoot == "" {
+		return nil
+	}
+	config, err := configFor(projectRoot)
+	if err != nil {
+		return err
+	}
+	name := stage + "-" + command
+	commands := config.Hooks[name]
+	if len(commands) == 0 {
+		return nil
+	}
+	paths := make([]string, len(files))
+	for i, file := range files {
+		absolutePath, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		paths[i] = displayPath(absolutePath)
+	}
+	for _, hook := range commands {
+		if dryRun {
+			printProgress("would run %s hook: %s", name, hook)
+			continue
+		}
+		printProgress("running %s hook: %s", name, hook)
+		cmd := exec.Command("sh", "-c", hook)
+		cmd.Dir = projectRoot
+		cmd.Env = append(os.Environ(),
+			"SECRETS_HOOK="+name,
+			"SECRETS_COMMAND="+command,
+			"SECRETS_ROOT="+projectRoot,
+			"SECRETS_FILES="+strings.Join(paths, "\n"),
+		)
+		// Stdout is for the output of secrets itself, such as --ci summaries.
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		span := startSpan("hook "+name, "hook", nil)
+		err := cmd.Run()
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", name, hook, err)
+		}
+	}
+	return nil
+}
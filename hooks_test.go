@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHooks(t *testing.T) {
+	config := &secretsConfig{}
+	if err := parseConfig(configFileName, []byte("hooks:\n  pre-seal: ./lint.sh\n  post-open:\n    - make config\n    - make restart\n"), config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Hooks["pre-seal"]) != 1 || len(config.Hooks["post-open"]) != 2 {
+		t.Errorf("unexpected hooks %q", config.Hooks)
+	}
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{"hooks: ./lint.sh\n", "hooks must be a mapping"},
+		{"hooks:\n  pre-commit: ./lint.sh\n", `unknown hook "pre-commit"`},
+		{"hooks:\n  post-seal: ''\n", "hook post-seal has an empty command"},
+	} {
+		if err := parseConfig(configFileName, []byte(test.config), &secretsConfig{}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expecting an error with %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestForEachFileRunsHooks(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		hooks     string
+		failing   string
+		processed int
+		log       string
+		err       string
+	}{
+		{
+			"pre and post", "hooks:\n  pre-seal: echo \"$SECRETS_HOOK $SECRETS_FILES\" >> hooks.log\n  post-seal:\n    - echo \"$SECRETS_HOOK $SECRETS_COMMAND $SECRETS_FILES\" >> hooks.log\n",
+			"b", 3, "pre-seal a\nb\nc\npost-seal seal a\nc\n", "1 of 3 files failed",
+		},
+		{"failing pre hook", "hooks:\n  pre-seal: exit 3\n", "", 0, "", `pre-seal hook "exit 3" failed`},
+		{"failing post hook", "hooks:\n  post-seal: exit 4\n", "", 3, "", `post-seal hook "exit 4" failed`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeConfigs(t, root, map[string]string{".": test.hooks})
+			keepGoing, jobs = true, 1
+			defer func() { keepGoing, jobs = false, 1 }()
+			files := []string{filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c")}
+			processed := 0
+			var err error
+			captureStderr(t, func() {
+				err = forEachFile(encryptCmd, "testing", files, func(file string) error {
+					processed++
+					if filepath.Base(file) == test.failing {
+						return errors.New("failed")
+					}
+					return nil
+				})
+			})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+			if processed != test.processed {
+				t.Errorf("expecting %d files processed, got %d", test.processed, processed)
+			}
+			if log, _ := os.ReadFile(filepath.Join(root, "hooks.log")); string(log) != test.log {
+				t.Errorf("expecting hooks to log %q, got %q", test.log, log)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// A PERMISSION_DENIED from KMS names at most one permission, and says the
+// resource "may not exist" either way. Asking KMS which of the permissions
+// secrets needs the caller has on the key tells which ones are missing, or
+// that none are and the key is what's missing.
+
+var keyPermissions = []string{
+	"cloudkms.cryptoKeyVersions.useToEncrypt",
+	"cloudkms.cryptoKeyVersions.useToDecrypt",
+	"cloudkms.cryptoKeys.get",
+	"cloudkms.cryptoKeyVersions.list",
+}
+
+func (n *nativeBackend) testIAMPermissions(keyName string, permissions []string) ([]string, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Permissions []string `json:"permissions"`
+	}
+	err = n.request("POST", name+":testIamPermissions", map[string][]string{"permissions": permissions}, &response)
+	return response.Permissions, err
+}
+
+// testIAMPermissions goes to the REST API with gcloud's access token, as
+// gcloud has no command for it.
+func (g *gcloudBackend) testIAMPermissions(keyName string, permissions []string) ([]string, error) {
+	name, err := g.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	rest := &nativeBackend{token: &cachedToken{fetch: g.accessToken}}
+	return rest.testIAMPermissions(name, permissions)
+}
+
+// missingPermissions are the permissions secrets needs on keyName that the
+// caller doesn't have, or nil when that can't be told.
+func missingPermissions(keyName string) []string {
+	client, err := kmsClient()
+	if err != nil {
+		return nil
+	}
+	granted, err := client.testIAMPermissions(keyName, keyPermissions)
+	if err != nil {
+		printDebugln("could not test permissions on %s: %s", keyName, err)
+		return nil
+	}
+	missing := []string{}
+	for _, permission := range keyPermissions {
+		if !containsString(granted, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// rolesFor are the narrowest predefined roles granting permissions.
+func rolesFor(permissions []string) []string {
+	encrypt, decrypt, view := false, false, false
+	for _, permission := range permissions {
+		switch {
+		case strings.HasSuffix(permission, ".useToEncrypt"):
+			encrypt = true
+		case strings.HasSuffix(permission, ".useToDecrypt"):
+			decrypt = true
+		case strings.HasSuffix(permission, ".create"):
+			return []string{"roles/cloudkms.admin"}
+		default:
+			view = true
+		}
+	}
+	roles := []string{}
+	switch {
+	case encrypt && decrypt:
+		roles = append(roles, "roles/cloudkms.cryptoKeyEncrypterDecrypter")
+	case encrypt:
+		roles = append(roles, "roles/cloudkms.cryptoKeyEncrypter")
+	case decrypt:
+		roles = append(roles, "roles/cloudkms.cryptoKeyDecrypter")
+	}
+	if view {
+		roles = append(roles, "roles/cloudkms.viewer")
+	}
+	sort.Strings(roles)
+	return roles
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type grantingBackend struct {
+	fakeBackend
+	granted []string
+	err     error
+}
+
+func (g *grantingBackend) testIAMPermissions(keyName string, permissions []string) ([]string, error) {
+	return g.granted, g.err
+}
+
+func TestMissingPermissions(t *testing.T) {
+	useFakeBackend(t)
+	for _, test := range []struct {
+		backend *grantingBackend
+		missing []string
+	}{
+		{&grantingBackend{granted: keyPermissions}, []string{}},
+		{&grantingBackend{granted: []string{"cloudkms.cryptoKeyVersions.useToEncrypt", "cloudkms.cryptoKeys.get"}}, []string{"cloudkms.cryptoKeyVersions.useToDecrypt", "cloudkms.cryptoKeyVersions.list"}},
+		{&grantingBackend{}, keyPermissions},
+		{&grantingBackend{err: errors.New("no token")}, nil},
+	} {
+		kmsSelected = test.backend
+		if missing := missingPermissions(testKey); !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("granted %v: expecting %v missing, got %v", test.backend.granted, test.missing, missing)
+		}
+	}
+}
+
+func TestRolesFor(t *testing.T) {
+	for _, test := range []struct {
+		permissions []string
+		roles       []string
+	}{
+		{keyPermissions, []string{"roles/cloudkms.cryptoKeyEncrypterDecrypter", "roles/cloudkms.viewer"}},
+		{[]string{"cloudkms.cryptoKeyVersions.useToEncrypt"}, []string{"roles/cloudkms.cryptoKeyEncrypter"}},
+		{[]string{"cloudkms.cryptoKeyVersions.useToDecrypt", "cloudkms.cryptoKeys.get"}, []string{"roles/cloudkms.cryptoKeyDecrypter", "roles/cloudkms.viewer"}},
+		{[]string{"cloudkms.cryptoKeys.get", "cloudkms.cryptoKeys.create"}, []string{"roles/cloudkms.admin"}},
+		{nil, []string{}},
+	} {
+		if roles := rolesFor(test.permissions); !reflect.DeepEqual(roles, test.roles) {
+			t.Errorf("%v: expecting %v, got %v", test.permissions, test.roles, roles)
+		}
+	}
+}
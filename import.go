@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// secretStore is a place outside the repository that secrets are imported
+// from and exported to, such as a password manager. Stores are named with a
+// URI whose scheme picks the store, e.g. op://vault/item for 1Password or
+// pass://folder for a password-store.
+type secretStore interface {
+	// read returns the values stored at ref.
+	read(ref string) ([]secretField, error)
+	// write stores fields at ref, replacing values of the same name.
+	write(ref string, fields []secretField) error
+}
+
+func storeFor(uri string) (secretStore, string, error) {
+	scheme, ref, ok := strings.Cut(uri, "://")
+	if !ok || ref == "" {
+		return nil, "", fmt.Errorf("expecting a store such as op://vault/item, got %q", uri)
+	}
+	switch scheme {
+	case "op":
+		return &onePasswordStore{}, ref, nil
+	case "pass":
+		return &passStore{}, ref, nil
+	}
+	return nil, "", fmt.Errorf("unsupported store %s://, expecting op:// or pass://", scheme)
+}
+
+// importedPath is the .enc an import writes to: the file given, or one named
+// after the last part of the store reference in the current folder.
+func importedPath(ref string, files []string) (string, error) {
+	if len(files) > 1 {
+		return "", fmt.Errorf("import writes a single file, got %d", len(files))
+	}
+	target := outPath
+	if len(files) == 1 {
+		target = files[0]
+	}
+	if target == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		target = filepath.Join(cwd, refName(ref)+"-secret.yaml")
+	}
+	if !strings.HasSuffix(target, ".enc") {
+		target += ".enc"
+	}
+	return target, nil
+}
+
+// refName names a file after the last part of a store reference.
+func refName(ref string) string {
+	return credentialNameCharacters.ReplaceAllString(ref[strings.LastIndex(ref, "/")+1:], "-")
+}
+
+func importSecrets(uri string, files []string) error {
+	if strings.HasSuffix(uri, bundleSuffix) {
+		return importBundle(uri, files)
+	}
+	store, ref, err := storeFor(uri)
+	if err != nil {
+		return err
+	}
+	return pullSecrets(store, uri, ref, files)
+}
+
+func exportSecrets(uri string, files []string) error {
+	if exportBundle != "" {
+		if uri != "" {
+			file, err := filepath.Abs(uri)
+			if err != nil {
+				return err
+			}
+			files = append([]string{file}, files...)
+		}
+		return sealBundle(exportBundle, files)
+	}
+	if uri == "" {
+		return errors.New("expecting the store to export to, or --bundle")
+	}
+	store, ref, err := storeFor(uri)
+	if err != nil {
+		return err
+	}
+	return pushSecrets(store, uri, ref, files)
+}
+
+// pullSecrets seals the values kept in a store into a YAML file, without
+// writing the plaintext. source names the store in messages.
+func pullSecrets(store secretStore, source string, ref string, files []string) error {
+	ciphertextFile, err := importedPath(ref, files)
+	if err != nil {
+		return err
+	}
+	plaintextFile := strings.TrimSuffix(ciphertextFile, ".enc")
+	if dryRun {
+		printProgress("would import %s into %s", source, ciphertextFile)
+		return nil
+	}
+	if err := checkPolicy("seal", plaintextFile); err != nil {
+		return fmt.Errorf("%s: %w", plaintextFile, err)
+	}
+	if fileExists(ciphertextFile) && !confirm(fmt.Sprintf("Replace %s with the values from %s?", ciphertextFile, source)) {
+		return errors.New("import cancelled")
+	}
+	fields, err := store.read(ref)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	if len(fields) == 0 {
+		return fmt.Errorf("%s: no values to import", source)
+	}
+	headers := plaintextHeaders{Path: projectPath(plaintextFile), Mode: 0600, ModifiedAt: time.Now()}
+	e, err := sealBytes(plaintextFile, fileKey(ciphertextFile), fieldsYAML(fields), headers, readEnvelope(ciphertextFile))
+	if err != nil {
+		return err
+	}
+	if err := writeEnvelope(ciphertextFile, e); err != nil {
+		return err
+	}
+	printProgress("imported %d value(s) from %s into %s", len(fields), source, ciphertextFile)
+	if err := addGitIgnore(projectRoot, plaintextFile); err != nil && !errors.Is(err, ErrPlaintextTracked) {
+		return err
+	}
+	return nil
+}
+
+// pushSecrets copies the values of sealed files to a store.
+func pushSecrets(store secretStore, destination string, ref string, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("expecting the .enc files to copy to %s", destination)
+	}
+	fields := []secretField{}
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".enc") {
+			return fmt.Errorf("%w: %s", ErrNotEncFile, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		plaintext, _, err := openBytes(file, data)
+		recordAudit(commandName, file, err)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		fileFields, err := secretFields(file, plaintext)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		fields = append(fields, fileFields...)
+	}
+	if dryRun {
+		for _, field := range fields {
+			printProgress("would export %s to %s", field.name("."), destination)
+		}
+		return nil
+	}
+	if err := store.write(ref, fields); err != nil {
+		return fmt.Errorf("%s: %w", destination, err)
+	}
+	printProgress("exported %d value(s) to %s", len(fields), destination)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memoryStore keeps fields in memory by reference, in place of a password
+// manager.
+type memoryStore map[string][]secretField
+
+func (m memoryStore) read(ref string) ([]secretField, error) {
+	return m[ref], nil
+}
+
+func (m memoryStore) write(ref string, fields []secretField) error {
+	m[ref] = fields
+	return nil
+}
+
+func TestStoreFor(t *testing.T) {
+	for _, test := range []struct {
+		uri string
+		ref string
+		err string
+	}{
+		{"op://vault/item", "vault/item", ""},
+		{"vault/item", "", "expecting a store such as op://vault/item"},
+		{"op://", "", "expecting a store such as op://vault/item"},
+		{"vault://secret/app", "", "unsupported store vault://"},
+	} {
+		_, ref, err := storeFor(test.uri)
+		if test.err == "" && (err != nil || ref != test.ref) {
+			t.Errorf("%s: expecting %s, got %s (%v)", test.uri, test.ref, ref, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expecting an error with %q, got %v", test.uri, test.err, err)
+		}
+	}
+}
+
+func TestImportedPath(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if dir, err = os.Getwd(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { outPath = "" }()
+	for _, test := range []struct {
+		ref     string
+		files   []string
+		outPath string
+		path    string
+	}{
+		{"vault/payments api", nil, "", filepath.Join(dir, "payments-api-secret.yaml.enc")},
+		{"vault/item", []string{"/app/db-secret.yaml"}, "", "/app/db-secret.yaml.enc"},
+		{"vault/item", []string{"/app/db-secret.yaml.enc"}, "", "/app/db-secret.yaml.enc"},
+		{"vault/item", nil, "/app/out-secret.yaml", "/app/out-secret.yaml.enc"},
+	} {
+		outPath = test.outPath
+		if path, err := importedPath(test.ref, test.files); err != nil || path != test.path {
+			t.Errorf("%s %q: expecting %s, got %s (%v)", test.ref, test.files, test.path, path, err)
+		}
+	}
+	if _, err := importedPath("vault/item", []string{"a", "b"}); err == nil {
+		t.Error("expecting an error importing into two files")
+	}
+}
+
+func TestImportAndExport(t *testing.T) {
+	root := useFakeBackend(t)
+	fields := []secretField{
+		{[]string{"database", "password"}, "hunter2"},
+		{[]string{"api-key"}, "abc"},
+	}
+	store := memoryStore{"vault/app": fields}
+	file := filepath.Join(root, "app-secret.yaml")
+	if err := pullSecrets(store, "op://vault/app", "vault/app", []string{file}); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(file) {
+		t.Error("expecting no plaintext written")
+	}
+	if err := pushSecrets(store, "op://vault/copy", "vault/copy", []string{file + ".enc"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(store["vault/copy"], fields) {
+		t.Errorf("expecting %q exported, got %q", fields, store["vault/copy"])
+	}
+	if err := pullSecrets(store, "op://vault/empty", "vault/empty", []string{filepath.Join(root, "empty-secret.yaml")}); err == nil || !strings.Contains(err.Error(), "no values to import") {
+		t.Errorf("expecting nothing to import, got %v", err)
+	}
+	if err := pushSecrets(store, "op://vault/copy", "vault/copy", []string{file}); err == nil || !strings.Contains(err.Error(), ErrNotEncFile.Error()) {
+		t.Errorf("expecting only .enc files exported, got %v", err)
+	}
+}
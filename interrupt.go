@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// An interrupted run over files stops starting new ones, cancels the KMS
+// calls in flight and lists the files it got through. Files are written to
+// a temporary file renamed into place, so that none is left half written; a
+// second interrupt removes the temporary files and exits right away.
+
+var errInterrupted = errors.New("interrupted")
+
+// tempFilePrefix starts the names of temporary files, which are named
+// .secrets-tmp-<random>-<name of the file written>.
+const tempFilePrefix string = ".secrets-tmp-"
+
+// interruptContext is canceled on the first interrupt, to cancel KMS calls.
+var interruptContext, cancelInterruptContext = context.WithCancel(context.Background())
+
+var tempFiles = map[string]bool{}
+var tempFilesMutex sync.Mutex
+
+// exit sends pending audit events and telemetry before exiting with code.
+// main exits through exit or exitIfError, and everything else returns
+// errors, except handleInterrupts, which exits with 130 from its goroutine
+// on a second interrupt without waiting for the command to return.
+func exit(code int) {
+	flushAudit()
+	flushTelemetry(code)
+	os.Exit(code)
+}
+
+func isInterrupted() bool {
+	return interruptContext.Err() != nil
+}
+
+// handleInterrupts traps SIGINT and SIGTERM until the returned function is
+// called.
+func handleInterrupts() func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case s := <-signals:
+			errPrintln("Interrupted by %s, stopping; interrupt again to exit now", s)
+			cancelInterruptContext()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			removeTempFiles()
+			exit(130)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func removeTempFiles() {
+	tempFilesMutex.Lock()
+	defer tempFilesMutex.Unlock()
+	for file := range tempFiles {
+		os.Remove(file)
+	}
+}
+
+func trackTempFile(file string, track bool) {
+	tempFilesMutex.Lock()
+	defer tempFilesMutex.Unlock()
+	if track {
+		tempFiles[file] = true
+	} else {
+		delete(tempFiles, file)
+	}
+}
+
+// writeFileAtomically writes data to file with mode through a temporary file
+// in the same directory, which is removed if writing fails or is interrupted.
+func writeFileAtomically(file string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), tempFilePrefix+"*-"+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	trackTempFile(tmp.Name(), true)
+	defer trackTempFile(tmp.Name(), false)
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useInterruptContext gives the test an interrupt context of its own, for
+// it to interrupt.
+func useInterruptContext(t *testing.T) context.CancelFunc {
+	t.Helper()
+	previousContext, previousCancel := interruptContext, cancelInterruptContext
+	interruptContext, cancelInterruptContext = context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancelInterruptContext()
+		interruptContext, cancelInterruptContext = previousContext, previousCancel
+	})
+	return cancelInterruptContext
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "secret.yaml")
+	writeTestFile(t, file, []byte("old"), 0644)
+	if err := writeFileAtomically(file, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("expecting the new content, got %q", data)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expecting mode 0600, got %v, %v", info.Mode().Perm(), err)
+	}
+	if err := writeFileAtomically(filepath.Join(dir, "missing", "secret.yaml"), []byte("new"), 0600); err == nil {
+		t.Error("expecting an error writing into a missing folder")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			t.Errorf("expecting no temporary file left, got %s", entry.Name())
+		}
+	}
+	if len(tempFiles) != 0 {
+		t.Errorf("expecting no temporary file tracked, got %v", tempFiles)
+	}
+}
+
+func TestForEachFileStopsOnInterrupt(t *testing.T) {
+	useFakeBackend(t)
+	interrupt := useInterruptContext(t)
+	files := []string{"a", "b", "c", "d"}
+	processed := []string{}
+	var err error
+	output := captureStderr(t, func() {
+		err = forEachFile(encryptCmd, "sealing", files, func(file string) error {
+			processed = append(processed, file)
+			if file == "b" {
+				interrupt()
+				return errInterrupted
+			}
+			return nil
+		})
+	})
+	if !errors.Is(err, errInterrupted) {
+		t.Errorf("expecting an interrupted error, got %v", err)
+	}
+	if strings.Join(processed, " ") != "a b" {
+		t.Errorf("expecting no file started after the interrupt, got %q", processed)
+	}
+	for _, expected := range []string{"Interrupted sealing after 1 of 4 files", "  done:     a\n", "  not done: b\n", "  not done: d\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expecting %q in %q", expected, output)
+		}
+	}
+}
+
+// quotaBackend is the fake backend always out of quota.
+type quotaBackend struct {
+	fakeBackend
+	calls int
+}
+
+func (q *quotaBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	q.calls++
+	return nil, &kmsAPIError{Status: "RESOURCE_EXHAUSTED", Message: "Quota exceeded"}
+}
+
+func TestQuotaRetriesStopOnInterrupt(t *testing.T) {
+	useFakeBackend(t)
+	backend := &quotaBackend{}
+	kmsSelected = backend
+	useInterruptContext(t)()
+	start := time.Now()
+	if _, err := callKms("encrypt", testKey, []byte("data")); !errors.Is(err, errInterrupted) {
+		t.Errorf("expecting an interrupted error, got %v", err)
+	}
+	if backend.calls != 1 || time.Since(start) >= quotaBackoff(0) {
+		t.Errorf("expecting no retry once interrupted, got %d calls in %s", backend.calls, time.Since(start))
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	protectionSoftware string = "software"
+	protectionHSM      string = "hsm"
+)
+
+const defaultRotationPeriod time.Duration = 100 * 24 * time.Hour
+
+// keyCreation is how new keys are created. A zero RotationPeriod creates
+// keys that are never rotated automatically.
+type keyCreation struct {
+	RotationPeriod   time.Duration
+	NextRotationTime time.Time
+	ProtectionLevel  string
+	Labels           map[string]string
+}
+
+// keyCreationSettings are the settings as written in .secrets.yaml or
+// given as flags, before defaults are applied.
+type keyCreationSettings struct {
+	RotationPeriod   string
+	NextRotationTime string
+	ProtectionLevel  string
+	Labels           map[string]string
+}
+
+var keyCreationFlags = keyCreationSettings{Labels: map[string]string{}}
+
+// labelsFlag collects repeated --label name=value flags.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	return formatLabels(l)
+}
+
+func (l labelsFlag) Set(value string) error {
+	name, labelValue, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expecting name=value, got %q", value)
+	}
+	l[name] = labelValue
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+var labelPattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+var invalidLabelCharacters = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// labelValue turns a name such as an email address into a valid label value.
+func labelValue(value string) string {
+	value = invalidLabelCharacters.ReplaceAllString(strings.ToLower(value), "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-")
+}
+
+// defaultKeyLabels attribute new keys to the repository and the person who
+// created them, so cost and ownership tooling can tell what they are for.
+func defaultKeyLabels() map[string]string {
+	labels := map[string]string{"managed-by": "secrets"}
+	if projectRoot != "" {
+		if repo := labelValue(getKeyName(projectRoot)); repo != "" {
+			labels["repo"] = repo
+		}
+	}
+	if createdBy := labelValue(callerProvenance().By); createdBy != "" {
+		labels["created-by"] = createdBy
+	}
+	return labels
+}
+
+func checkLabels(labels map[string]string) error {
+	for name, value := range labels {
+		if name == "" || !labelPattern.MatchString(name) || !labelPattern.MatchString(value) {
+			return fmt.Errorf("invalid label %s=%s, names and values are up to 63 lowercase letters, digits, _ and -", name, value)
+		}
+	}
+	return nil
+}
+
+// parseDays parses durations like 90d as well as anything
+// time.ParseDuration accepts.
+func parseDays(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func parseKeyCreationSettings(file string, node *yamlNode) (keyCreationSettings, error) {
+	settings := keyCreationSettings{Labels: map[string]string{}}
+	if node.kind != yamlMapping {
+		return settings, fmt.Errorf("%s: key-creation must be a mapping", file)
+	}
+	settings.RotationPeriod = strings.TrimSpace(node.get("rotation-period").value())
+	settings.NextRotationTime = strings.TrimSpace(node.get("next-rotation-time").value())
+	settings.ProtectionLevel = strings.TrimSpace(node.get("protection-level").value())
+	if labels := node.get("labels"); labels != nil {
+		if labels.kind != yamlMapping {
+			return settings, fmt.Errorf("%s: key-creation labels must be a mapping", file)
+		}
+		for i, name := range labels.keys {
+			settings.Labels[yamlUnquote(name)] = labels.values[i].value()
+		}
+	}
+	return settings, nil
+}
+
+// merge applies the settings that are set in other.
+func (s *keyCreationSettings) merge(other keyCreationSettings) {
+	if other.RotationPeriod != "" {
+		s.RotationPeriod = other.RotationPeriod
+	}
+	if other.NextRotationTime != "" {
+		s.NextRotationTime = other.NextRotationTime
+	}
+	if other.ProtectionLevel != "" {
+		s.ProtectionLevel = other.ProtectionLevel
+	}
+	for name, value := range other.Labels {
+		s.Labels[name] = value
+	}
+}
+
+// requiredProtectionLevel is the protection level set in the root
+// .secrets.yaml or with --protection-level, or "" when none was set.
+func requiredProtectionLevel() (string, error) {
+	level := keyCreationFlags.ProtectionLevel
+	if level == "" && projectRoot != "" {
+		config, err := configFor(projectRoot)
+		if err != nil {
+			return "", err
+		}
+		level = config.KeyCreation.ProtectionLevel
+	}
+	return strings.ToLower(level), nil
+}
+
+// checkProtectionLevel refuses to seal with an existing key that is less
+// protected than required, so that asking for hsm keys is a guarantee and not
+// only a setting for new keys. Keys that don't exist yet are created with the
+// required level.
+func checkProtectionLevel(keyName string) error {
+	required, err := requiredProtectionLevel()
+	if err != nil || required != protectionHSM {
+		return err
+	}
+	k, err := describeKey(keyName)
+	if isNotFoundError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(k.Primary.ProtectionLevel, protectionHSM) {
+		level := strings.ToLower(k.Primary.ProtectionLevel)
+		if level == "" {
+			level = protectionSoftware
+		}
+		return fmt.Errorf("key %s is %s protected but %s is required, seal with an HSM key (--key) or create one with `secrets seal --key <new key> --protection-level hsm`", k.Name, level, protectionHSM)
+	}
+	return nil
+}
+
+// keyCreationParams are the settings for creating keys in this project: the
+// defaults, overridden by the root .secrets.yaml, overridden by flags.
+// Labels set to an empty value are left off.
+func keyCreationParams() (*keyCreation, error) {
+	settings := keyCreationSettings{Labels: defaultKeyLabels()}
+	if projectRoot != "" {
+		config, err := configFor(projectRoot)
+		if err != nil {
+			return nil, err
+		}
+		settings.merge(config.KeyCreation)
+	}
+	settings.merge(keyCreationFlags)
+
+	params := &keyCreation{
+		RotationPeriod:  defaultRotationPeriod,
+		ProtectionLevel: protectionSoftware,
+		Labels:          settings.Labels,
+	}
+	if settings.RotationPeriod == "never" {
+		params.RotationPeriod = 0
+	} else if settings.RotationPeriod != "" {
+		period, err := parseDays(settings.RotationPeriod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotation period: %w", err)
+		}
+		if period < 24*time.Hour {
+			return nil, fmt.Errorf("rotation period %s is shorter than the 1 day KMS allows", settings.RotationPeriod)
+		}
+		params.RotationPeriod = period
+	}
+	if params.RotationPeriod > 0 {
+		params.NextRotationTime = time.Now().Add(params.RotationPeriod)
+		if settings.NextRotationTime != "" {
+			if t, err := time.Parse(time.RFC3339, settings.NextRotationTime); err == nil {
+				params.NextRotationTime = t
+			} else if offset, err := parseDays(settings.NextRotationTime); err == nil {
+				params.NextRotationTime = time.Now().Add(offset)
+			} else {
+				return nil, fmt.Errorf("invalid next rotation time %q, expecting a time such as 2030-01-01T00:00:00Z or an offset such as 30d", settings.NextRotationTime)
+			}
+		}
+	}
+	if settings.ProtectionLevel != "" {
+		params.ProtectionLevel = strings.ToLower(settings.ProtectionLevel)
+	}
+	if params.ProtectionLevel != protectionSoftware && params.ProtectionLevel != protectionHSM {
+		return nil, fmt.Errorf("unknown protection level %q, expecting %s or %s", settings.ProtectionLevel, protectionSoftware, protectionHSM)
+	}
+	for name, value := range params.Labels {
+		if value == "" {
+			delete(params.Labels, name)
+		}
+	}
+	if err := checkLabels(params.Labels); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
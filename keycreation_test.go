@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDays(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		duration time.Duration
+		err      bool
+	}{
+		{"90d", 90 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"xd", 0, true},
+		{"soon", 0, true},
+	} {
+		duration, err := parseDays(test.value)
+		if duration != test.duration || (err != nil) != test.err {
+			t.Errorf("%q: expecting %s, got %s (%v)", test.value, test.duration, duration, err)
+		}
+	}
+}
+
+func TestKeyCreationParams(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		config          string
+		flags           keyCreationSettings
+		rotationPeriod  time.Duration
+		protectionLevel string
+		err             string
+	}{
+		{"defaults", "", keyCreationSettings{}, defaultRotationPeriod, protectionSoftware, ""},
+		{"config", "key-creation:\n  rotation-period: 30d\n  protection-level: HSM\n", keyCreationSettings{}, 30 * 24 * time.Hour, protectionHSM, ""},
+		{"flags override config", "key-creation:\n  rotation-period: 30d\n", keyCreationSettings{RotationPeriod: "never"}, 0, protectionSoftware, ""},
+		{"too short", "", keyCreationSettings{RotationPeriod: "12h"}, 0, "", "shorter than the 1 day KMS allows"},
+		{"unknown level", "key-creation:\n  protection-level: external\n", keyCreationSettings{}, 0, "", "unknown protection level"},
+		{"bad next rotation", "", keyCreationSettings{NextRotationTime: "tomorrow"}, 0, "", "invalid next rotation time"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeConfigs(t, root, map[string]string{".": test.config})
+			test.flags.Labels = map[string]string{}
+			keyCreationFlags = test.flags
+			defer func() { keyCreationFlags = keyCreationSettings{Labels: map[string]string{}} }()
+			params, err := keyCreationParams()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expecting an error with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params.RotationPeriod != test.rotationPeriod || params.ProtectionLevel != test.protectionLevel {
+				t.Errorf("expecting rotation every %s at %s, got %+v", test.rotationPeriod, test.protectionLevel, params)
+			}
+			if params.RotationPeriod > 0 && params.NextRotationTime.IsZero() {
+				t.Error("expecting a next rotation time")
+			}
+		})
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		label string
+	}{
+		{"payments-api", "payments-api"},
+		{"Jane.Doe@Example.com", "jane-doe-example-com"},
+		{"deploy@my-project.iam.gserviceaccount.com", "deploy-my-project-iam-gserviceaccount-com"},
+		{"--", ""},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+	} {
+		if label := labelValue(test.value); label != test.label || !labelPattern.MatchString(label) {
+			t.Errorf("%q: expecting %q, got %q", test.value, test.label, label)
+		}
+	}
+}
+
+func TestDefaultKeyLabels(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config string
+		labels map[string]string
+	}{
+		{"defaults", "", map[string]string{"managed-by": "secrets", "repo": "project", "created-by": "dev-example-com"}},
+		{"config", "key-creation:\n  labels:\n    team: payments\n    created-by: ''\n", map[string]string{"managed-by": "secrets", "repo": "project", "team": "payments"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeConfigs(t, root, map[string]string{".": test.config})
+			provenanceOnce.Do(func() {})
+			currentProvenance = provenance{By: "Dev@Example.com"}
+			defer func() { currentProvenance = provenance{} }()
+			params, err := keyCreationParams()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(params.Labels, test.labels) {
+				t.Errorf("expecting %v, got %v", test.labels, params.Labels)
+			}
+		})
+	}
+}
+
+func TestCheckProtectionLevel(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		required string
+		level    string
+		missing  bool
+		err      string
+	}{
+		{"not required", "", "SOFTWARE", false, ""},
+		{"hsm key", "hsm", "HSM", false, ""},
+		{"software key", "hsm", "SOFTWARE", false, "is software protected but hsm is required"},
+		{"unknown level", "hsm", "", false, "is software protected"},
+		{"missing key", "hsm", "", true, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeConfigs(t, root, map[string]string{".": "key-creation:\n  protection-level: " + test.required + "\n"})
+			if test.missing {
+				kmsSelected = &missingKeyBackend{}
+			} else {
+				describedKeys[testKey] = &kmsKey{Name: testKey, Primary: kmsKeyVersion{ProtectionLevel: test.level}}
+			}
+			err := checkProtectionLevel(testKey)
+			if test.err == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
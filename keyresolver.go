@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A key resolver in the root .secrets.yaml names the project's default key
+// in place of the repository name, for organizations with their own naming
+// scheme, e.g.
+//
+//	key-resolver: ./tools/key-name
+//
+// It's a shell command run from the project root, given the project's root,
+// folder name, repository and git remotes as JSON on stdin, that prints the
+// key name.
+
+// The key-fallback setting of the root .secrets.yaml names the project's key
+// when no remote does: repo-name, the default, after the project's folder;
+// path-hash after the folder and its path from the home folder; config-value
+// with its fallback-key; error refuses to guess.
+const (
+	keyFallbackRepoName    string = "repo-name"
+	keyFallbackPathHash    string = "path-hash"
+	keyFallbackConfigValue string = "config-value"
+	keyFallbackError       string = "error"
+)
+
+var keyFallbacks = []string{keyFallbackRepoName, keyFallbackPathHash, keyFallbackConfigValue, keyFallbackError}
+
+func isKeyFallback(name string) bool {
+	for _, fallback := range keyFallbacks {
+		if name == fallback {
+			return true
+		}
+	}
+	return false
+}
+
+type gitRemote struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Direction string `json:"direction"`
+}
+
+type keyResolverInput struct {
+	Root    string      `json:"root"`
+	Folder  string      `json:"folder"`
+	Repo    string      `json:"repo"`
+	Remotes []gitRemote `json:"remotes"`
+}
+
+var resolvedKeysMutex sync.Mutex
+var resolvedKeys = map[string]string{}
+
+// gitRemotes lists the fetch and push URLs of the remotes of a project.
+func gitRemotes(projectRoot string) ([]gitRemote, error) {
+	_, stdOut, _, err := runCommand("git", "-C", projectRoot, "remote", "-v")
+	if err != nil {
+		return nil, err
+	}
+	remotes := []gitRemote{}
+	for _, line := range strings.Split(stdOut, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		remotes = append(remotes, gitRemote{
+			Name:      fields[0],
+			URL:       fields[1],
+			Direction: strings.Trim(fields[2], "()"),
+		})
+	}
+	return remotes, nil
+}
+
+// resolveKeyName runs resolver for the project at root, once per run.
+func resolveKeyName(root string, resolver string) (string, error) {
+	resolvedKeysMutex.Lock()
+	defer resolvedKeysMutex.Unlock()
+	if keyName, ok := resolvedKeys[root]; ok {
+		return keyName, nil
+	}
+	input := keyResolverInput{Root: root, Folder: filepath.Base(root), Remotes: []gitRemote{}}
+	if repo, err := getProjectRepo(root); err == nil {
+		input.Repo = repo
+	}
+	if remotes, err := gitRemotes(root); err == nil {
+		input.Remotes = remotes
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("sh", "-c", resolver)
+	cmd.Dir = root
+	cmd.Stdin = bytes.NewReader(data)
+	var stdOut, stdErr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdOut, &stdErr
+	span := startSpan("key-resolver", "key-resolver", nil)
+	err = cmd.Run()
+	endSpan(span, err)
+	if message := strings.TrimSpace(stdErr.String()); err != nil && message != "" {
+		return "", fmt.Errorf("key resolver %q failed: %w: %s", resolver, err, message)
+	}
+	if err != nil {
+		return "", fmt.Errorf("key resolver %q failed: %w", resolver, err)
+	}
+	keyName, _, _ := strings.Cut(strings.TrimSpace(stdOut.String()), "\n")
+	keyName, err = normalizeKeyName(keyName)
+	if err != nil {
+		return "", fmt.Errorf("key resolver %q: %w", resolver, err)
+	}
+	if keyName == "" {
+		return "", fmt.Errorf("key resolver %q printed no key name", resolver)
+	}
+	printDebugln("key resolver %q named the key %s", resolver, keyName)
+	resolvedKeys[root] = keyName
+	return keyName, nil
+}
+
+// projectKeyName is the name of a project's key: the one its key resolver
+// gives if it has one, else the name of its repository, else the one its
+// key-fallback gives.
+func projectKeyName(root string) (string, error) {
+	config, err := configFor(root)
+	if err != nil {
+		return "", err
+	}
+	if config.KeyResolver != "" {
+		return resolveKeyName(root, config.KeyResolver)
+	}
+	if isGitless() {
+		return fallbackKeyName(config, root, errGitless)
+	}
+	repo, err := getProjectRepo(root)
+	if err == nil {
+		return repo, nil
+	}
+	var ambiguous *ambiguousRemoteError
+	if errors.As(err, &ambiguous) {
+		return "", err
+	}
+	return fallbackKeyName(config, root, err)
+}
+
+// fallbackKeyName names the key of a project without a remote to name it
+// after, for the reason given by err.
+func fallbackKeyName(config *secretsConfig, root string, err error) (string, error) {
+	switch config.KeyFallback {
+	case keyFallbackPathHash:
+		return pathHashKeyName(root), nil
+	case keyFallbackConfigValue:
+		return config.FallbackKey, nil
+	case keyFallbackError:
+		return "", fmt.Errorf("%w; set key in .secrets.yaml or change its key-fallback", err)
+	}
+	return filepath.Base(root), nil
+}
+
+// pathHashKeyName names a key after the project's folder and a hash of its
+// path from the home folder, the same for clones at the same place.
+func pathHashKeyName(root string) string {
+	path := root
+	if home, err := os.UserHomeDir(); err == nil {
+		if relativePath, err := filepath.Rel(home, root); err == nil && !strings.HasPrefix(relativePath, "..") {
+			path = relativePath
+		}
+	}
+	digest := sha256.Sum256([]byte(filepath.ToSlash(path)))
+	return filepath.Base(root) + "-" + hex.EncodeToString(digest[:4])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keys are created automatically but key rings aren't, since a key ring can
+// never be deleted and its location can't be changed. --create-keyring
+// creates a missing one after confirmation.
+
+var createKeyRingFlag bool
+
+type missingKeyRingError struct {
+	keyRingName string
+}
+
+func (e *missingKeyRingError) Error() string {
+	parts := strings.Split(e.keyRingName, "/")
+	project, ringLocation, ring := parts[1], parts[3], parts[5]
+	return fmt.Sprintf("key ring %s doesn't exist.\n"+
+		"Create it with `secrets seal --create-keyring`, or ask a project owner to run:\n"+
+		"  gcloud kms keyrings create %s --location %s --project %s",
+		e.keyRingName, ring, ringLocation, project)
+}
+
+// createKeyRing creates the key ring of keyName when --create-keyring was
+// given and the creation is confirmed, and otherwise explains how to.
+func createKeyRing(keyName string) error {
+	name, err := keyResourceName(keyName)
+	if err != nil {
+		return err
+	}
+	keyRingName := keyRingOf(name)
+	if !createKeyRingFlag {
+		return &missingKeyRingError{keyRingName}
+	}
+	if !confirm(fmt.Sprintf("Key ring %s doesn't exist, create it? Key rings can't be deleted.", keyRingName)) {
+		return &missingKeyRingError{keyRingName}
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return err
+	}
+	printProgress("creating key ring %s", keyRingName)
+	err = client.createKeyRing(keyRingName)
+	if err != nil && !hasKmsStatus(err, "ALREADY_EXISTS") {
+		return explainKmsError(name, err)
+	}
+	return nil
+}
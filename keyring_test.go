@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// missingKeyRingBackend is the fake backend without a key ring until one is
+// created.
+type missingKeyRingBackend struct {
+	fakeBackend
+	keyRings []string
+}
+
+func (m *missingKeyRingBackend) createKey(keyName string, params *keyCreation) error {
+	if len(m.keyRings) == 0 {
+		return &kmsAPIError{Status: "NOT_FOUND", Message: "KeyRing not found"}
+	}
+	return nil
+}
+
+func (m *missingKeyRingBackend) createKeyRing(keyRingName string) error {
+	m.keyRings = append(m.keyRings, keyRingName)
+	return nil
+}
+
+func TestCreateKeyInMissingKeyRing(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		createKeyRing bool
+		yes           bool
+		created       bool
+	}{
+		{"explain", false, false, false},
+		{"not confirmed", true, false, false},
+		{"create", true, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeBackend(t)
+			backend := &missingKeyRingBackend{}
+			kmsSelected = backend
+			createKeyRingFlag, assumeYes, ciMode = test.createKeyRing, test.yes, true
+			defer func() { createKeyRingFlag, assumeYes, ciMode = false, false, false }()
+			var err error
+			captureStderr(t, func() { err = createKey(testKey) })
+			if test.created {
+				if err != nil || len(backend.keyRings) != 1 || backend.keyRings[0] != keyRingOf(testKey) {
+					t.Errorf("expecting %s created, got %q (%v)", keyRingOf(testKey), backend.keyRings, err)
+				}
+				return
+			}
+			var missing *missingKeyRingError
+			if !errors.As(err, &missing) || len(backend.keyRings) != 0 {
+				t.Fatalf("expecting the missing key ring explained and not created, got %q (%v)", backend.keyRings, err)
+			}
+			if !strings.Contains(err.Error(), "gcloud kms keyrings create secrets --location global --project fake-project") {
+				t.Errorf("expecting the gcloud command to create the key ring, got %q", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const keysReportCmd string = "report"
+
+// keyUsage is a key version and the files sealed with it.
+type keyUsage struct {
+	Key     string   `json:"key"`
+	Version string   `json:"version"`
+	Files   []string `json:"files"`
+}
+
+// unexpectedKey is a file sealed with another key than the configuration
+// gives it, such as a production secret sealed with the development key.
+type unexpectedKey struct {
+	File     string `json:"file"`
+	Key      string `json:"key"`
+	Expected string `json:"expected"`
+}
+
+type keysReportResult struct {
+	Keys       []keyUsage      `json:"keys"`
+	Unexpected []unexpectedKey `json:"unexpected"`
+	Legacy     []string        `json:"legacy"`
+}
+
+func buildKeysReport(files []string) (*keysReportResult, error) {
+	result := &keysReportResult{Keys: []keyUsage{}, Unexpected: []unexpectedKey{}, Legacy: []string{}}
+	usage := map[[2]string]*keyUsage{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		name := displayPath(file)
+		e, err := parseEnvelope(data)
+		if errors.Is(err, errNotEnvelope) {
+			result.Legacy = append(result.Legacy, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		id := [2]string{e.Key, versionNumber(e.KeyVersion)}
+		if usage[id] == nil {
+			usage[id] = &keyUsage{Key: id[0], Version: id[1], Files: []string{}}
+		}
+		usage[id].Files = append(usage[id].Files, name)
+		if expected := fileKey(file); !isSameKey(e.Key, expected) {
+			result.Unexpected = append(result.Unexpected, unexpectedKey{name, e.Key, expected})
+		}
+		secondKey, err := fileSecondKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if secondKey != "" && !isSameKey(e.SecondKey, secondKey) {
+			result.Unexpected = append(result.Unexpected, unexpectedKey{name, e.SecondKey, secondKey + " (dual control)"})
+		}
+	}
+	for _, u := range usage {
+		result.Keys = append(result.Keys, *u)
+	}
+	sort.Slice(result.Keys, func(i, j int) bool {
+		if result.Keys[i].Key != result.Keys[j].Key {
+			return result.Keys[i].Key < result.Keys[j].Key
+		}
+		return result.Keys[i].Version < result.Keys[j].Version
+	})
+	return result, nil
+}
+
+// keysReport prints which key versions the files are sealed with, and fails
+// when any file is sealed with a key its configuration doesn't give it.
+func keysReport(files []string) error {
+	result, err := buildKeysReport(files)
+	if err != nil {
+		return err
+	}
+	if ciMode {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVERSION\tFILES")
+		for _, u := range result.Keys {
+			fmt.Fprintf(w, "%s\t%s\t%d: %s\n", u.Key, u.Version, len(u.Files), strings.Join(u.Files, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		for _, u := range result.Unexpected {
+			errPrintln("Warning: %s is sealed with %s, expecting %s", u.File, u.Key, u.Expected)
+		}
+		if len(result.Legacy) > 0 {
+			errPrintln("Warning: %d file(s) in the legacy format don't record their key, reseal them: %s", len(result.Legacy), strings.Join(result.Legacy, ", "))
+		}
+	}
+	if len(result.Unexpected) > 0 {
+		return fmt.Errorf("%d file(s) sealed with unexpected keys, open and seal them again, or fix .secrets.yaml", len(result.Unexpected))
+	}
+	return nil
+}
+
+func keys(subCmd string, files []string) error {
+	if subCmd != keysReportCmd {
+		return fmt.Errorf("unknown keys command %q, expecting %s", subCmd, keysReportCmd)
+	}
+	return keysReport(files)
+}
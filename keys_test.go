@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuildKeysReport(t *testing.T) {
+	root := useFakeBackend(t)
+	prodKey := testKey + "-prod"
+	writeConfigs(t, root, map[string]string{".": "key: " + testKey + "\nrules:\n  - path: prod/**\n    key: " + prodKey + "\n"})
+	if err := os.MkdirAll(filepath.Join(root, "prod"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"secret.yaml", "prod/secret.yaml"} {
+		writeTestFile(t, filepath.Join(root, file), []byte("token: abc\n"), 0600)
+		if err := encrypt(testKey, filepath.Join(root, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "legacy-secret.yaml.enc"), []byte("\x0a\x24binary"), 0644)
+	result, err := buildKeysReport([]string{
+		filepath.Join(root, "prod", "secret.yaml.enc"),
+		filepath.Join(root, "secret.yaml.enc"),
+		filepath.Join(root, "legacy-secret.yaml.enc"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &keysReportResult{
+		Keys:       []keyUsage{{testKey, "1", []string{"prod/secret.yaml.enc", "secret.yaml.enc"}}},
+		Unexpected: []unexpectedKey{{"prod/secret.yaml.enc", testKey, prodKey}},
+		Legacy:     []string{"legacy-secret.yaml.enc"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, result)
+	}
+	if err := keys("rotate", nil); err == nil {
+		t.Error("expecting an unknown keys command rejected")
+	}
+}
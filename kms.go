@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	transportAuto   string = "auto"
+	transportGcloud string = "gcloud"
+	transportNative string = "native"
+	transportFake   string = "fake"
+)
+
+type kmsKeyVersion struct {
+	Name            string `json:"name"`
+	State           string `json:"state"`
+	ProtectionLevel string `json:"protectionLevel"`
+}
+
+type kmsKey struct {
+	Name             string        `json:"name"`
+	Primary          kmsKeyVersion `json:"primary"`
+	RotationPeriod   string        `json:"rotationPeriod"`
+	NextRotationTime string        `json:"nextRotationTime"`
+}
+
+// kmsBackend talks to Cloud KMS, either through the gcloud CLI or directly
+// to the REST API. Errors are returned as is; the functions below handle
+// retries, key creation and explaining failures for both.
+type kmsBackend interface {
+	encrypt(keyName string, plaintext []byte) ([]byte, error)
+	decrypt(keyName string, ciphertext []byte) ([]byte, error)
+	describeKey(keyName string) (*kmsKey, error)
+	listKeyVersions(keyName string) ([]kmsKeyVersion, error)
+	createKey(keyName string, params *keyCreation) error
+	createKeyRing(keyRingName string) error
+	resourceName(keyName string) (string, error)
+	keyIAMPolicy(keyName string) (*iamPolicy, error)
+	keyRingIAMPolicy(keyName string) (*iamPolicy, error)
+	asymmetricSign(versionName string, digestAlgorithm string, message []byte) ([]byte, error)
+	publicKey(versionName string) (*kmsPublicKey, error)
+	identity() (string, error)
+	whoami() (*callerIdentity, error)
+	testIAMPermissions(keyName string, permissions []string) ([]string, error)
+}
+
+var kmsOnce sync.Once
+var kmsSelected kmsBackend
+var kmsSelectErr error
+
+// kmsClient picks the backend on first use. With --kms-transport auto,
+// gcloud is used when it is installed and the REST API otherwise, or always
+// with --credentials-config. --kms-record and --kms-replay wrap or replace it.
+func kmsClient() (kmsBackend, error) {
+	kmsOnce.Do(func() {
+		if kmsReplay != "" {
+			printDebugln("KMS calls replayed from %s", kmsReplay)
+			kmsSelected, kmsSelectErr = newReplayBackend(kmsReplay)
+			return
+		}
+		transport := kmsTransport
+		if transport == transportAuto && credentialsConfig != "" {
+			transport = transportNative
+		} else if transport == transportAuto {
+			transport = transportGcloud
+			if _, err := exec.LookPath("gcloud"); err != nil && hasDefaultCredentials() {
+				transport = transportNative
+			}
+		}
+		printDebugln("KMS transport: %s", transport)
+		switch transport {
+		case transportGcloud:
+			if credentialsConfig != "" {
+				kmsSelectErr = fmt.Errorf("--credentials-config needs --kms-transport native or auto")
+				return
+			}
+			kmsSelected = &gcloudBackend{}
+		case transportNative:
+			kmsSelected, kmsSelectErr = newNativeBackend()
+		case transportFake:
+			errPrintln("Warning: using the fake KMS backend, files are sealed with a publicly known key")
+			kmsSelected = &fakeBackend{}
+		default:
+			kmsSelectErr = fmt.Errorf("unknown --kms-transport %s, expecting auto, gcloud, native or fake", transport)
+		}
+		if kmsSelectErr == nil && kmsRecord != "" {
+			printDebugln("KMS calls recorded to %s", kmsRecord)
+			kmsSelected = newRecordingBackend(kmsSelected, kmsRecord)
+		}
+	})
+	return kmsSelected, kmsSelectErr
+}
+
+func isKeyResourceName(keyName string) bool {
+	return strings.HasPrefix(keyName, "projects/")
+}
+
+var keyResourcePattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// normalizeKeyName checks a key name or resource name, as pasted from the
+// console or gcloud, so a typo can't silently pick another key. The full
+// name form with the //cloudkms.googleapis.com/ prefix is accepted too.
+func normalizeKeyName(keyName string) (string, error) {
+	keyName = strings.TrimPrefix(strings.TrimSpace(keyName), "//cloudkms.googleapis.com/")
+	if keyName == "" || keyResourcePattern.MatchString(keyName) {
+		return keyName, nil
+	}
+	if strings.Contains(keyName, "/cryptoKeyVersions/") {
+		return "", fmt.Errorf("%s is a key version, use the key %s", keyName, strings.Split(keyName, "/cryptoKeyVersions/")[0])
+	}
+	if strings.Contains(keyName, "/") {
+		return "", fmt.Errorf("invalid key %s, expecting a key name or projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>", keyName)
+	}
+	return keyName, nil
+}
+
+// projectOf is the project of a key resource name.
+func projectOf(keyName string) string {
+	project, _, _ := strings.Cut(strings.TrimPrefix(keyName, "projects/"), "/")
+	return project
+}
+
+// keyRingOf is the key ring of a key resource name.
+func keyRingOf(keyName string) string {
+	keyRingName, _, _ := strings.Cut(keyName, "/cryptoKeys/")
+	return keyRingName
+}
+
+// hasKmsStatus reports whether a backend error carries a KMS status such as
+// NOT_FOUND.
+func hasKmsStatus(err error, status string) bool {
+	var gcloudErr *gcloudError
+	if errors.As(err, &gcloudErr) {
+		return strings.Contains(gcloudErr.stdErr, status)
+	}
+	var apiErr *kmsAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status == status
+	}
+	return false
+}
+
+func isNotFoundError(err error) bool {
+	return errors.Is(err, ErrKeyNotFound)
+}
+
+// callKms encrypts or decrypts input with keyName, creating the key when it
+// doesn't exist yet to encrypt with. A missing key has nothing to decrypt.
+func callKms(operation string, keyName string, input []byte) (string, error) {
+	return callKmsKey(operation, keyName, input, operation == "encrypt")
+}
+
+// callKmsKey is callKms for keys that may not be created, like dual control
+// keys owned by another team, when canCreate is false. The call is retried
+// once after the key is created, and fails if the key is still missing.
+func callKmsKey(operation string, keyName string, input []byte, canCreate bool) (string, error) {
+	client, err := kmsClient()
+	if err != nil {
+		return "", err
+	}
+	call := client.encrypt
+	if operation == "decrypt" {
+		call = client.decrypt
+	}
+	start := time.Now()
+	output, err := call(keyName, input)
+	for attempt := 0; err != nil && isQuotaError(err) && attempt < maxQuotaRetries; attempt++ {
+		delay := quotaBackoff(attempt)
+		printDebugln("KMS quota exceeded, retrying in %s", delay)
+		select {
+		case <-time.After(delay):
+		case <-interruptContext.Done():
+			return "", errInterrupted
+		}
+		output, err = call(keyName, input)
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	countMetric("secrets_kms_requests_total", "operation", operation, "outcome", outcome)
+	observeMetric("secrets_kms_request_duration_seconds", time.Since(start), "operation", operation)
+	if err != nil {
+		if isNotFoundError(err) && canCreate {
+			if ciMode {
+				return "", fmt.Errorf("key %s not found, keys are never created in CI mode: %w", keyName, err)
+			}
+			if err := createKey(keyName); err != nil {
+				return "", err
+			}
+			return callKmsKey(operation, keyName, input, false)
+		}
+		if operation == "encrypt" && len(input) > maxKmsPlaintext && hasKmsStatus(err, "INVALID_ARGUMENT") {
+			return "", fmt.Errorf("%d bytes is over the %d byte limit of KMS encryption", len(input), maxKmsPlaintext)
+		}
+		return "", explainKmsError(keyName, err)
+	}
+	return string(output), nil
+}
+
+var createKeyMutex sync.Mutex
+
+func createKey(keyName string) error {
+	createKeyMutex.Lock()
+	defer createKeyMutex.Unlock()
+	printDebugln("creating key for the project %s", keyName)
+	if dryRun {
+		return nil
+	}
+	params, err := keyCreationParams()
+	if err != nil {
+		return err
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return err
+	}
+	err = client.createKey(keyName, params)
+	if err != nil && isNotFoundError(err) {
+		if err := createKeyRing(keyName); err != nil {
+			return err
+		}
+		err = client.createKey(keyName, params)
+	}
+	if err != nil && hasKmsStatus(err, "ALREADY_EXISTS") {
+		printDebugln("key %s was created concurrently", keyName)
+		return nil
+	}
+	if err != nil {
+		return explainKmsError(keyName, err)
+	}
+	return nil
+}
+
+var keyCacheMutex sync.Mutex
+var describedKeys = map[string]*kmsKey{}
+
+func describeKey(keyName string) (*kmsKey, error) {
+	keyCacheMutex.Lock()
+	k, ok := describedKeys[keyName]
+	keyCacheMutex.Unlock()
+	countMetric("secrets_key_cache_requests_total", "cache", "key", "result", cacheResult(ok))
+	if ok {
+		return k, nil
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return nil, err
+	}
+	k, err = client.describeKey(keyName)
+	if err != nil {
+		return nil, explainKmsError(keyName, err)
+	}
+	keyCacheMutex.Lock()
+	describedKeys[keyName] = k
+	keyCacheMutex.Unlock()
+	return k, nil
+}
+
+var listedKeyVersions = map[string][]kmsKeyVersion{}
+
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+func listKeyVersions(keyName string) ([]kmsKeyVersion, error) {
+	keyCacheMutex.Lock()
+	versions, ok := listedKeyVersions[keyName]
+	keyCacheMutex.Unlock()
+	countMetric("secrets_key_cache_requests_total", "cache", "versions", "result", cacheResult(ok))
+	if ok {
+		return versions, nil
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return nil, err
+	}
+	versions, err = client.listKeyVersions(keyName)
+	if err != nil {
+		return nil, explainKmsError(keyName, err)
+	}
+	keyCacheMutex.Lock()
+	listedKeyVersions[keyName] = versions
+	keyCacheMutex.Unlock()
+	return versions, nil
+}
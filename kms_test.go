@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// missingKeyBackend is the fake backend with a key that stays missing, even
+// after it was created.
+type missingKeyBackend struct {
+	fakeBackend
+	calls   int
+	creates int
+}
+
+func (m *missingKeyBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	m.calls++
+	return nil, &kmsAPIError{Status: "NOT_FOUND", Message: "key not found"}
+}
+
+func (m *missingKeyBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	m.calls++
+	return nil, &kmsAPIError{Status: "NOT_FOUND", Message: "key not found"}
+}
+
+func (m *missingKeyBackend) describeKey(keyName string) (*kmsKey, error) {
+	return nil, &kmsAPIError{Status: "NOT_FOUND", Message: "key not found"}
+}
+
+func (m *missingKeyBackend) createKey(keyName string, params *keyCreation) error {
+	m.creates++
+	return nil
+}
+
+func TestCallKmsCreatesMissingKeysOnce(t *testing.T) {
+	for _, test := range []struct {
+		operation string
+		dryRun    bool
+		calls     int
+		creates   int
+	}{
+		{"encrypt", false, 2, 1},
+		{"encrypt", true, 2, 0},
+		{"decrypt", false, 1, 0},
+		{"decrypt", true, 1, 0},
+	} {
+		backend := &missingKeyBackend{}
+		useFakeBackend(t)
+		kmsSelected, dryRun = backend, test.dryRun
+		_, err := callKms(test.operation, testKey, []byte("data"))
+		dryRun = false
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s (dry run %v): expecting the key not found, got %v", test.operation, test.dryRun, err)
+		}
+		if backend.calls != test.calls || backend.creates != test.creates {
+			t.Errorf("%s (dry run %v): expecting %d calls and %d keys created, got %d and %d", test.operation, test.dryRun, test.calls, test.creates, backend.calls, backend.creates)
+		}
+	}
+}
+
+func TestNormalizeKeyName(t *testing.T) {
+	for _, test := range []struct {
+		keyName    string
+		normalized string
+		err        string
+	}{
+		{"test", "test", ""},
+		{" " + testKey + "\n", testKey, ""},
+		{"//cloudkms.googleapis.com/" + testKey, testKey, ""},
+		{"", "", ""},
+		{testKey + "/cryptoKeyVersions/2", "", "is a key version, use the key " + testKey},
+		{"projects/fake-project/keyRings/secrets/cryptoKeys/test", "", "invalid key"},
+		{"secrets/test", "", "invalid key"},
+	} {
+		normalized, err := normalizeKeyName(test.keyName)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expecting an error with %q, got %v", test.keyName, test.err, err)
+			}
+			continue
+		}
+		if err != nil || normalized != test.normalized {
+			t.Errorf("%q: expecting %q, got %q (%v)", test.keyName, test.normalized, normalized, err)
+		}
+	}
+}
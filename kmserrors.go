@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// These replace KMS errors with a known fix, so the message says what to run
+// instead of repeating gcloud's stderr or the API response.
+
+type gcloudNotInstalledError struct{}
+
+func (e *gcloudNotInstalledError) Error() string {
+	return "gcloud is not installed or not on PATH.\n" +
+		"Install the Google Cloud SDK from https://cloud.google.com/sdk/install and run `gcloud auth login`, " +
+		"or set GOOGLE_APPLICATION_CREDENTIALS to use the KMS API without gcloud"
+}
+
+type notAuthenticatedError struct {
+	message string
+	native  bool
+}
+
+func (e *notAuthenticatedError) Error() string {
+	if e.native {
+		return fmt.Sprintf("not authenticated: %s\n"+
+			"Run `gcloud auth application-default login`, or point GOOGLE_APPLICATION_CREDENTIALS at a service account key",
+			e.message)
+	}
+	return fmt.Sprintf("gcloud is not authenticated: %s\n"+
+		"Run `gcloud auth login`, or `gcloud auth activate-service-account --key-file <file>` on a build machine",
+		e.message)
+}
+
+type permissionDeniedError struct {
+	keyName    string
+	permission string
+	// missing are the permissions testIamPermissions found missing, when
+	// diagnosed; none missing means the key itself is likely missing.
+	missing   []string
+	diagnosed bool
+}
+
+func (e *permissionDeniedError) Error() string {
+	if e.diagnosed && len(e.missing) == 0 {
+		return fmt.Sprintf("access to key %s was denied, but you have every permission needed on it.\n"+
+			"The key may not exist: check its name and project with `secrets whoami`", e.keyName)
+	}
+	permissions := e.missing
+	if len(permissions) == 0 && e.permission != "" {
+		permissions = []string{e.permission}
+	}
+	permission := strings.Join(permissions, ", ")
+	if permission == "" {
+		permission = "the required permission"
+	}
+	roles := rolesFor(permissions)
+	if len(roles) == 0 {
+		roles = []string{"roles/cloudkms.viewer"}
+	}
+	binding := "gcloud kms keys add-iam-policy-binding " + e.keyName
+	owner := "a project owner"
+	if isKeyResourceName(e.keyName) {
+		// The key may be in another project than the caller's, whose owners
+		// are the only ones who can grant access to it.
+		owner = "an owner of the project " + projectOf(e.keyName)
+		if strings.HasSuffix(e.permission, "keyRings.create") {
+			binding = "gcloud projects add-iam-policy-binding " + projectOf(e.keyName)
+		} else if strings.HasSuffix(e.permission, "cryptoKeys.create") {
+			binding = "gcloud kms keyrings add-iam-policy-binding " + keyRingOf(e.keyName)
+		}
+	} else if strings.HasSuffix(e.permission, "cryptoKeys.create") {
+		binding = fmt.Sprintf("gcloud kms keyrings add-iam-policy-binding %s --location %s", keyRing, location)
+	} else {
+		binding += fmt.Sprintf(" --location %s --keyring %s", location, keyRing)
+	}
+	commands := make([]string, 0, len(roles))
+	for _, role := range roles {
+		commands = append(commands, fmt.Sprintf("  %s --member user:<your email> --role %s", binding, role))
+	}
+	return fmt.Sprintf("you are missing %s on key %s.\n"+
+		"Ask %s to grant %s:\n%s",
+		permission, e.keyName, owner, strings.Join(roles, " and "), strings.Join(commands, "\n"))
+}
+
+type apiDisabledError struct {
+	project string
+}
+
+func (e *apiDisabledError) Error() string {
+	enable := "gcloud services enable cloudkms.googleapis.com"
+	if e.project != "" {
+		enable += " --project " + e.project
+	}
+	return fmt.Sprintf("the Cloud KMS API is not enabled for the project.\nEnable it with:\n  %s", enable)
+}
+
+var deniedPermission = regexp.MustCompile(`Permission '?([A-Za-z.]+)'? denied`)
+var disabledProject = regexp.MustCompile(`in project ([A-Za-z0-9-]+) before`)
+
+var notAuthenticatedMessages = []string{
+	"You do not currently have an active account selected",
+	"There was a problem refreshing your current auth tokens",
+	"Reauthentication failed",
+	"invalid_grant",
+	"UNAUTHENTICATED",
+}
+
+// kmsErrorText is what a failed KMS call said, from gcloud's stderr or the
+// API response.
+func kmsErrorText(err error) (string, bool) {
+	var gcloudErr *gcloudError
+	if errors.As(err, &gcloudErr) {
+		return gcloudErr.stdErr, false
+	}
+	var apiErr *kmsAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status + ": " + apiErr.Message + "\n" + apiErr.body, true
+	}
+	return err.Error(), true
+}
+
+// explainKmsError picks the error for a failed KMS call about keyName.
+func explainKmsError(keyName string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return &gcloudNotInstalledError{}
+	}
+	text, native := kmsErrorText(err)
+	for _, message := range notAuthenticatedMessages {
+		if strings.Contains(text, message) {
+			return &notAuthenticatedError{strings.TrimSpace(strings.SplitN(text, "\n", 2)[0]), native}
+		}
+	}
+	if strings.Contains(text, "SERVICE_DISABLED") || strings.Contains(text, "API has not been used in project") {
+		project := ""
+		if match := disabledProject.FindStringSubmatch(text); match != nil {
+			project = match[1]
+		}
+		return &apiDisabledError{project}
+	}
+	if errors.Is(err, ErrPermissionDenied) {
+		permission := ""
+		if match := deniedPermission.FindStringSubmatch(text); match != nil {
+			permission = match[1]
+		}
+		denied := &permissionDeniedError{keyName: keyName, permission: permission}
+		if permission == "" || containsString(keyPermissions, permission) {
+			denied.missing = missingPermissions(keyName)
+			denied.diagnosed = denied.missing != nil
+		}
+		return denied
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestExplainKmsError(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		err     error
+		message string
+	}{
+		{"not installed", fmt.Errorf("running gcloud: %w", exec.ErrNotFound), "gcloud is not installed"},
+		{"no account", &gcloudError{errors.New("exit status 1"), "ERROR: (gcloud.kms.encrypt) You do not currently have an active account selected.\nPlease run:"}, "Run `gcloud auth login`"},
+		{"expired token", &kmsAPIError{Status: "UNAUTHENTICATED", Message: "Request had invalid authentication credentials."}, "Run `gcloud auth application-default login`"},
+		{"disabled", &kmsAPIError{Status: "PERMISSION_DENIED", Message: "Cloud Key Management Service (KMS) API has not been used in project my-project before or it is disabled."}, "gcloud services enable cloudkms.googleapis.com --project my-project"},
+		{"other", &kmsAPIError{Status: "INTERNAL", Message: "backend error"}, "INTERNAL"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := explainKmsError(testKey, test.err)
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expecting an error with %q, got %v", test.message, err)
+			}
+		})
+	}
+}
+
+func TestPermissionDeniedMessage(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		err     *permissionDeniedError
+		message string
+	}{
+		{"decrypt", &permissionDeniedError{keyName: testKey, permission: "cloudkms.cryptoKeyVersions.useToDecrypt"}, "--role roles/cloudkms.cryptoKeyDecrypter"},
+		{"create key", &permissionDeniedError{keyName: testKey, permission: "cloudkms.cryptoKeys.create"}, "gcloud kms keyrings add-iam-policy-binding"},
+		{"owner", &permissionDeniedError{keyName: testKey, permission: "cloudkms.cryptoKeyVersions.useToEncrypt"}, "an owner of the project fake-project"},
+		{"key missing", &permissionDeniedError{keyName: testKey, diagnosed: true}, "The key may not exist"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if message := test.err.Error(); !strings.Contains(message, test.message) {
+				t.Errorf("expecting %q in %q", test.message, message)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// kubectl opens .enc manifests in memory and pipes them to kubectl, so a
+// deploy is one command and the plaintext never touches the disk.
+
+var kubeNamespace string
+var kubeContext string
+
+var kubectlActions = []string{"apply", "delete", "diff"}
+
+// isClusterScoped tells kinds that don't take a namespace apart, for those
+// commonly kept with secrets.
+func isClusterScoped(kind string) bool {
+	return kind == "Namespace" || strings.HasPrefix(kind, "Cluster") || kind == "PersistentVolume" || kind == "StorageClass" || kind == "CustomResourceDefinition"
+}
+
+// withNamespace puts objects that don't name a namespace in namespace, which
+// kubectl --namespace would refuse for the objects that do name another one.
+func withNamespace(manifest []byte, namespace string) ([]byte, error) {
+	documents, err := parseYAMLDocuments(manifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, document := range documents {
+		metadata := document.get("metadata")
+		if metadata == nil || metadata.kind != yamlMapping || isClusterScoped(document.get("kind").value()) {
+			continue
+		}
+		if metadata.get("namespace") == nil {
+			metadata.set("namespace", newYAMLScalar(namespace))
+		}
+	}
+	return marshalYAMLDocuments(documents), nil
+}
+
+func openManifests(files []string) ([]byte, error) {
+	var manifests bytes.Buffer
+	for i, file := range files {
+		if !strings.HasSuffix(file, ".enc") {
+			return nil, fmt.Errorf("%w: %s", ErrNotEncFile, file)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, _, err := openBytes(file, data)
+		recordAudit(commandName, file, err)
+		if err == nil && kubeNamespace != "" {
+			plaintext, err = withNamespace(plaintext, kubeNamespace)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if i > 0 {
+			manifests.WriteString("---\n")
+		}
+		manifests.Write(plaintext)
+		if len(plaintext) > 0 && plaintext[len(plaintext)-1] != '\n' {
+			manifests.WriteString("\n")
+		}
+	}
+	return manifests.Bytes(), nil
+}
+
+// kubectl runs kubectl action on the opened files and returns its exit code,
+// so that diff still exits with 1 when there are differences.
+func kubectl(action string, files []string) (int, error) {
+	if !containsString(kubectlActions, action) {
+		return 0, fmt.Errorf("kubectl expects apply, delete or diff, got %q", action)
+	}
+	if len(files) == 0 {
+		return 0, errors.New("kubectl expects the .enc manifests to " + action)
+	}
+	args := []string{action, "-f", "-"}
+	if kubeContext != "" {
+		args = append(args, "--context", kubeContext)
+	}
+	if dryRun {
+		printProgress("would open %s and run kubectl %s", strings.Join(files, ", "), strings.Join(args, " "))
+		return 0, nil
+	}
+	manifests, err := openManifests(files)
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command("kubectl", args...)
+	cmd.Stdin = bytes.NewReader(manifests)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWithNamespace(t *testing.T) {
+	for _, test := range []struct {
+		manifest string
+		expected string
+	}{
+		{"kind: ConfigMap\nmetadata:\n  name: a\n", "kind: ConfigMap\nmetadata:\n  name: a\n  namespace: ns\n"},
+		{"kind: Secret\nmetadata:\n  name: a\n  namespace: other\n", "kind: Secret\nmetadata:\n  name: a\n  namespace: other\n"},
+		{"kind: Namespace\nmetadata:\n  name: a\n", "kind: Namespace\nmetadata:\n  name: a\n"},
+		{"kind: ClusterRole\nmetadata:\n  name: a\n", "kind: ClusterRole\nmetadata:\n  name: a\n"},
+		{"kind: Secret\nmetadata:\n  name: a\n---\nkind: Namespace\nmetadata:\n  name: b\n", "kind: Secret\nmetadata:\n  name: a\n  namespace: ns\n---\nkind: Namespace\nmetadata:\n  name: b\n"},
+	} {
+		manifest, err := withNamespace([]byte(test.manifest), "ns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(manifest) != test.expected {
+			t.Errorf("%q: expecting %q, got %q", test.manifest, test.expected, manifest)
+		}
+	}
+}
+
+// useFakeKubectl puts a kubectl on the PATH that keeps its arguments and
+// stdin in dir, and exits with 1 like kubectl diff with differences.
+func useFakeKubectl(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake kubectl is a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat > " + filepath.Join(dir, "stdin") + "\nexit 1\n"
+	writeTestFile(t, filepath.Join(dir, "kubectl"), []byte(script), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestKubectl(t *testing.T) {
+	root := useFakeBackend(t)
+	dir := useFakeKubectl(t)
+	files := []string{}
+	for name, content := range map[string]string{
+		"a-secret.yaml": "kind: Secret\nmetadata:\n  name: a\n",
+		"b-secret.yaml": "kind: Secret\nmetadata:\n  name: b",
+	} {
+		file := filepath.Join(root, name)
+		writeTestFile(t, file, []byte(content), 0600)
+		if err := encrypt(testKey, file); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file+".enc")
+	}
+	if files[0] > files[1] {
+		files[0], files[1] = files[1], files[0]
+	}
+	kubeNamespace, kubeContext = "ns", "staging"
+	defer func() { kubeNamespace, kubeContext = "", "" }()
+	code, err := kubectl("diff", files)
+	if err != nil || code != 1 {
+		t.Fatalf("expecting kubectl's exit code 1, got %d (%v)", code, err)
+	}
+	if args, _ := os.ReadFile(filepath.Join(dir, "args")); string(args) != "diff -f - --context staging\n" {
+		t.Errorf("unexpected kubectl arguments %q", args)
+	}
+	expected := "kind: Secret\nmetadata:\n  name: a\n  namespace: ns\n---\nkind: Secret\nmetadata:\n  name: b\n  namespace: ns\n"
+	if stdin, _ := os.ReadFile(filepath.Join(dir, "stdin")); string(stdin) != expected {
+		t.Errorf("expecting %q piped to kubectl, got %q", expected, stdin)
+	}
+	for _, name := range []string{"a-secret.yaml", "b-secret.yaml"} {
+		if fileExists(filepath.Join(root, name)) {
+			t.Errorf("expecting %s not opened to disk", name)
+		}
+	}
+}
+
+func TestKubectlRejects(t *testing.T) {
+	for _, test := range []struct {
+		action string
+		files  []string
+		err    string
+	}{
+		{"get", []string{"secret.yaml.enc"}, "expects apply, delete or diff"},
+		{"apply", nil, "expects the .enc manifests"},
+		{"apply", []string{"secret.yaml"}, ErrNotEncFile.Error()},
+	} {
+		if _, err := kubectl(test.action, test.files); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s %q: expecting an error with %q, got %v", test.action, test.files, test.err, err)
+		}
+	}
+}
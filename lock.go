@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const lockFileName string = "secrets.lock"
+
+// lockEntry records a .enc as it was last written by secrets, so that a
+// ciphertext changed by hand, or copied over from another environment, is
+// caught by verify.
+type lockEntry struct {
+	SHA256     string `json:"sha256"`
+	Key        string `json:"key,omitempty"`
+	KeyVersion string `json:"keyVersion,omitempty"`
+}
+
+// lockFile is secrets.lock in the project root, meant to be committed. Files
+// are keyed by their path relative to the root.
+type lockFile struct {
+	Files map[string]lockEntry `json:"files"`
+}
+
+var lockMutex sync.Mutex
+
+func lockPath(root string) string {
+	return filepath.Join(root, lockFileName)
+}
+
+// readLock returns nil when the project has no secrets.lock.
+func readLock(root string) (*lockFile, error) {
+	data, err := os.ReadFile(lockPath(root))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseLock(data)
+}
+
+// parseLock reads an empty file as a lock without entries.
+func parseLock(data []byte) (*lockFile, error) {
+	l := &lockFile{}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, l); err != nil {
+			return nil, fmt.Errorf("%s: %w, run `secrets lock` to rewrite it", lockFileName, err)
+		}
+	}
+	if l.Files == nil {
+		l.Files = map[string]lockEntry{}
+	}
+	return l, nil
+}
+
+func (l *lockFile) marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (l *lockFile) write(root string) error {
+	data, err := l.marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(lockPath(root), data, 0644)
+}
+
+func newLockEntry(data []byte) lockEntry {
+	digest := sha256.Sum256(data)
+	entry := lockEntry{SHA256: hex.EncodeToString(digest[:])}
+	if e, err := parseEnvelope(data); err == nil {
+		entry.Key, entry.KeyVersion = e.Key, versionNumber(e.KeyVersion)
+	}
+	return entry
+}
+
+// lockCiphertext records data as the content of ciphertextFile in the
+// project's secrets.lock, or drops the file from it when data is nil.
+func lockCiphertext(ciphertextFile string, data []byte) error {
+	path := projectPath(ciphertextFile)
+	if projectRoot == "" || path == "" || strings.HasPrefix(path, "..") {
+		return nil
+	}
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+	l, err := readLock(projectRoot)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		if data == nil {
+			return nil
+		}
+		l = &lockFile{Files: map[string]lockEntry{}}
+	}
+	if data == nil {
+		delete(l.Files, path)
+	} else {
+		l.Files[path] = newLockEntry(data)
+	}
+	return l.write(projectRoot)
+}
+
+// writeEnvelope signs e if required, writes it to a .enc and records it in
+// secrets.lock, and on this machine what it holds.
+func writeEnvelope(ciphertextFile string, e *envelope) error {
+	if err := signFor(ciphertextFile, e); err != nil {
+		return err
+	}
+	data := e.marshal()
+	if err := writeFileAtomically(ciphertextFile, data, 0644); err != nil {
+		return err
+	}
+	if e.contentHash != "" {
+		rememberSealedContent(ciphertextFile, data, e.contentHash)
+	}
+	return lockCiphertext(ciphertextFile, data)
+}
+
+// relockFile updates the entry of a .enc that was moved or removed.
+func relockFile(ciphertextFile string) error {
+	data, err := os.ReadFile(ciphertextFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return lockCiphertext(ciphertextFile, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return lockCiphertext(ciphertextFile, data)
+}
+
+// writeLock rewrites secrets.lock from the .enc files in the project, for
+// instance after resolving a conflict in it.
+func writeLock(root string) error {
+	files, err := findFiles(root, *regexp.MustCompile(`\.enc$`))
+	if err != nil {
+		return err
+	}
+	l := &lockFile{Files: map[string]lockEntry{}}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		l.Files[projectPath(file)] = newLockEntry(data)
+	}
+	printProgress("writing %d file(s) to %s", len(l.Files), lockPath(root))
+	if dryRun {
+		return nil
+	}
+	return l.write(root)
+}
+
+// checkLock compares a .enc with its secrets.lock entry. Projects without a
+// lock file are not checked.
+func checkLock(l *lockFile, ciphertextFile string, data []byte) error {
+	if l == nil {
+		return nil
+	}
+	path := projectPath(ciphertextFile)
+	entry, ok := l.Files[path]
+	if !ok {
+		return fmt.Errorf("%s: not in %s, seal it with secrets or run `secrets lock`", path, lockFileName)
+	}
+	actual := newLockEntry(data)
+	if actual.SHA256 != entry.SHA256 {
+		if actual.Key != entry.Key {
+			return fmt.Errorf("%s: does not match %s, it was sealed with %s instead of %s", path, lockFileName, actual.Key, entry.Key)
+		}
+		return fmt.Errorf("%s: does not match %s, the ciphertext was changed outside of secrets", path, lockFileName)
+	}
+	return nil
+}
+
+// missingLockedFiles lists the files in secrets.lock whose .enc is gone.
+func missingLockedFiles(root string, l *lockFile) []string {
+	missing := []string{}
+	if l == nil {
+		return missing
+	}
+	for path := range l.Files {
+		if !fileExists(filepath.Join(root, filepath.FromSlash(path))) {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// mergeLocks merges secrets.lock entry by entry. A .enc changed on both
+// sides is sealed again by the merge driver of .enc files, into a ciphertext
+// neither side has, so its entry is left out and returned for `secrets lock`
+// to record once the merge is done.
+func mergeLocks(base *lockFile, ours *lockFile, theirs *lockFile) (*lockFile, []string) {
+	merged := &lockFile{Files: map[string]lockEntry{}}
+	unresolved := []string{}
+	paths := map[string]bool{}
+	for _, l := range []*lockFile{base, ours, theirs} {
+		for path := range l.Files {
+			paths[path] = true
+		}
+	}
+	for path := range paths {
+		b, inBase := base.Files[path]
+		o, inOurs := ours.Files[path]
+		t, inTheirs := theirs.Files[path]
+		switch {
+		case inOurs == inTheirs && o == t:
+			if inOurs {
+				merged.Files[path] = o
+			}
+		case inBase == inOurs && b == o:
+			if inTheirs {
+				merged.Files[path] = t
+			}
+		case inBase == inTheirs && b == t:
+			if inOurs {
+				merged.Files[path] = o
+			}
+		default:
+			unresolved = append(unresolved, path)
+		}
+	}
+	sort.Strings(unresolved)
+	return merged, unresolved
+}
+
+// gitMergeLock is the git merge driver of secrets.lock, invoked as
+// `secrets git-merge-lock %O %A %B`, so that branches sealing different
+// files don't conflict in it. The result is written to the %A file.
+func gitMergeLock(baseFile string, oursFile string, theirsFile string) error {
+	locks := make([]*lockFile, 3)
+	for i, file := range []string{baseFile, oursFile, theirsFile} {
+		data, err := readOptional(file)
+		if err != nil {
+			return err
+		}
+		if locks[i], err = parseLock(data); err != nil {
+			return err
+		}
+	}
+	merged, unresolved := mergeLocks(locks[0], locks[1], locks[2])
+	for _, path := range unresolved {
+		errPrintln("Warning: %s was changed on both sides, run `secrets lock` once the merge is done to record it in %s", path, lockFileName)
+	}
+	data, err := merged.marshal()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(oursFile, data, 0644)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeLocks(t *testing.T) {
+	a, b, c := lockEntry{SHA256: "a"}, lockEntry{SHA256: "b"}, lockEntry{SHA256: "c"}
+	for _, test := range []struct {
+		name       string
+		base       map[string]lockEntry
+		ours       map[string]lockEntry
+		theirs     map[string]lockEntry
+		merged     map[string]lockEntry
+		unresolved []string
+	}{
+		{"unchanged", map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": a}, []string{}},
+		{"other files", map[string]lockEntry{"x.enc": a, "y.enc": a}, map[string]lockEntry{"x.enc": b, "y.enc": a}, map[string]lockEntry{"x.enc": a, "y.enc": c}, map[string]lockEntry{"x.enc": b, "y.enc": c}, []string{}},
+		{"same change", map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": b}, map[string]lockEntry{"x.enc": b}, map[string]lockEntry{"x.enc": b}, []string{}},
+		{"added", map[string]lockEntry{}, map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"y.enc": b}, map[string]lockEntry{"x.enc": a, "y.enc": b}, []string{}},
+		{"removed", map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": a}, map[string]lockEntry{}, map[string]lockEntry{}, []string{}},
+		{"both changed", map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": b}, map[string]lockEntry{"x.enc": c}, map[string]lockEntry{}, []string{"x.enc"}},
+		{"changed and removed", map[string]lockEntry{"x.enc": a}, map[string]lockEntry{"x.enc": b}, map[string]lockEntry{}, map[string]lockEntry{}, []string{"x.enc"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			merged, unresolved := mergeLocks(&lockFile{Files: test.base}, &lockFile{Files: test.ours}, &lockFile{Files: test.theirs})
+			if !reflect.DeepEqual(merged.Files, test.merged) {
+				t.Errorf("expecting %v, got %v", test.merged, merged.Files)
+			}
+			if !reflect.DeepEqual(unresolved, test.unresolved) {
+				t.Errorf("expecting %v unresolved, got %v", test.unresolved, unresolved)
+			}
+		})
+	}
+}
+
+// TestGitMergeLeavesLockAlone merges two branches that each sealed another
+// file: the .enc driver must not write secrets.lock, and the lock driver
+// must merge both entries without a conflict.
+func TestGitMergeLeavesLockAlone(t *testing.T) {
+	root := useFakeBackend(t)
+	seal := func(name string, content string) []byte {
+		plaintextFile := filepath.Join(root, name)
+		writeTestFile(t, plaintextFile, []byte(content), 0600)
+		if err := encrypt(testKey, plaintextFile); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(plaintextFile + ".enc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	readLockFile := func() []byte {
+		data, err := os.ReadFile(lockPath(root))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	seal("one.yaml", "a: 1\nb: 1\n")
+	seal("two.yaml", "c: 1\n")
+	base := readLockFile()
+	baseEnc, _ := os.ReadFile(filepath.Join(root, "one.yaml.enc"))
+	theirsEnc := seal("one.yaml", "a: 1\nb: 2\n")
+	theirs := readLockFile()
+	writeTestFile(t, lockPath(root), base, 0644)
+	oursEnc := seal("one.yaml", "a: 2\nb: 1\n")
+	seal("two.yaml", "c: 2\n")
+	ours := readLockFile()
+
+	dir := t.TempDir()
+	baseFile, oursFile, theirsFile := filepath.Join(dir, "base"), filepath.Join(dir, "ours"), filepath.Join(dir, "theirs")
+	writeTestFile(t, baseFile, baseEnc, 0644)
+	writeTestFile(t, oursFile, oursEnc, 0644)
+	writeTestFile(t, theirsFile, theirsEnc, 0644)
+	if err := gitMerge(testKey, baseFile, oursFile, theirsFile, filepath.Join(root, "one.yaml.enc")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readLockFile(), ours) {
+		t.Error("the merge driver of .enc files wrote secrets.lock")
+	}
+
+	writeTestFile(t, baseFile, base, 0644)
+	writeTestFile(t, oursFile, ours, 0644)
+	writeTestFile(t, theirsFile, theirs, 0644)
+	if err := gitMergeLock(baseFile, oursFile, theirsFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(oursFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := parseLock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oursLock, _ := parseLock(ours)
+	if _, ok := merged.Files["one.yaml.enc"]; ok {
+		t.Error("kept an entry for a file changed on both sides")
+	}
+	if merged.Files["two.yaml.enc"] != oursLock.Files["two.yaml.enc"] {
+		t.Error("lost the entry of a file changed on one side")
+	}
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 var ignore = struct{}{}
@@ -19,33 +24,82 @@ var ignoreFolders = map[string]struct{}{
 	".git":         ignore,
 	"node_modules": ignore,
 	"mongo-data":   ignore,
+	"vendor":       ignore,
+	".terraform":   ignore,
+	"build":        ignore,
 }
 
 const (
 	expectedOrganization string = "jobbatical"
 	expectedRepoHost     string = "github.com"
-	usage                string = "Usage secrets <open|seal> [<file path>...] [--dry-run] [--verbose] [--root <project root>] [--key <encryption key name>] [--open-all]"
+	usage                string = "Usage secrets <open|seal|status|reseal-all|prune|clean|lock|purge-history|mv|gitattributes|git-config|git-hooks|mask|plan|ui|verify|workspace|access|keys|report|find|kubectl|env-file|systemd-creds|import|export|bundle|unbundle|sync|config|whoami|agent|version|self-update|help> [<file path>...] [--dry-run] [--verbose] [--root <project root>] [--key <encryption key name>] [--key-project <project>] [--key-ring <key ring>] [--profile <name>] [--open-all] [--yes] [--ci] [--keep-going] [--detailed-exitcode] [--jobs <n>] [--kms-rate <requests per second>] [--kms-transport|--backend <auto|gcloud|native|fake>] [--kms-record <file>] [--kms-replay <file>] [--credentials-config <file>] [--impersonate-service-account <email>] [--files-from <file|->] [--out <path>] [--out-dir <dir>] [--bundle <path>] [--stdout] [--no-git] [--namespace <namespace>] [--context <context>] [--pull] [--mount <mount>] [--path <path>] [--repo <owner>/<name>] [--group <group>] [--env <environment>] [--masked <glob>]... [--protected <glob>]... [--service <name>] [--region <region>] [--paths-only] [--print0] [--encrypted] [--plaintext] [--pattern <regexp>] [--exclude <glob>]... [--max-depth <n>] [--follow-symlinks] [--deterministic] [--force] [--backup] [-i|--interactive] [--ttl <duration>] [--listen <address>] [--format <json|csv>] [--signing-key <key>] [--rotation-period <days>] [--next-rotation-time <time>] [--protection-level <software|hsm>] [--label <name=value>]... [--create-keyring] [--location <location>] [--secondary-location <location>] [--color <auto|always|never>]"
 	encryptCmd           string = "seal"
 	decryptCmd           string = "open"
-	keyRing              string = "immi-project-secrets"
-	location             string = "global"
+	statusCmd            string = "status"
+	listCmd              string = "ls"
+	resealAllCmd         string = "reseal-all"
+	pruneCmd             string = "prune"
+	cleanCmd             string = "clean"
+	purgeHistoryCmd      string = "purge-history"
+	lockCmd              string = "lock"
+	moveCmd              string = "mv"
+	gitAttributesCmd     string = "gitattributes"
+	gitConfigCmd         string = "git-config"
+	gitTextconvCmd       string = "git-textconv"
+	gitMergeCmd          string = "git-merge"
+	gitMergeLockCmd      string = "git-merge-lock"
+	gitHooksCmd          string = "git-hooks"
+	gitHookCmd           string = "git-hook"
+	maskCmd              string = "mask"
+	planCmd              string = "plan"
+	versionCmd           string = "version"
+	selfUpdateCmd        string = "self-update"
+	uiCmd                string = "ui"
+	verifyCmd            string = "verify"
+	workspaceCmd         string = "workspace"
+	accessCmd            string = "access"
+	agentCmd             string = "agent"
+	reportCmd            string = "report"
+	keysCmd              string = "keys"
+	whoamiCmd            string = "whoami"
+	findCmd              string = "find"
+	kubectlCmd           string = "kubectl"
+	envFileCmd           string = "env-file"
+	systemdCredsCmd      string = "systemd-creds"
+	importCmd            string = "import"
+	exportCmd            string = "export"
+	bundleCmd            string = "bundle"
+	unbundleCmd          string = "unbundle"
+	syncCmd              string = "sync"
+	configCmd            string = "config"
+	helpCmd              string = "help"
+	defaultKeyRing       string = "immi-project-secrets"
 )
 
-var errFileAlreadyTracked = errors.New("file already tracked")
 var verbose bool
 var dryRun bool
 var projectRoot string
 var key string
 var openAll bool
-
-type gcloudError struct {
-	err    error
-	stdErr string
-}
-
-func (e *gcloudError) Error() string {
-	return fmt.Sprintf("gcloud command failed: %s", e.stdErr)
-}
+var assumeYes bool
+var ciMode bool
+var keepGoing bool
+var ciSystem string
+var detailedExitCode bool
+var jobs int
+var kmsRate float64
+var maxDepth int
+var followSymlinks bool
+var deterministic bool
+var force bool
+var backup bool
+var interactive bool
+var kmsTransport string
+var ttl time.Duration
+var reportFormat string
+var signingKey string
+var keyProjectFlag string
+var keyRing string
 
 func isIgnoredFolder(path string) bool {
 	_, ok := ignoreFolders[path]
@@ -57,7 +111,7 @@ func findEncryptedFiles(root string) ([]string, error) {
 	if openAll {
 		rgx = `\.enc$`
 	} else {
-		rgx = `secret\.(yaml|yml)\.enc$`
+		rgx = `secret\.(yaml|yml)\.enc(\.d/[^/]+\.enc)?$`
 	}
 	return findFiles(root, *regexp.MustCompile(rgx))
 }
@@ -66,33 +120,6 @@ func findUnencryptedFiles(root string) ([]string, error) {
 	return findFiles(root, *regexp.MustCompile(`secret\.(yaml|yml)$`))
 }
 
-func findFiles(root string, re regexp.Regexp) ([]string, error) {
-	result := make([]string, 0, 1)
-
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if isIgnoredFolder(info.Name()) {
-			return filepath.SkipDir
-		}
-
-		if !info.IsDir() && re.MatchString(path) {
-			absolutePath, _ := filepath.Abs(path)
-			result = append(result, absolutePath)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		errPrintln("%s", err)
-	}
-
-	return result, nil
-}
-
 func printDebugln(format string, a ...interface{}) error {
 	if !verbose {
 		return nil
@@ -101,20 +128,43 @@ func printDebugln(format string, a ...interface{}) error {
 }
 
 func errPrintln(format string, a ...interface{}) error {
-	_, err := fmt.Fprintf(os.Stderr, format+"\n", a...)
+	_, err := fmt.Fprintln(os.Stderr, colorize(fmt.Sprintf(format, a...)))
 	return err
 }
 
 func runCommand(name string, arg ...string) (*exec.Cmd, string, string, error) {
-	cmd := exec.Command(
-		name,
-		arg...,
-	)
+	return runCommandWithInput(nil, name, arg...)
+}
+
+func runCommandWithInput(input []byte, name string, arg ...string) (*exec.Cmd, string, string, error) {
+	category := timingCategory(name, arg)
+	// Interrupts cancel KMS calls.
+	ctx := context.Background()
+	if category == "kms" {
+		ctx = interruptContext
+	}
+	cmd := exec.CommandContext(ctx, name, arg...)
 	var stdOut bytes.Buffer
 	var stdErr bytes.Buffer
+	if name == "git" && isGitless() {
+		printDebugln("not running %s: %s", commandLabel(name, arg), errGitless)
+		return cmd, "", errGitless.Error(), errGitless
+	}
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
 	cmd.Stdout = &stdOut
 	cmd.Stderr = &stdErr
+	if category == "kms" {
+		kmsLimiter.wait()
+	}
+	span := startSpan(commandLabel(name, arg), category, nil)
+	start := time.Now()
 	err := cmd.Run()
+	elapsed := time.Since(start)
+	recordTiming(category, elapsed)
+	endSpan(span, err)
+	printDebugln("%s took %s", commandLabel(name, arg), formatDuration(elapsed))
 	if err != nil {
 		printDebugln("command failed: %s", cmd)
 		printDebugln("%s", stdErr.String())
@@ -122,75 +172,486 @@ func runCommand(name string, arg ...string) (*exec.Cmd, string, string, error) {
 	return cmd, stdOut.String(), stdErr.String(), err
 }
 
-func callKms(operation string, keyName string, plaintextFile string, ciphertextFile string) error {
+// encryptBytes encrypts plaintext with KMS, or with a KMS-wrapped data key
+// when it's over the KMS size limit or a fragment, which also authenticates
+// headers. previous is the envelope being replaced, if any, which
+// --deterministic reuses the data key of.
+func encryptBytes(keyName string, plaintext []byte, headers plaintextHeaders, previous *envelope) (*envelope, error) {
+	if deterministic {
+		return encryptDeterministic(keyName, plaintext, headers.additionalData(), previous)
+	}
+	hash, err := plaintextHash(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	e := &envelope{SealedAt: time.Now(), contentHash: hash}
+	if len(plaintext) > maxKmsPlaintext || headers.Fragment != "" {
+		printDebugln("%d bytes is over the KMS limit of %d or a fragment, encrypting with a data key", len(plaintext), maxKmsPlaintext)
+		ciphertext, dataKey, err := sealWithDataKey(plaintext, headers.additionalData())
+		if err != nil {
+			return nil, err
+		}
+		wrappedKey, err := callKms("encrypt", keyName, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		e.WrappedKey, e.Ciphertext = []byte(wrappedKey), ciphertext
+	} else {
+		ciphertext, err := callKms("encrypt", keyName, plaintext)
+		if err != nil {
+			return nil, err
+		}
+		e.Ciphertext = []byte(ciphertext)
+	}
+	k, err := describeKey(keyName)
+	if err != nil {
+		return nil, err
+	}
+	e.Key, e.KeyVersion = k.Name, k.Primary.Name
+	return e, nil
+}
+
+// decryptBytes opens the contents of a .enc file, returning the parsed
+// envelope too unless the file predates envelopes.
+func decryptBytes(keyName string, data []byte) ([]byte, *envelope, error) {
+	if err := checkCiphertext(data); err != nil {
+		return nil, nil, err
+	}
+	e, err := parseEnvelope(data)
+	if errors.Is(err, errNotEnvelope) {
+		plaintext, err := callKms("decrypt", keyName, data)
+		return []byte(plaintext), nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.Key != "" {
+		keyName = e.Key
+	}
+	if e.SecondKey != "" {
+		plaintext, err := decryptDualControl(keyName, e)
+		return plaintext, e, err
+	}
+	if e.FallbackKey != "" {
+		plaintext, err := decryptWithFallback(keyName, e)
+		return plaintext, e, err
+	}
+	if len(e.WrappedKey) > 0 {
+		dataKey, err := callKms("decrypt", keyName, e.WrappedKey)
+		if err != nil {
+			return nil, e, err
+		}
+		plaintext, err := openWithDataKey([]byte(dataKey), e.Ciphertext, e.additionalData())
+		return plaintext, e, err
+	}
+	plaintext, err := callKms("decrypt", keyName, e.Ciphertext)
+	return []byte(plaintext), e, err
+}
+
+// openBytes opens the contents of file with its key, once its access policy
+// allows it, and checks its signature.
+func openBytes(file string, data []byte) ([]byte, *envelope, error) {
+	if err := checkAccessPolicy(file); err != nil {
+		return nil, nil, err
+	}
+	if err := checkPolicy("open", file); err != nil {
+		return nil, nil, err
+	}
+	plaintext, e, err := decryptBytes(fileKey(file), data)
+	if err == nil {
+		err = checkSignature(file, e)
+	}
+	if err == nil {
+		err = checkPath(file, e)
+	}
+	return plaintext, e, err
+}
+
+func encrypt(keyName string, plaintextFile string) error {
 	if dryRun {
 		return nil
 	}
-	_, _, stdErr, err := runCommand(
-		"gcloud",
-		"kms",
-		operation,
-		"--location", location,
-		"--keyring", keyRing,
-		"--key", keyName,
-		"--plaintext-file", plaintextFile,
-		"--ciphertext-file", ciphertextFile,
-	)
+	info, err := os.Stat(plaintextFile)
 	if err != nil {
-		if strings.Contains(stdErr, "NOT_FOUND: ") {
-			err := createKey(keyName)
-			if err != nil {
-				return err
-			}
-			return callKms(operation, keyName, plaintextFile, ciphertextFile)
+		return err
+	}
+	plaintext, err := os.ReadFile(plaintextFile)
+	if err != nil {
+		return err
+	}
+	split := isSplitFile(sealedAs(plaintextFile))
+	if !split && !force && isUpToDate(keyName, plaintextFile, plaintext, info.Mode().Perm()) {
+		printProgress("%s is already up to date", plaintextFile)
+		return nil
+	}
+	if err := checkPolicy("seal", plaintextFile); err != nil {
+		return fmt.Errorf("%s: %w", plaintextFile, err)
+	}
+	ciphertextFile := sealedPath(plaintextFile)
+	headers := plaintextHeaders{Path: projectPath(sealedAs(plaintextFile)), Mode: info.Mode().Perm(), ModifiedAt: info.ModTime()}
+	if split {
+		return sealFragments(keyName, plaintextFile, ciphertextFile, plaintext, headers)
+	}
+	e, err := sealBytes(sealedAs(plaintextFile), keyName, plaintext, headers, readEnvelope(ciphertextFile))
+	if err != nil {
+		return err
+	}
+	if err := makeOutputDir(ciphertextFile); err != nil {
+		return err
+	}
+	if err := writeEnvelope(ciphertextFile, e); err != nil {
+		return err
+	}
+	return removeFragments(ciphertextFile)
+}
+
+// isSameKey reports whether an envelope's key is keyName, without asking KMS.
+func isSameKey(envelopeKey string, keyName string) bool {
+	if isKeyResourceName(keyName) {
+		return envelopeKey == keyName
+	}
+	return strings.HasSuffix(envelopeKey, "/locations/"+location+"/keyRings/"+keyRing+"/cryptoKeys/"+keyName)
+}
+
+// isUpToDate reports whether the existing .enc of plaintextFile already holds
+// plaintext, with the same mode, under the same keys and signed as required.
+func isUpToDate(keyName string, plaintextFile string, plaintext []byte, mode os.FileMode) bool {
+	data, err := os.ReadFile(sealedPath(plaintextFile))
+	if err != nil {
+		return false
+	}
+	// Envelopes with the plaintext hash older versions recorded are sealed
+	// again without it.
+	e, err := parseEnvelope(data)
+	if err != nil || e.PlaintextHash != "" {
+		return false
+	}
+	secondKey, err := fileSecondKey(sealedAs(plaintextFile))
+	if err != nil || (secondKey != "" && !isSameKey(e.SecondKey, secondKey)) {
+		return false
+	}
+	signingKeyName, err := fileSigningKey(sealedAs(plaintextFile))
+	if err != nil || (signingKeyName != "" && !isSameSigningKey(e.SigningKey, signingKeyName)) {
+		return false
+	}
+	return isSameKey(e.Key, keyName) && hasFallback(e) && e.Mode == mode && holdsPlaintext(sealedPath(plaintextFile), data, plaintext)
+}
+
+func isFileUpToDate(keyName string, plaintextFile string) bool {
+	info, err := os.Stat(plaintextFile)
+	if err != nil {
+		return false
+	}
+	plaintext, err := os.ReadFile(plaintextFile)
+	if err != nil {
+		return false
+	}
+	if isFragmented(sealedPath(plaintextFile)) {
+		return fragmentsMatch(sealedPath(plaintextFile), plaintext)
+	}
+	return isUpToDate(keyName, plaintextFile, plaintext, info.Mode().Perm())
+}
+
+// isOpenedUpToDate reports whether plaintextFile already holds what the .enc
+// ciphertextFile holds, with its mode, so that opening it changes nothing.
+func isOpenedUpToDate(ciphertextFile string, plaintextFile string) bool {
+	info, err := os.Stat(plaintextFile)
+	if err != nil {
+		return false
+	}
+	plaintext, err := os.ReadFile(plaintextFile)
+	if err != nil {
+		return false
+	}
+	if isFragmented(ciphertextFile) {
+		return fragmentsMatch(ciphertextFile, plaintext)
+	}
+	data, err := os.ReadFile(ciphertextFile)
+	if err != nil {
+		return false
+	}
+	e, err := parseEnvelope(data)
+	if err != nil {
+		return false
+	}
+	return plaintextMode(ciphertextFile, e) == info.Mode().Perm() && holdsPlaintext(ciphertextFile, data, plaintext)
+}
+
+// isResealed reports whether the .enc ciphertextFile is already sealed as
+// reseal-all would seal it: with the primary version of its key, and with
+// the second key, fallback and signature its path requires.
+func isResealed(ciphertextFile string) bool {
+	e := readEnvelope(ciphertextFile)
+	if e == nil || e.KeyVersion == "" || e.PlaintextHash != "" || !hasFallback(e) {
+		return false
+	}
+	if len(e.WrappedKey) > 0 && !e.HeadersAuthenticated {
+		return false
+	}
+	k, err := describeKey(e.Key)
+	if err != nil || k.Primary.Name != e.KeyVersion {
+		return false
+	}
+	plaintextFile := strings.TrimSuffix(ciphertextFile, ".enc")
+	secondKey, err := fileSecondKey(plaintextFile)
+	if err != nil || (secondKey != "" && !isSameKey(e.SecondKey, secondKey)) {
+		return false
+	}
+	signingKeyName, err := fileSigningKey(ciphertextFile)
+	return err == nil && (signingKeyName == "" || isSameSigningKey(e.SigningKey, signingKeyName))
+}
+
+func decrypt(keyName string, ciphertextFile string) error {
+	plaintextFile, err := openedPath(ciphertextFile)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	if err := checkAccessPolicy(ciphertextFile); err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	if err := checkPolicy("open", ciphertextFile); err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	plaintext, e, err := readSealedFile(keyName, ciphertextFile)
+	if err != nil {
+		return err
+	}
+	if err := makeOutputDir(plaintextFile); err != nil {
+		return err
+	}
+	if backup {
+		if err := backupPlaintext(plaintextFile, plaintext); err != nil {
+			return err
 		}
-		return &gcloudError{err, stdErr}
+	}
+	if e == nil {
+		err = writeFileAtomically(plaintextFile, plaintext, 0644)
+	} else {
+		warnIfStale(ciphertextFile, e)
+		err = writePlaintext(ciphertextFile, plaintextFile, plaintext, e)
+	}
+	if err == nil && ttl > 0 {
+		err = recordOpenedFile(plaintextFile, plaintext, ttl)
+	}
+	return err
+}
+
+// writePlaintext writes a decrypted file with the mode and modification time
+// recorded in its envelope, for files sealed since those were recorded.
+func writePlaintext(ciphertextFile string, plaintextFile string, plaintext []byte, e *envelope) error {
+	if err := writeFileAtomically(plaintextFile, plaintext, plaintextMode(ciphertextFile, e)); err != nil {
+		return err
+	}
+	if !e.ModifiedAt.IsZero() {
+		return os.Chtimes(plaintextFile, e.ModifiedAt, e.ModifiedAt)
 	}
 	return nil
 }
 
-func createKey(keyName string) error {
-	printDebugln("creating key for the project %s", keyName)
+// backupPlaintext saves plaintextFile as <file>.bak.<timestamp>, kept out of
+// git, before it is overwritten with different content.
+func backupPlaintext(plaintextFile string, plaintext []byte) error {
+	info, err := os.Stat(plaintextFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(plaintextFile)
+	if err != nil || bytes.Equal(current, plaintext) {
+		return err
+	}
+	backupFile := plaintextFile + ".bak." + time.Now().UTC().Format("20060102T150405Z")
+	if err := writeFileAtomically(backupFile, current, info.Mode().Perm()); err != nil {
+		return err
+	}
+	printProgress("saved %s as %s", displayPath(plaintextFile), displayPath(backupFile))
+	err = addGitIgnore(projectRoot, backupFile)
+	if errors.Is(err, ErrPlaintextTracked) {
+		return nil
+	}
+	return err
+}
+
+// reseal re-encrypts a .enc file under the current primary version of the
+// key it was sealed with, without writing the plaintext to disk.
+func reseal(keyName string, ciphertextFile string) error {
 	if dryRun {
 		return nil
 	}
-	_, _, stdErr, err := runCommand(
-		"gcloud",
-		"kms",
-		"keys",
-		"create", keyName,
-		"--purpose", "encryption",
-		"--rotation-period", "100d",
-		"--next-rotation-time", "+p100d",
-		"--location", location,
-		"--keyring", keyRing,
-	)
+	if !force && isResealed(ciphertextFile) {
+		printProgress("%s is already up to date", ciphertextFile)
+		return nil
+	}
+	if err := checkPolicy("rotate", ciphertextFile); err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	data, err := os.ReadFile(ciphertextFile)
 	if err != nil {
-		return &gcloudError{err, stdErr}
+		return err
 	}
-	return nil
+	plaintext, e, err := decryptBytes(keyName, data)
+	if err == nil {
+		err = checkSignature(ciphertextFile, e)
+	}
+	if err == nil {
+		err = checkPath(ciphertextFile, e)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	if e != nil && e.Key != "" {
+		keyName = e.Key
+	}
+	headers := plaintextHeaders{}
+	if e != nil {
+		headers = e.plaintextHeaders()
+		if e.Mode != 0 {
+			headers.Mode = plaintextMode(ciphertextFile, e)
+		}
+	}
+	if headers.Fragment, err = fragmentPlace(ciphertextFile, e); err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	resealed, err := sealBytes(strings.TrimSuffix(ciphertextFile, ".enc"), keyName, plaintext, headers, e)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	return writeEnvelope(ciphertextFile, resealed)
 }
 
-func encrypt(keyName string, plaintextFile string) error {
-	return callKms("encrypt", keyName, plaintextFile, plaintextFile+".enc")
+// staleness reports how long ago the envelope was sealed when that is longer
+// than the rotation period of its key, so old key versions can't be destroyed.
+func staleness(e *envelope) (time.Duration, bool, error) {
+	if e.Key == "" || e.SealedAt.IsZero() {
+		return 0, false, nil
+	}
+	k, err := describeKey(e.Key)
+	if err != nil {
+		return 0, false, err
+	}
+	if k.RotationPeriod == "" {
+		return 0, false, nil
+	}
+	period, err := time.ParseDuration(k.RotationPeriod)
+	if err != nil {
+		return 0, false, err
+	}
+	age := time.Since(e.SealedAt)
+	return age, age > period, nil
 }
 
-func decrypt(keyName string, ciphertextFile string) error {
-	re := regexp.MustCompile(`\.enc$`)
-	plaintextFile := re.ReplaceAllString(ciphertextFile, "")
-	if plaintextFile == ciphertextFile {
-		errPrintln("Not a .enc file: %s", ciphertextFile)
-		os.Exit(1)
+func formatAge(age time.Duration) string {
+	return fmt.Sprintf("%dd", int(age.Hours()/24))
+}
+
+func warnIfStale(ciphertextFile string, e *envelope) {
+	age, stale, err := staleness(e)
+	if err != nil {
+		printDebugln("could not check key rotation period for %s: %s", ciphertextFile, err)
+		return
+	}
+	if stale {
+		errPrintln("Warning: %s was sealed %s ago, longer than its key's rotation period. Run `secrets reseal-all` so old key versions can be destroyed", ciphertextFile, formatAge(age))
 	}
-	return callKms("decrypt", keyName, plaintextFile, ciphertextFile)
 }
 
-func isProjectRoot(path string) bool {
-	info, err := os.Stat(filepath.Join(path, ".git"))
+func versionNumber(versionName string) string {
+	return path.Base(versionName)
+}
+
+// keyVersionStatus describes a key version relative to the key's current
+// primary. Any version that is no longer primary is considered retired.
+func keyVersionStatus(keyName string, versionName string) (string, bool, error) {
+	k, err := describeKey(keyName)
 	if err != nil {
-		return false
+		return "", false, err
+	}
+	if versionName == k.Primary.Name {
+		return "current", false, nil
+	}
+	versions, err := listKeyVersions(keyName)
+	if err != nil {
+		return "", true, err
+	}
+	for _, v := range versions {
+		if v.Name == versionName {
+			return fmt.Sprintf("RETIRED (%s)", strings.ToLower(v.State)), true, nil
+		}
+	}
+	return "RETIRED (unknown version)", true, nil
+}
+
+func status(projectRoot string, files []string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tKEY\tVERSION\tSEALED BY\tSTATUS")
+	retired := 0
+	staleFiles := 0
+	for _, file := range files {
+		name, err := filepath.Rel(projectRoot, file)
+		if err != nil {
+			name = file
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		e, err := parseEnvelope(data)
+		if checkErr := checkCiphertext(data); checkErr != nil {
+			err = checkErr
+		}
+		if errors.Is(err, errNotEnvelope) {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\tunknown (legacy format, reseal to record key version)\n", name, fileKey(file))
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\terror: %s\n", name, err)
+			continue
+		}
+		state, isRetired, err := keyVersionStatus(e.Key, e.KeyVersion)
+		if err != nil {
+			state = fmt.Sprintf("error: %s", err)
+		}
+		if isRetired {
+			retired++
+		}
+		if age, stale, err := staleness(e); err == nil && stale {
+			staleFiles++
+			state += fmt.Sprintf(", STALE (sealed %s ago)", formatAge(age))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, e.Key, versionNumber(e.KeyVersion), describeProvenance(e), state)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if retired > 0 {
+		errPrintln("Warning: %d file(s) still encrypted under retired key versions", retired)
+	}
+	if staleFiles > 0 {
+		errPrintln("Warning: %d file(s) sealed longer ago than their key's rotation period. Run `secrets reseal-all` so old key versions can be destroyed", staleFiles)
 	}
-	return info.IsDir()
+	return nil
+}
+
+// projectPath is the slash separated path of a file relative to the project
+// root, as recorded in envelopes.
+func projectPath(filePath string) string {
+	relativePath, err := filepath.Rel(projectRoot, filePath)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(relativePath)
+}
+
+// isProjectRoot reports whether path is the top of a git work tree, where
+// .git is a folder, or a file in worktrees and submodules.
+func isProjectRoot(path string) bool {
+	_, err := os.Stat(filepath.Join(path, ".git"))
+	return err == nil
 }
 
 func findProjectRoot(path string) (string, error) {
@@ -205,6 +666,9 @@ func findProjectRoot(path string) (string, error) {
 	if isProjectRoot(path) {
 		return path, nil
 	}
+	if filepath.Base(path) != ".git" && isBareRepository(path) {
+		return path, fmt.Errorf("%s: %w", path, errBareRepository)
+	}
 	return findProjectRoot(nextPath)
 }
 
@@ -226,6 +690,8 @@ func popCommand(args []string) (string, []string, error) {
 	return "", args, errors.New("command not found")
 }
 
+// popFiles takes the file arguments before the first flag. Files after the
+// flags, including any after `--`, are left to flag.Parse and added later.
 func popFiles(args []string) ([]string, []string, error) {
 	var (
 		file string
@@ -238,21 +704,17 @@ func popFiles(args []string) ([]string, []string, error) {
 		if err != nil {
 			break
 		}
-		absolutePath, err := filepath.Abs(file)
-		if err != nil {
-			return files, os.Args, err
-		}
-		files = append(files, absolutePath)
+		files = append(files, file)
 	}
-
-	return files, os.Args, nil
+	files, err = absolutePaths(files)
+	return files, os.Args, err
 }
 
 func isGitTracked(projectRoot string, filePath string) (bool, error) {
 	_, _, _, err := runCommand(
 		"git",
 		"-C", projectRoot,
-		"ls-files", "--error-unmatch", filePath,
+		"--literal-pathspecs", "ls-files", "--error-unmatch", "--", filePath,
 	)
 	if err != nil {
 		return false, err
@@ -264,7 +726,7 @@ func isGitIgnored(projectRoot string, filePath string) (bool, error) {
 	_, stdOut, _, err := runCommand(
 		"git",
 		"-C", projectRoot,
-		"check-ignore", filePath,
+		"check-ignore", "--", filePath,
 	)
 	if err != nil {
 		return false, err
@@ -272,98 +734,227 @@ func isGitIgnored(projectRoot string, filePath string) (bool, error) {
 	return (strings.TrimSpace(stdOut) == filePath), nil
 }
 
-func appendToFile(filePath string, line string) error {
-	f, err := os.OpenFile(filePath,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+func confirm(question string) bool {
+	if assumeYes {
+		return true
 	}
-	defer f.Close()
-	if _, err := f.WriteString(line + "\n"); err != nil {
-		return err
+	if ciMode {
+		errPrintln("Warning: not prompting in CI mode, pass --yes to confirm: %s", question)
+		return false
 	}
-	return nil
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
 }
 
+// defaultRemote is the remote a project is named after, unless the root
+// .secrets.yaml sets another.
+const defaultRemote string = "origin"
+
+// ambiguousRemoteError is a project whose remotes can't tell its name, as
+// opposed to one without a remote in the expected organization, which
+// key-fallback names instead.
+type ambiguousRemoteError struct {
+	message string
+}
+
+func (e *ambiguousRemoteError) Error() string {
+	return e.message
+}
+
+var scpRemotePattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+var remoteProjectPattern = regexp.MustCompile(`^([^/]+)/([^/.]+)$`)
+
+// remoteRepo parses the organization and project of a remote URL in one of
+// hosts, either scp-like as git@host:org/repo.git or a URL with a protocol
+// and maybe a port, as ssh://git@host:2222/org/repo.git.
+func remoteRepo(remoteURL string, hosts []string) (string, string, bool) {
+	var host, repoPath string
+	if u, err := url.Parse(remoteURL); err == nil && u.Scheme != "" && u.Host != "" {
+		host, repoPath = u.Hostname(), u.Path
+	} else if matches := scpRemotePattern.FindStringSubmatch(remoteURL); matches != nil {
+		host, repoPath = matches[1], matches[2]
+	} else {
+		return "", "", false
+	}
+	known := false
+	for _, h := range hosts {
+		known = known || strings.EqualFold(host, h)
+	}
+	matches := remoteProjectPattern.FindStringSubmatch(strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git"))
+	if !known || matches == nil {
+		return "", "", false
+	}
+	return matches[1], matches[2], true
+}
+
+// repoHosts are the git hosts of the project's repositories, those in the
+// root .secrets.yaml or else expectedRepoHost.
+func repoHosts(config *secretsConfig) []string {
+	if config != nil && len(config.GitHosts) > 0 {
+		return config.GitHosts
+	}
+	return []string{expectedRepoHost}
+}
+
+// getProjectRepo names the project after its repository in the expected
+// organization: that of the preferred remote, its fetch URL before its push
+// URL, or else that of the only repository the other remotes point to.
 func getProjectRepo(projectRoot string) (string, error) {
-	_, stdOut, _, err := runCommand("git", "-C", projectRoot, "remote", "-v")
+	remotes, err := gitRemotes(projectRoot)
 	if err != nil {
 		return "", err
 	}
-	example := fmt.Sprintf("git@%s:%s/<project name>.git", expectedRepoHost, expectedOrganization)
-	re := regexp.MustCompile("(?i)" + expectedRepoHost + `:([^/]*)/([^/\.]*)\.git`)
-	matches := re.FindStringSubmatch(stdOut)
-	if len(matches) == 3 {
-		org := matches[1]
-		project := matches[2]
-
-		if strings.ToLower(org) == expectedOrganization {
+	var config *secretsConfig
+	if c, err := configFor(projectRoot); err == nil {
+		config = c
+	}
+	preferred, explicit := defaultRemote, false
+	if config != nil && config.Remote != "" {
+		preferred, explicit = config.Remote, true
+	}
+	hosts := repoHosts(config)
+	host := strings.Join(hosts, " or ")
+	example := fmt.Sprintf("git@%s:%s/<project name>.git", hosts[0], expectedOrganization)
+	// Fetch URLs come first in git remote -v, so they're tried first.
+	var otherOrg, otherProject string
+	for _, remote := range remotes {
+		if remote.Name != preferred {
+			continue
+		}
+		org, project, ok := remoteRepo(remote.URL, hosts)
+		if ok && strings.ToLower(org) == expectedOrganization {
 			return project, nil
 		}
-
-		return "", fmt.Errorf(
-			`%s not a %s project in %s: expecting a remote %s, got %s in %s`,
-			projectRoot,
-			expectedOrganization,
-			expectedRepoHost,
-			example,
-			project,
-			org,
-		)
-	}
-	return "", fmt.Errorf(
-		`%s not a project in %s: expecting a remote %s`,
-		projectRoot,
-		expectedRepoHost,
-		example,
-	)
-}
-
-func addGitIgnore(projectRoot string, fileToIgnore string) error {
-	relativePath, err := filepath.Rel(projectRoot, fileToIgnore)
-	if err != nil {
-		return err
+		if ok && otherOrg == "" {
+			otherOrg, otherProject = org, project
+		}
 	}
-
-	isTracked, err := isGitTracked(projectRoot, relativePath)
-	if isTracked {
-		printDebugln("NOT appending %s to gitignore because it's already tracked", fileToIgnore)
-		return errFileAlreadyTracked
+	if explicit {
+		if otherOrg != "" {
+			return "", &ambiguousRemoteError{fmt.Sprintf(`%s not a %s project in %s: expecting the remote %s to be %s, got %s in %s`, projectRoot, expectedOrganization, host, preferred, example, otherProject, otherOrg)}
+		}
+		return "", &ambiguousRemoteError{fmt.Sprintf(`%s not a project in %s: expecting the remote %s to be %s`, projectRoot, host, preferred, example)}
 	}
-	isIgnored, err := isGitIgnored(projectRoot, fileToIgnore)
-	if isIgnored {
-		printDebugln("NOT appending %s to gitignore because it's already ignored", fileToIgnore)
-		return nil
+	projects := map[string][]string{}
+	names := []string{}
+	for _, remote := range remotes {
+		org, project, ok := remoteRepo(remote.URL, hosts)
+		if !ok {
+			continue
+		}
+		if strings.ToLower(org) != expectedOrganization {
+			if otherOrg == "" {
+				otherOrg, otherProject = org, project
+			}
+			continue
+		}
+		if len(projects[project]) == 0 {
+			names = append(names, project)
+		}
+		if !containsString(projects[project], remote.Name) {
+			projects[project] = append(projects[project], remote.Name)
+		}
+	}
+	if len(names) == 1 {
+		return names[0], nil
+	}
+	if len(names) > 1 {
+		described := make([]string, len(names))
+		for i, name := range names {
+			described[i] = fmt.Sprintf("%s (%s)", name, strings.Join(projects[name], ", "))
+		}
+		return "", &ambiguousRemoteError{fmt.Sprintf(`%s has remotes for several %s projects: %s; set remote in .secrets.yaml to the one to name keys after`, projectRoot, expectedOrganization, strings.Join(described, ", "))}
+	}
+	if otherOrg != "" {
+		return "", fmt.Errorf(`%s not a %s project in %s: expecting a remote %s, got %s in %s`, projectRoot, expectedOrganization, host, example, otherProject, otherOrg)
 	}
-	return appendToFile(path.Join(projectRoot, ".gitignore"), relativePath)
+	return "", fmt.Errorf(`%s not a project in %s: expecting a remote %s`, projectRoot, host, example)
 }
 
 func exitIfError(err error) {
+	if errors.Is(err, errInterrupted) {
+		exit(130)
+	}
 	if err != nil {
 		errPrintln("Error: %s", err)
-		os.Exit(1)
+		exit(1)
 	}
 }
 
+// getKeyName is the name of the project's key, or of its folder when it
+// can't be named, for labels and messages.
 func getKeyName(projectRoot string) string {
-	repo, err := getProjectRepo(projectRoot)
-	if err == nil {
-		return repo
+	keyName, err := projectKeyName(projectRoot)
+	if err != nil {
+		printDebugln("could not name the key of %s: %s", projectRoot, err)
+		return filepath.Base(projectRoot)
 	}
-	return filepath.Base(projectRoot)
+	return keyName
+}
+
+func sealFile(path string) error {
+	if err := encrypt(fileKey(sealedAs(path)), path); err != nil {
+		return err
+	}
+	err := addGitIgnore(projectRoot, path)
+	if errors.Is(err, ErrPlaintextTracked) {
+		errPrintln("Warning: plain-text file already checked in: %s", path)
+		return nil
+	}
+	return err
+}
+
+func openFile(path string) error {
+	return decrypt(fileKey(path), path)
+}
+
+func resealFile(path string) error {
+	return reseal(fileKey(path), path)
 }
 
 func main() {
 	var (
-		cmd   string
-		files []string
-		err   error
+		cmd         string
+		subCmd      string
+		files       []string
+		commandArgs []string
+		err         error
 	)
 
 	cmd, os.Args, err = popCommand(os.Args)
 	if err != nil {
 		errPrintln("Error: %s\n%s", err, usage)
-		os.Exit(1)
+		exit(1)
+	}
+
+	if cmd == planCmd || cmd == workspaceCmd || cmd == accessCmd || cmd == keysCmd || cmd == gitHookCmd || cmd == kubectlCmd || cmd == importCmd || cmd == exportCmd || cmd == syncCmd || cmd == configCmd {
+		subCmd, os.Args, err = popCommand(os.Args)
+		// export --bundle takes files instead of a store.
+		if err != nil && cmd != exportCmd {
+			errPrintln("Error: %s command missing\n%s", cmd, usage)
+			exit(1)
+		}
+	}
+
+	if cmd == helpCmd {
+		subCmd, os.Args, _ = popCommand(os.Args)
+	}
+	if cmd == gitHookCmd || cmd == configCmd {
+		for {
+			var arg string
+			arg, os.Args, err = popCommand(os.Args)
+			if err != nil {
+				break
+			}
+			commandArgs = append(commandArgs, arg)
+		}
 	}
 
 	files, os.Args, err = popFiles(os.Args)
@@ -372,54 +963,435 @@ func main() {
 	printDebugln("%s", os.Args)
 
 	flag.BoolVar(&verbose, "verbose", false, "Log debug info")
-	flag.BoolVar(&dryRun, "dry-run", false, "Skip calls to GCP")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print what would be done without changing anything")
 	flag.BoolVar(&openAll, "open-all", false, "Opens all .enc files within the repository")
 	flag.StringVar(&projectRoot, "root", "", "Project root folder(name will be used as key name)")
 	flag.StringVar(&key, "key", "", "Key to use")
+	flag.BoolVar(&assumeYes, "yes", false, "Answer yes to all prompts")
+	flag.BoolVar(&ciMode, "ci", false, "Non-interactive mode for pipelines: no prompts, no color, no key creation and a JSON summary on stdout")
+	flag.BoolVar(&keepGoing, "keep-going", false, "Continue with the remaining files when one fails")
+	flag.IntVar(&jobs, "jobs", 4, "Number of files to process in parallel")
+	flag.StringVar(&kmsTransport, "kms-transport", transportAuto, "How to call Cloud KMS: gcloud, native (the REST API with Application Default Credentials), auto, or fake to encrypt locally in tests")
+	flag.StringVar(&kmsTransport, "backend", transportAuto, "Same as --kms-transport")
+	flag.StringVar(&kmsRecord, "kms-record", "", "Save every KMS call and its response to this file, for --kms-replay")
+	flag.StringVar(&kmsReplay, "kms-replay", "", "Answer KMS calls from a file saved with --kms-record instead of calling KMS")
+	flag.StringVar(&credentialsConfig, "credentials-config", "", "Credentials file to call the KMS API with, such as a workload identity federation configuration")
+	flag.StringVar(&impersonateServiceAccount, "impersonate-service-account", "", "Service account to call Google Cloud as, with the credentials' permission to impersonate it")
+	flag.Float64Var(&kmsRate, "kms-rate", 10, "Maximum KMS requests per second, 0 for no limit")
+	flag.IntVar(&maxDepth, "max-depth", 0, "How many folders deep below the project root to look for files, 0 for no limit")
+	flag.BoolVar(&deterministic, "deterministic", false, "Produce the same .enc when sealing the same content under the same key version")
+	flag.BoolVar(&force, "force", false, "Seal, reseal or plan files even when they are up to date")
+	flag.BoolVar(&backup, "backup", false, "Save plaintext that open would overwrite as <file>.bak.<timestamp>")
+	flag.BoolVar(&interactive, "interactive", false, "Pick which files to seal or open from a list")
+	flag.BoolVar(&interactive, "i", false, "Short for --interactive")
+	flag.DurationVar(&ttl, "ttl", 0, "Remove opened files after this long, e.g. 30m, sealing any changes first")
+	flag.StringVar(&filesFrom, "files-from", "", "Read the files to work on from this file, or from stdin for -, one per line or NUL-separated")
+	flag.BoolVar(&pathsOnly, "paths-only", false, "Make status and ls print only the paths of the files, one per line")
+	flag.BoolVar(&print0, "print0", false, "Like --paths-only, ending each path with a NUL character instead of a newline, for xargs -0")
+	flag.BoolVar(&findEncrypted, "encrypted", false, "Make find list .enc files")
+	flag.BoolVar(&findPlaintext, "plaintext", false, "Make find list plaintext secret files")
+	flag.StringVar(&findPattern, "pattern", "", "Regular expression on paths for find to match instead of secret file names")
+	flag.Var(&excludes, "exclude", "Glob of paths for discovery to skip, relative to the project root, can be repeated")
+	flag.StringVar(&kubeNamespace, "namespace", "", "Namespace for kubectl to put objects in that don't name one")
+	flag.StringVar(&kubeContext, "context", "", "kubeconfig context for kubectl to use")
+	flag.BoolVar(&syncPull, "pull", false, "Make sync import the values of the target into a sealed file instead of pushing them")
+	flag.StringVar(&vaultMount, "mount", "", "Mount of the Vault KV version 2 secrets engine to sync with")
+	flag.StringVar(&vaultPath, "path", "", "Path of the Vault secret to sync with")
+	flag.StringVar(&syncRepo, "repo", "", "Repository to sync secrets to, as owner/name on GitHub or the project path on GitLab")
+	flag.StringVar(&syncGroup, "group", "", "GitLab group to sync CI variables to, instead of a project")
+	flag.StringVar(&syncEnvironment, "env", "", "Environment to sync secrets to: a GitHub environment, or the environment scope of GitLab variables")
+	flag.StringVar(&syncService, "service", "", "Cloud Run service or Cloud Function to sync environment variables to, by name or full resource name")
+	flag.StringVar(&syncRegion, "region", "", "Region of the Cloud Run service or Cloud Function to sync with")
+	flag.Var(&maskedVariables, "masked", "Glob of GitLab variable names to mask in job logs, can be repeated")
+	flag.Var(&protectedVariables, "protected", "Glob of GitLab variable names to only pass to protected branches and tags, can be repeated")
+	flag.StringVar(&agentListen, "listen", "", "Address for agent to serve Prometheus metrics and health checks on, such as 127.0.0.1:9464")
+	flag.BoolVar(&noGit, "no-git", false, "Never invoke git, as without it: no remote parsing, .gitignore entries or commit lookups")
+	flag.BoolVar(&toStdout, "stdout", false, "Write what open or seal produces for one file, or for stdin, to stdout")
+	flag.StringVar(&outPath, "out", "", "Write the plaintext of the one file opened, the .enc of the one file sealed, or the bundle, to this path")
+	flag.StringVar(&outDir, "out-dir", "", "Write opened, sealed or unbundled files under this folder, keeping their path relative to the project root")
+	flag.StringVar(&exportBundle, "bundle", "", "Make export seal the files into this bundle instead of a store, for import into another repository")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "Search symlinked folders when looking for files")
+	flag.BoolVar(&detailedExitCode, "detailed-exitcode", false, "Make plan exit with 2 when there are changes, 0 when there are none")
+	flag.StringVar(&keyCreationFlags.RotationPeriod, "rotation-period", "", "Rotation period of new keys, e.g. 90d, or never (100d by default)")
+	flag.StringVar(&keyCreationFlags.NextRotationTime, "next-rotation-time", "", "First rotation of new keys, a time such as 2030-01-01T00:00:00Z or an offset such as 30d")
+	flag.StringVar(&keyCreationFlags.ProtectionLevel, "protection-level", "", "Protection level of new keys: software or hsm")
+	flag.Var(labelsFlag(keyCreationFlags.Labels), "label", "Label to put on new keys as name=value, can be repeated")
+	flag.BoolVar(&createKeyRingFlag, "create-keyring", false, "Create the key ring when it doesn't exist, after confirmation")
+	flag.StringVar(&keyProjectFlag, "key-project", "", "Project of keys given by name, when it isn't the one gcloud or the credentials are set up for")
+	flag.StringVar(&keyRing, "key-ring", defaultKeyRing, "Key ring of keys given by name")
+	flag.StringVar(&profile, "profile", os.Getenv("SECRETS_PROFILE"), "Profile of the user config to use, such as the project, key ring and service account of a client, $SECRETS_PROFILE by default")
+	flag.StringVar(&locationFlag, "location", "", "Location of the key ring, global by default")
+	flag.StringVar(&secondaryLocationFlag, "secondary-location", "", "Second location to also seal with and to open from when the location is unavailable")
+	flag.StringVar(&signingKey, "signing-key", "", "Asymmetric KMS key to sign .enc files with and to check their signatures against")
+	flag.StringVar(&reportFormat, "format", formatJSON, "Output format of report: json or csv")
+	flag.StringVar(&ciSystem, "ci-system", "", "CI system to emit masking directives for (github, gitlab, buildkite), detected by default")
+	flag.StringVar(&colorMode, "color", colorAuto, "When to color messages: auto, when stderr is a terminal, always or never")
 
+	flag.Usage = func() {
+		if err := printHelp(os.Stderr, cmd); err != nil {
+			errPrintln("%s", usage)
+		}
+	}
 	flag.Parse()
+	if cmd == helpCmd {
+		exitIfError(printHelp(os.Stdout, subCmd))
+		exit(0)
+	}
+	// A broken user config doesn't stop config from showing or fixing
+	// settings, and config validate reports it as a problem.
+	if err := applyUserConfig(); err != nil && cmd == configCmd {
+		if subCmd != configValidateCmd {
+			errPrintln("Warning: %s", err)
+		}
+	} else {
+		exitIfError(err)
+	}
+	commandName = strings.TrimSpace(cmd + " " + subCmd)
+	startCommandSpan("secrets " + commandName)
+	kmsLimiter.setRate(kmsRate)
+	exitIfError(absoluteGitEnv())
+	moreFiles, err := absolutePaths(flag.Args())
+	exitIfError(err)
+	files = append(files, moreFiles...)
+	if filesFrom != "" {
+		listed, err := readFileList(filesFrom)
+		exitIfError(err)
+		if len(listed) == 0 {
+			printProgress("No files listed in %s, nothing to do", filesFrom)
+			exit(0)
+		}
+		listed, err = absolutePaths(listed)
+		exitIfError(err)
+		files = append(files, listed...)
+	}
+	if projectRoot != "" {
+		projectRoot = expandHome(projectRoot)
+	}
+	if outPath != "" {
+		outPath, err = filepath.Abs(expandHome(outPath))
+		exitIfError(err)
+	}
+	if outDir != "" {
+		outDir, err = filepath.Abs(expandHome(outDir))
+		exitIfError(err)
+	}
+	if exportBundle != "" {
+		exportBundle, err = filepath.Abs(expandHome(exportBundle))
+		exitIfError(err)
+	}
+	key, err = normalizeKeyName(key)
+	exitIfError(err)
 
+	if cmd == versionCmd {
+		printVersion()
+		exit(0)
+	}
+	if cmd == selfUpdateCmd {
+		exitIfError(selfUpdate())
+		exit(0)
+	}
+	if cmd == workspaceCmd {
+		root := projectRoot
+		if root == "" {
+			root = "."
+		}
+		exitIfError(workspace(root, subCmd))
+		exit(0)
+	}
+
+	if projectRoot == "" && !noGit {
+		projectRoot, err = gitEnvWorkTree()
+		exitIfError(err)
+	}
 	if projectRoot == "" {
-		projectRoot, _ = findProjectRoot(".")
+		projectRoot, err = findProjectRoot(".")
+		if errors.Is(err, errBareRepository) {
+			exitIfError(err)
+		}
+		if err != nil {
+			if root, err := findConfigRoot("."); err == nil {
+				projectRoot = root
+			}
+		}
 	}
 
+	if cmd == configCmd {
+		exitIfError(configCommand(subCmd, commandArgs))
+		exit(0)
+	}
+
+	exitIfError(applyProjectOptions(projectRoot))
+	exitIfError(applyProfile())
+	exitIfError(applyLocations(projectRoot))
+	keyExplicit = key != ""
 	if key == "" {
-		key = getKeyName(projectRoot)
+		key, err = projectKey(projectRoot)
+		exitIfError(err)
 	}
 
 	printDebugln("dry run: %t", dryRun)
 	printDebugln("key: %s", key)
 	printDebugln("project root: %s", projectRoot)
-	printDebugln("cmd: %s", cmd)
+	printDebugln("cmd: %s %s", cmd, subCmd)
 	printDebugln("files: %s (%d)", files, len(files))
 
+	if cmd == agentCmd {
+		exitIfError(agent())
+		exit(0)
+	}
+	if err := expireOpenedFiles(); err != nil {
+		errPrintln("Warning: could not remove expired files: %s", err)
+	}
+	if containsString(recoverCommands, cmd) {
+		exitIfError(recoverTempFiles(projectRoot))
+	}
+
+	if cmd == kubectlCmd {
+		code, err := kubectl(subCmd, files)
+		exitIfError(err)
+		exit(code)
+	}
+	if toStdout && cmd == encryptCmd {
+		exitIfError(sealToStdout(files))
+		exit(0)
+	}
+	if toStdout && cmd == decryptCmd {
+		exitIfError(openToStdout(files))
+		exit(0)
+	}
 	if cmd == encryptCmd {
 		if len(files) == 0 {
-			files, _ = findUnencryptedFiles(projectRoot)
-		}
-		for _, path := range files {
-			fmt.Printf("encrypting %s\n", path)
-			exitIfError(encrypt(key, path))
-			err := addGitIgnore(projectRoot, path)
-			if err == errFileAlreadyTracked {
-				errPrintln("Warning: plain-text file already checked in: %s", path)
-				continue
+			files, err = findUnencryptedFiles(projectRoot)
+			exitIfError(err)
+			if !interactive && !dryRun {
+				exitIfError(confirmDiscoveredFiles(files))
 			}
+		}
+		if interactive {
+			files, err = selectPaths(files)
 			exitIfError(err)
 		}
-		os.Exit(0)
+		exitIfError(checkOutputFlags(files))
+		if dryRun {
+			exitIfError(printPlan(planSeal(files)))
+			exit(0)
+		}
+		exitIfError(forEachFile(cmd, "encrypting", files, sealFile))
+		exit(0)
 	}
 	if cmd == decryptCmd {
 		if len(files) == 0 {
-			files, _ = findEncryptedFiles(projectRoot)
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		files = foldFragments(files)
+		if interactive {
+			files, err = selectPaths(files)
+			exitIfError(err)
+		}
+		exitIfError(checkOutputFlags(files))
+		if dryRun {
+			exitIfError(printPlan(planOpen(files)))
+			exit(0)
+		}
+		exitIfError(forEachFile(cmd, "decrypting", files, openFile))
+		exit(0)
+	}
+	if cmd == bundleCmd {
+		exitIfError(sealBundle(outPath, files))
+		exit(0)
+	}
+	if cmd == unbundleCmd {
+		exitIfError(unbundle(files))
+		exit(0)
+	}
+	if cmd == findCmd {
+		exitIfError(find(projectRoot))
+		exit(0)
+	}
+	if cmd == statusCmd || cmd == listCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
 		}
-		for _, path := range files {
-			fmt.Printf("decrypting %s\n", path)
-			err := decrypt(key, path)
+		if pathsOnly || print0 {
+			exitIfError(printPaths(files))
+			exit(0)
+		}
+		exitIfError(status(projectRoot, files))
+		exit(0)
+	}
+	if cmd == planCmd {
+		if len(files) == 0 && subCmd == encryptCmd {
+			files, err = findUnencryptedFiles(projectRoot)
+			exitIfError(err)
+		} else if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
 			exitIfError(err)
 		}
-		os.Exit(0)
+		p, err := buildPlan(subCmd, files)
+		exitIfError(printPlan(p, err))
+		if detailedExitCode && p.hasChanges() {
+			exit(2)
+		}
+		exit(0)
+	}
+	if cmd == verifyCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(verify(files))
+		exit(0)
+	}
+	if cmd == reportCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(report(projectRoot, files, reportFormat))
+		exit(0)
+	}
+	if cmd == keysCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(keys(subCmd, files))
+		exit(0)
+	}
+	if cmd == accessCmd {
+		exitIfError(access(subCmd, files))
+		exit(0)
+	}
+	if cmd == whoamiCmd {
+		exitIfError(whoami())
+		exit(0)
+	}
+	if cmd == uiCmd {
+		exitIfError(ui(projectRoot))
+		exit(0)
+	}
+	if cmd == maskCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(mask(files))
+		exit(0)
+	}
+	if cmd == envFileCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(envFile(files))
+		exit(0)
+	}
+	if cmd == systemdCredsCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(systemdCredentials(files))
+		exit(0)
+	}
+	if cmd == importCmd {
+		exitIfError(importSecrets(subCmd, files))
+		exit(0)
+	}
+	if cmd == exportCmd {
+		exitIfError(exportSecrets(subCmd, files))
+		exit(0)
+	}
+	if cmd == syncCmd {
+		if len(files) == 0 && !syncPull {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		exitIfError(syncSecrets(subCmd, files))
+		exit(0)
+	}
+	if cmd == gitAttributesCmd {
+		exitIfError(writeGitAttributes(projectRoot))
+		exit(0)
+	}
+	if cmd == gitConfigCmd {
+		exitIfError(configureGitDrivers(projectRoot))
+		exit(0)
+	}
+	if cmd == gitHooksCmd {
+		exitIfError(installGitHooks(projectRoot))
+		exit(0)
+	}
+	if cmd == gitHookCmd {
+		exitIfError(gitHook(projectRoot, subCmd, commandArgs))
+		exit(0)
+	}
+	if cmd == gitTextconvCmd {
+		if len(files) != 1 {
+			errPrintln("Error: git-textconv expects a single file")
+			exit(1)
+		}
+		exitIfError(gitTextconv(files[0]))
+		exit(0)
+	}
+	if cmd == gitMergeCmd {
+		if len(files) != 4 {
+			errPrintln("Error: git-merge expects %%O %%A %%B %%P from git")
+			exit(1)
+		}
+		exitIfError(gitMerge(fileKey(files[3]), files[0], files[1], files[2], files[3]))
+		exit(0)
+	}
+	if cmd == gitMergeLockCmd {
+		if len(files) != 3 {
+			errPrintln("Error: git-merge-lock expects %%O %%A %%B from git")
+			exit(1)
+		}
+		exitIfError(gitMergeLock(files[0], files[1], files[2]))
+		exit(0)
+	}
+	if cmd == moveCmd {
+		if len(files) != 2 {
+			errPrintln("Error: mv expects a source and a destination\n%s", usage)
+			exit(1)
+		}
+		exitIfError(move(projectRoot, files[0], files[1]))
+		exit(0)
+	}
+	if cmd == pruneCmd {
+		exitIfError(prune(projectRoot))
+		exit(0)
+	}
+	if cmd == purgeHistoryCmd {
+		if len(files) != 1 {
+			errPrintln("Error: purge-history expects the path of the leaked file\n%s", usage)
+			exit(1)
+		}
+		exitIfError(purgeHistory(projectRoot, files[0]))
+		exit(0)
+	}
+	if cmd == lockCmd {
+		exitIfError(writeLock(projectRoot))
+		exit(0)
+	}
+	if cmd == cleanCmd {
+		exitIfError(clean(projectRoot))
+		exit(0)
+	}
+	if cmd == resealAllCmd {
+		if len(files) == 0 {
+			files, err = findEncryptedFiles(projectRoot)
+			exitIfError(err)
+		}
+		if dryRun {
+			exitIfError(printPlan(planReseal(files)))
+			exit(0)
+		}
+		exitIfError(forEachFile(cmd, "resealing", files, resealFile))
+		exit(0)
 	}
 	errPrintln("Unknown command: %s\n%s", cmd, usage)
-	os.Exit(1)
+	exit(1)
 }
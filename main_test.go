@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyVersionStatus(t *testing.T) {
+	useFakeBackend(t)
+	describedKeys[testKey] = &kmsKey{Name: testKey, Primary: kmsKeyVersion{Name: testKey + "/cryptoKeyVersions/3"}}
+	listedKeyVersions[testKey] = []kmsKeyVersion{
+		{Name: testKey + "/cryptoKeyVersions/1", State: "DESTROYED"},
+		{Name: testKey + "/cryptoKeyVersions/2", State: "ENABLED"},
+		{Name: testKey + "/cryptoKeyVersions/3", State: "ENABLED"},
+	}
+	for _, test := range []struct {
+		version string
+		state   string
+		retired bool
+	}{
+		{"3", "current", false},
+		{"2", "RETIRED (enabled)", true},
+		{"1", "RETIRED (destroyed)", true},
+		{"4", "RETIRED (unknown version)", true},
+	} {
+		t.Run(test.version, func(t *testing.T) {
+			state, retired, err := keyVersionStatus(testKey, testKey+"/cryptoKeyVersions/"+test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != test.state || retired != test.retired {
+				t.Errorf("expecting %q retired %t, got %q retired %t", test.state, test.retired, state, retired)
+			}
+		})
+	}
+}
+
+func TestSealRecordsKeyVersion(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	e := readEnvelope(plaintextFile + ".enc")
+	if e == nil || e.KeyVersion != testKey+"/cryptoKeyVersions/1" || versionNumber(e.KeyVersion) != "1" {
+		t.Errorf("expecting key version 1 recorded, got %+v", e)
+	}
+}
+
+func TestStaleness(t *testing.T) {
+	useFakeBackend(t)
+	describedKeys[testKey] = &kmsKey{Name: testKey, RotationPeriod: "8640000s"}
+	describedKeys[testKey+"-unrotated"] = &kmsKey{Name: testKey + "-unrotated"}
+	for _, test := range []struct {
+		name  string
+		e     *envelope
+		stale bool
+	}{
+		{"recent", &envelope{Key: testKey, SealedAt: time.Now().Add(-10 * 24 * time.Hour)}, false},
+		{"older than the rotation period", &envelope{Key: testKey, SealedAt: time.Now().Add(-200 * 24 * time.Hour)}, true},
+		{"key not rotated", &envelope{Key: testKey + "-unrotated", SealedAt: time.Now().Add(-200 * 24 * time.Hour)}, false},
+		{"legacy envelope", &envelope{}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, stale, err := staleness(test.e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stale != test.stale {
+				t.Errorf("expecting stale %t, got %t", test.stale, stale)
+			}
+		})
+	}
+}
+
+func TestOpenRestoresModeAndModificationTime(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content []byte
+		mode    os.FileMode
+		opened  os.FileMode
+	}{
+		{"owner only", []byte("token: abc\n"), 0600, 0600},
+		{"unauthenticated", []byte("token: abc\n"), 0755, 0700},
+		{"data key", bytes.Repeat([]byte("0123456789abcdef"), 8192), 0755, 0755},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			plaintextFile := filepath.Join(root, "secret.yaml")
+			writeTestFile(t, plaintextFile, test.content, test.mode)
+			modifiedAt := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+			if err := os.Chtimes(plaintextFile, modifiedAt, modifiedAt); err != nil {
+				t.Fatal(err)
+			}
+			if err := encrypt(testKey, plaintextFile); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, plaintextFile, []byte("token: def\n"), 0666)
+			force = true
+			defer func() { force = false }()
+			if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(plaintextFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != test.opened || !info.ModTime().Equal(modifiedAt) {
+				t.Errorf("expecting mode %v modified at %s, got %v modified at %s", test.opened, modifiedAt, info.Mode().Perm(), info.ModTime())
+			}
+		})
+	}
+}
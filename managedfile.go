@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	managedBlockStart string = "# BEGIN secrets (managed by `secrets`, do not edit)"
+	managedBlockEnd   string = "# END secrets"
+)
+
+// managedFile is a git config file such as .gitignore or .gitattributes,
+// split around the block of entries managed by this tool. Lines outside the
+// block are never rewritten.
+type managedFile struct {
+	path    string
+	before  []string
+	entries []string
+	after   []string
+}
+
+func readManagedFile(filePath string) (*managedFile, error) {
+	g := &managedFile{path: filePath}
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return g, nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	section := &g.before
+	for _, line := range lines {
+		switch {
+		case line == managedBlockStart && section == &g.before:
+			section = &g.entries
+		case line == managedBlockEnd && section == &g.entries:
+			section = &g.after
+		default:
+			*section = append(*section, line)
+		}
+	}
+	return g, nil
+}
+
+func normalizeGitIgnoreEntry(entry string) string {
+	return strings.TrimPrefix(strings.TrimSpace(entry), "/")
+}
+
+func (g *managedFile) lines() []string {
+	all := make([]string, 0, len(g.before)+len(g.entries)+len(g.after))
+	all = append(all, g.before...)
+	all = append(all, g.entries...)
+	return append(all, g.after...)
+}
+
+func (g *managedFile) has(entry string) bool {
+	entry = normalizeGitIgnoreEntry(entry)
+	for _, line := range g.lines() {
+		if normalizeGitIgnoreEntry(line) == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *managedFile) add(entry string) bool {
+	if g.has(entry) {
+		return false
+	}
+	g.entries = append(g.entries, entry)
+	return true
+}
+
+// remove drops an entry wherever it appears, including entries written
+// before the managed block existed.
+func (g *managedFile) remove(entry string) bool {
+	entry = normalizeGitIgnoreEntry(entry)
+	removed := false
+	filter := func(lines []string) []string {
+		kept := lines[:0]
+		for _, line := range lines {
+			if normalizeGitIgnoreEntry(line) == entry {
+				removed = true
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return kept
+	}
+	g.before = filter(g.before)
+	g.entries = filter(g.entries)
+	g.after = filter(g.after)
+	return removed
+}
+
+func (g *managedFile) write() error {
+	return os.WriteFile(g.path, g.contents(), 0644)
+}
+
+// contents are the lines of the file, with the managed block sorted and
+// without duplicates, also of lines outside it.
+func (g *managedFile) contents() []byte {
+	sort.Strings(g.entries)
+	seen := map[string]bool{}
+	for _, line := range append(g.before, g.after...) {
+		seen[normalizeGitIgnoreEntry(line)] = true
+	}
+	entries := g.entries[:0]
+	for _, entry := range g.entries {
+		normalized := normalizeGitIgnoreEntry(entry)
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		entries = append(entries, entry)
+	}
+	g.entries = entries
+	lines := make([]string, 0, len(g.before)+len(g.entries)+len(g.after)+3)
+	lines = append(lines, g.before...)
+	if len(g.entries) > 0 {
+		if len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, managedBlockStart)
+		lines = append(lines, g.entries...)
+		lines = append(lines, managedBlockEnd)
+	}
+	lines = append(lines, g.after...)
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+const managedFileLockTimeout time.Duration = 10 * time.Second
+
+// updateManagedFile reads filePath, lets update change it and writes it
+// back if update reports a change. It holds filePath.lock meanwhile, as git
+// does for its own files, so that secrets processes running at the same
+// time don't drop each other's entries, and writes the file by renaming the
+// lock over it.
+func updateManagedFile(filePath string, update func(g *managedFile) bool) error {
+	lockPath := filePath + ".lock"
+	lock, err := createLockFile(lockPath)
+	if err != nil {
+		return err
+	}
+	defer trackTempFile(lockPath, false)
+	g, err := readManagedFile(filePath)
+	if err == nil && update(g) {
+		_, err = lock.Write(g.contents())
+		// Renaming the lock over the file would reset its mode otherwise.
+		if info, statErr := os.Stat(filePath); err == nil && statErr == nil {
+			err = lock.Chmod(info.Mode().Perm())
+		}
+		if closeErr := lock.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			return os.Rename(lockPath, filePath)
+		}
+	}
+	lock.Close()
+	os.Remove(lockPath)
+	return err
+}
+
+// createLockFile creates lockPath, waiting for another process holding it.
+func createLockFile(lockPath string) (*os.File, error) {
+	deadline := time.Now().Add(managedFileLockTimeout)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			trackTempFile(lockPath, true)
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another process; remove it if no secrets is running", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
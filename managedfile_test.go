@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManagedFileContents(t *testing.T) {
+	g := &managedFile{
+		before:  []string{"node_modules/", "/.env"},
+		entries: []string{"b.yaml", "a.yaml", "/b.yaml", ".env"},
+		after:   []string{"dist/"},
+	}
+	expected := "node_modules/\n/.env\n\n" + managedBlockStart + "\n/b.yaml\na.yaml\n" + managedBlockEnd + "\ndist/\n"
+	if contents := string(g.contents()); contents != expected {
+		t.Errorf("expecting %q, got %q", expected, contents)
+	}
+	if contents := string((&managedFile{}).contents()); contents != "" {
+		t.Errorf("expecting no contents, got %q", contents)
+	}
+}
+
+func TestManagedFileRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".gitignore")
+	initial := "*.log\n\n" + managedBlockStart + "\nsecret.yaml\n" + managedBlockEnd + "\nbuild/\n"
+	if err := os.WriteFile(file, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := readManagedFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(g.contents()) != initial {
+		t.Errorf("expecting the file unchanged, got %q", g.contents())
+	}
+	if g.add("/secret.yaml") {
+		t.Error("added an entry the block already has")
+	}
+	if !g.add("other.yaml") || !g.remove("*.log") {
+		t.Error("expecting other.yaml added and *.log removed")
+	}
+	expected := "\n" + managedBlockStart + "\nother.yaml\nsecret.yaml\n" + managedBlockEnd + "\nbuild/\n"
+	if contents := string(g.contents()); contents != expected {
+		t.Errorf("expecting %q, got %q", expected, contents)
+	}
+}
+
+func TestUpdateManagedFileKeepsMode(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".gitignore")
+	if err := os.WriteFile(file, []byte("*.log\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := updateManagedFile(file, func(g *managedFile) bool { return g.add("secret.yaml") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expecting the mode kept at 0600, got %04o", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(file); string(data) != "*.log\n\n"+managedBlockStart+"\nsecret.yaml\n"+managedBlockEnd+"\n" {
+		t.Errorf("unexpected contents %q", data)
+	}
+}
+
+func TestUpdateManagedFileConcurrently(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".gitignore")
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = updateManagedFile(file, func(g *managedFile) bool {
+				return g.add(fmt.Sprintf("secret-%02d.yaml", i))
+			})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := readManagedFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.entries) != len(errs) {
+		t.Errorf("expecting %d entries, got %q", len(errs), g.entries)
+	}
+	if fileExists(file + ".lock") {
+		t.Error("expecting the lock removed")
+	}
+}
+
+func TestUpdateManagedFileWaitsForLock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".gitignore")
+	writeTestFile(t, file+".lock", nil, 0644)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Remove(file + ".lock")
+	}()
+	if err := updateManagedFile(file, func(g *managedFile) bool { return g.add("secret.yaml") }); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); !strings.Contains(string(data), "\nsecret.yaml\n") {
+		t.Errorf("expecting the entry added once the lock is released, got %q", data)
+	}
+}
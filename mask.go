@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const minMaskedLength int = 4
+
+func detectCISystem() string {
+	if ciSystem != "" {
+		return ciSystem
+	}
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return "github"
+	case os.Getenv("GITLAB_CI") == "true":
+		return "gitlab"
+	case os.Getenv("BUILDKITE") == "true":
+		return "buildkite"
+	}
+	return ""
+}
+
+func collectYAMLValues(n *yamlNode, values map[string]struct{}) {
+	if n == nil {
+		return
+	}
+	if n.kind == yamlScalar {
+		values[n.value()] = ignore
+		return
+	}
+	for _, child := range n.values {
+		collectYAMLValues(child, values)
+	}
+}
+
+// secretValues extracts the values worth masking from a decrypted file:
+// every scalar of a YAML file (plus the decoded data of Kubernetes Secrets),
+// every value of a dotenv file, or else every line.
+func secretValues(filePath string, plaintext []byte) map[string]struct{} {
+	values := map[string]struct{}{}
+	if isDotenvFile(filePath) {
+		for _, v := range parseDotenv(plaintext) {
+			values[v.Value] = ignore
+		}
+		return values
+	}
+	if regexp.MustCompile(`\.(yaml|yml)(\.enc)?$`).MatchString(filePath) {
+		documents, err := parseYAMLDocuments(plaintext)
+		if err == nil {
+			for _, document := range documents {
+				collectYAMLValues(document, values)
+				if document.get("kind").value() != "Secret" || document.get("data") == nil {
+					continue
+				}
+				for _, encoded := range document.get("data").values {
+					if decoded, err := base64.StdEncoding.DecodeString(encoded.value()); err == nil {
+						values[string(decoded)] = ignore
+					}
+				}
+			}
+			return values
+		}
+		printDebugln("%s is not valid YAML, masking every line: %s", filePath, err)
+	}
+	for _, line := range strings.Split(string(plaintext), "\n") {
+		values[strings.TrimSpace(line)] = ignore
+	}
+	return values
+}
+
+func isMaskable(value string) bool {
+	if len(value) < minMaskedLength {
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "true", "false", "null":
+		return false
+	}
+	return true
+}
+
+// mask emits directives so the CI system redacts every secret value from
+// the rest of the job's log.
+func mask(files []string) error {
+	system := detectCISystem()
+	if system == "" {
+		return fmt.Errorf("could not detect the CI system, pass --ci-system github|gitlab|buildkite")
+	}
+	if system == "gitlab" {
+		return fmt.Errorf("GitLab can't mask values at runtime, define them as masked CI/CD variables instead")
+	}
+	if system != "github" && system != "buildkite" {
+		return fmt.Errorf("unsupported CI system %s, expecting github, gitlab or buildkite", system)
+	}
+
+	all := map[string]struct{}{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		plaintext, _, err := openBytes(file, data)
+		recordAudit(commandName, file, err)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for value := range secretValues(file, plaintext) {
+			// Multi-line values are masked line by line, as that's how
+			// they show up in logs.
+			for _, line := range strings.Split(value, "\n") {
+				if line = strings.TrimSpace(line); isMaskable(line) {
+					all[line] = ignore
+				}
+			}
+		}
+	}
+	values := make([]string, 0, len(all))
+	for value := range all {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	printDebugln("masking %d values", len(values))
+
+	if system == "github" {
+		for _, value := range values {
+			fmt.Printf("::add-mask::%s\n", value)
+		}
+		return nil
+	}
+	redactions := map[string]string{}
+	for i, value := range values {
+		redactions[fmt.Sprintf("secret_%d", i)] = value
+	}
+	input, err := json.Marshal(redactions)
+	if err != nil {
+		return err
+	}
+	_, _, stdErr, err := runCommandWithInput(input, "buildkite-agent", "redactor", "add")
+	if err != nil {
+		return fmt.Errorf("buildkite-agent redactor add failed: %s", strings.TrimSpace(stdErr))
+	}
+	return nil
+}
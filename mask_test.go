@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSecretValues(t *testing.T) {
+	for _, test := range []struct {
+		file      string
+		plaintext string
+		values    []string
+	}{
+		{"secret.yaml", "user: admin\npassword: hunter2\n", []string{"admin", "hunter2"}},
+		{"secret.yaml", "kind: Secret\ndata:\n  token: aHVudGVyMg==\n", []string{"Secret", "aHVudGVyMg==", "hunter2"}},
+		{"app.env", "USER=admin\nPASSWORD=\"hunter2\"\n", []string{"admin", "hunter2"}},
+		{"id_rsa", "line one\n  line two\n", []string{"", "line one", "line two"}},
+	} {
+		values := []string{}
+		for value := range secretValues(test.file, []byte(test.plaintext)) {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s %q: expecting %q, got %q", test.file, test.plaintext, test.values, values)
+		}
+	}
+}
+
+func TestIsMaskable(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		maskable bool
+	}{
+		{"hunter2", true},
+		{"abc", false},
+		{"True", false},
+		{"null", false},
+		{"1234", true},
+	} {
+		if maskable := isMaskable(test.value); maskable != test.maskable {
+			t.Errorf("%q: expecting %t, got %t", test.value, test.maskable, maskable)
+		}
+	}
+}
+
+func TestDetectCISystem(t *testing.T) {
+	for _, test := range []struct {
+		flag   string
+		env    string
+		system string
+	}{
+		{"", "", ""},
+		{"", "GITHUB_ACTIONS", "github"},
+		{"", "GITLAB_CI", "gitlab"},
+		{"", "BUILDKITE", "buildkite"},
+		{"buildkite", "GITHUB_ACTIONS", "buildkite"},
+	} {
+		for _, name := range []string{"GITHUB_ACTIONS", "GITLAB_CI", "BUILDKITE"} {
+			t.Setenv(name, "")
+		}
+		if test.env != "" {
+			t.Setenv(test.env, "true")
+		}
+		ciSystem = test.flag
+		if system := detectCISystem(); system != test.system {
+			t.Errorf("--ci-system=%q with %s set: expecting %q, got %q", test.flag, test.env, test.system, system)
+		}
+	}
+	ciSystem = ""
+}
+
+func TestMaskRefusesGitLab(t *testing.T) {
+	ciSystem = "gitlab"
+	defer func() { ciSystem = "" }()
+	if err := mask(nil); err == nil {
+		t.Error("expecting GitLab refused")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var errMergeConflict = errors.New("merge conflict")
+
+// yamlCanonical renders a node ignoring comments and formatting, so that
+// nodes can be compared for equality.
+func yamlCanonical(n *yamlNode) string {
+	if n == nil {
+		return "<nil>"
+	}
+	switch n.kind {
+	case yamlMapping:
+		parts := make([]string, 0, len(n.keys))
+		for i, key := range n.keys {
+			parts = append(parts, fmt.Sprintf("%q:%s", yamlUnquote(key), yamlCanonical(n.values[i])))
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case yamlSequence:
+		parts := make([]string, 0, len(n.values))
+		for _, item := range n.values {
+			parts = append(parts, yamlCanonical(item))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	}
+	return fmt.Sprintf("%q", n.value())
+}
+
+func yamlChildPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func yamlEqual(a *yamlNode, b *yamlNode) bool {
+	return yamlCanonical(a) == yamlCanonical(b)
+}
+
+func yamlKeyIndex(n *yamlNode, key string) int {
+	if n == nil || n.kind != yamlMapping {
+		return -1
+	}
+	for i, k := range n.keys {
+		if yamlUnquote(k) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeYAMLNodes is a 3-way merge recursing into mappings. A nil node means
+// the entry doesn't exist on that side. Sequences and scalars changed on
+// both sides are conflicts, recorded by their path.
+func mergeYAMLNodes(path string, base *yamlNode, ours *yamlNode, theirs *yamlNode, conflicts *[]string) *yamlNode {
+	if yamlEqual(ours, theirs) {
+		return ours
+	}
+	if yamlEqual(base, ours) {
+		return theirs
+	}
+	if yamlEqual(base, theirs) {
+		return ours
+	}
+	if ours == nil || theirs == nil || ours.kind != yamlMapping || theirs.kind != yamlMapping {
+		*conflicts = append(*conflicts, path)
+		return ours
+	}
+	if base != nil && base.kind != yamlMapping {
+		base = nil
+	}
+
+	merged := &yamlNode{kind: yamlMapping, offset: ours.offset, trailing: ours.trailing, start: ours.start}
+	add := func(key string, value *yamlNode, comments []string) {
+		merged.keys = append(merged.keys, key)
+		merged.values = append(merged.values, value)
+		merged.comments = append(merged.comments, comments)
+	}
+	for i, key := range ours.keys {
+		name := yamlUnquote(key)
+		var b, t *yamlNode
+		if j := yamlKeyIndex(base, name); j >= 0 {
+			b = base.values[j]
+		}
+		if j := yamlKeyIndex(theirs, name); j >= 0 {
+			t = theirs.values[j]
+		}
+		if value := mergeYAMLNodes(yamlChildPath(path, name), b, ours.values[i], t, conflicts); value != nil {
+			add(key, value, ours.comments[i])
+		}
+	}
+	for i, key := range theirs.keys {
+		name := yamlUnquote(key)
+		if yamlKeyIndex(ours, name) >= 0 {
+			continue
+		}
+		var b *yamlNode
+		if j := yamlKeyIndex(base, name); j >= 0 {
+			b = base.values[j]
+		}
+		if value := mergeYAMLNodes(yamlChildPath(path, name), b, nil, theirs.values[i], conflicts); value != nil {
+			add(key, value, theirs.comments[i])
+		}
+	}
+	return merged
+}
+
+func mergeYAML(base []byte, ours []byte, theirs []byte) ([]byte, []string, error) {
+	parse := func(data []byte) ([]*yamlNode, error) {
+		if len(bytes.TrimSpace(data)) == 0 {
+			return []*yamlNode{}, nil
+		}
+		return parseYAMLDocuments(data)
+	}
+	baseDocuments, err := parse(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	ourDocuments, err := parse(ours)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirDocuments, err := parse(theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ourDocuments) != len(theirDocuments) {
+		return mergeWhole(base, ours, theirs)
+	}
+
+	conflicts := []string{}
+	merged := make([]*yamlNode, 0, len(ourDocuments))
+	for i := range ourDocuments {
+		var b *yamlNode
+		if len(baseDocuments) == len(ourDocuments) {
+			b = baseDocuments[i]
+		}
+		path := ""
+		if len(ourDocuments) > 1 {
+			path = fmt.Sprintf("[document %d]", i+1)
+		}
+		merged = append(merged, mergeYAMLNodes(path, b, ourDocuments[i], theirDocuments[i], &conflicts))
+	}
+	return marshalYAMLDocuments(merged), conflicts, nil
+}
+
+// mergeWhole is used for anything that can't be merged structurally: it
+// only succeeds when at most one side changed the file.
+func mergeWhole(base []byte, ours []byte, theirs []byte) ([]byte, []string, error) {
+	if bytes.Equal(ours, theirs) || bytes.Equal(base, theirs) {
+		return ours, nil, nil
+	}
+	if bytes.Equal(base, ours) {
+		return theirs, nil, nil
+	}
+	return ours, []string{"(whole file)"}, nil
+}
+
+func readOptional(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return data, err
+}
+
+// gitMerge is a git merge driver for .enc files, invoked as
+// `secrets git-merge %O %A %B %P`. The merged result is sealed back into
+// the %A file; on conflicts %A is left as ours and git reports a conflict.
+// secrets.lock is left to its own merge driver, see gitMergeLock.
+func gitMerge(keyName string, baseFile string, oursFile string, theirsFile string, pathName string) error {
+	if err := checkAccessPolicy(pathName); err != nil {
+		return fmt.Errorf("%s: %w", pathName, err)
+	}
+	if err := checkPolicy("open", pathName); err != nil {
+		return fmt.Errorf("%s: %w", pathName, err)
+	}
+	ciphertexts := make([][]byte, 3)
+	plaintexts := make([][]byte, 3)
+	var ourEnvelope *envelope
+	for i, file := range []string{baseFile, oursFile, theirsFile} {
+		data, err := readOptional(file)
+		if err != nil {
+			return err
+		}
+		ciphertexts[i] = data
+		if len(bytes.TrimSpace(data)) == 0 {
+			plaintexts[i] = []byte{}
+			continue
+		}
+		plaintext, e, err := decryptBytes(keyName, data)
+		if err == nil {
+			err = checkSignature(pathName, e)
+		}
+		if err == nil {
+			err = checkPath(pathName, e)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		plaintexts[i] = plaintext
+		if i == 1 {
+			ourEnvelope = e
+		}
+	}
+	base, ours, theirs := plaintexts[0], plaintexts[1], plaintexts[2]
+
+	var merged []byte
+	var conflicts []string
+	var err error
+	plaintextFile := strings.TrimSuffix(pathName, ".enc")
+	if regexp.MustCompile(`\.(yaml|yml)$`).MatchString(plaintextFile) {
+		merged, conflicts, err = mergeYAML(base, ours, theirs)
+		if err != nil {
+			errPrintln("Warning: %s is not valid YAML, falling back to a whole-file merge: %s", pathName, err)
+			merged, conflicts, err = mergeWhole(base, ours, theirs)
+		}
+	} else {
+		merged, conflicts, err = mergeWhole(base, ours, theirs)
+	}
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		errPrintln("Conflicting changes to %s:", pathName)
+		for _, conflict := range conflicts {
+			errPrintln("  %s", conflict)
+		}
+		errPrintln("Keeping our version; open it with `secrets open`, resolve by hand and seal it again")
+		return errMergeConflict
+	}
+
+	if bytes.Equal(merged, ours) {
+		return nil
+	}
+	if bytes.Equal(merged, theirs) {
+		return os.WriteFile(oursFile, ciphertexts[2], 0644)
+	}
+	if ourEnvelope != nil && ourEnvelope.Key != "" {
+		keyName = ourEnvelope.Key
+	}
+	headers := plaintextHeaders{Path: projectPath(strings.TrimSuffix(sealedFileOf(pathName), ".enc")), ModifiedAt: time.Now()}
+	if ourEnvelope != nil && ourEnvelope.Mode != 0 {
+		headers.Mode = plaintextMode(pathName, ourEnvelope)
+	}
+	if ourEnvelope != nil {
+		headers.Fragment = ourEnvelope.Fragment
+	}
+	e, err := sealBytes(plaintextFile, keyName, merged, headers, ourEnvelope)
+	if err != nil {
+		return err
+	}
+	if err := signFor(pathName, e); err != nil {
+		return err
+	}
+	return os.WriteFile(oursFile, e.marshal(), 0644)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeYAML(t *testing.T) {
+	base := "database:\n  user: app\n  password: a\napi_token: x\n"
+	for _, test := range []struct {
+		name      string
+		ours      string
+		theirs    string
+		merged    string
+		conflicts []string
+	}{
+		{"other keys", "database:\n  user: app\n  password: b\napi_token: x\n", "database:\n  user: app\n  password: a\napi_token: y\n", "database:\n  user: app\n  password: b\napi_token: y\n", []string{}},
+		{"nested keys", "database:\n  user: admin\n  password: a\napi_token: x\n", "database:\n  user: app\n  password: c\napi_token: x\n", "database:\n  user: admin\n  password: c\napi_token: x\n", []string{}},
+		{"same change", "database:\n  user: app\n  password: b\napi_token: x\n", "database:\n  user: app\n  password: b\napi_token: x\n", "database:\n  user: app\n  password: b\napi_token: x\n", []string{}},
+		{"added and removed", "database:\n  user: app\n  password: a\napi_token: x\nsmtp: z\n", "database:\n  user: app\n  password: a\n", "database:\n  user: app\n  password: a\nsmtp: z\n", []string{}},
+		{"same key", "database:\n  user: app\n  password: b\napi_token: x\n", "database:\n  user: app\n  password: c\napi_token: x\n", "database:\n  user: app\n  password: b\napi_token: x\n", []string{"database.password"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts, err := mergeYAML([]byte(base), []byte(test.ours), []byte(test.theirs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(merged) != test.merged {
+				t.Errorf("expecting %q, got %q", test.merged, merged)
+			}
+			if !reflect.DeepEqual(conflicts, test.conflicts) {
+				t.Errorf("expecting conflicts %v, got %v", test.conflicts, conflicts)
+			}
+		})
+	}
+}
+
+func TestMergeWhole(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		ours     string
+		theirs   string
+		merged   string
+		conflict bool
+	}{
+		{"unchanged", "a", "a", "a", false},
+		{"ours changed", "b", "a", "b", false},
+		{"theirs changed", "a", "c", "c", false},
+		{"both changed", "b", "c", "b", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			merged, conflicts, err := mergeWhole([]byte("a"), []byte(test.ours), []byte(test.theirs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(merged) != test.merged || (len(conflicts) > 0) != test.conflict {
+				t.Errorf("expecting %q with conflict %t, got %q with %v", test.merged, test.conflict, merged, conflicts)
+			}
+		})
+	}
+}
+
+func TestGitMergeSealsMergedYAML(t *testing.T) {
+	root := useFakeBackend(t)
+	ciphertextFile := filepath.Join(root, "secret.yaml.enc")
+	dir := t.TempDir()
+	seal := func(name string, content string) string {
+		writeTestFile(t, filepath.Join(root, "secret.yaml"), []byte(content), 0600)
+		if err := encrypt(testKey, filepath.Join(root, "secret.yaml")); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(ciphertextFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, name)
+		writeTestFile(t, file, data, 0644)
+		return file
+	}
+	base := seal("base", "a: 1\nb: 1\n")
+	ours := seal("ours", "a: 2\nb: 1\n")
+	theirs := seal("theirs", "a: 1\nb: 2\n")
+	if err := gitMerge(testKey, base, ours, theirs, ciphertextFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, _, err := openBytes(ciphertextFile, data)
+	if err != nil || string(merged) != "a: 2\nb: 2\n" {
+		t.Errorf("expecting both changes, got %q (%v)", merged, err)
+	}
+	conflicting := seal("conflicting", "a: 3\nb: 1\n")
+	if err := gitMerge(testKey, base, conflicting, ours, ciphertextFile); !errors.Is(err, errMergeConflict) {
+		t.Errorf("expecting a merge conflict, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On Compute Engine, GKE with Workload Identity, Cloud Run and Cloud Build,
+// the metadata server hands out tokens for the service account the workload
+// runs as, so no credentials file or gcloud login is needed there.
+
+const metadataFlavor string = "Google"
+
+var metadataOnce sync.Once
+var metadataFound bool
+
+func metadataHost() string {
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		return host
+	}
+	return "metadata.google.internal"
+}
+
+func metadataGet(path string, timeout time.Duration) (string, error) {
+	request, err := http.NewRequest("GET", "http://"+metadataHost()+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata-Flavor", metadataFlavor)
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %s: %s", response.Status, strings.TrimSpace(string(data)))
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// onMetadataServer reports whether a metadata server answers, asking it once
+// and only briefly, since outside of Google Cloud nothing will.
+func onMetadataServer() bool {
+	metadataOnce.Do(func() {
+		request, err := http.NewRequest("GET", "http://"+metadataHost()+"/", nil)
+		if err != nil {
+			return
+		}
+		request.Header.Set("Metadata-Flavor", metadataFlavor)
+		response, err := (&http.Client{Timeout: time.Second}).Do(request)
+		if err != nil {
+			printDebugln("no metadata server: %s", err)
+			return
+		}
+		response.Body.Close()
+		metadataFound = response.Header.Get("Metadata-Flavor") == metadataFlavor
+	})
+	return metadataFound
+}
+
+// metadataCredentials are the credentials of the service account attached
+// to the workload.
+func metadataCredentials() (*credentialsFile, *cachedToken, error) {
+	email, err := metadataGet("instance/service-accounts/default/email", 10*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get the service account from the metadata server: %w", err)
+	}
+	project, err := metadataGet("project/project-id", 10*time.Second)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get the project from the metadata server: %w", err)
+	}
+	printDebugln("using the service account %s from the metadata server", email)
+	creds := &credentialsFile{Type: "metadata", ProjectID: project, ClientEmail: email}
+	return creds, &cachedToken{fetch: func() (string, time.Duration, error) {
+		data, err := metadataGet("instance/service-accounts/default/token?"+url.Values{"scopes": {credentialsScope()}}.Encode(), 30*time.Second)
+		if err != nil {
+			return "", 0, fmt.Errorf("could not get an access token from the metadata server: %w", err)
+		}
+		var token tokenResponse
+		if err := json.Unmarshal([]byte(data), &token); err != nil || token.AccessToken == "" {
+			return "", 0, fmt.Errorf("could not read the access token from the metadata server: %s", data)
+		}
+		return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+	}}, nil
+}
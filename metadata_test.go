@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useMetadataServer points metadata lookups at a fake metadata server
+// answering paths from responses.
+func useMetadataServer(t *testing.T, responses map[string]string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Metadata-Flavor", metadataFlavor)
+		if r.Header.Get("Metadata-Flavor") != metadataFlavor {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		response, ok := responses[strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+}
+
+func TestMetadataCredentials(t *testing.T) {
+	useMetadataServer(t, map[string]string{
+		"instance/service-accounts/default/email": "ci@project.iam.gserviceaccount.com\n",
+		"project/project-id":                      "project",
+		"instance/service-accounts/default/token": `{"access_token": "token", "expires_in": 3600}`,
+	})
+	creds, token, err := metadataCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.ClientEmail != "ci@project.iam.gserviceaccount.com" || creds.ProjectID != "project" {
+		t.Errorf("expecting the service account and project of the metadata server, got %+v", creds)
+	}
+	if value, err := token.token(); err != nil || value != "token" {
+		t.Errorf("expecting the token of the metadata server, got %q (%v)", value, err)
+	}
+}
+
+func TestMetadataCredentialsErrors(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		responses map[string]string
+		err       string
+	}{
+		{"no service account", map[string]string{}, "could not get the service account"},
+		{"bad token", map[string]string{
+			"instance/service-accounts/default/email": "ci@project.iam.gserviceaccount.com",
+			"project/project-id":                      "project",
+			"instance/service-accounts/default/token": `{"error": "scopes"}`,
+		}, "could not read the access token"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useMetadataServer(t, test.responses)
+			_, token, err := metadataCredentials()
+			if err == nil {
+				_, err = token.token()
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The agent serves Prometheus metrics on --listen: the KMS calls it makes
+// and how long they took, hits of the cache of key metadata, and its sweeps
+// of opened files, so unusual volumes of decryption can be alerted on.
+
+var agentListen string
+
+type metricFamily struct {
+	name string
+	kind string
+	help string
+}
+
+var metricFamilies = []metricFamily{
+	{"secrets_kms_requests_total", "counter", "KMS encrypt and decrypt calls by operation and outcome."},
+	{"secrets_kms_request_duration_seconds", "histogram", "Duration of KMS encrypt and decrypt calls, retries included."},
+	{"secrets_key_cache_requests_total", "counter", "Lookups of key metadata by cache and result, hit or miss."},
+	{"secrets_agent_sweeps_total", "counter", "Checks of opened files for expiry by outcome."},
+	{"secrets_agent_sweep_duration_seconds", "histogram", "Duration of checks of opened files for expiry."},
+	{"secrets_agent_expired_files_total", "counter", "Expired opened files by action: removed, sealed then removed, or kept because they changed outside a project."},
+	{"secrets_agent_tracked_files", "gauge", "Opened files waiting to expire."},
+}
+
+var metricsMutex sync.Mutex
+var metricValues = map[string]map[string]float64{}
+var metricHistograms = map[string]map[string]*durationHistogram{}
+
+// metricLabels formats name, value pairs as the labels of a series.
+func metricLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	return strings.Join(labels, ",")
+}
+
+func addMetric(name string, value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if metricValues[name] == nil {
+		metricValues[name] = map[string]float64{}
+	}
+	metricValues[name][metricLabels(labels...)] += value
+}
+
+func countMetric(name string, labels ...string) {
+	addMetric(name, 1, labels...)
+}
+
+func setMetric(name string, value float64, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if metricValues[name] == nil {
+		metricValues[name] = map[string]float64{}
+	}
+	metricValues[name][metricLabels(labels...)] = value
+}
+
+func observeMetric(name string, elapsed time.Duration, labels ...string) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	if metricHistograms[name] == nil {
+		metricHistograms[name] = map[string]*durationHistogram{}
+	}
+	series := metricLabels(labels...)
+	histogram, ok := metricHistograms[name][series]
+	if !ok {
+		histogram = newDurationHistogram(nil)
+		metricHistograms[name][series] = histogram
+	}
+	histogram.observe(elapsed.Seconds())
+}
+
+func seriesName(name string, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// writeMetrics writes every metric in the Prometheus text format.
+func writeMetrics(w io.Writer) error {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+	var b strings.Builder
+	for _, family := range metricFamilies {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		if family.kind != "histogram" {
+			series := make([]string, 0, len(metricValues[family.name]))
+			for labels := range metricValues[family.name] {
+				series = append(series, labels)
+			}
+			sort.Strings(series)
+			for _, labels := range series {
+				fmt.Fprintf(&b, "%s %s\n", seriesName(family.name, labels), formatMetricValue(metricValues[family.name][labels]))
+			}
+			continue
+		}
+		series := make([]string, 0, len(metricHistograms[family.name]))
+		for labels := range metricHistograms[family.name] {
+			series = append(series, labels)
+		}
+		sort.Strings(series)
+		for _, labels := range series {
+			histogram := metricHistograms[family.name][labels]
+			separator := ""
+			if labels != "" {
+				separator = ","
+			}
+			cumulative := 0
+			for i, bound := range durationBounds {
+				cumulative += histogram.buckets[i]
+				fmt.Fprintf(&b, "%s_bucket{%s%sle=\"%s\"} %d\n", family.name, labels, separator, formatMetricValue(bound), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", family.name, labels, separator, histogram.count)
+			fmt.Fprintf(&b, "%s %s\n", seriesName(family.name+"_sum", labels), formatMetricValue(histogram.sum))
+			fmt.Fprintf(&b, "%s %d\n", seriesName(family.name+"_count", labels), histogram.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// serveAgent listens on addr in the background, failing right away when it
+// can't.
+func serveAgent(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := writeMetrics(w); err != nil {
+			printDebugln("could not write metrics: %s", err)
+		}
+	})
+	handleHealth(mux)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			errPrintln("Error: serving on %s: %s", addr, err)
+		}
+	}()
+	printProgress("serving /metrics, /healthz and /readyz on %s", listener.Addr())
+	return nil
+}
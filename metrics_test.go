@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func resetMetrics(t *testing.T) {
+	t.Helper()
+	metricValues, metricHistograms = map[string]map[string]float64{}, map[string]map[string]*durationHistogram{}
+	t.Cleanup(func() {
+		metricValues, metricHistograms = map[string]map[string]float64{}, map[string]map[string]*durationHistogram{}
+	})
+}
+
+func TestWriteMetrics(t *testing.T) {
+	resetMetrics(t)
+	countMetric("secrets_kms_requests_total", "operation", "decrypt", "outcome", "ok")
+	countMetric("secrets_kms_requests_total", "operation", "decrypt", "outcome", "ok")
+	countMetric("secrets_kms_requests_total", "operation", "encrypt", "outcome", "error")
+	setMetric("secrets_agent_tracked_files", 3)
+	setMetric("secrets_agent_tracked_files", 2)
+	observeMetric("secrets_agent_sweep_duration_seconds", 30*time.Millisecond)
+	observeMetric("secrets_agent_sweep_duration_seconds", 2*time.Second)
+	var b strings.Builder
+	if err := writeMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE secrets_kms_requests_total counter",
+		`secrets_kms_requests_total{operation="decrypt",outcome="ok"} 2`,
+		`secrets_kms_requests_total{operation="encrypt",outcome="error"} 1`,
+		"secrets_agent_tracked_files 2",
+		`secrets_agent_sweep_duration_seconds_bucket{le="0.025"} 0`,
+		`secrets_agent_sweep_duration_seconds_bucket{le="0.05"} 1`,
+		`secrets_agent_sweep_duration_seconds_bucket{le="2.5"} 2`,
+		`secrets_agent_sweep_duration_seconds_bucket{le="+Inf"} 2`,
+		"secrets_agent_sweep_duration_seconds_sum 2.03",
+		"secrets_agent_sweep_duration_seconds_count 2",
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("expecting %q in\n%s", line, b.String())
+		}
+	}
+}
+
+func TestKmsCallsAreCounted(t *testing.T) {
+	useFakeBackend(t)
+	resetMetrics(t)
+	kmsSelected = &deniedKeyBackend{denied: "/test"}
+	if _, err := callKms("encrypt", testKey, []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callKms("decrypt", testKey, []byte("hunter2")); err == nil {
+		t.Fatal("expecting decrypt denied")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := describeKey(testKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for series, value := range map[string]float64{
+		`operation="encrypt",outcome="ok"`:    1,
+		`operation="decrypt",outcome="error"`: 1,
+	} {
+		if got := metricValues["secrets_kms_requests_total"][series]; got != value {
+			t.Errorf("secrets_kms_requests_total{%s}: expecting %v, got %v", series, value, got)
+		}
+	}
+	for series, value := range map[string]float64{`cache="key",result="miss"`: 1, `cache="key",result="hit"`: 1} {
+		if got := metricValues["secrets_key_cache_requests_total"][series]; got != value {
+			t.Errorf("secrets_key_cache_requests_total{%s}: expecting %v, got %v", series, value, got)
+		}
+	}
+	if histogram := metricHistograms["secrets_kms_request_duration_seconds"][`operation="encrypt"`]; histogram == nil || histogram.count != 1 {
+		t.Errorf("expecting the duration of the encrypt call observed, got %+v", histogram)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func gitMove(projectRoot string, from string, to string) error {
+	_, _, stdErr, err := runCommand("git", "-C", projectRoot, "--literal-pathspecs", "mv", "--", from, to)
+	if err != nil {
+		return fmt.Errorf("git mv failed: %s", strings.TrimSpace(stdErr))
+	}
+	return nil
+}
+
+func gitAdd(projectRoot string, filePath string) error {
+	_, _, stdErr, err := runCommand("git", "-C", projectRoot, "--literal-pathspecs", "add", "--", filePath)
+	if err != nil {
+		return fmt.Errorf("git add failed: %s", strings.TrimSpace(stdErr))
+	}
+	return nil
+}
+
+// rebindEnvelope seals the content of fromEnc again for toEnc, whose path
+// envelopes sealed with a data key or signed authenticate, with the keys of
+// that path. Files that predate envelopes have no path to rebind, and give
+// a nil envelope.
+func rebindEnvelope(fromEnc string, toEnc string) (*envelope, error) {
+	data, err := os.ReadFile(fromEnc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseEnvelope(data); errors.Is(err, errNotEnvelope) {
+		return nil, nil
+	}
+	plaintext, e, err := decryptBytes(fileKey(fromEnc), data)
+	if err == nil {
+		err = checkSignature(fromEnc, e)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fromEnc, err)
+	}
+	plaintextFile := strings.TrimSuffix(toEnc, ".enc")
+	headers := e.plaintextHeaders()
+	headers.Path = projectPath(plaintextFile)
+	if e.Mode != 0 {
+		headers.Mode = plaintextMode(fromEnc, e)
+	}
+	moved, err := sealBytes(plaintextFile, fileKey(toEnc), plaintext, headers, e)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", toEnc, err)
+	}
+	return moved, nil
+}
+
+// move renames a secret's plaintext and .enc together, keeping the
+// .gitignore entry, the envelope path and the git index in step.
+func move(projectRoot string, from string, to string) error {
+	from = strings.TrimSuffix(from, ".enc")
+	to = strings.TrimSuffix(to, ".enc")
+	fromEnc, toEnc := from+".enc", to+".enc"
+
+	if !fileExists(fromEnc) {
+		return fmt.Errorf("not an encrypted secret: %s", fromEnc)
+	}
+	if fileExists(to) || fileExists(toEnc) {
+		return fmt.Errorf("destination already exists: %s", to)
+	}
+
+	printProgress("moving %s to %s", from, to)
+	if dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	moved, err := rebindEnvelope(fromEnc, toEnc)
+	if err != nil {
+		return err
+	}
+
+	isTracked, _ := isGitTracked(projectRoot, fromEnc)
+	if isTracked {
+		if err := gitMove(projectRoot, fromEnc, toEnc); err != nil {
+			return err
+		}
+	} else if err := os.Rename(fromEnc, toEnc); err != nil {
+		return err
+	}
+	if moved != nil {
+		if err := writeEnvelope(toEnc, moved); err != nil {
+			return err
+		}
+	}
+	if err := relockFile(fromEnc); err != nil {
+		return err
+	}
+	if err := relockFile(toEnc); err != nil {
+		return err
+	}
+	if isTracked {
+		if err := gitAdd(projectRoot, toEnc); err != nil {
+			return err
+		}
+	}
+
+	if fileExists(from) {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+
+	if err := removeGitIgnore(projectRoot, from); err != nil {
+		return err
+	}
+	err = addGitIgnore(projectRoot, to)
+	if errors.Is(err, ErrPlaintextTracked) {
+		errPrintln("Warning: plain-text file already checked in: %s", to)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveOpensAfterwards(t *testing.T) {
+	large := bytes.Repeat([]byte("key: value\n"), 10000)
+	for _, test := range []struct {
+		name          string
+		content       []byte
+		deterministic bool
+		signingKey    string
+	}{
+		{"small", []byte("password: hunter2\n"), false, ""},
+		{"data key", large, false, ""},
+		{"deterministic", []byte("password: hunter2\n"), true, ""},
+		{"signed", large, false, testKey + "-signing"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			deterministic, signingKey = test.deterministic, test.signingKey
+			defer func() { deterministic, signingKey = false, "" }()
+			from := filepath.Join(root, "config", "secret.yaml")
+			to := filepath.Join(root, "moved", "secret.yaml")
+			if err := os.MkdirAll(filepath.Dir(from), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, from, test.content, 0600)
+			if err := encrypt(fileKey(from), from); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(from); err != nil {
+				t.Fatal(err)
+			}
+			if err := move(root, from+".enc", to+".enc"); err != nil {
+				t.Fatal(err)
+			}
+			if fileExists(from + ".enc") {
+				t.Error("the .enc is still at its old path")
+			}
+			if e := readEnvelope(to + ".enc"); e == nil || e.Path != "moved/secret.yaml" {
+				t.Errorf("expecting the envelope bound to moved/secret.yaml, got %+v", e)
+			}
+			if err := decrypt(fileKey(to), to+".enc"); err != nil {
+				t.Fatal(err)
+			}
+			if opened, _ := os.ReadFile(to); !bytes.Equal(opened, test.content) {
+				t.Error("the moved file opens to other content")
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const kmsEndpoint string = "https://cloudkms.googleapis.com/v1/"
+
+// kmsEndpointURL honors the endpoint override gcloud uses, e.g. for a
+// private service connect endpoint.
+func kmsEndpointURL() string {
+	if override := os.Getenv("CLOUDSDK_API_ENDPOINT_OVERRIDES_CLOUDKMS"); override != "" {
+		return strings.TrimSuffix(override, "/") + "/v1/"
+	}
+	return kmsEndpoint
+}
+
+type kmsAPIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	body    string
+}
+
+func (e *kmsAPIError) Error() string {
+	return fmt.Sprintf("KMS request failed: %s: %s", e.Status, e.Message)
+}
+
+// nativeBackend calls the Cloud KMS REST API with Application Default
+// Credentials, for machines without gcloud.
+type nativeBackend struct {
+	client       *http.Client
+	token        *cachedToken
+	project      string
+	email        string
+	source       string
+	quotaProject string
+	impersonated string
+}
+
+func newNativeBackend() (*nativeBackend, error) {
+	creds, token, err := loadDefaultCredentials()
+	if err != nil {
+		return nil, err
+	}
+	n := &nativeBackend{client: newKMSHTTPClient(), token: token, project: defaultProject(creds), email: creds.ClientEmail, quotaProject: creds.QuotaProjectID}
+	n.source = fmt.Sprintf("%s credentials from %s", creds.Type, defaultCredentialsPath())
+	if creds.Type == "metadata" {
+		n.source = "the metadata server at " + metadataHost()
+	}
+	if creds.Type == "external_account" {
+		n.impersonated = creds.ClientEmail
+	}
+	if impersonateServiceAccount != "" {
+		credentialsToken := token
+		impersonationURL := googleAPIEndpoint("iamcredentials", "v1") + "projects/-/serviceAccounts/" + impersonateServiceAccount + ":generateAccessToken"
+		n.token = &cachedToken{fetch: func() (string, time.Duration, error) {
+			value, err := credentialsToken.token()
+			if err != nil {
+				return "", 0, err
+			}
+			return impersonate(impersonationURL, value)
+		}}
+		n.impersonated, n.email = impersonateServiceAccount, impersonateServiceAccount
+	}
+	return n, nil
+}
+
+func (n *nativeBackend) resourceName(keyName string) (string, error) {
+	if isKeyResourceName(keyName) {
+		return keyName, nil
+	}
+	if n.project == "" {
+		return "", fmt.Errorf("no project for key %s, set GOOGLE_CLOUD_PROJECT or pass a full key resource name with --key", keyName)
+	}
+	return fmt.Sprintf("projects/%s/locations/%s/keyRings/%s/cryptoKeys/%s", n.project, location, keyRing, keyName), nil
+}
+
+// newKMSHTTPClient is shared by every KMS call of a run, keeping a connection
+// alive per worker so that only the first calls pay for the TLS handshake.
+func newKMSHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = jobs + 1
+	transport.MaxIdleConnsPerHost = jobs + 1
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.ForceAttemptHTTP2 = true
+	return &http.Client{Transport: transport, Timeout: time.Minute}
+}
+
+// traceConnections records the time taken to open new connections, so
+// --verbose shows how many calls had to.
+func traceConnections(request *http.Request) *http.Request {
+	var start time.Time
+	return request.WithContext(httptrace.WithClientTrace(request.Context(), &httptrace.ClientTrace{
+		GetConn: func(string) { start = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !info.Reused {
+				recordTiming("kms connection", time.Since(start))
+			}
+		},
+	}))
+}
+
+func (n *nativeBackend) request(method string, path string, body interface{}, result interface{}) (err error) {
+	span := startSpan("KMS "+method, "kms", map[string]string{"url.path": path})
+	defer func() {
+		endSpan(span, err)
+	}()
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	token, err := n.token.token()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(interruptContext, method, kmsEndpointURL()+path, payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	client := n.client
+	if client == nil {
+		client = httpClient
+	}
+	kmsLimiter.wait()
+	start := time.Now()
+	response, err := client.Do(traceConnections(request))
+	elapsed := time.Since(start)
+	recordTiming("kms", elapsed)
+	printDebugln("KMS %s %s took %s", method, path, formatDuration(elapsed))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		printDebugln("KMS request failed: %s", data)
+		var apiErr struct {
+			Error kmsAPIError `json:"error"`
+		}
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Error.Status == "" {
+			return fmt.Errorf("KMS request failed: %s", response.Status)
+		}
+		apiErr.Error.body = string(data)
+		return &apiErr.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (n *nativeBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err = n.request("POST", name+":encrypt", map[string][]byte{"plaintext": plaintext}, &response)
+	return response.Ciphertext, err
+}
+
+func (n *nativeBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err = n.request("POST", name+":decrypt", map[string][]byte{"ciphertext": ciphertext}, &response)
+	return response.Plaintext, err
+}
+
+func (n *nativeBackend) describeKey(keyName string) (*kmsKey, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	k := &kmsKey{}
+	if err := n.request("GET", name, nil, k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (n *nativeBackend) listKeyVersions(keyName string) ([]kmsKeyVersion, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	versions := []kmsKeyVersion{}
+	pageToken := ""
+	for {
+		var page struct {
+			CryptoKeyVersions []kmsKeyVersion `json:"cryptoKeyVersions"`
+			NextPageToken     string          `json:"nextPageToken"`
+		}
+		query := url.Values{"pageSize": {"1000"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		if err := n.request("GET", name+"/cryptoKeyVersions?"+query.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		versions = append(versions, page.CryptoKeyVersions...)
+		if page.NextPageToken == "" {
+			return versions, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (n *nativeBackend) keyIAMPolicy(keyName string) (*iamPolicy, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &iamPolicy{}
+	return policy, n.request("GET", name+":getIamPolicy", nil, policy)
+}
+
+func (n *nativeBackend) keyRingIAMPolicy(keyName string) (*iamPolicy, error) {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	policy := &iamPolicy{}
+	return policy, n.request("GET", keyRingOf(name)+":getIamPolicy", nil, policy)
+}
+
+func (n *nativeBackend) asymmetricSign(versionName string, digestAlgorithm string, message []byte) ([]byte, error) {
+	var hash crypto.Hash
+	switch digestAlgorithm {
+	case "sha256":
+		hash = crypto.SHA256
+	case "sha384":
+		hash = crypto.SHA384
+	case "sha512":
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported digest algorithm %s", digestAlgorithm)
+	}
+	var response struct {
+		Signature []byte `json:"signature"`
+	}
+	body := map[string]map[string][]byte{"digest": {digestAlgorithm: digestOf(hash, message)}}
+	err := n.request("POST", versionName+":asymmetricSign", body, &response)
+	return response.Signature, err
+}
+
+func (n *nativeBackend) publicKey(versionName string) (*kmsPublicKey, error) {
+	k := &kmsPublicKey{}
+	return k, n.request("GET", versionName+"/publicKey", nil, k)
+}
+
+// identity is the service account of the credentials, or else the user the
+// access token was issued to.
+func (n *nativeBackend) identity() (string, error) {
+	if n.email != "" {
+		return n.email, nil
+	}
+	token, err := n.token.token()
+	if err != nil {
+		return "", err
+	}
+	return tokenEmail(token)
+}
+
+func (n *nativeBackend) createKey(keyName string, params *keyCreation) error {
+	name, err := n.resourceName(keyName)
+	if err != nil {
+		return err
+	}
+	parent, id, _ := strings.Cut(name, "/cryptoKeys/")
+	body := map[string]interface{}{
+		"purpose": "ENCRYPT_DECRYPT",
+		"versionTemplate": map[string]string{
+			"algorithm":       "GOOGLE_SYMMETRIC_ENCRYPTION",
+			"protectionLevel": strings.ToUpper(params.ProtectionLevel),
+		},
+	}
+	if params.RotationPeriod > 0 {
+		body["rotationPeriod"] = fmt.Sprintf("%ds", int(params.RotationPeriod.Seconds()))
+		body["nextRotationTime"] = params.NextRotationTime.UTC().Format(time.RFC3339)
+	}
+	if len(params.Labels) > 0 {
+		body["labels"] = params.Labels
+	}
+	return n.request("POST", parent+"/cryptoKeys?"+url.Values{"cryptoKeyId": {id}}.Encode(), body, nil)
+}
+
+func (n *nativeBackend) createKeyRing(keyRingName string) error {
+	parent, id, _ := strings.Cut(keyRingName, "/keyRings/")
+	return n.request("POST", parent+"/keyRings?"+url.Values{"keyRingId": {id}}.Encode(), map[string]string{}, nil)
+}
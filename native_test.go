@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKMSClientReusesConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "{}")
+	}))
+	defer server.Close()
+	timings = map[string]*timingStats{}
+	defer func() { timings = map[string]*timingStats{} }()
+	client := newKMSHTTPClient()
+	for i := 0; i < 3; i++ {
+		request, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := client.Do(traceConnections(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}
+	if stats := timings["kms connection"]; stats == nil || stats.calls != 1 {
+		t.Errorf("expecting 1 connection for 3 calls, got %+v", stats)
+	}
+}
+
+func TestKMSClientKeepsAConnectionPerWorker(t *testing.T) {
+	previousJobs := jobs
+	defer func() { jobs = previousJobs }()
+	jobs = 8
+	transport := newKMSHTTPClient().Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != jobs+1 || transport.MaxIdleConns != jobs+1 {
+		t.Errorf("expecting %d idle connections for %d jobs, got %d per host and %d in total", jobs+1, jobs, transport.MaxIdleConnsPerHost, transport.MaxIdleConns)
+	}
+}
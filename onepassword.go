@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// onePasswordStore keeps values as the fields of a 1Password item, through
+// the op CLI, which talks to a Connect server instead of the 1Password
+// service when OP_CONNECT_HOST and OP_CONNECT_TOKEN are set. Items are named
+// op://vault/item. Values are passed to op on stdin rather than as arguments,
+// where other users of the machine could see them. Nested values are
+// labeled with their path joined by dots.
+type onePasswordStore struct{}
+
+type onePasswordField struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+	Label   string `json:"label"`
+	Value   string `json:"value,omitempty"`
+}
+
+type onePasswordItem struct {
+	ID       string             `json:"id,omitempty"`
+	Title    string             `json:"title"`
+	Category string             `json:"category"`
+	Fields   []onePasswordField `json:"fields"`
+}
+
+func splitOnePasswordRef(ref string) (string, string, error) {
+	vault, item, ok := strings.Cut(ref, "/")
+	if !ok || vault == "" || item == "" || strings.Contains(item, "/") {
+		return "", "", fmt.Errorf("expecting op://vault/item, got op://%s", ref)
+	}
+	return vault, item, nil
+}
+
+func runOp(input []byte, args ...string) (string, error) {
+	_, stdOut, stdErr, err := runCommandWithInput(input, "op", args...)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.New("the 1Password CLI (op) is not installed")
+		}
+		return "", fmt.Errorf("op %s failed: %s", args[0], strings.TrimSpace(stdErr))
+	}
+	return stdOut, nil
+}
+
+func (o *onePasswordStore) get(vault string, item string) (*onePasswordItem, error) {
+	output, err := runOp(nil, "item", "get", item, "--vault", vault, "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	result := &onePasswordItem{}
+	if err := json.Unmarshal([]byte(output), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (o *onePasswordStore) read(ref string) ([]secretField, error) {
+	vault, item, err := splitOnePasswordRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	result, err := o.get(vault, item)
+	if err != nil {
+		return nil, err
+	}
+	fields := []secretField{}
+	for _, field := range result.Fields {
+		if field.Value == "" {
+			continue
+		}
+		label := field.Label
+		if label == "" {
+			label = field.ID
+		}
+		fields = append(fields, secretField{strings.Split(label, "."), field.Value})
+	}
+	return fields, nil
+}
+
+// write updates the fields of the item with the same labels, or adds them
+// as concealed fields, creating the item when there is none.
+func (o *onePasswordStore) write(ref string, fields []secretField) error {
+	vault, item, err := splitOnePasswordRef(ref)
+	if err != nil {
+		return err
+	}
+	existing, err := o.get(vault, item)
+	if err != nil {
+		printDebugln("creating %s, could not get it: %s", item, err)
+		existing = nil
+	}
+	target := existing
+	if target == nil {
+		target = &onePasswordItem{Title: item, Category: "SECURE_NOTE"}
+	}
+	for _, field := range fields {
+		label := field.name(".")
+		updated := false
+		for i := range target.Fields {
+			if target.Fields[i].Label == label {
+				target.Fields[i].Value = field.Value
+				updated = true
+			}
+		}
+		if !updated {
+			target.Fields = append(target.Fields, onePasswordField{Type: "CONCEALED", Label: label, Value: field.Value})
+		}
+	}
+	data, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		_, err = runOp(data, "item", "create", "--vault", vault, "--template", "/dev/stdin")
+		return err
+	}
+	_, err = runOp(data, "item", "edit", existing.ID, "--vault", vault, "--template", "/dev/stdin")
+	return err
+}
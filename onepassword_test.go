@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSplitOnePasswordRef(t *testing.T) {
+	for _, test := range []struct {
+		ref   string
+		vault string
+		item  string
+		ok    bool
+	}{
+		{"Engineering/payments", "Engineering", "payments", true},
+		{"Engineering", "", "", false},
+		{"/payments", "", "", false},
+		{"Engineering/payments/extra", "", "", false},
+	} {
+		vault, item, err := splitOnePasswordRef(test.ref)
+		if (err == nil) != test.ok || vault != test.vault || item != test.item {
+			t.Errorf("%s: expecting %q and %q (ok %v), got %q and %q (%v)", test.ref, test.vault, test.item, test.ok, vault, item, err)
+		}
+	}
+}
+
+// useFakeOp puts an op on the PATH that keeps its arguments and stdin in
+// dir, and prints dir/item for item get, failing when there is none.
+func useFakeOp(t *testing.T, item *onePasswordItem) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake op is a shell script")
+	}
+	dir := t.TempDir()
+	if item != nil {
+		data, err := json.Marshal(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, "item"), data, 0600)
+	}
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\n" +
+		"if [ \"$2\" = get ]; then cat " + filepath.Join(dir, "item") + " || exit 1; exit 0; fi\n" +
+		"cat > " + filepath.Join(dir, "stdin") + "\n"
+	writeTestFile(t, filepath.Join(dir, "op"), []byte(script), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestOnePasswordRead(t *testing.T) {
+	useFakeOp(t, &onePasswordItem{ID: "abc", Title: "payments", Fields: []onePasswordField{
+		{ID: "notesPlain", Purpose: "NOTES", Label: "notesPlain"},
+		{ID: "password", Label: "stripe.key", Value: "sk_live"},
+		{ID: "token", Value: "t0k3n"},
+	}})
+	fields, err := (&onePasswordStore{}).read("Engineering/payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []secretField{{[]string{"stripe", "key"}, "sk_live"}, {[]string{"token"}, "t0k3n"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expecting %q, got %q", expected, fields)
+	}
+}
+
+func TestOnePasswordWrite(t *testing.T) {
+	fields := []secretField{{[]string{"stripe", "key"}, "sk_new"}, {[]string{"token"}, "t0k3n"}}
+	for _, test := range []struct {
+		name     string
+		existing *onePasswordItem
+		args     string
+		written  onePasswordItem
+	}{
+		{
+			"create", nil,
+			"item create --vault Engineering --template /dev/stdin\n",
+			onePasswordItem{Title: "payments", Category: "SECURE_NOTE", Fields: []onePasswordField{
+				{Type: "CONCEALED", Label: "stripe.key", Value: "sk_new"},
+				{Type: "CONCEALED", Label: "token", Value: "t0k3n"},
+			}},
+		},
+		{
+			"edit", &onePasswordItem{ID: "abc", Title: "payments", Category: "LOGIN", Fields: []onePasswordField{{ID: "k", Type: "CONCEALED", Label: "stripe.key", Value: "sk_old"}}},
+			"item edit abc --vault Engineering --template /dev/stdin\n",
+			onePasswordItem{ID: "abc", Title: "payments", Category: "LOGIN", Fields: []onePasswordField{
+				{ID: "k", Type: "CONCEALED", Label: "stripe.key", Value: "sk_new"},
+				{Type: "CONCEALED", Label: "token", Value: "t0k3n"},
+			}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := useFakeOp(t, test.existing)
+			if err := (&onePasswordStore{}).write("Engineering/payments", fields); err != nil {
+				t.Fatal(err)
+			}
+			if args, _ := os.ReadFile(filepath.Join(dir, "args")); string(args) != test.args {
+				t.Errorf("expecting op %q, got %q", test.args, args)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "stdin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), "sk_old") {
+				t.Error("expecting the old value replaced")
+			}
+			written := onePasswordItem{}
+			if err := json.Unmarshal(data, &written); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(written, test.written) {
+				t.Errorf("expecting %+v written, got %+v", test.written, written)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --out and --out-dir write what open produces outside the working tree,
+// e.g. to a tmpfs mount or a container volume, so plaintext never exists in
+// the repository. With seal, they write the .enc elsewhere or under another
+// name, and the file is sealed as if it were there: with the key, second key
+// and signing key that path gets from .secrets.yaml.
+
+var outPath string
+var outDir string
+
+func checkOutputFlags(files []string) error {
+	if outPath != "" && outDir != "" {
+		return errors.New("--out and --out-dir can't be used together")
+	}
+	if outPath != "" && len(files) != 1 {
+		return fmt.Errorf("--out takes a single file, got %d, use --out-dir for several", len(files))
+	}
+	return nil
+}
+
+// outputPath is where a file ends up under --out or --out-dir, given the
+// path it would have in the working tree. --out-dir keeps the path of files
+// relative to the project root, so files with the same name don't collide.
+func outputPath(file string) string {
+	switch {
+	case outPath != "":
+		return outPath
+	case outDir != "":
+		name := projectPath(file)
+		if name == "" || strings.HasPrefix(name, "../") {
+			name = filepath.Base(file)
+		}
+		return filepath.Join(outDir, filepath.FromSlash(name))
+	}
+	return file
+}
+
+// openedPath is where open writes the plaintext of ciphertextFile.
+func openedPath(ciphertextFile string) (string, error) {
+	if !strings.HasSuffix(ciphertextFile, ".enc") {
+		return "", fmt.Errorf("%w: %s", ErrNotEncFile, ciphertextFile)
+	}
+	return outputPath(strings.TrimSuffix(ciphertextFile, ".enc")), nil
+}
+
+// sealedPath is where seal writes the .enc of plaintextFile.
+func sealedPath(plaintextFile string) string {
+	if outPath != "" {
+		return outPath
+	}
+	return outputPath(plaintextFile) + ".enc"
+}
+
+// sealedAs is the plaintext path that the .enc of plaintextFile stands for,
+// which .secrets.yaml rules are matched against.
+func sealedAs(plaintextFile string) string {
+	return strings.TrimSuffix(sealedPath(plaintextFile), ".enc")
+}
+
+func makeOutputDir(file string) error {
+	if outPath == "" && outDir == "" {
+		return nil
+	}
+	return os.MkdirAll(filepath.Dir(file), 0700)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckOutputFlags(t *testing.T) {
+	defer func() { outPath, outDir = "", "" }()
+	for _, test := range []struct {
+		outPath string
+		outDir  string
+		files   int
+		ok      bool
+	}{
+		{"", "", 3, true},
+		{"/tmp/secret.yaml", "", 1, true},
+		{"/tmp/secret.yaml", "", 2, false},
+		{"", "/tmp/secrets", 2, true},
+		{"/tmp/secret.yaml", "/tmp/secrets", 1, false},
+	} {
+		outPath, outDir = test.outPath, test.outDir
+		if err := checkOutputFlags(make([]string, test.files)); (err == nil) != test.ok {
+			t.Errorf("--out %q --out-dir %q with %d files: expecting ok %v, got %v", test.outPath, test.outDir, test.files, test.ok, err)
+		}
+	}
+}
+
+func TestOpenedPath(t *testing.T) {
+	root := useFakeBackend(t)
+	defer func() { outPath, outDir = "", "" }()
+	for _, test := range []struct {
+		outPath string
+		outDir  string
+		file    string
+		opened  string
+	}{
+		{"", "", filepath.Join(root, "app/secret.yaml.enc"), filepath.Join(root, "app/secret.yaml")},
+		{"/run/secret.yaml", "", filepath.Join(root, "app/secret.yaml.enc"), "/run/secret.yaml"},
+		{"", "/run/secrets", filepath.Join(root, "app/secret.yaml.enc"), "/run/secrets/app/secret.yaml"},
+		{"", "/run/secrets", "/elsewhere/secret.yaml.enc", "/run/secrets/secret.yaml"},
+	} {
+		outPath, outDir = test.outPath, test.outDir
+		if opened, err := openedPath(test.file); err != nil || opened != test.opened {
+			t.Errorf("%s with --out %q --out-dir %q: expecting %s, got %s (%v)", test.file, test.outPath, test.outDir, test.opened, opened, err)
+		}
+	}
+	if _, err := openedPath(filepath.Join(root, "secret.yaml")); !errors.Is(err, ErrNotEncFile) {
+		t.Errorf("expecting ErrNotEncFile, got %v", err)
+	}
+}
+
+func TestOpenToOutDir(t *testing.T) {
+	root := useFakeBackend(t)
+	if err := os.MkdirAll(filepath.Join(root, "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	plaintextFile := filepath.Join(root, "app", "secret.yaml")
+	content := []byte("password: hunter2\n")
+	writeTestFile(t, plaintextFile, content, 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	outDir = filepath.Join(t.TempDir(), "volume")
+	defer func() { outDir = "" }()
+	if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := os.ReadFile(filepath.Join(outDir, "app", "secret.yaml")); err != nil || !bytes.Equal(opened, content) {
+		t.Errorf("expecting the plaintext under --out-dir, got %q (%v)", opened, err)
+	}
+	if fileExists(plaintextFile) {
+		t.Error("expecting no plaintext in the working tree")
+	}
+}
+
+func TestSealedPath(t *testing.T) {
+	root := useFakeBackend(t)
+	defer func() { outPath, outDir = "", "" }()
+	for _, test := range []struct {
+		outPath string
+		outDir  string
+		sealed  string
+	}{
+		{"", "", filepath.Join(root, "app/secret.yaml.enc")},
+		{"/run/other.yaml.enc", "", "/run/other.yaml.enc"},
+		{"", "/run/secrets", "/run/secrets/app/secret.yaml.enc"},
+	} {
+		outPath, outDir = test.outPath, test.outDir
+		file := filepath.Join(root, "app/secret.yaml")
+		if sealed := sealedPath(file); sealed != test.sealed {
+			t.Errorf("--out %q --out-dir %q: expecting %s, got %s", test.outPath, test.outDir, test.sealed, sealed)
+		}
+		if as := sealedAs(file); as+".enc" != test.sealed {
+			t.Errorf("--out %q --out-dir %q: expecting it sealed as %s, got %s", test.outPath, test.outDir, test.sealed, as)
+		}
+	}
+}
+
+func TestSealToOutWithItsKey(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{".": "key: root-key\nrules:\n  - path: prod/**\n    key: prod-key\n"})
+	plaintextFile := filepath.Join(root, "draft.yaml")
+	writeTestFile(t, plaintextFile, []byte("password: hunter2\n"), 0600)
+	outPath = filepath.Join(root, "prod", "secret.yaml.enc")
+	defer func() { outPath = "" }()
+	if err := sealFile(plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(plaintextFile + ".enc") {
+		t.Error("expecting no .enc next to the plaintext")
+	}
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := parseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prodKey, _ := kmsSelected.resourceName("prod-key"); e.Path != "prod/secret.yaml" || e.Key != prodKey {
+		t.Errorf("expecting it sealed as prod/secret.yaml with %s, got %s with %s", prodKey, e.Path, e.Key)
+	}
+}
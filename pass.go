@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// passStore keeps values in a pass (password-store) store, through the pass
+// CLI and GPG. pass://team/app names either one entry, whose first line is
+// imported as password and whose "name: value" lines as the other values,
+// or a folder of entries, imported as one value each, named after their path
+// in the folder. Exports write one entry per value under the folder.
+type passStore struct{}
+
+func passStoreDir() string {
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir
+	}
+	return expandHome("~/.password-store")
+}
+
+func runPass(input []byte, args ...string) (string, error) {
+	_, stdOut, stdErr, err := runCommandWithInput(input, "pass", args...)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.New("pass is not installed")
+		}
+		return "", fmt.Errorf("pass %s failed: %s", args[0], strings.TrimSpace(stdErr))
+	}
+	return stdOut, nil
+}
+
+// parsePassEntry splits an entry into its password and "name: value" lines.
+func parsePassEntry(content string) []secretField {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	fields := []secretField{{[]string{"password"}, lines[0]}}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && name != "" && !strings.ContainsAny(name, " \t") {
+			fields = append(fields, secretField{[]string{name}, strings.TrimSpace(value)})
+		}
+	}
+	return fields
+}
+
+func (p *passStore) read(ref string) ([]secretField, error) {
+	ref = strings.Trim(ref, "/")
+	dir := filepath.Join(passStoreDir(), filepath.FromSlash(ref))
+	if fileExists(dir + ".gpg") {
+		content, err := runPass(nil, "show", ref)
+		if err != nil {
+			return nil, err
+		}
+		return parsePassEntry(content), nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("no entry or folder %s in %s", ref, passStoreDir())
+	}
+	entries := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".gpg") {
+			entry, err := filepath.Rel(dir, strings.TrimSuffix(path, ".gpg"))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, filepath.ToSlash(entry))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(entries)
+	fields := []secretField{}
+	for _, entry := range entries {
+		content, err := runPass(nil, "show", ref+"/"+entry)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, secretField{strings.Split(entry, "/"), strings.TrimSuffix(content, "\n")})
+	}
+	return fields, nil
+}
+
+func (p *passStore) write(ref string, fields []secretField) error {
+	ref = strings.Trim(ref, "/")
+	for _, field := range fields {
+		if _, err := runPass([]byte(field.Value+"\n"), "insert", "--multiline", "--force", ref+"/"+field.name("/")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestParsePassEntry(t *testing.T) {
+	for _, test := range []struct {
+		content string
+		fields  []secretField
+	}{
+		{"hunter2\n", []secretField{{[]string{"password"}, "hunter2"}}},
+		{"hunter2\nuser: app\nurl:https://example.com\nsome notes: not a value\n", []secretField{
+			{[]string{"password"}, "hunter2"},
+			{[]string{"user"}, "app"},
+			{[]string{"url"}, "https://example.com"},
+		}},
+	} {
+		if fields := parsePassEntry(test.content); !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%q: expecting %q, got %q", test.content, test.fields, fields)
+		}
+	}
+}
+
+// useFakePass puts a pass on the PATH that keeps entries unencrypted in
+// PASSWORD_STORE_DIR, which it returns.
+func useFakePass(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake pass is a shell script")
+	}
+	bin, store := t.TempDir(), t.TempDir()
+	script := "#!/bin/sh\ncase $1 in\n" +
+		"show) cat \"$PASSWORD_STORE_DIR/$2.gpg\" ;;\n" +
+		"insert) mkdir -p \"$(dirname \"$PASSWORD_STORE_DIR/$4\")\" && cat > \"$PASSWORD_STORE_DIR/$4.gpg\" ;;\n" +
+		"esac\n"
+	writeTestFile(t, filepath.Join(bin, "pass"), []byte(script), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("PASSWORD_STORE_DIR", store)
+	return store
+}
+
+func TestPassStore(t *testing.T) {
+	store := useFakePass(t)
+	p := &passStore{}
+	fields := []secretField{
+		{[]string{"database", "password"}, "hunter2"},
+		{[]string{"api-key"}, "abc"},
+	}
+	if err := p.write("team/app/", fields); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(store, "team", "app", "database", "password.gpg")); err != nil || string(data) != "hunter2\n" {
+		t.Errorf("expecting an entry per value, got %q (%v)", data, err)
+	}
+	read, err := p.read("team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []secretField{fields[1], fields[0]}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("expecting the folder read as %q, got %q", expected, read)
+	}
+	writeTestFile(t, filepath.Join(store, "team", "db.gpg"), []byte("s3cret\nuser: app\n"), 0600)
+	read, err = p.read("team/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []secretField{{[]string{"password"}, "s3cret"}, {[]string{"user"}, "app"}}
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("expecting the entry read as %q, got %q", expected, read)
+	}
+	if _, err := p.read("team/missing"); err == nil {
+		t.Error("expecting an error reading a missing entry")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandHome expands a leading ~ to the home folder, for paths given to
+// flags such as --root=~/src that the shell doesn't expand.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// absolutePaths resolves file arguments, which may have spaces, start with a
+// dash after `--`, or start with ~.
+func absolutePaths(paths []string) ([]string, error) {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		absolutePath, err := filepath.Abs(expandHome(path))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, absolutePath)
+	}
+	return result, nil
+}
+
+// escapeGitIgnore makes a path match only itself in .gitignore, where *, ?,
+// [ and \ are special.
+func escapeGitIgnore(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if strings.ContainsRune(`\*?[`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unescapeGitIgnore is the path a .gitignore entry matches, or false when
+// the entry is a pattern.
+func unescapeGitIgnore(entry string) (string, bool) {
+	var b strings.Builder
+	escaped := false
+	for _, r := range entry {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+			continue
+		case strings.ContainsRune("*?[", r):
+			return "", false
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), true
+}
+
+var filesFrom string
+
+// readFileList reads the paths listed in source, or on stdin for -, one per
+// line or separated by NUL characters as from `find -print0`.
+func readFileList(source string) ([]string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if source == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(expandHome(source))
+	}
+	if err != nil {
+		return nil, err
+	}
+	separator := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		separator = "\x00"
+	}
+	paths := []string{}
+	for _, path := range strings.Split(string(data), separator) {
+		if separator == "\n" {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+var pathsOnly bool
+var print0 bool
+
+// printPaths lists files relative to the current folder for scripts, one
+// per line or NUL-terminated with --print0 so that any path survives xargs -0.
+// Paths starting with a dash get ./ so commands don't take them for options.
+func printPaths(files []string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	terminator := "\n"
+	if print0 {
+		terminator = "\x00"
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for _, file := range files {
+		if relativePath, err := filepath.Rel(cwd, file); err == nil {
+			file = relativePath
+		}
+		if strings.HasPrefix(file, "-") {
+			file = "." + string(filepath.Separator) + file
+		}
+		if _, err := w.WriteString(file + terminator); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
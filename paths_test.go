@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandHome(t *testing.T) {
+	t.Setenv("HOME", "/home/dev")
+	for path, expected := range map[string]string{
+		"~":          "/home/dev",
+		"~/src":      "/home/dev/src",
+		"~dev/src":   "~dev/src",
+		"src/~":      "src/~",
+		"/tmp/a b c": "/tmp/a b c",
+	} {
+		if expanded := expandHome(path); expanded != expected {
+			t.Errorf("%q: expecting %q, got %q", path, expected, expanded)
+		}
+	}
+}
+
+func TestGitIgnoreEscaping(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		entry string
+	}{
+		{"config/secret.yaml", "config/secret.yaml"},
+		{"with space.json", "with space.json"},
+		{"[prod]*.json", `\[prod]\*.json`},
+		{`back\slash?.json`, `back\\slash\?.json`},
+	} {
+		entry := escapeGitIgnore(test.path)
+		if entry != test.entry {
+			t.Errorf("%q: expecting entry %q, got %q", test.path, test.entry, entry)
+		}
+		if path, literal := unescapeGitIgnore(entry); !literal || path != test.path {
+			t.Errorf("%q: expecting %q back, got %q (literal %v)", entry, test.path, path, literal)
+		}
+	}
+	if _, literal := unescapeGitIgnore("*.json"); literal {
+		t.Error("expecting *.json to be a pattern")
+	}
+}
+
+func TestUnusualPathsAreGitIgnored(t *testing.T) {
+	root := t.TempDir()
+	initGitRepo(t, root)
+	gitIgnore := filepath.Join(root, ".gitignore")
+	for _, name := range []string{"-dash.json", "with space.json", "[prod]*.json", "star*.json"} {
+		file := filepath.Join(root, name)
+		writeTestFile(t, file, []byte("{}"), 0600)
+		entry, err := gitIgnoreEntry(gitIgnore, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, gitIgnore, []byte(entry+"\n"), 0644)
+		if ignored, err := isGitIgnored(root, file); err != nil || !ignored {
+			t.Errorf("%q with entry %q: expecting it ignored, got %v (%v)", name, entry, ignored, err)
+		}
+		if name == "star*.json" {
+			other := filepath.Join(root, "starry.json")
+			writeTestFile(t, other, []byte("{}"), 0600)
+			if ignored, _ := isGitIgnored(root, other); ignored {
+				t.Errorf("entry %q: expecting starry.json not ignored", entry)
+			}
+		}
+	}
+}
+
+func TestReadFileList(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		content string
+		paths   []string
+	}{
+		{"a.json\nb c.json\n\n", []string{"a.json", "b c.json"}},
+		{"a.json\r\nb.json\r\n", []string{"a.json", "b.json"}},
+		{"line\nbreak.json\x00-dash.json\x00", []string{"line\nbreak.json", "-dash.json"}},
+		{"", []string{}},
+	} {
+		list := filepath.Join(dir, "files")
+		writeTestFile(t, list, []byte(test.content), 0644)
+		paths, err := readFileList(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(paths, test.paths) {
+			t.Errorf("%q: expecting %q, got %q", test.content, test.paths, paths)
+		}
+	}
+}
+
+func TestPrintPaths(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	files := []string{filepath.Join(dir, "-dash.json"), filepath.Join(dir, "config", "a b.json")}
+	for _, test := range []struct {
+		print0 bool
+		output string
+	}{
+		{false, "./-dash.json\nconfig/a b.json\n"},
+		{true, "./-dash.json\x00config/a b.json\x00"},
+	} {
+		print0 = test.print0
+		output := captureStdout(t, func() {
+			if err := printPaths(files); err != nil {
+				t.Error(err)
+			}
+		})
+		if output != test.output {
+			t.Errorf("print0 %v: expecting %q, got %q", test.print0, test.output, output)
+		}
+	}
+	print0 = false
+}
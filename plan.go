@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type plannedFile struct {
+	Action    string `json:"action"`
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Overwrite bool   `json:"overwrite"`
+	Key       string `json:"key"`
+}
+
+type plannedKey struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+type plannedGitIgnore struct {
+	File  string `json:"file"`
+	Entry string `json:"entry"`
+}
+
+// operationPlan is everything a seal, open or reseal-all would change.
+type operationPlan struct {
+	Command   string             `json:"command"`
+	Files     []plannedFile      `json:"files"`
+	Keys      []plannedKey       `json:"keys"`
+	GitIgnore []plannedGitIgnore `json:"gitignore"`
+	Warnings  []string           `json:"warnings"`
+}
+
+// planRotate is how `plan` also calls reseal-all, which rotates files to
+// the current version of their key.
+const planRotate string = "rotate"
+
+const (
+	keyExists  string = "exists"
+	keyCreate  string = "create"
+	keyMissing string = "missing"
+	keyUnknown string = "unknown"
+)
+
+func displayPath(filePath string) string {
+	if relativePath := projectPath(filePath); relativePath != "" && !strings.HasPrefix(relativePath, "..") {
+		return relativePath
+	}
+	return filePath
+}
+
+func newOperationPlan(command string) *operationPlan {
+	return &operationPlan{
+		Command:   command,
+		Files:     []plannedFile{},
+		Keys:      []plannedKey{},
+		GitIgnore: []plannedGitIgnore{},
+		Warnings:  []string{},
+	}
+}
+
+func (p *operationPlan) addKey(keyName string, canCreate bool) string {
+	k, err := describeKey(keyName)
+	planned := plannedKey{Name: keyName, State: keyExists}
+	switch {
+	case err == nil:
+		planned.Name = k.Name
+	case isNotFoundError(err) && canCreate && !ciMode:
+		planned.State = keyCreate
+	case isNotFoundError(err):
+		planned.State = keyMissing
+	default:
+		planned.State = keyUnknown
+		planned.Error = err.Error()
+	}
+	for _, existing := range p.Keys {
+		if existing.Name == planned.Name {
+			return planned.Name
+		}
+	}
+	p.Keys = append(p.Keys, planned)
+	return planned.Name
+}
+
+func (p *operationPlan) addGitIgnore(gitIgnorePath string, entry string) {
+	for _, existing := range p.GitIgnore {
+		if existing.File == gitIgnorePath && existing.Entry == entry {
+			return
+		}
+	}
+	p.GitIgnore = append(p.GitIgnore, plannedGitIgnore{gitIgnorePath, entry})
+}
+
+// envelopeKey is the key a .enc was sealed with, or the project key for
+// files that predate envelopes.
+func (p *operationPlan) envelopeKey(ciphertextFile string) string {
+	data, err := os.ReadFile(ciphertextFile)
+	if err == nil {
+		err = checkCiphertext(data)
+	}
+	if err != nil {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("%s: %s", displayPath(ciphertextFile), err))
+		return fileKey(ciphertextFile)
+	}
+	e, err := parseEnvelope(data)
+	if err != nil && !errors.Is(err, errNotEnvelope) {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("%s: %s", displayPath(ciphertextFile), err))
+	}
+	if e != nil && e.Key != "" {
+		return e.Key
+	}
+	return fileKey(ciphertextFile)
+}
+
+func planSeal(files []string) (*operationPlan, error) {
+	p := newOperationPlan(encryptCmd)
+	for _, file := range files {
+		target := sealedPath(file)
+		keyName := p.addKey(fileKey(sealedAs(file)), true)
+		secondKey, err := fileSecondKey(sealedAs(file))
+		if err != nil {
+			return nil, err
+		}
+		if secondKey != "" {
+			p.addKey(secondKey, false)
+		}
+		if !force && isFileUpToDate(fileKey(sealedAs(file)), file) {
+			printDebugln("%s is already up to date", file)
+		} else {
+			p.Files = append(p.Files, plannedFile{"encrypt", file, target, fileExists(target), keyName})
+		}
+		gitIgnorePath, entry, err := plannedGitIgnoreEntry(projectRoot, file)
+		if errors.Is(err, ErrPlaintextTracked) {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("plain-text file already checked in: %s", displayPath(file)))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry != "" {
+			p.addGitIgnore(gitIgnorePath, entry)
+		}
+	}
+	return p, nil
+}
+
+func planOpen(files []string) (*operationPlan, error) {
+	p := newOperationPlan(decryptCmd)
+	for _, file := range files {
+		target, err := openedPath(file)
+		if err != nil {
+			return nil, err
+		}
+		keyName := p.addKey(p.envelopeKey(file), false)
+		if !force && isOpenedUpToDate(file, target) {
+			printDebugln("%s is already up to date", target)
+			continue
+		}
+		p.Files = append(p.Files, plannedFile{"decrypt", file, target, fileExists(target), keyName})
+	}
+	return p, nil
+}
+
+func planReseal(files []string) (*operationPlan, error) {
+	p := newOperationPlan(resealAllCmd)
+	for _, file := range files {
+		keyName := p.addKey(p.envelopeKey(file), false)
+		if !force && isResealed(file) {
+			printDebugln("%s is already up to date", file)
+			continue
+		}
+		p.Files = append(p.Files, plannedFile{"reseal", file, file, true, keyName})
+	}
+	return p, nil
+}
+
+func (p *operationPlan) keysToCreate() int {
+	count := 0
+	for _, k := range p.Keys {
+		if k.State == keyCreate {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *operationPlan) overwrites() int {
+	count := 0
+	for _, f := range p.Files {
+		if f.Overwrite {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *operationPlan) hasChanges() bool {
+	return len(p.Files) > 0 || p.keysToCreate() > 0 || len(p.GitIgnore) > 0
+}
+
+func buildPlan(command string, files []string) (*operationPlan, error) {
+	switch command {
+	case encryptCmd:
+		return planSeal(files)
+	case decryptCmd:
+		return planOpen(files)
+	case resealAllCmd, planRotate:
+		return planReseal(files)
+	}
+	return nil, fmt.Errorf("cannot plan %q, expecting %s, %s, %s or %s", command, encryptCmd, decryptCmd, resealAllCmd, planRotate)
+}
+
+func printPlan(p *operationPlan, err error) error {
+	if err != nil {
+		return err
+	}
+	return p.print()
+}
+
+func (p *operationPlan) print() error {
+	if ciMode {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	for _, k := range p.Keys {
+		description := map[string]string{
+			keyExists:  "exists",
+			keyCreate:  "will be created",
+			keyMissing: "does not exist",
+			keyUnknown: "could not be checked: " + k.Error,
+		}[k.State]
+		marker := " "
+		if k.State == keyCreate {
+			marker = "+"
+		}
+		fmt.Printf("%s key        %s (%s)\n", marker, k.Name, description)
+	}
+	for _, f := range p.Files {
+		marker := "+"
+		if f.Overwrite {
+			marker = "~"
+		}
+		fmt.Printf("%s %-10s %s -> %s\n", marker, f.Action, displayPath(f.Source), displayPath(f.Target))
+	}
+	for _, g := range p.GitIgnore {
+		fmt.Printf("+ gitignore  %s: %s\n", displayPath(g.File), g.Entry)
+	}
+	for _, warning := range p.Warnings {
+		errPrintln("Warning: %s", warning)
+	}
+	fmt.Printf(
+		"Plan: %d file(s) to create, %d to overwrite, %d key(s) to create, %d .gitignore entries to add\n",
+		len(p.Files)-p.overwrites(), p.overwrites(), p.keysToCreate(), len(p.GitIgnore),
+	)
+	return nil
+}
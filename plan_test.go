@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildPlan(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	force = true
+	defer func() { force = false }()
+	for _, test := range []struct {
+		command string
+		file    string
+		action  string
+		err     string
+	}{
+		{encryptCmd, plaintextFile, "encrypt", ""},
+		{decryptCmd, plaintextFile + ".enc", "decrypt", ""},
+		{resealAllCmd, plaintextFile + ".enc", "reseal", ""},
+		{planRotate, plaintextFile + ".enc", "reseal", ""},
+		{"prune", plaintextFile + ".enc", "", "cannot plan \"prune\""},
+	} {
+		t.Run(test.command, func(t *testing.T) {
+			p, err := buildPlan(test.command, []string{test.file})
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expecting an error with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Files) != 1 || p.Files[0].Action != test.action {
+				t.Errorf("expecting to %s %s, got %+v", test.action, test.file, p.Files)
+			}
+		})
+	}
+}
+
+func TestPlanKeyStates(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		missing   bool
+		canCreate bool
+		ci        bool
+		state     string
+	}{
+		{"exists", false, true, false, keyExists},
+		{"create", true, true, false, keyCreate},
+		{"missing", true, false, false, keyMissing},
+		{"never created in CI", true, true, true, keyMissing},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeBackend(t)
+			if test.missing {
+				kmsSelected = &missingKeyBackend{}
+			}
+			ciMode = test.ci
+			defer func() { ciMode = false }()
+			p := newOperationPlan(encryptCmd)
+			p.addKey(testKey, test.canCreate)
+			p.addKey(testKey, test.canCreate)
+			if len(p.Keys) != 1 || p.Keys[0].State != test.state {
+				t.Errorf("expecting the key planned once as %s, got %+v", test.state, p.Keys)
+			}
+		})
+	}
+}
+
+func TestPlanSealChangesNothing(t *testing.T) {
+	root := useFakeBackend(t)
+	initGitRepo(t, root)
+	kmsSelected = &missingKeyBackend{}
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	p, err := planSeal([]string{plaintextFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Files) != 1 || p.Files[0].Target != plaintextFile+".enc" || p.Files[0].Overwrite {
+		t.Errorf("expecting secret.yaml.enc planned, got %+v", p.Files)
+	}
+	if len(p.GitIgnore) != 1 || p.GitIgnore[0].Entry != "*secret.yaml" {
+		t.Errorf("expecting *secret.yaml planned in .gitignore, got %+v", p.GitIgnore)
+	}
+	if !p.hasChanges() || p.keysToCreate() != 1 || p.overwrites() != 0 {
+		t.Errorf("expecting a plan creating one key and overwriting nothing, got %+v", p)
+	}
+	for _, file := range []string{plaintextFile + ".enc", filepath.Join(root, ".gitignore")} {
+		if _, err := os.Stat(file); err == nil {
+			t.Errorf("planning wrote %s", file)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The policy section of the root .secrets.yaml has every file sealed, opened
+// or rotated checked against a Rego policy first, evaluated with the opa
+// command or by an OPA server, e.g.
+//
+//	policy:
+//	  rego: policy/secrets.rego
+//	  query: data.secrets.decision
+//	  env: [CI, GITHUB_REF]
+//
+// The input is the operation, the caller, the file relative to the project
+// root, its key, the repository, the command, the host and the environment
+// variables listed in env. The query gives either a boolean or an object
+// with allow and a reason, or deny as a list of reasons.
+
+type policySettings struct {
+	Rego  string
+	URL   string
+	Query string
+	Env   []string
+}
+
+type policyInput struct {
+	Operation string            `json:"operation"`
+	User      string            `json:"user"`
+	File      string            `json:"file"`
+	Key       string            `json:"key"`
+	Repo      string            `json:"repo"`
+	Command   string            `json:"command"`
+	Host      string            `json:"host"`
+	CI        bool              `json:"ci"`
+	Env       map[string]string `json:"env"`
+}
+
+const defaultPolicyQuery string = "data.secrets"
+
+var policyOnce sync.Once
+var policyConfig *policySettings
+var policyErr error
+
+func parsePolicySettings(file string, node *yamlNode) (*policySettings, error) {
+	if node.kind != yamlMapping {
+		return nil, fmt.Errorf("%s: policy must be a mapping", file)
+	}
+	settings := &policySettings{
+		Rego:  strings.TrimSpace(node.get("rego").value()),
+		URL:   strings.TrimSpace(node.get("url").value()),
+		Query: strings.TrimSpace(node.get("query").value()),
+		Env:   node.get("env").strings(),
+	}
+	if (settings.Rego == "") == (settings.URL == "") {
+		return nil, fmt.Errorf("%s: policy needs either a rego file or the url of an OPA server", file)
+	}
+	if settings.Query == "" {
+		settings.Query = defaultPolicyQuery
+	}
+	if !strings.HasPrefix(settings.Query, "data.") {
+		return nil, fmt.Errorf("%s: policy query %q should start with data.", file, settings.Query)
+	}
+	return settings, nil
+}
+
+// loadPolicySettings is the policy section of the root config, or nil.
+// Unlike audit settings, a broken root config is an error, so it can't
+// silently turn the policy off.
+func loadPolicySettings() (*policySettings, error) {
+	policyOnce.Do(func() {
+		if projectRoot == "" {
+			return
+		}
+		config, err := configFor(projectRoot)
+		if err != nil {
+			policyErr = err
+			return
+		}
+		policyConfig = config.Policy
+	})
+	return policyConfig, policyErr
+}
+
+// checkPolicy refuses operation on file when the policy denies it, with the
+// reason it gives.
+func checkPolicy(operation string, file string) error {
+	settings, err := loadPolicySettings()
+	if err != nil || settings == nil {
+		return err
+	}
+	absolutePath, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	p := callerProvenance()
+	input := policyInput{
+		Operation: operation,
+		User:      p.By,
+		File:      projectPath(strings.TrimSuffix(absolutePath, ".enc")),
+		Key:       fileKey(file),
+		Repo:      getKeyName(projectRoot),
+		Command:   commandName,
+		Host:      p.Host,
+		CI:        ciMode,
+		Env:       map[string]string{},
+	}
+	for _, name := range settings.Env {
+		if value, ok := os.LookupEnv(name); ok {
+			input.Env[name] = value
+		}
+	}
+	result, err := evaluatePolicy(settings, input)
+	if err != nil {
+		return fmt.Errorf("could not evaluate policy %s: %w", settings.Query, err)
+	}
+	allowed, reasons, err := policyDecision(result)
+	if err != nil {
+		return fmt.Errorf("policy %s: %w", settings.Query, err)
+	}
+	printDebugln("policy %s for %s %s: allowed %t %v", settings.Query, operation, input.File, allowed, reasons)
+	if allowed {
+		return nil
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("policy denies %s %s", operation, input.File)
+	}
+	return fmt.Errorf("policy denies %s %s: %s", operation, input.File, strings.Join(reasons, "; "))
+}
+
+// evaluatePolicy evaluates the query with input, returning nil when the
+// policy leaves it undefined.
+func evaluatePolicy(settings *policySettings, input policyInput) (interface{}, error) {
+	if settings.URL != "" {
+		var response struct {
+			Result interface{} `json:"result"`
+		}
+		if err := jsonRequest("POST", settings.URL, nil, map[string]interface{}{"input": input}, &response); err != nil {
+			return nil, err
+		}
+		return response.Result, nil
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	rego := expandHome(settings.Rego)
+	if !filepath.IsAbs(rego) {
+		rego = filepath.Join(projectRoot, rego)
+	}
+	_, stdOut, stdErr, err := runCommandWithInput(data, "opa", "eval", "--format", "json", "--stdin-input", "--data", rego, settings.Query)
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errors.New("opa is not installed, see https://www.openpolicyagent.org/docs/latest/#running-opa")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opa eval failed: %s", strings.TrimSpace(stdErr))
+	}
+	var output struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(stdOut), &output); err != nil {
+		return nil, fmt.Errorf("unexpected opa output: %w", err)
+	}
+	if len(output.Result) == 0 || len(output.Result[0].Expressions) == 0 {
+		return nil, nil
+	}
+	return output.Result[0].Expressions[0].Value, nil
+}
+
+// policyDecision reads a decision: true or false, or an object with allow
+// and reason or reasons, or with deny as a list of reasons. It's a denial
+// unless allow is true, or absent with an empty deny.
+func policyDecision(result interface{}) (bool, []string, error) {
+	switch decision := result.(type) {
+	case nil:
+		return false, []string{"the policy is undefined for this input"}, nil
+	case bool:
+		return decision, nil, nil
+	case map[string]interface{}:
+		reasons := []string{}
+		for _, name := range []string{"reason", "reasons", "deny"} {
+			switch value := decision[name].(type) {
+			case string:
+				if value != "" {
+					reasons = append(reasons, value)
+				}
+			case []interface{}:
+				for _, item := range value {
+					reasons = append(reasons, fmt.Sprint(item))
+				}
+			}
+		}
+		deny, hasDeny := decision["deny"].([]interface{})
+		allow, hasAllow := decision["allow"].(bool)
+		if !hasAllow && !hasDeny {
+			return false, nil, errors.New("the decision has neither allow nor deny")
+		}
+		return (allow || !hasAllow) && len(deny) == 0, reasons, nil
+	}
+	return false, nil, fmt.Errorf("unexpected decision %v, expecting a boolean or an object", result)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// usePolicyServer has every operation checked against an OPA server that
+// denies it, and returns the files it was asked about.
+func usePolicyServer(t *testing.T) *[]string {
+	t.Helper()
+	var mutex sync.Mutex
+	files := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input policyInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		mutex.Lock()
+		files = append(files, request.Input.File)
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": {"allow": false, "reason": "denied in tests"}}`))
+	}))
+	t.Cleanup(server.Close)
+	policyOnce.Do(func() {})
+	previous := policyConfig
+	policyConfig, policyErr = &policySettings{URL: server.URL, Query: defaultPolicyQuery}, nil
+	t.Cleanup(func() { policyConfig = previous })
+	return &files
+}
+
+func TestPolicyDecision(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		result  string
+		allowed bool
+		reasons int
+	}{
+		{"undefined", `null`, false, 1},
+		{"allowed", `true`, true, 0},
+		{"denied", `false`, false, 0},
+		{"allow with reason", `{"allow": false, "reason": "not on main"}`, false, 1},
+		{"deny list", `{"deny": ["one", "two"]}`, false, 2},
+		{"empty deny", `{"deny": []}`, true, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var result interface{}
+			if err := json.Unmarshal([]byte(test.result), &result); err != nil {
+				t.Fatal(err)
+			}
+			allowed, reasons, err := policyDecision(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != test.allowed || len(reasons) != test.reasons {
+				t.Errorf("expecting allowed %v with %d reasons, got %v with %v", test.allowed, test.reasons, allowed, reasons)
+			}
+		})
+	}
+}
+
+func TestPolicyDecisionErrors(t *testing.T) {
+	for _, result := range []string{`{"reason": "no verdict"}`, `"allow"`, `[true]`} {
+		var decision interface{}
+		if err := json.Unmarshal([]byte(result), &decision); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := policyDecision(decision); err == nil {
+			t.Errorf("%s: expecting an error", result)
+		}
+	}
+}
+
+func TestParsePolicySettings(t *testing.T) {
+	config := &secretsConfig{}
+	if err := parseConfig(configFileName, []byte("policy:\n  rego: policy/secrets.rego\n  env: [CI, GITHUB_REF]\n"), config); err != nil {
+		t.Fatal(err)
+	}
+	expected := &policySettings{Rego: "policy/secrets.rego", Query: defaultPolicyQuery, Env: []string{"CI", "GITHUB_REF"}}
+	if !reflect.DeepEqual(config.Policy, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, config.Policy)
+	}
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{"policy: strict\n", "policy must be a mapping"},
+		{"policy:\n  query: data.secrets\n", "needs either a rego file or the url"},
+		{"policy:\n  rego: secrets.rego\n  url: http://opa:8181/v1/data/secrets\n", "needs either a rego file or the url"},
+		{"policy:\n  rego: secrets.rego\n  query: secrets.allow\n", "should start with data."},
+	} {
+		if err := parseConfig(configFileName, []byte(test.config), &secretsConfig{}); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expecting an error with %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestCheckPolicy(t *testing.T) {
+	root := useFakeBackend(t)
+	t.Setenv("GITHUB_REF", "refs/heads/main")
+	var input policyInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input policyInput `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		input = request.Input
+		if request.Input.Operation == "open" {
+			w.Write([]byte(`{"result": {"deny": ["open in CI only", "ask security"]}}`))
+			return
+		}
+		w.Write([]byte(`{"result": true}`))
+	}))
+	defer server.Close()
+	policyOnce.Do(func() {})
+	provenanceOnce.Do(func() {})
+	previous := policyConfig
+	policyConfig, policyErr = &policySettings{URL: server.URL, Query: defaultPolicyQuery, Env: []string{"GITHUB_REF", "UNSET_VARIABLE"}}, nil
+	currentProvenance = provenance{"dev@example.com", "laptop", "def"}
+	defer func() { policyConfig, currentProvenance = previous, provenance{} }()
+	if err := checkPolicy("seal", filepath.Join(root, "app", "secret.yaml")); err != nil {
+		t.Errorf("expecting seal allowed, got %v", err)
+	}
+	if input.File != "app/secret.yaml" || input.User != "dev@example.com" || !reflect.DeepEqual(input.Env, map[string]string{"GITHUB_REF": "refs/heads/main"}) {
+		t.Errorf("unexpected input %+v", input)
+	}
+	err := checkPolicy("open", filepath.Join(root, "app", "secret.yaml.enc"))
+	if err == nil || err.Error() != "policy denies open app/secret.yaml: open in CI only; ask security" {
+		t.Errorf("expecting open denied with the reasons, got %v", err)
+	}
+}
+
+func TestEvaluatePolicyWithOpa(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake opa is a shell script")
+	}
+	root := useFakeBackend(t)
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(bin, "args") + "\ncat > /dev/null\n" +
+		"echo '{\"result\": [{\"expressions\": [{\"value\": {\"allow\": false, \"reason\": \"not on main\"}}]}]}'\n"
+	writeTestFile(t, filepath.Join(bin, "opa"), []byte(script), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	result, err := evaluatePolicy(&policySettings{Rego: "policy/secrets.rego", Query: "data.secrets.decision"}, policyInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, reasons, err := policyDecision(result); err != nil || allowed || !reflect.DeepEqual(reasons, []string{"not on main"}) {
+		t.Errorf("expecting a denial because not on main, got %v %q (%v)", allowed, reasons, err)
+	}
+	expected := "eval --format json --stdin-input --data " + filepath.Join(root, "policy", "secrets.rego") + " data.secrets.decision\n"
+	if args, _ := os.ReadFile(filepath.Join(bin, "args")); string(args) != expected {
+		t.Errorf("expecting opa %q, got %q", expected, args)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const googleTokenInfoURL string = "https://oauth2.googleapis.com/tokeninfo"
+
+// provenance is who sealed a file, from which host and at which commit, as
+// recorded in its envelope.
+type provenance struct {
+	By     string
+	Host   string
+	Commit string
+}
+
+var provenanceOnce sync.Once
+var currentProvenance provenance
+
+// callerProvenance is looked up once per run. Anything that can't be found
+// out is left empty rather than failing the seal.
+func callerProvenance() provenance {
+	provenanceOnce.Do(func() {
+		if client, err := kmsClient(); err == nil {
+			identity, err := client.identity()
+			if err != nil {
+				printDebugln("could not find out who is sealing: %s", err)
+			}
+			currentProvenance.By = identity
+		}
+		if host, err := os.Hostname(); err == nil {
+			currentProvenance.Host = host
+		}
+		if projectRoot != "" {
+			_, stdOut, _, err := runCommand("git", "-C", projectRoot, "rev-parse", "HEAD")
+			if err == nil {
+				currentProvenance.Commit = strings.TrimSpace(stdOut)
+			}
+		}
+	})
+	return currentProvenance
+}
+
+// recordProvenance stamps e with the caller, unless a deterministic seal
+// kept the previous envelope's timestamp, in which case its provenance is
+// kept too.
+func recordProvenance(e *envelope, previous *envelope) {
+	if previous != nil && !previous.SealedAt.IsZero() && e.SealedAt.Equal(previous.SealedAt) {
+		e.SealedBy, e.SealedHost, e.SealedCommit = previous.SealedBy, previous.SealedHost, previous.SealedCommit
+		return
+	}
+	p := callerProvenance()
+	e.SealedBy, e.SealedHost, e.SealedCommit = p.By, p.Host, p.Commit
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// describeProvenance is the provenance of e for status.
+func describeProvenance(e *envelope) string {
+	if e.SealedBy == "" && e.SealedHost == "" && e.SealedCommit == "" {
+		return "-"
+	}
+	by := e.SealedBy
+	if by == "" {
+		by = "unknown"
+	}
+	details := []string{}
+	if e.SealedHost != "" {
+		details = append(details, "on "+e.SealedHost)
+	}
+	if e.SealedCommit != "" {
+		details = append(details, "at "+shortCommit(e.SealedCommit))
+	}
+	if len(details) == 0 {
+		return by
+	}
+	return fmt.Sprintf("%s (%s)", by, strings.Join(details, " "))
+}
+
+// tokenEmail asks Google who an access token belongs to. Tokens of users
+// logged in without the email scope have no email. The token is posted
+// rather than put in the URL, which --verbose logs.
+func tokenEmail(token string) (string, error) {
+	response, err := httpClient.PostForm(googleTokenInfoURL, url.Values{"access_token": {token}})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var info struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.Email, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDescribeProvenance(t *testing.T) {
+	for _, test := range []struct {
+		e           *envelope
+		description string
+	}{
+		{&envelope{}, "-"},
+		{&envelope{SealedBy: "dev@example.com"}, "dev@example.com"},
+		{&envelope{SealedBy: "dev@example.com", SealedHost: "laptop", SealedCommit: "0123456789abcdef"}, "dev@example.com (on laptop at 0123456)"},
+		{&envelope{SealedCommit: "0123456"}, "unknown (at 0123456)"},
+	} {
+		if description := describeProvenance(test.e); description != test.description {
+			t.Errorf("%+v: expecting %q, got %q", test.e, test.description, description)
+		}
+	}
+}
+
+func TestRecordProvenance(t *testing.T) {
+	sealedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	previous := &envelope{SealedAt: sealedAt, SealedBy: "previous@example.com", SealedHost: "old-laptop", SealedCommit: "abc"}
+	provenanceOnce.Do(func() {})
+	currentProvenance = provenance{"dev@example.com", "laptop", "def"}
+	defer func() { currentProvenance = provenance{} }()
+	for _, test := range []struct {
+		name     string
+		sealedAt time.Time
+		previous *envelope
+		by       string
+	}{
+		{"first seal", sealedAt, nil, "dev@example.com"},
+		{"changed", sealedAt.Add(time.Hour), previous, "dev@example.com"},
+		{"kept by --deterministic", sealedAt, previous, "previous@example.com"},
+	} {
+		e := &envelope{SealedAt: test.sealedAt}
+		recordProvenance(e, test.previous)
+		if e.SealedBy != test.by {
+			t.Errorf("%s: expecting sealed by %s, got %+v", test.name, test.by, e)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// findOrphanedEncryptedFiles returns .enc files whose plaintext is neither
+// present nor ignored, which happens when a secret is renamed or its
+// .gitignore entry is removed by hand.
+func findOrphanedEncryptedFiles(projectRoot string) ([]string, error) {
+	files, err := findFiles(projectRoot, *regexp.MustCompile(`\.enc$`))
+	if err != nil {
+		return nil, err
+	}
+	orphans := make([]string, 0)
+	for _, file := range files {
+		plaintextFile := strings.TrimSuffix(file, ".enc")
+		if fileExists(plaintextFile) {
+			continue
+		}
+		if ignored, _ := isGitIgnored(projectRoot, plaintextFile); ignored {
+			continue
+		}
+		orphans = append(orphans, file)
+	}
+	return orphans, nil
+}
+
+func findUnsealedFiles(projectRoot string) ([]string, error) {
+	files, err := findUnencryptedFiles(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	unsealed := make([]string, 0)
+	for _, file := range files {
+		if !fileExists(file + ".enc") {
+			unsealed = append(unsealed, file)
+		}
+	}
+	return unsealed, nil
+}
+
+type gitIgnoreEntryRef struct {
+	gitIgnore string
+	entry     string
+}
+
+// findStaleGitIgnoreEntries returns per-file entries written by this tool
+// for which neither the plaintext nor the .enc exists any more. Outside the
+// managed block only entries that look like secret files are considered.
+func findStaleGitIgnoreEntries(projectRoot string) ([]gitIgnoreEntryRef, error) {
+	gitIgnores, err := findFiles(projectRoot, *regexp.MustCompile(`(^|/)\.gitignore$`))
+	if err != nil {
+		return nil, err
+	}
+	re := regexp.MustCompile(`secret\.(yaml|yml)$`)
+	stale := make([]gitIgnoreEntryRef, 0)
+	for _, gitIgnorePath := range gitIgnores {
+		g, err := readManagedFile(gitIgnorePath)
+		if err != nil {
+			return nil, err
+		}
+		candidates := append([]string{}, g.entries...)
+		for _, line := range append(g.before, g.after...) {
+			if re.MatchString(line) {
+				candidates = append(candidates, line)
+			}
+		}
+		for _, line := range candidates {
+			normalized := normalizeGitIgnoreEntry(line)
+			if strings.HasPrefix(normalized, "#") || strings.HasPrefix(normalized, "!") {
+				continue
+			}
+			entry, literal := unescapeGitIgnore(normalized)
+			if entry == "" || !literal {
+				continue
+			}
+			entryPath := filepath.Join(filepath.Dir(gitIgnorePath), filepath.FromSlash(entry))
+			if fileExists(entryPath) || fileExists(entryPath+".enc") {
+				continue
+			}
+			stale = append(stale, gitIgnoreEntryRef{gitIgnorePath, line})
+		}
+	}
+	return stale, nil
+}
+
+func removeGitIgnoreEntries(entries []gitIgnoreEntryRef) error {
+	byFile := map[string][]string{}
+	for _, ref := range entries {
+		byFile[ref.gitIgnore] = append(byFile[ref.gitIgnore], ref.entry)
+	}
+	for gitIgnorePath, fileEntries := range byFile {
+		err := updateManagedFile(gitIgnorePath, func(g *managedFile) bool {
+			removed := false
+			for _, entry := range fileEntries {
+				removed = g.remove(entry) || removed
+			}
+			return removed
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printPruneList(projectRoot string, title string, files []string) {
+	if len(files) == 0 {
+		return
+	}
+	fmt.Println(title)
+	for _, file := range files {
+		if relativePath, err := filepath.Rel(projectRoot, file); err == nil {
+			file = relativePath
+		}
+		fmt.Printf("  %s\n", file)
+	}
+}
+
+func prune(projectRoot string) error {
+	orphans, err := findOrphanedEncryptedFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+	unsealed, err := findUnsealedFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+	staleEntries, err := findStaleGitIgnoreEntries(projectRoot)
+	if err != nil {
+		return err
+	}
+
+	printPruneList(projectRoot, "Encrypted files with no plaintext counterpart:", orphans)
+	printPruneList(projectRoot, "Plaintext files that were never sealed (seal or delete them yourself):", unsealed)
+	if len(staleEntries) > 0 {
+		fmt.Println("Stale .gitignore entries:")
+		for _, ref := range staleEntries {
+			fmt.Printf("  %s: %s\n", projectPath(ref.gitIgnore), ref.entry)
+		}
+	}
+
+	if len(orphans) == 0 && len(staleEntries) == 0 {
+		fmt.Println("Nothing to prune")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+	question := fmt.Sprintf("Delete %d encrypted file(s) and %d .gitignore entries?", len(orphans), len(staleEntries))
+	if !confirm(question) {
+		return nil
+	}
+	for _, file := range orphans {
+		printDebugln("removing %s", file)
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		if err := relockFile(file); err != nil {
+			return err
+		}
+	}
+	if len(staleEntries) > 0 {
+		return removeGitIgnoreEntries(staleEntries)
+	}
+	return nil
+}
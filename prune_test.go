@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindOrphanedEncryptedFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	initGitRepo(t, root)
+	writeTestFile(t, filepath.Join(root, ".gitignore"), []byte(managedBlockStart+"\n/ignored-secret.yaml\n"+managedBlockEnd+"\n"), 0644)
+	for _, name := range []string{"orphan-secret.yaml.enc", "ignored-secret.yaml.enc", "open-secret.yaml.enc", "open-secret.yaml"} {
+		writeTestFile(t, filepath.Join(root, name), []byte("content\n"), 0644)
+	}
+	orphans, err := findOrphanedEncryptedFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join(root, "orphan-secret.yaml.enc")}; !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("expecting %v, got %v", expected, orphans)
+	}
+}
+
+func TestFindStaleGitIgnoreEntries(t *testing.T) {
+	root := useFakeBackend(t)
+	gitIgnore := "/hand-written-secret.yaml\n/build\n" + managedBlockStart + "\n/gone-secret.yaml\n/sealed-secret.yaml\n/open-secret.yaml\n" + managedBlockEnd + "\n"
+	writeTestFile(t, filepath.Join(root, ".gitignore"), []byte(gitIgnore), 0644)
+	writeTestFile(t, filepath.Join(root, "sealed-secret.yaml.enc"), []byte("content\n"), 0644)
+	writeTestFile(t, filepath.Join(root, "open-secret.yaml"), []byte("content\n"), 0600)
+	stale, err := findStaleGitIgnoreEntries(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitIgnorePath := filepath.Join(root, ".gitignore")
+	expected := []gitIgnoreEntryRef{{gitIgnorePath, "/gone-secret.yaml"}, {gitIgnorePath, "/hand-written-secret.yaml"}}
+	if !reflect.DeepEqual(stale, expected) {
+		t.Errorf("expecting %v, got %v", expected, stale)
+	}
+}
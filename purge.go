@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// commitsWithPath lists the commits reachable from any ref that add or
+// change path.
+func commitsWithPath(projectRoot string, path string) ([]string, error) {
+	_, stdOut, stdErr, err := runCommand("git", "-C", projectRoot, "log", "--all", "--format=%h %s", "--", path)
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %s", strings.TrimSpace(stdErr))
+	}
+	commits := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(stdOut), "\n") {
+		if line != "" {
+			commits = append(commits, line)
+		}
+	}
+	return commits, nil
+}
+
+func filterRepoArgs(path string) []string {
+	return []string{"filter-repo", "--invert-paths", "--path", path}
+}
+
+// purgeHistory removes a leaked file from every commit with git filter-repo,
+// which rewrites history, so it only runs once confirmed.
+func purgeHistory(projectRoot string, file string) error {
+	path := projectPath(file)
+	if path == "" || strings.HasPrefix(path, "..") {
+		return fmt.Errorf("%s is not in the project %s", file, projectRoot)
+	}
+	if strings.HasSuffix(path, ".enc") {
+		return fmt.Errorf("%s is encrypted, give the path of the leaked plaintext", path)
+	}
+	commits, err := commitsWithPath(projectRoot, path)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		printProgress("%s is not in the history of any ref, nothing to purge", path)
+		return nil
+	}
+	printProgress("%s is in %d commit(s):", path, len(commits))
+	for _, commit := range commits {
+		printProgress("  %s", commit)
+	}
+	printProgress("To remove it from all of history, run in %s:", projectRoot)
+	printProgress("  git %s", strings.Join(filterRepoArgs(path), " "))
+	printProgress("git filter-repo only rewrites fresh clones unless given --force, and removes the origin remote when done.")
+
+	if dryRun || !confirm("Rewrite history now?") {
+		printPurgeReminders(path)
+		return nil
+	}
+	if _, _, _, err := runCommand("git", "filter-repo", "--version"); err != nil {
+		return errors.New("git filter-repo is not installed, see https://github.com/newren/git-filter-repo")
+	}
+	_, _, stdErr, err := runCommand("git", append([]string{"-C", projectRoot}, filterRepoArgs(path)...)...)
+	if err != nil {
+		return fmt.Errorf("git filter-repo failed: %s", strings.TrimSpace(stdErr))
+	}
+	commits, err = commitsWithPath(projectRoot, path)
+	if err != nil {
+		return err
+	}
+	if len(commits) > 0 {
+		return fmt.Errorf("%s is still in %d commit(s) after filter-repo, check `git log --all -- %s`", path, len(commits), path)
+	}
+	printProgress("%s is gone from all refs", path)
+	printPurgeReminders(path)
+	return nil
+}
+
+func printPurgeReminders(path string) {
+	printProgress("Afterwards:")
+	printProgress("  - force-push every branch and tag: git push --force --all && git push --force --tags")
+	printProgress("  - have everyone re-clone, old clones and forks still hold %s", path)
+	printProgress("  - treat the values in %s as compromised: change them and `secrets seal` again", path)
+	printProgress("  - if a key file or KMS credentials leaked, rotate the key and run `secrets reseal-all`")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommitsWithPath(t *testing.T) {
+	root := useFakeBackend(t)
+	initGitRepo(t, root)
+	writeTestFile(t, filepath.Join(root, "README.md"), []byte("readme\n"), 0644)
+	gitCommitAll(t, root, "readme")
+	if output, err := exec.Command("git", "-C", root, "checkout", "-q", "-b", "leak").CombinedOutput(); err != nil {
+		t.Fatalf("git checkout failed: %s", output)
+	}
+	writeTestFile(t, filepath.Join(root, "secret.yaml"), []byte("token: abc\n"), 0600)
+	gitCommitAll(t, root, "leak")
+	if err := os.Remove(filepath.Join(root, "secret.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	gitCommitAll(t, root, "remove the leak")
+	if output, err := exec.Command("git", "-C", root, "checkout", "-q", "-").CombinedOutput(); err != nil {
+		t.Fatalf("git checkout failed: %s", output)
+	}
+	for _, test := range []struct {
+		path    string
+		commits []string
+	}{
+		{"secret.yaml", []string{"remove the leak", "leak"}},
+		{"README.md", []string{"readme"}},
+		{"other.yaml", []string{}},
+	} {
+		commits, err := commitsWithPath(root, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects := []string{}
+		for _, commit := range commits {
+			_, subject, _ := strings.Cut(commit, " ")
+			subjects = append(subjects, subject)
+		}
+		if !reflect.DeepEqual(subjects, test.commits) {
+			t.Errorf("%s: expecting %q, got %q", test.path, test.commits, subjects)
+		}
+	}
+}
+
+func TestPurgeHistoryRejects(t *testing.T) {
+	root := useFakeBackend(t)
+	for _, test := range []struct {
+		file string
+		err  string
+	}{
+		{filepath.Join(root, "secret.yaml.enc"), "is encrypted, give the path of the leaked plaintext"},
+		{filepath.Join(filepath.Dir(root), "secret.yaml"), "is not in the project"},
+	} {
+		err := purgeHistory(root, test.file)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expecting an error with %q, got %v", test.file, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxQuotaRetries int = 5
+
+// rateLimiter spaces out calls so no more than a given number start per
+// second, across all workers.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func (r *rateLimiter) setRate(perSecond float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if perSecond <= 0 {
+		r.interval = 0
+		return
+	}
+	r.interval = time.Duration(float64(time.Second) / perSecond)
+}
+
+func (r *rateLimiter) wait() {
+	r.mutex.Lock()
+	if r.interval == 0 {
+		r.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+	if delay > 0 {
+		printDebugln("rate limit: waiting %s", formatDuration(delay))
+		time.Sleep(delay)
+	}
+}
+
+var kmsLimiter = &rateLimiter{}
+
+func isQuotaError(err error) bool {
+	return hasKmsStatus(err, "RESOURCE_EXHAUSTED") || strings.Contains(err.Error(), "Quota exceeded")
+}
+
+// quotaBackoff is the delay before retrying a call rejected for quota,
+// doubling from one second.
+func quotaBackoff(attempt int) time.Duration {
+	return time.Second << uint(attempt)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsQuotaError(t *testing.T) {
+	for _, test := range []struct {
+		err   error
+		quota bool
+	}{
+		{&kmsAPIError{Status: "RESOURCE_EXHAUSTED", Message: "too many requests"}, true},
+		{&gcloudError{errors.New("exit status 1"), "ERROR: (gcloud.kms.encrypt) RESOURCE_EXHAUSTED: Quota exceeded"}, true},
+		{errors.New("Quota exceeded for quota metric"), true},
+		{&kmsAPIError{Status: "NOT_FOUND", Message: "key not found"}, false},
+	} {
+		if quota := isQuotaError(test.err); quota != test.quota {
+			t.Errorf("%v: expecting %t, got %t", test.err, test.quota, quota)
+		}
+	}
+}
+
+func TestQuotaBackoff(t *testing.T) {
+	for attempt, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if b := quotaBackoff(attempt); b != backoff {
+			t.Errorf("attempt %d: expecting %s, got %s", attempt, backoff, b)
+		}
+	}
+}
+
+func TestRateLimiterSpacesCalls(t *testing.T) {
+	for _, test := range []struct {
+		perSecond float64
+		least     time.Duration
+		most      time.Duration
+	}{
+		{0, 0, 20 * time.Millisecond},
+		{50, 40 * time.Millisecond, time.Second},
+	} {
+		r := &rateLimiter{}
+		r.setRate(test.perSecond)
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			r.wait()
+		}
+		if elapsed := time.Since(start); elapsed < test.least || elapsed > test.most {
+			t.Errorf("%g per second: expecting 3 calls in %s to %s, took %s", test.perSecond, test.least, test.most, elapsed)
+		}
+	}
+}
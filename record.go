@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// --kms-record saves every KMS call of a run with its response to a
+// cassette file, and --kms-replay answers KMS calls from one without
+// credentials or network, so end-to-end tests of the CLI can run offline.
+// Calls are matched on their exact inputs: opening recorded .enc files
+// replays, while sealing draws new data keys and can't.
+
+var kmsRecord string
+var kmsReplay string
+
+type kmsRequest struct {
+	Method string   `json:"method"`
+	Args   []string `json:"args"`
+}
+
+type kmsRecordedError struct {
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+type kmsInteraction struct {
+	Request  kmsRequest        `json:"request"`
+	Response json.RawMessage   `json:"response,omitempty"`
+	Error    *kmsRecordedError `json:"error,omitempty"`
+}
+
+type cassette struct {
+	mutex        sync.Mutex
+	file         string
+	Interactions []kmsInteraction `json:"interactions"`
+}
+
+func (r kmsRequest) key() string {
+	return r.Method + "\x00" + strings.Join(r.Args, "\x00")
+}
+
+func encodeBytes(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// recordedError keeps the KMS status of an error, so that a replayed error
+// is handled like the recorded one, e.g. NOT_FOUND creating the key.
+func recordedError(err error) *kmsRecordedError {
+	var apiErr *kmsAPIError
+	if errors.As(err, &apiErr) {
+		return &kmsRecordedError{Status: apiErr.Status, Message: apiErr.Message}
+	}
+	recorded := &kmsRecordedError{Message: err.Error()}
+	var gcloudErr *gcloudError
+	if errors.As(err, &gcloudErr) {
+		recorded.Status = gcloudErr.status()
+	}
+	return recorded
+}
+
+func (c *cassette) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+func loadCassette(file string) (*cassette, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := &cassette{file: file}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return c, nil
+}
+
+// recordingBackend passes calls on to a real backend and saves them, after
+// each call so that a run that exits early still leaves a usable cassette.
+type recordingBackend struct {
+	inner    kmsBackend
+	cassette *cassette
+}
+
+func newRecordingBackend(inner kmsBackend, file string) *recordingBackend {
+	return &recordingBackend{inner: inner, cassette: &cassette{file: file, Interactions: []kmsInteraction{}}}
+}
+
+func (r *recordingBackend) record(method string, args []string, result interface{}, err error) error {
+	interaction := kmsInteraction{Request: kmsRequest{method, args}}
+	if err != nil {
+		interaction.Error = recordedError(err)
+	} else {
+		data, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			return marshalErr
+		}
+		interaction.Response = data
+	}
+	r.cassette.mutex.Lock()
+	defer r.cassette.mutex.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if saveErr := r.cassette.save(); saveErr != nil {
+		errPrintln("Warning: could not save KMS calls to %s: %s", r.cassette.file, saveErr)
+	}
+	return err
+}
+
+func (r *recordingBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	output, err := r.inner.encrypt(keyName, plaintext)
+	return output, r.record("encrypt", []string{keyName, encodeBytes(plaintext)}, output, err)
+}
+
+func (r *recordingBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	output, err := r.inner.decrypt(keyName, ciphertext)
+	return output, r.record("decrypt", []string{keyName, encodeBytes(ciphertext)}, output, err)
+}
+
+func (r *recordingBackend) describeKey(keyName string) (*kmsKey, error) {
+	k, err := r.inner.describeKey(keyName)
+	return k, r.record("describeKey", []string{keyName}, k, err)
+}
+
+func (r *recordingBackend) listKeyVersions(keyName string) ([]kmsKeyVersion, error) {
+	versions, err := r.inner.listKeyVersions(keyName)
+	return versions, r.record("listKeyVersions", []string{keyName}, versions, err)
+}
+
+// createKey is matched on the key name only, as the settings of new keys
+// include the time of their first rotation.
+func (r *recordingBackend) createKey(keyName string, params *keyCreation) error {
+	err := r.inner.createKey(keyName, params)
+	return r.record("createKey", []string{keyName}, nil, err)
+}
+
+func (r *recordingBackend) createKeyRing(keyRingName string) error {
+	err := r.inner.createKeyRing(keyRingName)
+	return r.record("createKeyRing", []string{keyRingName}, nil, err)
+}
+
+func (r *recordingBackend) keyIAMPolicy(keyName string) (*iamPolicy, error) {
+	policy, err := r.inner.keyIAMPolicy(keyName)
+	return policy, r.record("keyIAMPolicy", []string{keyName}, policy, err)
+}
+
+func (r *recordingBackend) keyRingIAMPolicy(keyName string) (*iamPolicy, error) {
+	policy, err := r.inner.keyRingIAMPolicy(keyName)
+	return policy, r.record("keyRingIAMPolicy", []string{keyName}, policy, err)
+}
+
+func (r *recordingBackend) asymmetricSign(versionName string, digestAlgorithm string, message []byte) ([]byte, error) {
+	signature, err := r.inner.asymmetricSign(versionName, digestAlgorithm, message)
+	return signature, r.record("asymmetricSign", []string{versionName, digestAlgorithm, encodeBytes(message)}, signature, err)
+}
+
+func (r *recordingBackend) publicKey(versionName string) (*kmsPublicKey, error) {
+	k, err := r.inner.publicKey(versionName)
+	return k, r.record("publicKey", []string{versionName}, k, err)
+}
+
+func (r *recordingBackend) resourceName(keyName string) (string, error) {
+	name, err := r.inner.resourceName(keyName)
+	return name, r.record("resourceName", []string{keyName}, name, err)
+}
+
+func (r *recordingBackend) identity() (string, error) {
+	account, err := r.inner.identity()
+	return account, r.record("identity", []string{}, account, err)
+}
+
+func (r *recordingBackend) whoami() (*callerIdentity, error) {
+	identity, err := r.inner.whoami()
+	return identity, r.record("whoami", []string{}, identity, err)
+}
+
+func (r *recordingBackend) testIAMPermissions(keyName string, permissions []string) ([]string, error) {
+	granted, err := r.inner.testIAMPermissions(keyName, permissions)
+	return granted, r.record("testIAMPermissions", append([]string{keyName}, permissions...), granted, err)
+}
+
+// replayBackend answers calls from a cassette, failing those that weren't
+// recorded.
+type replayBackend struct {
+	responses map[string]kmsInteraction
+	file      string
+}
+
+func newReplayBackend(file string) (*replayBackend, error) {
+	c, err := loadCassette(file)
+	if err != nil {
+		return nil, err
+	}
+	p := &replayBackend{responses: map[string]kmsInteraction{}, file: file}
+	for _, interaction := range c.Interactions {
+		if _, ok := p.responses[interaction.Request.key()]; !ok {
+			p.responses[interaction.Request.key()] = interaction
+		}
+	}
+	return p, nil
+}
+
+func (p *replayBackend) replay(method string, args []string, result interface{}) error {
+	interaction, ok := p.responses[kmsRequest{method, args}.key()]
+	if !ok {
+		name := ""
+		if len(args) > 0 {
+			name = " " + args[0]
+		}
+		return fmt.Errorf("no recorded response for %s%s in %s, record it again with --kms-record", method, name, p.file)
+	}
+	if interaction.Error != nil {
+		if interaction.Error.Status != "" {
+			return &kmsAPIError{Status: interaction.Error.Status, Message: interaction.Error.Message}
+		}
+		return errors.New(interaction.Error.Message)
+	}
+	if result == nil || len(interaction.Response) == 0 {
+		return nil
+	}
+	return json.Unmarshal(interaction.Response, result)
+}
+
+func (p *replayBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	var output []byte
+	return output, p.replay("encrypt", []string{keyName, encodeBytes(plaintext)}, &output)
+}
+
+func (p *replayBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	var output []byte
+	return output, p.replay("decrypt", []string{keyName, encodeBytes(ciphertext)}, &output)
+}
+
+func (p *replayBackend) describeKey(keyName string) (*kmsKey, error) {
+	k := &kmsKey{}
+	return k, p.replay("describeKey", []string{keyName}, k)
+}
+
+func (p *replayBackend) listKeyVersions(keyName string) ([]kmsKeyVersion, error) {
+	versions := []kmsKeyVersion{}
+	return versions, p.replay("listKeyVersions", []string{keyName}, &versions)
+}
+
+func (p *replayBackend) createKey(keyName string, params *keyCreation) error {
+	return p.replay("createKey", []string{keyName}, nil)
+}
+
+func (p *replayBackend) createKeyRing(keyRingName string) error {
+	return p.replay("createKeyRing", []string{keyRingName}, nil)
+}
+
+func (p *replayBackend) keyIAMPolicy(keyName string) (*iamPolicy, error) {
+	policy := &iamPolicy{}
+	return policy, p.replay("keyIAMPolicy", []string{keyName}, policy)
+}
+
+func (p *replayBackend) keyRingIAMPolicy(keyName string) (*iamPolicy, error) {
+	policy := &iamPolicy{}
+	return policy, p.replay("keyRingIAMPolicy", []string{keyName}, policy)
+}
+
+func (p *replayBackend) asymmetricSign(versionName string, digestAlgorithm string, message []byte) ([]byte, error) {
+	var signature []byte
+	return signature, p.replay("asymmetricSign", []string{versionName, digestAlgorithm, encodeBytes(message)}, &signature)
+}
+
+func (p *replayBackend) publicKey(versionName string) (*kmsPublicKey, error) {
+	k := &kmsPublicKey{}
+	return k, p.replay("publicKey", []string{versionName}, k)
+}
+
+func (p *replayBackend) resourceName(keyName string) (string, error) {
+	var name string
+	return name, p.replay("resourceName", []string{keyName}, &name)
+}
+
+func (p *replayBackend) identity() (string, error) {
+	var account string
+	return account, p.replay("identity", []string{}, &account)
+}
+
+func (p *replayBackend) whoami() (*callerIdentity, error) {
+	identity := &callerIdentity{}
+	return identity, p.replay("whoami", []string{}, identity)
+}
+
+func (p *replayBackend) testIAMPermissions(keyName string, permissions []string) ([]string, error) {
+	granted := []string{}
+	return granted, p.replay("testIAMPermissions", append([]string{keyName}, permissions...), &granted)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayOpensRecordedFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	cassetteFile := filepath.Join(root, "kms.json")
+	kmsSelected = newRecordingBackend(&fakeBackend{}, cassetteFile)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	content := bytes.Repeat([]byte("key: value\n"), 10000)
+	writeTestFile(t, plaintextFile, content, 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := newReplayBackend(cassetteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kmsSelected = replay
+	if err := os.Remove(plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+		t.Fatal(err)
+	}
+	if opened, _ := os.ReadFile(plaintextFile); !bytes.Equal(opened, content) {
+		t.Error("the replayed open wrote other content")
+	}
+	if _, err := replay.encrypt(testKey, []byte("not recorded")); err == nil || !strings.Contains(err.Error(), "no recorded response for encrypt") {
+		t.Errorf("expecting an error for a call that wasn't recorded, got %v", err)
+	}
+}
+
+func TestReplayKeepsErrorStatus(t *testing.T) {
+	cassetteFile := filepath.Join(t.TempDir(), "kms.json")
+	recording := newRecordingBackend(&fakeBackend{}, cassetteFile)
+	notFound := &kmsAPIError{Status: "NOT_FOUND", Message: "key not found"}
+	if err := recording.record("describeKey", []string{"missing"}, nil, notFound); err != notFound {
+		t.Fatalf("expecting the recorded error back, got %v", err)
+	}
+	replay, err := newReplayBackend(cassetteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = replay.describeKey("missing")
+	var apiErr *kmsAPIError
+	if !errors.As(err, &apiErr) || apiErr.Status != "NOT_FOUND" {
+		t.Errorf("expecting a NOT_FOUND error, got %v", err)
+	}
+}
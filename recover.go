@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A run that crashed or was killed while writing files leaves temporary
+// files behind, holding part of a plaintext or .enc. They are looked for
+// when a command over files starts, and removed, or removed and the file
+// they were for opened or sealed again.
+
+// tempFilePattern matches the paths of temporary files, with either
+// separator as walks on Windows find paths with backslashes.
+var tempFilePattern = regexp.MustCompile(`(?:^|[/\\])` + regexp.QuoteMeta(tempFilePrefix) + `[0-9]+-([^/\\]+)$`)
+
+// recoverCommands are the commands that look for temporary files.
+var recoverCommands = []string{encryptCmd, decryptCmd, statusCmd, resealAllCmd, cleanCmd}
+
+// tempFileTarget is the file a temporary file was written for.
+func tempFileTarget(tmp string) string {
+	match := tempFilePattern.FindStringSubmatch(filepath.Base(tmp))
+	if match == nil {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(tmp), match[1])
+}
+
+// recoverTempFiles offers to remove the temporary files left in root, or to
+// redo the files they were written for. In CI and without a terminal they
+// are listed and kept, unless --yes removes them.
+func recoverTempFiles(root string) error {
+	if root == "" {
+		return nil
+	}
+	temps, err := findFiles(root, *tempFilePattern)
+	if err != nil || len(temps) == 0 {
+		return err
+	}
+	errPrintln("Warning: %d partially written file(s) left by an interrupted run:", len(temps))
+	for _, tmp := range temps {
+		errPrintln("  %s (for %s)", displayPath(tmp), displayPath(tempFileTarget(tmp)))
+	}
+	answer := "c"
+	if !assumeYes {
+		if dryRun || !isInteractiveTerminal() {
+			errPrintln("Warning: not touching them, run again with --yes to remove them")
+			return nil
+		}
+		// A closed input leaves them.
+		answer, _ = prompt("Remove them (c), remove them and open or seal their files again (r), or leave them (l)? [c/r/L]")
+	}
+	switch strings.ToLower(answer) {
+	case "c", "clean":
+		return removeFiles(temps)
+	case "r", "resume":
+		if err := removeFiles(temps); err != nil {
+			return err
+		}
+		for _, tmp := range temps {
+			if err := redoFile(tempFileTarget(tmp)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func removeFiles(files []string) error {
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		printDebugln("removed %s", file)
+	}
+	return nil
+}
+
+// redoFile seals a .enc again from its plaintext, or opens a plaintext
+// again from its .enc. Without either the file was never replaced and is
+// left alone.
+func redoFile(target string) error {
+	if strings.HasSuffix(target, ".enc") {
+		plaintextFile := strings.TrimSuffix(target, ".enc")
+		if !fileExists(plaintextFile) {
+			return nil
+		}
+		printProgress("encrypting %s", plaintextFile)
+		if err := sealFile(plaintextFile); err != nil {
+			return fmt.Errorf("%s: %w", plaintextFile, err)
+		}
+		return nil
+	}
+	ciphertextFile := target + ".enc"
+	if !fileExists(ciphertextFile) {
+		return nil
+	}
+	printProgress("decrypting %s", ciphertextFile)
+	return openFile(ciphertextFile)
+}
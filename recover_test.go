@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTempFilePattern(t *testing.T) {
+	for _, test := range []struct {
+		path  string
+		match bool
+	}{
+		{"/repo/config/.secrets-tmp-123-secret.yaml", true},
+		{`C:\repo\config\.secrets-tmp-123-secret.yaml`, true},
+		{".secrets-tmp-123-secret.yaml", true},
+		{"/repo/config/.secrets-tmp-123-", false},
+		{"/repo/.secrets-tmp-123-dir/secret.yaml", false},
+		{`C:\repo\.secrets-tmp-123-dir\secret.yaml`, false},
+		{"/repo/config/secret.yaml", false},
+	} {
+		if match := tempFilePattern.MatchString(test.path); match != test.match {
+			t.Errorf("%s: expecting a match %v, got %v", test.path, test.match, match)
+		}
+	}
+}
+
+func TestTempFileTarget(t *testing.T) {
+	tmp := filepath.Join("repo", "config", tempFilePrefix+"123-secret.yaml.enc")
+	if target := tempFileTarget(tmp); target != filepath.Join("repo", "config", "secret.yaml.enc") {
+		t.Errorf("expecting repo/config/secret.yaml.enc, got %s", target)
+	}
+	if target := tempFileTarget(filepath.Join("repo", "secret.yaml")); target != "" {
+		t.Errorf("expecting no target, got %s", target)
+	}
+}
+
+func TestRecoverTempFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	tmp := filepath.Join(root, tempFilePrefix+"123-secret.yaml")
+	writeTestFile(t, tmp, []byte("pass"), 0600)
+	dryRun = true
+	defer func() { dryRun, assumeYes = false, false }()
+	output := captureStderr(t, func() {
+		if err := recoverTempFiles(root); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(output, "not touching them") || !fileExists(tmp) {
+		t.Errorf("expecting the temporary file kept with --dry-run, got %q", output)
+	}
+	dryRun, assumeYes = false, true
+	captureStderr(t, func() {
+		if err := recoverTempFiles(root); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if fileExists(tmp) {
+		t.Error("expecting --yes to remove the temporary file")
+	}
+}
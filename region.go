@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Keys live in the key ring of one location, global unless configured. With
+// a secondary location, the data key of each file is also wrapped with the
+// key of the same name in that location, so files can still be opened, and
+// sealed, while the primary location is unavailable.
+
+// locationFlag and secondaryLocationFlag override the root .secrets.yaml.
+var locationFlag string
+var secondaryLocationFlag string
+var location string = defaultLocation
+var secondaryLocation string
+
+const defaultLocation string = "global"
+
+// applyLocations sets the locations of keys for the project at root: the
+// flags, or else its root .secrets.yaml, or else global with no secondary.
+func applyLocations(root string) error {
+	location, secondaryLocation = defaultLocation, ""
+	if root != "" {
+		config, err := configFor(root)
+		if err != nil {
+			return err
+		}
+		if config.Location != "" {
+			location = config.Location
+		}
+		secondaryLocation = config.SecondaryLocation
+	}
+	if locationFlag != "" {
+		location = locationFlag
+	}
+	if secondaryLocationFlag != "" {
+		secondaryLocation = secondaryLocationFlag
+	}
+	if secondaryLocation == location {
+		return fmt.Errorf("the secondary location must differ from the location %s", location)
+	}
+	return nil
+}
+
+// inLocation is the key of the same name and key ring in another location.
+func inLocation(keyName string, otherLocation string) string {
+	before, after, ok := strings.Cut(keyName, "/locations/")
+	if !ok {
+		return keyName
+	}
+	_, rest, _ := strings.Cut(after, "/")
+	return before + "/locations/" + otherLocation + "/" + rest
+}
+
+func keyResourceName(keyName string) (string, error) {
+	client, err := kmsClient()
+	if err != nil {
+		return "", err
+	}
+	return client.resourceName(keyName)
+}
+
+// isUnavailableError reports whether KMS couldn't be reached or didn't
+// answer, as opposed to refusing the request.
+func isUnavailableError(err error) bool {
+	if hasKmsStatus(err, "UNAVAILABLE") || hasKmsStatus(err, "DEADLINE_EXCEEDED") {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// encryptWithFallback encrypts plaintext with a data key wrapped by both the
+// primary and the secondary key. When only one of them is available the file
+// is sealed with that one and a warning says to seal it again later.
+func encryptWithFallback(plaintextFile string, keyName string, plaintext []byte, additionalData []byte) (*envelope, error) {
+	primary, err := keyResourceName(keyName)
+	if err != nil {
+		return nil, err
+	}
+	fallback := inLocation(primary, secondaryLocation)
+	hash, err := plaintextHash(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, dataKey, err := sealWithDataKey(plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	e := &envelope{SealedAt: time.Now(), contentHash: hash, Ciphertext: ciphertext}
+
+	wrapped, err := callKms("encrypt", primary, dataKey)
+	if err != nil && !isUnavailableError(err) {
+		return nil, err
+	}
+	primaryErr := err
+	fallbackWrapped, err := callKms("encrypt", fallback, dataKey)
+	if err != nil && (primaryErr != nil || !isUnavailableError(err)) {
+		return nil, fmt.Errorf("secondary key %s: %w", fallback, err)
+	}
+	fallbackErr := err
+
+	if primaryErr != nil {
+		errPrintln("Warning: %s is unavailable, sealed %s with the key in %s only, seal it again once %s is back: %s",
+			location, plaintextFile, secondaryLocation, location, primaryErr)
+		primary, wrapped = fallback, fallbackWrapped
+	}
+	k, err := describeKey(primary)
+	if err != nil {
+		return nil, err
+	}
+	e.Key, e.KeyVersion, e.WrappedKey = k.Name, k.Primary.Name, []byte(wrapped)
+	if primaryErr != nil {
+		return e, nil
+	}
+	if fallbackErr != nil {
+		errPrintln("Warning: %s is unavailable, sealed %s without a key in %s, seal it again once %s is back: %s",
+			secondaryLocation, plaintextFile, secondaryLocation, secondaryLocation, fallbackErr)
+		return e, nil
+	}
+	e.FallbackKey, e.FallbackWrappedKey = fallback, []byte(fallbackWrapped)
+	return e, nil
+}
+
+// decryptWithFallback opens an envelope sealed by encryptWithFallback,
+// unwrapping its data key in the secondary location when the primary
+// location is unavailable.
+func decryptWithFallback(keyName string, e *envelope) ([]byte, error) {
+	dataKey, err := callKms("decrypt", keyName, e.WrappedKey)
+	if err != nil && isUnavailableError(err) {
+		errPrintln("Warning: %s is unavailable, opening with %s", keyName, e.FallbackKey)
+		dataKey, err = callKmsKey("decrypt", e.FallbackKey, e.FallbackWrappedKey, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return openWithDataKey([]byte(dataKey), e.Ciphertext, e.additionalData())
+}
+
+// hasFallback reports whether an envelope is sealed as the current locations
+// require: with a key in the secondary location when there is one.
+func hasFallback(e *envelope) bool {
+	if secondaryLocation == "" || e.SecondKey != "" {
+		return true
+	}
+	return e.FallbackKey != "" && strings.Contains(e.FallbackKey, "/locations/"+secondaryLocation+"/")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// unavailableBackend is the fake backend with one location down.
+type unavailableBackend struct {
+	fakeBackend
+	down string
+}
+
+func (u *unavailableBackend) isDown(keyName string) bool {
+	return u.down != "" && strings.Contains(keyName, "/locations/"+u.down+"/")
+}
+
+func (u *unavailableBackend) encrypt(keyName string, plaintext []byte) ([]byte, error) {
+	if u.isDown(keyName) {
+		return nil, &kmsAPIError{Status: "UNAVAILABLE", Message: "the service is unavailable"}
+	}
+	return u.fakeBackend.encrypt(keyName, plaintext)
+}
+
+func (u *unavailableBackend) decrypt(keyName string, ciphertext []byte) ([]byte, error) {
+	if u.isDown(keyName) {
+		return nil, &kmsAPIError{Status: "UNAVAILABLE", Message: "the service is unavailable"}
+	}
+	return u.fakeBackend.decrypt(keyName, ciphertext)
+}
+
+func TestInLocation(t *testing.T) {
+	for _, test := range []struct {
+		keyName string
+		moved   string
+	}{
+		{testKey, "projects/fake-project/locations/europe-west1/keyRings/secrets/cryptoKeys/test"},
+		{"test", "test"},
+	} {
+		if moved := inLocation(test.keyName, "europe-west1"); moved != test.moved {
+			t.Errorf("%s: expecting %s, got %s", test.keyName, test.moved, moved)
+		}
+	}
+}
+
+func TestApplyLocations(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		config    string
+		flag      string
+		location  string
+		secondary string
+		err       bool
+	}{
+		{"default", "", "", defaultLocation, "", false},
+		{"config", "location: europe-west1\nsecondary-location: europe-west4\n", "", "europe-west1", "europe-west4", false},
+		{"flag", "location: europe-west1\n", "us-east1", "us-east1", "", false},
+		{"same", "location: europe-west1\nsecondary-location: europe-west1\n", "", "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeConfigs(t, root, map[string]string{".": test.config})
+			locationFlag = test.flag
+			defer func() { locationFlag, location, secondaryLocation = "", defaultLocation, "" }()
+			err := applyLocations(root)
+			if (err != nil) != test.err {
+				t.Fatalf("expecting an error %t, got %v", test.err, err)
+			}
+			if !test.err && (location != test.location || secondaryLocation != test.secondary) {
+				t.Errorf("expecting %s and %q, got %s and %q", test.location, test.secondary, location, secondaryLocation)
+			}
+		})
+	}
+}
+
+func TestSealWithFallback(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		downOnSeal  string
+		downOnOpen  string
+		keyLocation string
+		hasFallback bool
+	}{
+		{"both up", "", "global", "global", true},
+		{"primary down", "global", "", "europe-west1", false},
+		{"secondary down", "europe-west1", "", "global", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useFakeBackend(t)
+			secondaryLocation = "europe-west1"
+			defer func() { secondaryLocation = "" }()
+			backend := &unavailableBackend{down: test.downOnSeal}
+			kmsSelected = backend
+			var e *envelope
+			var err error
+			captureStderr(t, func() {
+				e, err = encryptWithFallback("secret.yaml", testKey, []byte("token: abc\n"), nil)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(e.Key, "/locations/"+test.keyLocation+"/") || (e.FallbackKey != "") != test.hasFallback || hasFallback(e) != test.hasFallback {
+				t.Errorf("expecting the key in %s with a fallback %t, got %+v", test.keyLocation, test.hasFallback, e)
+			}
+			backend.down = test.downOnOpen
+			var plaintext []byte
+			captureStderr(t, func() {
+				plaintext, err = decryptWithFallback(e.Key, e)
+			})
+			if err != nil || string(plaintext) != "token: abc\n" {
+				t.Errorf("expecting the file opened, got %q (%v)", plaintext, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	formatJSON string = "json"
+	formatCSV  string = "csv"
+)
+
+// reportRow describes one .enc for the inventory. Everything comes from the
+// envelope, so a report needs no access to the keys.
+type reportRow struct {
+	Repo       string `json:"repo"`
+	File       string `json:"file"`
+	Key        string `json:"key"`
+	KeyVersion string `json:"keyVersion"`
+	SealedAt   string `json:"sealedAt"`
+	SealedBy   string `json:"sealedBy"`
+	SealedHost string `json:"sealedHost"`
+	Commit     string `json:"commit"`
+	Size       int64  `json:"size"`
+	Format     string `json:"format"`
+	Error      string `json:"error,omitempty"`
+}
+
+func reportRows(root string, files []string) []reportRow {
+	repo := getKeyName(root)
+	rows := make([]reportRow, 0, len(files))
+	for _, file := range files {
+		row := reportRow{Repo: repo, File: displayPath(file), Format: "envelope"}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			row.Error = err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		row.Size = int64(len(data))
+		e, err := parseEnvelope(data)
+		switch {
+		case errors.Is(err, errNotEnvelope):
+			row.Format = "legacy"
+			row.Key = fileKey(file)
+		case err != nil:
+			row.Error = err.Error()
+		default:
+			row.Key = e.Key
+			row.KeyVersion = versionNumber(e.KeyVersion)
+			row.SealedBy, row.SealedHost, row.Commit = e.SealedBy, e.SealedHost, e.SealedCommit
+			if !e.SealedAt.IsZero() {
+				row.SealedAt = e.SealedAt.UTC().Format(time.RFC3339)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func printReport(rows []reportRow, format string) error {
+	switch format {
+	case formatJSON:
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	case formatCSV:
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"repo", "file", "key", "key_version", "sealed_at", "sealed_by", "sealed_host", "commit", "size", "format", "error"})
+		for _, row := range rows {
+			w.Write([]string{row.Repo, row.File, row.Key, row.KeyVersion, row.SealedAt, row.SealedBy, row.SealedHost, row.Commit, strconv.FormatInt(row.Size, 10), row.Format, row.Error})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	return fmt.Errorf("unknown report format %q, expecting %s or %s", format, formatJSON, formatCSV)
+}
+
+func report(root string, files []string, format string) error {
+	return printReport(reportRows(root, files), format)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReportRows(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "legacy-secret.yaml.enc"), []byte("\x0a\x24binary"), 0644)
+	writeTestFile(t, filepath.Join(root, "broken-secret.yaml.enc"), []byte("-----BEGIN "+envelopeType+"-----\nMode: 9999\n\nYQ==\n-----END "+envelopeType+"-----\n"), 0644)
+	files := []string{}
+	for _, file := range []string{"secret.yaml.enc", "legacy-secret.yaml.enc", "broken-secret.yaml.enc", "missing-secret.yaml.enc"} {
+		files = append(files, filepath.Join(root, file))
+	}
+	rows := reportRows(root, files)
+	for i, test := range []struct {
+		file     string
+		format   string
+		key      string
+		version  string
+		hasError bool
+	}{
+		{"secret.yaml.enc", "envelope", testKey, "1", false},
+		{"legacy-secret.yaml.enc", "legacy", fileKey(files[1]), "", false},
+		{"broken-secret.yaml.enc", "envelope", "", "", true},
+		{"missing-secret.yaml.enc", "envelope", "", "", true},
+	} {
+		row := rows[i]
+		if row.File != test.file || row.Format != test.format || row.Key != test.key || row.KeyVersion != test.version || (row.Error != "") != test.hasError {
+			t.Errorf("expecting %+v, got %+v", test, row)
+		}
+	}
+	if err := printReport(rows, "xml"); err == nil {
+		t.Error("expecting an unknown format rejected")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var secretFilePattern = regexp.MustCompile(`secret\.(yaml|yml)$`)
+
+// secretContentPatterns match values that should never be committed in the
+// clear. Lines containing secretAllowMarker are not reported.
+var secretContentPatterns = []struct {
+	description string
+	re          *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`)},
+	{"service account key", regexp.MustCompile(`"private_key"\s*:\s*"-----BEGIN`)},
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"password or token", regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key)["']?\s*[:=]\s*["']?[^\s"'$<>{}]{8,}`)},
+}
+
+const secretAllowMarker string = "secrets:allow"
+
+type stagedFinding struct {
+	path        string
+	line        int
+	description string
+}
+
+// scanLine returns what secret-looking content line contains, or an empty
+// string.
+func scanLine(line string) string {
+	if strings.Contains(line, secretAllowMarker) {
+		return ""
+	}
+	for _, pattern := range secretContentPatterns {
+		if pattern.re.MatchString(line) {
+			return pattern.description
+		}
+	}
+	return ""
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -[0-9,]+ \+([0-9]+)`)
+
+// scanStagedContent looks for secret-looking lines added by the staged
+// changes. .enc files are skipped, binary files are not shown by git diff.
+func scanStagedContent(projectRoot string) ([]stagedFinding, error) {
+	_, stdOut, stdErr, err := runCommand(
+		"git", "-C", projectRoot, "-c", "core.quotePath=false",
+		"diff", "--cached", "--no-color", "--no-ext-diff", "-U0", "--diff-filter=ACMR",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %s", strings.TrimSpace(stdErr))
+	}
+	findings := []stagedFinding{}
+	path := ""
+	line := 0
+	for _, text := range strings.Split(stdOut, "\n") {
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@ "):
+			if matches := hunkHeader.FindStringSubmatch(text); matches != nil {
+				fmt.Sscan(matches[1], &line)
+			}
+		case strings.HasPrefix(text, "+"):
+			if !strings.HasSuffix(path, ".enc") {
+				if description := scanLine(text[1:]); description != "" {
+					findings = append(findings, stagedFinding{path, line, description})
+				}
+			}
+			line++
+		}
+	}
+	return findings, nil
+}
+
+// checkCommit blocks commits that stage the plaintext of a secret or add
+// secret-looking content to any file.
+func checkCommit(projectRoot string) error {
+	_, stdOut, stdErr, err := runCommand("git", "-C", projectRoot, "-c", "core.quotePath=false", "diff", "--cached", "--name-only", "--diff-filter=ACMR")
+	if err != nil {
+		return fmt.Errorf("git diff failed: %s", strings.TrimSpace(stdErr))
+	}
+	problems := []string{}
+	for _, path := range strings.Split(strings.TrimSpace(stdOut), "\n") {
+		if reason := plaintextLeakReason(projectRoot, path); path != "" && reason != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", path, reason))
+		}
+	}
+	findings, err := scanStagedContent(projectRoot)
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		problems = append(problems, fmt.Sprintf("%s:%d: looks like a %s", f.path, f.line, f.description))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, problem := range problems {
+		errPrintln("  %s", problem)
+	}
+	return fmt.Errorf("commit blocked, seal these secrets or unstage them (add %q to a line that is not a secret, or commit with --no-verify to skip the check)", secretAllowMarker)
+}
+
+// plaintextLeakReason explains why a committed path looks like the plaintext
+// of a secret, or is empty when it doesn't.
+func plaintextLeakReason(projectRoot string, path string) string {
+	if strings.HasSuffix(path, ".enc") {
+		return ""
+	}
+	if fileExists(filepath.Join(projectRoot, filepath.FromSlash(path)+".enc")) {
+		return "it is the plaintext of " + path + ".enc"
+	}
+	if secretFilePattern.MatchString(path) {
+		return "it is named like a secret file"
+	}
+	return ""
+}
+
+// committedFiles lists the files added or changed by the commits in
+// revisions, by commit.
+func committedFiles(projectRoot string, revisions ...string) (map[string][]string, error) {
+	args := append([]string{"-C", projectRoot, "-c", "core.quotePath=false", "log", "--format=commit %h", "--name-only", "--diff-filter=ACMR"}, revisions...)
+	_, stdOut, stdErr, err := runCommand("git", args...)
+	if err != nil {
+		return nil, fmt.Errorf("git log failed: %s", strings.TrimSpace(stdErr))
+	}
+	files := map[string][]string{}
+	commit := ""
+	for _, line := range strings.Split(stdOut, "\n") {
+		if strings.HasPrefix(line, "commit ") {
+			commit = strings.TrimPrefix(line, "commit ")
+		} else if line != "" {
+			files[commit] = append(files[commit], line)
+		}
+	}
+	return files, nil
+}
+
+// outgoingRevisions are the commits a pre-push update line sends that the
+// remote doesn't have yet.
+func outgoingRevisions(projectRoot string, localRevision string, remoteRevision string) []string {
+	revisions := []string{localRevision, "--not", "--remotes"}
+	if remoteRevision != nullRevision {
+		if _, _, _, err := runCommand("git", "-C", projectRoot, "cat-file", "-e", remoteRevision+"^{commit}"); err == nil {
+			revisions = append(revisions, remoteRevision)
+		}
+	}
+	return revisions
+}
+
+// checkPush scans the commits being pushed for plaintext of secrets and
+// verifies that the .enc files they contain can be opened. input is what git
+// passes to the pre-push hook on stdin.
+func checkPush(projectRoot string, input io.Reader) error {
+	problems := []string{}
+	verified := map[string]bool{}
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[1] == nullRevision {
+			continue
+		}
+		localRevision := fields[1]
+		files, err := committedFiles(projectRoot, outgoingRevisions(projectRoot, localRevision, fields[3])...)
+		if err != nil {
+			return err
+		}
+		commits := make([]string, 0, len(files))
+		for commit := range files {
+			commits = append(commits, commit)
+		}
+		sort.Strings(commits)
+		for _, commit := range commits {
+			for _, path := range files[commit] {
+				if reason := plaintextLeakReason(projectRoot, path); reason != "" {
+					problems = append(problems, fmt.Sprintf("%s in commit %s: %s", path, commit, reason))
+				}
+				if !strings.HasSuffix(path, ".enc") || verified[localRevision+":"+path] {
+					continue
+				}
+				verified[localRevision+":"+path] = true
+				_, data, _, err := runCommand("git", "-C", projectRoot, "show", localRevision+":"+path)
+				if err != nil {
+					continue // removed again by a later commit
+				}
+				if err := verifyData(filepath.Join(projectRoot, filepath.FromSlash(path)), []byte(data)); err != nil {
+					problems = append(problems, err.Error())
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, problem := range problems {
+		errPrintln("  %s", problem)
+	}
+	return errors.New("push blocked, fix the commits above (or push with --no-verify to skip the check)")
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"math/bits"
+)
+
+// sealBox encrypts message for the holder of recipientKey as libsodium's
+// crypto_box_seal does, as GitHub expects secrets to be encrypted: with a
+// new X25519 key pair, XSalsa20-Poly1305, and a nonce derived from both
+// public keys with BLAKE2b. The primitives aren't in the standard library,
+// hence their small implementations here.
+func sealBox(message []byte, recipientKey []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	var sharedKey [32]byte
+	copy(sharedKey[:], shared)
+	var boxKey [32]byte
+	hsalsa20(&boxKey, &[16]byte{}, &sharedKey)
+
+	var nonce [24]byte
+	copy(nonce[:], blake2b(append(ephemeral.PublicKey().Bytes(), recipientKey...), 24))
+	return append(ephemeral.PublicKey().Bytes(), secretbox(message, &nonce, &boxKey)...), nil
+}
+
+// secretbox is NaCl's crypto_secretbox_easy: the Poly1305 tag followed by
+// the message encrypted with XSalsa20.
+func secretbox(message []byte, nonce *[24]byte, key *[32]byte) []byte {
+	var subKey [32]byte
+	var hNonce [16]byte
+	copy(hNonce[:], nonce[:16])
+	hsalsa20(&subKey, &hNonce, key)
+	stream := salsa20Stream(32+len(message), nonce[16:], &subKey)
+	ciphertext := make([]byte, len(message))
+	for i := range message {
+		ciphertext[i] = message[i] ^ stream[32+i]
+	}
+	var polyKey [32]byte
+	copy(polyKey[:], stream[:32])
+	return append(poly1305(ciphertext, &polyKey), ciphertext...)
+}
+
+func salsa20Rounds(x *[16]uint32) {
+	quarter := func(a, b, c, d int) {
+		x[b] ^= bits.RotateLeft32(x[a]+x[d], 7)
+		x[c] ^= bits.RotateLeft32(x[b]+x[a], 9)
+		x[d] ^= bits.RotateLeft32(x[c]+x[b], 13)
+		x[a] ^= bits.RotateLeft32(x[d]+x[c], 18)
+	}
+	for i := 0; i < 10; i++ {
+		quarter(0, 4, 8, 12)
+		quarter(5, 9, 13, 1)
+		quarter(10, 14, 2, 6)
+		quarter(15, 3, 7, 11)
+		quarter(0, 1, 2, 3)
+		quarter(5, 6, 7, 4)
+		quarter(10, 11, 8, 9)
+		quarter(15, 12, 13, 14)
+	}
+}
+
+func salsa20State(input *[16]byte, key *[32]byte) [16]uint32 {
+	var x [16]uint32
+	x[0], x[5], x[10], x[15] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 4; i++ {
+		x[1+i] = binary.LittleEndian.Uint32(key[4*i:])
+		x[11+i] = binary.LittleEndian.Uint32(key[16+4*i:])
+		x[6+i] = binary.LittleEndian.Uint32(input[4*i:])
+	}
+	return x
+}
+
+func hsalsa20(out *[32]byte, input *[16]byte, key *[32]byte) {
+	x := salsa20State(input, key)
+	salsa20Rounds(&x)
+	for i, word := range []uint32{x[0], x[5], x[10], x[15], x[6], x[7], x[8], x[9]} {
+		binary.LittleEndian.PutUint32(out[4*i:], word)
+	}
+}
+
+func salsa20Stream(length int, nonce []byte, key *[32]byte) []byte {
+	stream := make([]byte, 0, length+64)
+	var input [16]byte
+	copy(input[:8], nonce)
+	for counter := uint64(0); len(stream) < length; counter++ {
+		binary.LittleEndian.PutUint64(input[8:], counter)
+		initial := salsa20State(&input, key)
+		x := initial
+		salsa20Rounds(&x)
+		for i := range x {
+			stream = binary.LittleEndian.AppendUint32(stream, x[i]+initial[i])
+		}
+	}
+	return stream[:length]
+}
+
+// poly1305 is the Poly1305 tag of message under the one-time key, computed
+// in 26-bit limbs as poly1305-donna does, so that it takes the same time
+// whatever the key and message.
+func poly1305(message []byte, key *[32]byte) []byte {
+	const mask26 = 0x3ffffff
+	r0 := binary.LittleEndian.Uint32(key[0:]) & 0x3ffffff
+	r1 := (binary.LittleEndian.Uint32(key[3:]) >> 2) & 0x3ffff03
+	r2 := (binary.LittleEndian.Uint32(key[6:]) >> 4) & 0x3ffc0ff
+	r3 := (binary.LittleEndian.Uint32(key[9:]) >> 6) & 0x3f03fff
+	r4 := (binary.LittleEndian.Uint32(key[12:]) >> 8) & 0x00fffff
+	s1, s2, s3, s4 := r1*5, r2*5, r3*5, r4*5
+	var h0, h1, h2, h3, h4 uint32
+	for len(message) > 0 {
+		var block [16]byte
+		hibit := uint32(1 << 24)
+		if n := copy(block[:], message); n < 16 {
+			block[n], hibit = 1, 0
+		}
+		message = message[min(16, len(message)):]
+		h0 += binary.LittleEndian.Uint32(block[0:]) & mask26
+		h1 += (binary.LittleEndian.Uint32(block[3:]) >> 2) & mask26
+		h2 += (binary.LittleEndian.Uint32(block[6:]) >> 4) & mask26
+		h3 += (binary.LittleEndian.Uint32(block[9:]) >> 6) & mask26
+		h4 += (binary.LittleEndian.Uint32(block[12:]) >> 8) | hibit
+
+		d0 := uint64(h0)*uint64(r0) + uint64(h1)*uint64(s4) + uint64(h2)*uint64(s3) + uint64(h3)*uint64(s2) + uint64(h4)*uint64(s1)
+		d1 := uint64(h0)*uint64(r1) + uint64(h1)*uint64(r0) + uint64(h2)*uint64(s4) + uint64(h3)*uint64(s3) + uint64(h4)*uint64(s2)
+		d2 := uint64(h0)*uint64(r2) + uint64(h1)*uint64(r1) + uint64(h2)*uint64(r0) + uint64(h3)*uint64(s4) + uint64(h4)*uint64(s3)
+		d3 := uint64(h0)*uint64(r3) + uint64(h1)*uint64(r2) + uint64(h2)*uint64(r1) + uint64(h3)*uint64(r0) + uint64(h4)*uint64(s4)
+		d4 := uint64(h0)*uint64(r4) + uint64(h1)*uint64(r3) + uint64(h2)*uint64(r2) + uint64(h3)*uint64(r1) + uint64(h4)*uint64(r0)
+
+		h0 = uint32(d0) & mask26
+		d1 += d0 >> 26
+		h1 = uint32(d1) & mask26
+		d2 += d1 >> 26
+		h2 = uint32(d2) & mask26
+		d3 += d2 >> 26
+		h3 = uint32(d3) & mask26
+		d4 += d3 >> 26
+		h4 = uint32(d4) & mask26
+		h0 += uint32(d4>>26) * 5
+		h1 += h0 >> 26
+		h0 &= mask26
+	}
+
+	// Carry fully, then subtract p = 2^130 - 5 if h is at least p, choosing
+	// with a mask rather than a branch.
+	h2 += h1 >> 26
+	h1 &= mask26
+	h3 += h2 >> 26
+	h2 &= mask26
+	h4 += h3 >> 26
+	h3 &= mask26
+	h0 += (h4 >> 26) * 5
+	h4 &= mask26
+	h1 += h0 >> 26
+	h0 &= mask26
+
+	g0 := h0 + 5
+	g1 := h1 + g0>>26
+	g0 &= mask26
+	g2 := h2 + g1>>26
+	g1 &= mask26
+	g3 := h3 + g2>>26
+	g2 &= mask26
+	g4 := h4 + g3>>26 - 1<<26
+	g3 &= mask26
+	useG := (g4 >> 31) - 1
+	h0 = h0&^useG | g0&useG
+	h1 = h1&^useG | g1&useG
+	h2 = h2&^useG | g2&useG
+	h3 = h3&^useG | g3&useG
+	h4 = h4&^useG | g4&useG
+
+	// Add s, the second half of the key, modulo 2^128.
+	words := [4]uint32{h0 | h1<<26, h1>>6 | h2<<20, h2>>12 | h3<<14, h3>>18 | h4<<8}
+	tag := make([]byte, 16)
+	carry := uint64(0)
+	for i, word := range words {
+		carry += uint64(word) + uint64(binary.LittleEndian.Uint32(key[16+4*i:]))
+		binary.LittleEndian.PutUint32(tag[4*i:], uint32(carry))
+		carry >>= 32
+	}
+	return tag
+}
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+func blake2bCompress(h *[8]uint64, block []byte, counter uint64, final bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter
+	if final {
+		v[14] = ^v[14]
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] = v[a] + v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] = v[c] + v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] = v[a] + v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] = v[c] + v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for round := 0; round < 12; round++ {
+		s := blake2bSigma[round%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
+
+// blake2b is the unkeyed BLAKE2b digest of data, size bytes long.
+func blake2b(data []byte, size int) []byte {
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ uint64(size)
+	counter := uint64(0)
+	for len(data) > 128 {
+		counter += 128
+		blake2bCompress(&h, data[:128], counter, false)
+		data = data[128:]
+	}
+	var last [128]byte
+	copy(last[:], data)
+	blake2bCompress(&h, last[:], counter+uint64(len(data)), true)
+	digest := make([]byte, 0, 64)
+	for _, word := range h {
+		digest = binary.LittleEndian.AppendUint64(digest, word)
+	}
+	return digest[:size]
+}
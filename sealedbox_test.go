@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/subtle"
+	"encoding/hex"
+	"testing"
+)
+
+func fromHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func key32(t *testing.T, data []byte) *[32]byte {
+	t.Helper()
+	if len(data) != 32 {
+		t.Fatalf("expecting a 32 byte key, got %d bytes", len(data))
+	}
+	var key [32]byte
+	copy(key[:], data)
+	return &key
+}
+
+// TestPoly1305 checks the vector of RFC 8439 section 2.5.2, and tags
+// computed with libsodium's crypto_onetimeauth_poly1305, among them an
+// empty message and one whose accumulator reaches 2^130 - 5.
+func TestPoly1305(t *testing.T) {
+	sequence := func(from byte, n int) []byte {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = from + byte(i)
+		}
+		return data
+	}
+	for _, test := range []struct {
+		name    string
+		message []byte
+		key     []byte
+		tag     string
+	}{
+		{"RFC 8439", []byte("Cryptographic Forum Research Group"), fromHex(t, "85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b"), "a8061dc1305136c6c22b8baf0c0127a9"},
+		{"empty", nil, sequence(0, 32), "101112131415161718191a1b1c1d1e1f"},
+		{"all ones", bytes.Repeat([]byte{0xff}, 64), bytes.Repeat([]byte{0xff}, 32), "900fe32bc15fa8d7bca8efe4c7e37eb1"},
+		{"partial block", sequence(0, 47), sequence(100, 32), "285e1dcb958e12727653d58284f72ae7"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if tag := hex.EncodeToString(poly1305(test.message, key32(t, test.key))); tag != test.tag {
+				t.Errorf("expecting %s, got %s", test.tag, tag)
+			}
+		})
+	}
+}
+
+// TestBlake2b checks the vector of RFC 7693 appendix A, and digests computed
+// with Python's hashlib over messages of one and several blocks.
+func TestBlake2b(t *testing.T) {
+	long := make([]byte, 255)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		size   int
+		digest string
+	}{
+		{"RFC 7693", []byte("abc"), 64, "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+		{"empty", nil, 64, "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+		{"one full block", make([]byte, 128), 32, "378d0caaaa3855f1b38693c1d6ef004fd118691c95c959d4efa950d6d6fcf7c1"},
+		{"two blocks", long, 24, "70659e8f816c47e625d11b16b2480a2ef53c091dee2aaf9c"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if digest := hex.EncodeToString(blake2b(test.data, test.size)); digest != test.digest {
+				t.Errorf("expecting %s, got %s", test.digest, digest)
+			}
+		})
+	}
+}
+
+// TestHSalsa20 checks the first key derived in "Cryptography in NaCl"
+// section 8, from the X25519 shared secret of Alice and Bob.
+func TestHSalsa20(t *testing.T) {
+	var out [32]byte
+	hsalsa20(&out, &[16]byte{}, key32(t, fromHex(t, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")))
+	if expected := "1b27556473e985d462cd51197a9a46c76009549eac6474f206c4ee0844f68389"; hex.EncodeToString(out[:]) != expected {
+		t.Errorf("expecting %s, got %x", expected, out)
+	}
+}
+
+// TestSecretbox checks a box made with libsodium's crypto_secretbox_easy,
+// over several Salsa20 blocks.
+func TestSecretbox(t *testing.T) {
+	var key [32]byte
+	var nonce [24]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(i)
+	}
+	message := bytes.Repeat([]byte("attack at dawn, bring the secrets"), 3)
+	expected := "418b289792997240182d97b80ff39c673f8b4c2ea4a18271cf1ceb5f1fe166b730d72fb1eb054b87728214f136b5c495f0ba81a124d39e22d8f9c0fd1b11c56f03231af69df0fc3bd47a07ce7f02e590a8f39e6d7177aa863493f5f1ae12ffecdaf3b089fb7049d8ed50fb2a515e84dce42e77"
+	if box := hex.EncodeToString(secretbox(message, &nonce, &key)); box != expected {
+		t.Errorf("expecting %s, got %s", expected, box)
+	}
+}
+
+// openSealBox is crypto_box_seal_open, built on the primitives under test.
+func openSealBox(t *testing.T, sealed []byte, publicKey []byte, privateKey []byte) ([]byte, bool) {
+	t.Helper()
+	recipient, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var boxKey [32]byte
+	hsalsa20(&boxKey, &[16]byte{}, key32(t, shared))
+	var nonce [24]byte
+	copy(nonce[:], blake2b(append(append([]byte{}, sealed[:32]...), publicKey...), 24))
+	var subKey [32]byte
+	var hNonce [16]byte
+	copy(hNonce[:], nonce[:16])
+	hsalsa20(&subKey, &hNonce, &boxKey)
+	tag, ciphertext := sealed[32:48], sealed[48:]
+	stream := salsa20Stream(32+len(ciphertext), nonce[16:], &subKey)
+	if subtle.ConstantTimeCompare(poly1305(ciphertext, key32(t, stream[:32])), tag) != 1 {
+		return nil, false
+	}
+	message := make([]byte, len(ciphertext))
+	for i := range ciphertext {
+		message[i] = ciphertext[i] ^ stream[32+i]
+	}
+	return message, true
+}
+
+// The recipient key pair is libsodium's crypto_box_seed_keypair of 32
+// bytes 7, and sealedByLibsodium its crypto_box_seal of "ghp_secret_token".
+const (
+	recipientPublicKey  = "761d88ec830413919dfe9d4d1d56f17e653c8c994082df5b137b90a0ae6edf74"
+	recipientPrivateKey = "2fad39fefd7fa3e200a9c626eef599e61a2d055c48a8288a4e7e4c4bca3928f8"
+	sealedByLibsodium   = "51e72a4b0ac721cb3647c7e816093d5c56dead8e4335fa19ebaf52a8314de20ab804f8381c7eb7dd8c62311d74e11d5838f4e309d5537fa75062b27732f8db83"
+)
+
+func TestOpenLibsodiumSealBox(t *testing.T) {
+	message, ok := openSealBox(t, fromHex(t, sealedByLibsodium), fromHex(t, recipientPublicKey), fromHex(t, recipientPrivateKey))
+	if !ok || string(message) != "ghp_secret_token" {
+		t.Errorf("expecting ghp_secret_token, got %q (authenticated: %v)", message, ok)
+	}
+}
+
+func TestSealBox(t *testing.T) {
+	publicKey, privateKey := fromHex(t, recipientPublicKey), fromHex(t, recipientPrivateKey)
+	sealed, err := sealBox([]byte("ghp_secret_token"), publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != 48+len("ghp_secret_token") {
+		t.Fatalf("expecting %d bytes, got %d", 48+len("ghp_secret_token"), len(sealed))
+	}
+	message, ok := openSealBox(t, sealed, publicKey, privateKey)
+	if !ok || string(message) != "ghp_secret_token" {
+		t.Errorf("expecting ghp_secret_token, got %q (authenticated: %v)", message, ok)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, ok := openSealBox(t, sealed, publicKey, privateKey); ok {
+		t.Error("opened a tampered box")
+	}
+	if _, err := sealBox([]byte("x"), []byte("short")); err == nil {
+		t.Error("sealed for an invalid public key")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A .secretsignore in any folder of the project lists, in .gitignore syntax,
+// paths below it that discovery skips. Like in .gitignore, the last matching
+// line wins, the nearest .secretsignore is checked first, and files in a
+// skipped folder can't be brought back with !.
+const secretsIgnoreFileName string = ".secretsignore"
+
+type ignorePattern struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseSecretsIgnore(file string) ([]ignorePattern, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	patterns := []ignorePattern{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		line = trimUnescapedSpaces(line)
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		p.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		// .gitignore negates character classes with [!...], path.Match
+		// with [^...].
+		p.pattern = strings.ReplaceAll(line, "[!", "[^")
+		if p.pattern == "" {
+			continue
+		}
+		if _, err := path.Match(strings.ReplaceAll(p.pattern, "**", "*"), ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, lineNumber, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// trimUnescapedSpaces drops trailing spaces unless escaped with a backslash.
+func trimUnescapedSpaces(line string) string {
+	trimmed := strings.TrimRight(line, " ")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		return trimmed[:len(trimmed)-1] + " "
+	}
+	return trimmed
+}
+
+func (p ignorePattern) match(relativePath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anchored {
+		return matchGlob(p.pattern, relativePath)
+	}
+	ok, _ := path.Match(p.pattern, path.Base(relativePath))
+	return ok
+}
+
+// secretsIgnored tells whether a .secretsignore skips file, and which one.
+func secretsIgnored(file string, isDir bool) (string, bool) {
+	config, err := configFor(filepath.Dir(file))
+	if err != nil {
+		return "", false
+	}
+	for ; config != nil; config = config.parent {
+		relativePath, err := filepath.Rel(config.dir, file)
+		if err != nil {
+			continue
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		for i := len(config.ignores) - 1; i >= 0; i-- {
+			if config.ignores[i].match(relativePath, isDir) {
+				if config.ignores[i].negate {
+					return "", false
+				}
+				return filepath.Join(config.dir, secretsIgnoreFileName), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseSecretsIgnore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), secretsIgnoreFileName)
+	writeTestFile(t, file, []byte("# fixtures\n\nfixtures/\n!keep.yaml\n/app/*.yaml\r\nspace\\ \ntrailing   \n[!a]*.yml\n"), 0644)
+	patterns, err := parseSecretsIgnore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ignorePattern{
+		{pattern: "fixtures", dirOnly: true},
+		{pattern: "keep.yaml", negate: true},
+		{pattern: "app/*.yaml", anchored: true},
+		{pattern: "space "},
+		{pattern: "trailing"},
+		{pattern: "[^a]*.yml"},
+	}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, patterns)
+	}
+	writeTestFile(t, file, []byte("fixtures/\n[broken\n"), 0644)
+	if _, err := parseSecretsIgnore(file); err == nil || !strings.Contains(err.Error(), secretsIgnoreFileName+":2:") {
+		t.Errorf("expecting an error on line 2, got %v", err)
+	}
+}
+
+func TestIgnorePatternMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern ignorePattern
+		path    string
+		isDir   bool
+		match   bool
+	}{
+		{ignorePattern{pattern: "fixtures", dirOnly: true}, "app/fixtures", true, true},
+		{ignorePattern{pattern: "fixtures", dirOnly: true}, "app/fixtures", false, false},
+		{ignorePattern{pattern: "*.yml"}, "a/b/secret.yml", false, true},
+		{ignorePattern{pattern: "app/*.yaml", anchored: true}, "app/secret.yaml", false, true},
+		{ignorePattern{pattern: "app/*.yaml", anchored: true}, "lib/app/secret.yaml", false, false},
+	} {
+		if match := test.pattern.match(test.path, test.isDir); match != test.match {
+			t.Errorf("%+v on %q: expecting %v, got %v", test.pattern, test.path, test.match, match)
+		}
+	}
+}
+
+func TestFindFilesSkipsSecretsIgnored(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		ignores map[string]string
+		found   []string
+	}{
+		{"nothing ignored", nil, []string{"app/fixtures/secret.yaml", "app/secret.yaml", "lib/secret.yaml", "lib/test/secret.yaml"}},
+		{"folder", map[string]string{".": "fixtures/\n"}, []string{"app/secret.yaml", "lib/secret.yaml", "lib/test/secret.yaml"}},
+		{"anchored", map[string]string{"lib": "/test/secret.yaml\n"}, []string{"app/fixtures/secret.yaml", "app/secret.yaml", "lib/secret.yaml"}},
+		{"last line wins", map[string]string{".": "secret.yaml\n!secret.yaml\n"}, []string{"app/fixtures/secret.yaml", "app/secret.yaml", "lib/secret.yaml", "lib/test/secret.yaml"}},
+		{"nearest first", map[string]string{".": "secret.yaml\n", "lib": "!secret.yaml\n"}, []string{"lib/secret.yaml", "lib/test/secret.yaml"}},
+		{"no way back into ignored folders", map[string]string{".": "fixtures/\n", "app/fixtures": "!secret.yaml\n"}, []string{"app/secret.yaml", "lib/secret.yaml", "lib/test/secret.yaml"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			makeTree(t, root, "app/secret.yaml", "app/fixtures/secret.yaml", "lib/secret.yaml", "lib/test/secret.yaml")
+			for dir, content := range test.ignores {
+				writeTestFile(t, filepath.Join(root, dir, secretsIgnoreFileName), []byte(content), 0644)
+			}
+			found, err := findFiles(root, *regexp.MustCompile(`secret\.yaml$`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if relative := relativePaths(t, root, found); !reflect.DeepEqual(relative, test.found) {
+				t.Errorf("expecting %q, got %q", test.found, relative)
+			}
+		})
+	}
+}
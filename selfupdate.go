@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const releasesURL string = "https://api.github.com/repos/Jobbatical/secrets/releases/latest"
+const checksumsAsset string = "SHA256SUMS"
+const signatureAsset string = checksumsAsset + ".minisig"
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+func httpGet(url string) ([]byte, error) {
+	start := time.Now()
+	defer func() {
+		printDebugln("GET %s took %s", url, formatDuration(time.Since(start)))
+	}()
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// releaseBinary is the name build-all gives the binary for this platform.
+func releaseBinary() string {
+	name := fmt.Sprintf("secrets-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func (r *release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+// expectedChecksum finds the hex SHA-256 of file in a sha256sum listing.
+func expectedChecksum(checksums []byte, file string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == file {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", checksumsAsset, file)
+}
+
+// verifyMinisign checks a minisign signature of message by publicKey, the
+// base64 line of a minisign public key file, including its trusted comment.
+// Both the legacy Ed and the prehashed ED signatures are accepted.
+func verifyMinisign(publicKey string, message []byte, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != 2+8+ed25519.PublicKeySize || string(key[:2]) != "Ed" {
+		return errors.New("invalid minisign public key")
+	}
+	keyID, publicKeyBytes := key[2:10], ed25519.PublicKey(key[10:])
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid minisign signature file")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("invalid minisign trusted comment signature")
+	}
+	if !bytes.Equal(sig[2:10], keyID) {
+		return fmt.Errorf("signed with key %X, expecting %X", sig[2:10], keyID)
+	}
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		message = blake2b(message, 64)
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(publicKeyBytes, message, sig[10:]) {
+		return errors.New("invalid signature")
+	}
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(publicKeyBytes, append(append([]byte{}, sig[10:]...), trustedComment...), globalSig) {
+		return errors.New("invalid trusted comment signature")
+	}
+	return nil
+}
+
+// semanticVersion is a version such as 1.4.0 or 1.4.0-rc.1, with an
+// optional v in front and build metadata after a + ignored.
+type semanticVersion struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+func parseVersion(s string) (semanticVersion, error) {
+	var v semanticVersion
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, prerelease, hasPrerelease := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%q is not a version such as 1.4.0", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a version such as 1.4.0", s)
+		}
+		v.numbers[i] = n
+	}
+	if hasPrerelease {
+		v.prerelease = strings.Split(prerelease, ".")
+	}
+	return v, nil
+}
+
+// compare orders versions by semver precedence: -1, 0 or 1 as v is lower,
+// equal or higher than other. A prerelease is lower than its release.
+func (v semanticVersion) compare(other semanticVersion) int {
+	for i := range v.numbers {
+		if v.numbers[i] != other.numbers[i] {
+			return compareInts(v.numbers[i], other.numbers[i])
+		}
+	}
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		if a == b {
+			continue
+		}
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		switch {
+		case errA == nil && errB == nil:
+			return compareInts(na, nb)
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+	return compareInts(len(v.prerelease), len(other.prerelease))
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// replaceExecutable swaps the running binary for binary, writing next to it
+// first so the rename is atomic.
+func replaceExecutable(binary []byte) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(executable), ".secrets-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	// Windows can't replace a running executable, but can rename it.
+	if runtime.GOOS == "windows" {
+		old := executable + ".old"
+		os.Remove(old)
+		if err := os.Rename(executable, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), executable)
+}
+
+// selfUpdate installs the latest release when it is newer than this build,
+// once the release's checksums are signed by releasePublicKey and the binary
+// matches them.
+func selfUpdate() error {
+	current, err := parseVersion(version)
+	if err != nil {
+		return fmt.Errorf("secrets %s is a development build, install a release to update it: %w", version, err)
+	}
+	if releasePublicKey == "" {
+		return fmt.Errorf("secrets %s was built without a release public key to check updates against, update it by hand", version)
+	}
+	data, err := httpGet(releasesURL)
+	if err != nil {
+		return err
+	}
+	var latest release
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return err
+	}
+	latestVersion := strings.TrimPrefix(latest.TagName, "v")
+	parsed, err := parseVersion(latestVersion)
+	if err != nil {
+		return fmt.Errorf("latest release %s: %w", latest.TagName, err)
+	}
+	switch parsed.compare(current) {
+	case 0:
+		fmt.Printf("secrets %s is up to date\n", version)
+		return nil
+	case -1:
+		fmt.Printf("secrets %s is newer than the latest release %s, not updating\n", version, latestVersion)
+		return nil
+	}
+
+	binaryURL, err := latest.asset(releaseBinary())
+	if err != nil {
+		return err
+	}
+	checksumsURL, err := latest.asset(checksumsAsset)
+	if err != nil {
+		return err
+	}
+	signatureURL, err := latest.asset(signatureAsset)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("Would update secrets %s to %s from %s\n", version, latestVersion, binaryURL)
+		return nil
+	}
+	if !confirm(fmt.Sprintf("Update secrets %s to %s?", version, latestVersion)) {
+		return nil
+	}
+
+	checksums, err := httpGet(checksumsURL)
+	if err != nil {
+		return err
+	}
+	signature, err := httpGet(signatureURL)
+	if err != nil {
+		return err
+	}
+	if err := verifyMinisign(releasePublicKey, checksums, signature); err != nil {
+		return fmt.Errorf("%s of release %s: %w", checksumsAsset, latest.TagName, err)
+	}
+	expected, err := expectedChecksum(checksums, releaseBinary())
+	if err != nil {
+		return err
+	}
+	binary, err := httpGet(binaryURL)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(binary)
+	if actual := hex.EncodeToString(digest[:]); actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", releaseBinary(), expected, actual)
+	}
+	if err := replaceExecutable(binary); err != nil {
+		return fmt.Errorf("could not replace the secrets binary, reinstall it by hand: %w", err)
+	}
+	fmt.Printf("Updated secrets %s to %s\n", version, latestVersion)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// minisignFixture signs message the way minisign does, with algorithm Ed or
+// ED, and returns the public key line and the signature file.
+func minisignFixture(t *testing.T, algorithm string, message []byte) (string, []byte) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	signed := message
+	if algorithm == "ED" {
+		signed = blake2b(message, 64)
+	}
+	sig := append(append([]byte(algorithm), keyID...), ed25519.Sign(privateKey, signed)...)
+	trustedComment := "timestamp:1700000000\tfile:SHA256SUMS"
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig[10:]...), trustedComment...))
+	signature := fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(sig), trustedComment, base64.StdEncoding.EncodeToString(globalSig))
+	return base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...)), []byte(signature)
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	message := []byte("0123abcd  secrets-linux-amd64\n")
+	for _, algorithm := range []string{"Ed", "ED"} {
+		publicKey, signature := minisignFixture(t, algorithm, message)
+		if err := verifyMinisign(publicKey, message, signature); err != nil {
+			t.Errorf("%s: %s", algorithm, err)
+		}
+		if err := verifyMinisign(publicKey, []byte("tampered"), signature); err == nil {
+			t.Errorf("%s: accepted a signature of another message", algorithm)
+		}
+		otherKey, _ := minisignFixture(t, algorithm, message)
+		if err := verifyMinisign(otherKey, message, signature); err == nil {
+			t.Errorf("%s: accepted a signature by another key", algorithm)
+		}
+	}
+}
+
+func TestVerifyMinisignTrustedComment(t *testing.T) {
+	message := []byte("checksums")
+	publicKey, signature := minisignFixture(t, "ED", message)
+	tampered := strings.Replace(string(signature), "timestamp:1700000000", "timestamp:0", 1)
+	if err := verifyMinisign(publicKey, message, []byte(tampered)); err == nil {
+		t.Error("accepted a changed trusted comment")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.1", "1.2.0", "1.10.0", "2.0.0+build.5"}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := parseVersion(ordered[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := parseVersion(ordered[j])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := a.compare(b), compareInts(i, j); got != want {
+				t.Errorf("compare(%s, %s) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestParseVersionRejectsDevelopmentBuilds(t *testing.T) {
+	for _, v := range []string{"dev", "", "1.2", "1.2.x"} {
+		if _, err := parseVersion(v); err == nil {
+			t.Errorf("parseVersion(%q) succeeded", v)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// kmsPublicKey is the public half of an asymmetric KMS key version.
+// Algorithm is a KMS algorithm name such as EC_SIGN_P256_SHA256.
+type kmsPublicKey struct {
+	Pem       string `json:"pem"`
+	Algorithm string `json:"algorithm"`
+}
+
+// signatureHash is the digest an asymmetric signing algorithm signs, and
+// its name as KMS expects it.
+func signatureHash(algorithm string) (crypto.Hash, string, error) {
+	switch {
+	case strings.HasSuffix(algorithm, "_SHA256"):
+		return crypto.SHA256, "sha256", nil
+	case strings.HasSuffix(algorithm, "_SHA384"):
+		return crypto.SHA384, "sha384", nil
+	case strings.HasSuffix(algorithm, "_SHA512"):
+		return crypto.SHA512, "sha512", nil
+	}
+	return 0, "", fmt.Errorf("unsupported signing algorithm %s, expecting an EC_SIGN or RSA_SIGN key", algorithm)
+}
+
+func digestOf(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// signedContent is the envelope as signed: everything but the signature.
+func signedContent(e *envelope) []byte {
+	unsigned := *e
+	unsigned.Signature = nil
+	return unsigned.marshal()
+}
+
+var publicKeyMutex sync.Mutex
+var publicKeys = map[string]*kmsPublicKey{}
+
+func publicKey(versionName string) (*kmsPublicKey, error) {
+	publicKeyMutex.Lock()
+	k, ok := publicKeys[versionName]
+	publicKeyMutex.Unlock()
+	if ok {
+		return k, nil
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return nil, err
+	}
+	k, err = client.publicKey(versionName)
+	if err != nil {
+		return nil, explainKmsError(versionName, err)
+	}
+	publicKeyMutex.Lock()
+	publicKeys[versionName] = k
+	publicKeyMutex.Unlock()
+	return k, nil
+}
+
+// signingKeyVersion is the version to sign with: the one given, or else the
+// newest enabled version of the key.
+func signingKeyVersion(keyName string) (string, error) {
+	if strings.Contains(keyName, "/cryptoKeyVersions/") {
+		return keyName, nil
+	}
+	versions, err := listKeyVersions(keyName)
+	if err != nil {
+		return "", err
+	}
+	newest, newestNumber := "", 0
+	for _, v := range versions {
+		number, err := strconv.Atoi(versionNumber(v.Name))
+		if err == nil && v.State == "ENABLED" && number > newestNumber {
+			newest, newestNumber = v.Name, number
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("signing key %s has no enabled version", keyName)
+	}
+	return newest, nil
+}
+
+// signEnvelope signs e with keyName, recording the key version used in it.
+func signEnvelope(e *envelope, keyName string) error {
+	versionName, err := signingKeyVersion(keyName)
+	if err != nil {
+		return err
+	}
+	k, err := publicKey(versionName)
+	if err != nil {
+		return err
+	}
+	_, digestAlgorithm, err := signatureHash(k.Algorithm)
+	if err != nil {
+		return err
+	}
+	client, err := kmsClient()
+	if err != nil {
+		return err
+	}
+	e.SigningKey = versionName
+	signature, err := client.asymmetricSign(versionName, digestAlgorithm, signedContent(e))
+	if err != nil {
+		return explainKmsError(keyName, err)
+	}
+	e.Signature = signature
+	return nil
+}
+
+// signFor signs e when the configuration asks for the file to be signed.
+func signFor(ciphertextFile string, e *envelope) error {
+	keyName, err := fileSigningKey(ciphertextFile)
+	if err != nil {
+		return err
+	}
+	e.SigningKey, e.Signature = "", nil
+	if keyName == "" {
+		return nil
+	}
+	return signEnvelope(e, keyName)
+}
+
+func isSameSigningKey(versionName string, keyName string) bool {
+	if strings.Contains(keyName, "/cryptoKeyVersions/") {
+		return versionName == keyName
+	}
+	cryptoKey, _, _ := strings.Cut(versionName, "/cryptoKeyVersions/")
+	return isSameKey(cryptoKey, keyName)
+}
+
+// checkSignature verifies the signature of a .enc. Once a signing key is
+// configured for a file, unsigned envelopes and envelopes signed with any
+// other key are rejected; otherwise signatures are checked when present.
+func checkSignature(ciphertextFile string, e *envelope) error {
+	expected, err := fileSigningKey(ciphertextFile)
+	if err != nil {
+		return err
+	}
+	if e == nil || len(e.Signature) == 0 {
+		if expected != "" {
+			return fmt.Errorf("not signed, expecting a signature by %s", expected)
+		}
+		return nil
+	}
+	if expected != "" && !isSameSigningKey(e.SigningKey, expected) {
+		return fmt.Errorf("signed with %s, expecting %s", e.SigningKey, expected)
+	}
+	k, err := publicKey(e.SigningKey)
+	if err != nil {
+		return err
+	}
+	hash, _, err := signatureHash(k.Algorithm)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode([]byte(k.Pem))
+	if block == nil {
+		return fmt.Errorf("invalid public key for %s", e.SigningKey)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	digest := digestOf(hash, signedContent(e))
+	valid := false
+	switch pub := parsed.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest, e.Signature)
+	case *rsa.PublicKey:
+		if strings.Contains(k.Algorithm, "_PSS_") {
+			valid = rsa.VerifyPSS(pub, hash, digest, e.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		} else {
+			valid = rsa.VerifyPKCS1v15(pub, hash, digest, e.Signature) == nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T for %s", parsed, e.SigningKey)
+	}
+	if !valid {
+		return errors.New("invalid signature, the file was changed after it was signed")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSigningKey string = "projects/fake-project/locations/global/keyRings/secrets/cryptoKeys/signing"
+
+func TestSignatureHash(t *testing.T) {
+	for _, test := range []struct {
+		algorithm string
+		hash      crypto.Hash
+		name      string
+	}{
+		{"EC_SIGN_P256_SHA256", crypto.SHA256, "sha256"},
+		{"EC_SIGN_P384_SHA384", crypto.SHA384, "sha384"},
+		{"RSA_SIGN_PSS_4096_SHA512", crypto.SHA512, "sha512"},
+		{"GOOGLE_SYMMETRIC_ENCRYPTION", 0, ""},
+	} {
+		hash, name, err := signatureHash(test.algorithm)
+		if hash != test.hash || name != test.name || (err != nil) != (test.name == "") {
+			t.Errorf("%s: expecting %v %q, got %v %q (%v)", test.algorithm, test.hash, test.name, hash, name, err)
+		}
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		signedBy string
+		expected string
+		tamper   bool
+		err      string
+	}{
+		{"signed", testSigningKey, testSigningKey, false, ""},
+		{"signed without a signing key", testSigningKey, "", false, ""},
+		{"unsigned", "", "", false, ""},
+		{"not signed", "", testSigningKey, false, "not signed, expecting a signature by " + testSigningKey},
+		{"other key", testKey, testSigningKey, false, "expecting " + testSigningKey},
+		{"tampered", testSigningKey, testSigningKey, true, "invalid signature"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			ciphertextFile := filepath.Join(root, "secret.yaml.enc")
+			e := &envelope{Path: "secret.yaml", Key: testKey, Mode: 0600, Ciphertext: []byte("ciphertext")}
+			if test.signedBy != "" {
+				if err := signEnvelope(e, test.signedBy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.tamper {
+				e.Mode = 0644
+			}
+			if test.expected != "" {
+				writeConfigs(t, root, map[string]string{".": "signing-key: " + test.expected + "\n"})
+			}
+			err := checkSignature(ciphertextFile, e)
+			if test.err == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestSealSignsConfiguredFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{".": "signing-key: " + testSigningKey + "\n"})
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	e := readEnvelope(plaintextFile + ".enc")
+	if e == nil || !strings.HasPrefix(e.SigningKey, testSigningKey+"/cryptoKeyVersions/") || len(e.Signature) == 0 {
+		t.Fatalf("expecting the .enc signed with %s, got %+v", testSigningKey, e)
+	}
+	if err := os.Remove(plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(testKey, plaintextFile+".enc"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files matching a glob under split in .secrets.yaml are sealed as one .enc
+// per top-level key of their YAML, in a <file>.enc.d folder where their .enc
+// would be, with an index listing the fragments in order:
+//
+//	config/app-secret.yaml.enc.d/index
+//	config/app-secret.yaml.enc.d/database.enc
+//	config/app-secret.yaml.enc.d/stripe.enc
+//
+// Changing one value then only rewrites the .enc of its key, so reviews see
+// which entry changed. Each fragment authenticates its place in the index,
+// so that fragments can't be dropped, reordered or taken from another file. Fragments are ordinary .enc files that status,
+// verify, reseal-all and secrets.lock handle one by one; open, clean and ui
+// put them back together.
+
+const fragmentIndexName string = "index"
+
+// yamlFragment is a top-level key of a YAML file with its value, and the
+// comments above it.
+type yamlFragment struct {
+	name    string
+	content []byte
+}
+
+// isSplitFile reports whether plaintextFile is sealed in fragments.
+func isSplitFile(plaintextFile string) bool {
+	config, err := configFor(filepath.Dir(plaintextFile))
+	if err != nil {
+		return false
+	}
+	for ; config != nil; config = config.parent {
+		if _, ok := matchExcludes(config.Split, config.dir, plaintextFile); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func fragmentsDir(ciphertextFile string) string {
+	return ciphertextFile + ".d"
+}
+
+// isFragmented reports whether the .enc ciphertextFile was sealed in
+// fragments.
+func isFragmented(ciphertextFile string) bool {
+	return fileExists(filepath.Join(fragmentsDir(ciphertextFile), fragmentIndexName))
+}
+
+// sealedFileOf is the .enc that file is part of: file itself, or the .enc
+// that a fragment was split from.
+func sealedFileOf(file string) string {
+	if dir := filepath.Dir(file); strings.HasSuffix(dir, ".enc.d") && strings.HasSuffix(file, ".enc") {
+		return strings.TrimSuffix(dir, ".d")
+	}
+	return file
+}
+
+// foldFragments replaces the fragments among files by the .enc they are
+// part of.
+func foldFragments(files []string) []string {
+	result := make([]string, 0, len(files))
+	seen := map[string]bool{}
+	for _, file := range files {
+		file = sealedFileOf(file)
+		if !seen[file] {
+			seen[file] = true
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// splitYAML cuts a YAML mapping into its top-level keys, which start the
+// lines that aren't indented. Comments right above a key go with it, and
+// what comes before the first key goes with the first, so that the
+// fragments put together are the file as it was.
+func splitYAML(file string, data []byte) ([]yamlFragment, error) {
+	lines := strings.SplitAfter(string(data), "\n")
+	starts := []int{}
+	keys := []string{}
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || strings.ContainsRune(" \t#", rune(line[0])) {
+			continue
+		}
+		if strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...") {
+			if len(starts) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("%s: can't split a file of several YAML documents", file)
+		}
+		key, ok := topLevelKey(line)
+		if !ok {
+			return nil, fmt.Errorf("%s: only a YAML mapping can be split, line %d isn't a key", file, i+1)
+		}
+		start := i
+		for start > 0 && strings.HasPrefix(lines[start-1], "#") && (len(starts) == 0 || start-1 > starts[len(starts)-1]) {
+			start--
+		}
+		starts = append(starts, start)
+		keys = append(keys, key)
+	}
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("%s: only a YAML mapping can be split, it has no keys", file)
+	}
+	starts[0] = 0
+	fragments := make([]yamlFragment, 0, len(starts))
+	names := map[string]string{}
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		name := credentialNameCharacters.ReplaceAllString(keys[i], "-")
+		if strings.Trim(name, ".-") == "" {
+			return nil, fmt.Errorf("%s: can't name a fragment after key %q", file, keys[i])
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("%s: keys %q and %q would both be sealed as %s.enc", file, other, keys[i], name)
+		}
+		names[name] = keys[i]
+		fragments = append(fragments, yamlFragment{name + ".enc", []byte(strings.Join(lines[start:end], ""))})
+	}
+	return fragments, nil
+}
+
+// topLevelKey is the key a line of a YAML mapping starts with.
+func topLevelKey(line string) (string, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if line[0] == '"' || line[0] == '\'' {
+		end := strings.IndexByte(line[1:], line[0])
+		if end < 0 || !strings.HasPrefix(line[end+2:], ":") {
+			return "", false
+		}
+		return line[1 : end+1], true
+	}
+	if strings.ContainsRune("-[{&*!|>%@`", rune(line[0])) {
+		return "", false
+	}
+	if key, _, ok := strings.Cut(line, ": "); ok {
+		return strings.TrimSpace(key), true
+	}
+	if strings.HasSuffix(line, ":") {
+		return strings.TrimSpace(strings.TrimSuffix(line, ":")), true
+	}
+	return "", false
+}
+
+func readFragmentIndex(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, fragmentIndexName))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.ContainsAny(line, `/\`) || !strings.HasSuffix(line, ".enc") {
+			return nil, fmt.Errorf("%s: invalid fragment %q", filepath.Join(dir, fragmentIndexName), line)
+		}
+		names = append(names, line)
+	}
+	return names, nil
+}
+
+// fragmentNumber is the Fragment header of the i-th of count fragments.
+func fragmentNumber(i int, count int) string {
+	return fmt.Sprintf("%d/%d", i+1, count)
+}
+
+// sealFragments seals each top-level key of plaintextFile into its own .enc
+// in the fragments directory of ciphertextFile, numbered by its place in the
+// index, leaving those whose content and place didn't change alone, and
+// removes the fragments of keys that are gone and any .enc of the whole
+// file.
+func sealFragments(keyName string, plaintextFile string, ciphertextFile string, plaintext []byte, headers plaintextHeaders) error {
+	fragments, err := splitYAML(plaintextFile, plaintext)
+	if err != nil {
+		return err
+	}
+	dir := fragmentsDir(ciphertextFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	changed := false
+	index := []string{fmt.Sprintf("# fragments of %s in order, managed by `secrets`", filepath.Base(plaintextFile))}
+	kept := map[string]bool{}
+	for i, fragment := range fragments {
+		index = append(index, fragment.name)
+		kept[fragment.name] = true
+		fragmentFile := filepath.Join(dir, fragment.name)
+		fragmentHeaders := headers
+		fragmentHeaders.Fragment = fragmentNumber(i, len(fragments))
+		previous := readEnvelope(fragmentFile)
+		if !force && previous != nil && previous.PlaintextHash == "" && previous.Mode == headers.Mode && previous.Fragment == fragmentHeaders.Fragment && isSameKey(previous.Key, keyName) && hasFallback(previous) && sealedFileHolds(fragmentFile, fragment.content) {
+			continue
+		}
+		e, err := sealBytes(strings.TrimSuffix(ciphertextFile, ".enc"), keyName, fragment.content, fragmentHeaders, previous)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fragmentFile, err)
+		}
+		if err := writeEnvelope(fragmentFile, e); err != nil {
+			return err
+		}
+		printDebugln("sealed %s", fragmentFile)
+		changed = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".enc") && !kept[entry.Name()] {
+			if err := removeSealedFile(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+			changed = true
+		}
+	}
+	indexData := []byte(strings.Join(index, "\n") + "\n")
+	indexFile := filepath.Join(dir, fragmentIndexName)
+	if current, err := os.ReadFile(indexFile); err != nil || !bytes.Equal(current, indexData) {
+		if err := writeFileAtomically(indexFile, indexData, 0644); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if fileExists(ciphertextFile) {
+		if err := removeSealedFile(ciphertextFile); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		printProgress("%s is already up to date", plaintextFile)
+	}
+	return nil
+}
+
+// removeFragments removes the fragments of a file sealed whole again.
+func removeFragments(ciphertextFile string) error {
+	dir := fragmentsDir(ciphertextFile)
+	names, err := readFragmentIndex(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := removeSealedFile(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, fragmentIndexName)); err != nil {
+		return err
+	}
+	return os.Remove(dir)
+}
+
+// removeSealedFile removes a .enc and its entry in secrets.lock.
+func removeSealedFile(ciphertextFile string) error {
+	if err := os.Remove(ciphertextFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	printDebugln("removed %s", ciphertextFile)
+	return lockCiphertext(ciphertextFile, nil)
+}
+
+// readSealedFile decrypts ciphertextFile and checks its signature and path,
+// putting it together from its fragments if it was split. The envelope of a
+// split file is that of its first fragment, modified when the latest one
+// was.
+func readSealedFile(keyName string, ciphertextFile string) ([]byte, *envelope, error) {
+	if !isFragmented(ciphertextFile) {
+		return readSealedPart(keyName, ciphertextFile)
+	}
+	dir := fragmentsDir(ciphertextFile)
+	names, err := readFragmentIndex(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var plaintext []byte
+	var result *envelope
+	numbered := 0
+	for i, name := range names {
+		file := filepath.Join(dir, name)
+		content, e, err := readSealedPart(keyName, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if e != nil && e.Fragment != "" {
+			if e.Fragment != fragmentNumber(i, len(names)) {
+				return nil, nil, fmt.Errorf("%s: sealed as fragment %s, but listed as fragment %s in %s", file, e.Fragment, fragmentNumber(i, len(names)), filepath.Join(dir, fragmentIndexName))
+			}
+			numbered++
+		}
+		plaintext = append(plaintext, content...)
+		switch {
+		case e == nil:
+		case result == nil:
+			copied := *e
+			result = &copied
+		case e.ModifiedAt.After(result.ModifiedAt):
+			result.ModifiedAt = e.ModifiedAt
+		}
+	}
+	if numbered > 0 && numbered < len(names) {
+		return nil, nil, fmt.Errorf("%s: only %d of %d fragments are numbered, the others were sealed by an older version or taken from another file, run `secrets reseal-all` once they are checked", ciphertextFile, numbered, len(names))
+	}
+	return plaintext, result, nil
+}
+
+// fragmentPlace is the Fragment header to seal file with again: its place
+// in the index of the .enc it was split from, which must be the one it was
+// sealed with, if any. It is empty when file isn't a fragment.
+func fragmentPlace(file string, e *envelope) (string, error) {
+	if sealedFileOf(file) == file {
+		return "", nil
+	}
+	dir := filepath.Dir(file)
+	names, err := readFragmentIndex(dir)
+	if err != nil {
+		return "", err
+	}
+	for i, name := range names {
+		if name != filepath.Base(file) {
+			continue
+		}
+		place := fragmentNumber(i, len(names))
+		if e != nil && e.Fragment != "" && e.Fragment != place {
+			return "", fmt.Errorf("sealed as fragment %s, but listed as fragment %s in %s", e.Fragment, place, filepath.Join(dir, fragmentIndexName))
+		}
+		return place, nil
+	}
+	return "", fmt.Errorf("not listed in %s", filepath.Join(dir, fragmentIndexName))
+}
+
+// readSealedPart decrypts a .enc, or one of the fragments of a .enc.
+func readSealedPart(keyName string, file string) ([]byte, *envelope, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, e, err := decryptBytes(keyName, data)
+	if err == nil {
+		err = checkSignature(file, e)
+	}
+	if err == nil {
+		err = checkPath(file, e)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if hash, err := plaintextHash(plaintext); err == nil {
+		rememberSealedContent(file, data, hash)
+	}
+	return plaintext, e, nil
+}
+
+// fragmentsMatch reports whether plaintext is exactly what the fragments of
+// the .enc ciphertextFile hold.
+func fragmentsMatch(ciphertextFile string, plaintext []byte) bool {
+	fragments, err := splitYAML(ciphertextFile, plaintext)
+	if err != nil {
+		return false
+	}
+	dir := fragmentsDir(ciphertextFile)
+	names, err := readFragmentIndex(dir)
+	if err != nil || len(names) != len(fragments) {
+		return false
+	}
+	for i, fragment := range fragments {
+		if names[i] != fragment.name || !sealedFileHolds(filepath.Join(dir, names[i]), fragment.content) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTopLevelKey(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		key  string
+		ok   bool
+	}{
+		{"database: postgres\n", "database", true},
+		{"database:\n", "database", true},
+		{"database:\r\n", "database", true},
+		{"\"quoted key\": value\n", "quoted key", true},
+		{"'single: quoted': value\n", "single: quoted", true},
+		{"url: http://example.com\n", "url", true},
+		{"- item\n", "", false},
+		{"&anchor value\n", "", false},
+		{"\"unterminated: value\n", "", false},
+		{"plain scalar\n", "", false},
+	} {
+		key, ok := topLevelKey(test.line)
+		if key != test.key || ok != test.ok {
+			t.Errorf("topLevelKey(%q) = %q, %v, expecting %q, %v", test.line, key, ok, test.key, test.ok)
+		}
+	}
+}
+
+func TestSplitYAML(t *testing.T) {
+	data := "# Secrets of the app\n\ndatabase:\n  password: hunter2\n\n# The API\napi_token: abc\nsmtp/password: def\n"
+	fragments, err := splitYAML("secret.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []yamlFragment{
+		{"database.enc", []byte("# Secrets of the app\n\ndatabase:\n  password: hunter2\n\n")},
+		{"api_token.enc", []byte("# The API\napi_token: abc\n")},
+		{"smtp-password.enc", []byte("smtp/password: def\n")},
+	}
+	if len(fragments) != len(expected) {
+		t.Fatalf("expecting %d fragments, got %d", len(expected), len(fragments))
+	}
+	joined := ""
+	for i, fragment := range fragments {
+		if fragment.name != expected[i].name || string(fragment.content) != string(expected[i].content) {
+			t.Errorf("fragment %d: expecting %s %q, got %s %q", i, expected[i].name, expected[i].content, fragment.name, fragment.content)
+		}
+		joined += string(fragment.content)
+	}
+	if joined != data {
+		t.Errorf("the fragments put together are %q, expecting %q", joined, data)
+	}
+}
+
+func TestSplitYAMLErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"documents", "a: 1\n---\nb: 2\n", "several YAML documents"},
+		{"sequence", "- a\n- b\n", "isn't a key"},
+		{"empty", "# nothing\n", "has no keys"},
+		{"same fragment", "a/b: 1\na-b: 2\n", "would both be sealed as a-b.enc"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := splitYAML("secret.yaml", []byte(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestReadSealedFileChecksFragments(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		tamper func(t *testing.T, dir string, other string)
+		err    string
+	}{
+		{"untouched", func(t *testing.T, dir string, other string) {}, ""},
+		{"reordered", func(t *testing.T, dir string, other string) {
+			writeTestFile(t, filepath.Join(dir, fragmentIndexName), []byte("b.enc\na.enc\nc.enc\n"), 0644)
+		}, "sealed as fragment 2/3, but listed as fragment 1/3"},
+		{"dropped", func(t *testing.T, dir string, other string) {
+			writeTestFile(t, filepath.Join(dir, fragmentIndexName), []byte("a.enc\nc.enc\n"), 0644)
+		}, "sealed as fragment 1/3, but listed as fragment 1/2"},
+		{"dropped last", func(t *testing.T, dir string, other string) {
+			writeTestFile(t, filepath.Join(dir, fragmentIndexName), []byte("a.enc\nb.enc\n"), 0644)
+		}, "sealed as fragment 1/3, but listed as fragment 1/2"},
+		{"taken from another file", func(t *testing.T, dir string, other string) {
+			data, err := os.ReadFile(filepath.Join(fragmentsDir(other), "b.enc"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(dir, "b.enc"), data, 0644)
+		}, "sealed as other.yaml"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			writeTestFile(t, filepath.Join(root, ".secrets.yaml"), []byte("split:\n  - \"*.yaml\"\n"), 0644)
+			for _, name := range []string{"secret.yaml", "other.yaml"} {
+				plaintextFile := filepath.Join(root, name)
+				writeTestFile(t, plaintextFile, []byte("a: 1\nb: "+name+"\nc: 3\n"), 0600)
+				if err := encrypt(testKey, plaintextFile); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ciphertextFile := filepath.Join(root, "secret.yaml.enc")
+			test.tamper(t, fragmentsDir(ciphertextFile), filepath.Join(root, "other.yaml.enc"))
+			plaintext, _, err := readSealedFile(testKey, ciphertextFile)
+			if test.err == "" {
+				if err != nil || string(plaintext) != "a: 1\nb: secret.yaml\nc: 3\n" {
+					t.Errorf("expecting the sealed file, got %q (%v)", plaintext, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestResealKeepsFragmentsInPlace(t *testing.T) {
+	root := useFakeBackend(t)
+	writeTestFile(t, filepath.Join(root, ".secrets.yaml"), []byte("split:\n  - secret.yaml\n"), 0644)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("a: 1\nb: 2\n"), 0600)
+	if err := encrypt(testKey, plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	dir := fragmentsDir(plaintextFile + ".enc")
+	force = true
+	defer func() { force = false }()
+	if err := reseal(testKey, filepath.Join(dir, "b.enc")); err != nil {
+		t.Fatal(err)
+	}
+	if e := readEnvelope(filepath.Join(dir, "b.enc")); e == nil || e.Fragment != "2/2" {
+		t.Errorf("expecting the resealed fragment still 2/2, got %+v", e)
+	}
+	writeTestFile(t, filepath.Join(dir, fragmentIndexName), []byte("b.enc\na.enc\n"), 0644)
+	if err := reseal(testKey, filepath.Join(dir, "b.enc")); err == nil || !strings.Contains(err.Error(), "sealed as fragment 2/2") {
+		t.Errorf("expecting reseal to refuse moving the fragment, got %v", err)
+	}
+}
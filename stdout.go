@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// --stdout keeps secrets off the filesystem in pipelines: open writes the
+// plaintext of a .enc to stdout, and seal writes the .enc of a file to
+// stdout. Either reads from stdin when given no file, in which case rules in
+// .secrets.yaml apply as for a file in the current folder.
+var toStdout bool
+
+func stdinName() (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cwd, "-"), nil
+}
+
+func readStdoutInput(files []string) (string, []byte, error) {
+	if len(files) > 1 {
+		return "", nil, fmt.Errorf("--stdout takes a single file, got %d", len(files))
+	}
+	if outPath != "" || outDir != "" {
+		return "", nil, errors.New("--stdout can't be used with --out or --out-dir")
+	}
+	if len(files) == 1 {
+		data, err := os.ReadFile(files[0])
+		return files[0], data, err
+	}
+	name, err := stdinName()
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := io.ReadAll(os.Stdin)
+	return name, data, err
+}
+
+func openToStdout(files []string) error {
+	ciphertextFile, data, err := readStdoutInput(files)
+	if err != nil || dryRun {
+		return err
+	}
+	plaintext, e, err := openBytes(ciphertextFile, data)
+	recordAudit(commandName, ciphertextFile, err)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ciphertextFile, err)
+	}
+	if e != nil {
+		warnIfStale(ciphertextFile, e)
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
+
+func sealToStdout(files []string) error {
+	plaintextFile, plaintext, err := readStdoutInput(files)
+	if err != nil || dryRun {
+		return err
+	}
+	headers := plaintextHeaders{}
+	if len(files) == 1 {
+		info, err := os.Stat(plaintextFile)
+		if err != nil {
+			return err
+		}
+		headers = plaintextHeaders{Path: projectPath(plaintextFile), Mode: info.Mode().Perm(), ModifiedAt: info.ModTime()}
+	}
+	err = checkPolicy("seal", plaintextFile)
+	var e *envelope
+	if err == nil {
+		e, err = sealBytes(plaintextFile, fileKey(plaintextFile), plaintext, headers, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", plaintextFile, err)
+	}
+	if err := signFor(plaintextFile+".enc", e); err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(e.marshal())
+	return err
+}
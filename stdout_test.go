@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useStdin makes content what the test reads from stdin.
+func useStdin(t *testing.T, content []byte) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "stdin")
+	writeTestFile(t, file, content, 0600)
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+	})
+}
+
+func TestSealAndOpenThroughStdout(t *testing.T) {
+	root := useFakeBackend(t)
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	content := []byte("password: hunter2\n")
+	useStdin(t, content)
+	sealed := captureStdout(t, func() {
+		if err := sealToStdout(nil); err != nil {
+			t.Error(err)
+		}
+	})
+	if bytes.Contains([]byte(sealed), content) {
+		t.Fatal("the .enc holds the plaintext")
+	}
+	useStdin(t, []byte(sealed))
+	opened := captureStdout(t, func() {
+		if err := openToStdout(nil); err != nil {
+			t.Error(err)
+		}
+	})
+	if opened != string(content) {
+		t.Errorf("expecting %q, got %q", content, opened)
+	}
+	if entries, err := os.ReadDir(root); err != nil || len(entries) != 0 {
+		t.Errorf("expecting nothing written to the project, got %v (%v)", entries, err)
+	}
+}
+
+func TestReadStdoutInputErrors(t *testing.T) {
+	defer func() { outPath, outDir = "", "" }()
+	for _, test := range []struct {
+		files  []string
+		outDir string
+		err    string
+	}{
+		{[]string{"a.enc", "b.enc"}, "", "single file, got 2"},
+		{nil, "/run/secrets", "can't be used with --out"},
+	} {
+		outDir = test.outDir
+		if _, _, err := readStdoutInput(test.files); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q with --out-dir %q: expecting an error with %q, got %v", test.files, test.outDir, test.err, err)
+		}
+	}
+}
+
+func TestProgressGoesToStderrWithStdout(t *testing.T) {
+	toStdout = true
+	defer func() { toStdout = false }()
+	var stderr string
+	stdout := captureStdout(t, func() {
+		stderr = captureStderr(t, func() {
+			printProgress("sealing %s", "secret.yaml")
+		})
+	})
+	if stdout != "" || !strings.Contains(stderr, "sealing secret.yaml") {
+		t.Errorf("expecting progress on stderr only, got %q on stdout and %q on stderr", stdout, stderr)
+	}
+}
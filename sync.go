@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sync keeps a system that serves secrets at runtime in lockstep with the
+// sealed files, which stay the source of truth. Targets are secret stores
+// picked by name and set up with flags; pushing exports the values of the
+// files to it, and --pull, for targets that can be read, imports them back.
+
+var syncPull bool
+var syncRepo string
+var syncGroup string
+var syncEnvironment string
+
+func syncStore(target string) (secretStore, string, error) {
+	switch target {
+	case "vault":
+		if vaultMount == "" || vaultPath == "" {
+			return nil, "", errors.New("sync vault expects --mount and --path")
+		}
+		return &vaultStore{mount: strings.Trim(vaultMount, "/")}, strings.Trim(vaultPath, "/"), nil
+	case "github":
+		if syncRepo == "" {
+			return nil, "", errors.New("sync github expects --repo <owner>/<name>")
+		}
+		return &githubStore{environment: syncEnvironment}, syncRepo, nil
+	case "gitlab":
+		if (syncRepo == "") == (syncGroup == "") {
+			return nil, "", errors.New("sync gitlab expects either --repo <project path> or --group <group path>")
+		}
+		if syncGroup != "" {
+			return &gitlabStore{kind: "groups", environment: syncEnvironment}, syncGroup, nil
+		}
+		return &gitlabStore{kind: "projects", environment: syncEnvironment}, syncRepo, nil
+	case "cloudrun", "cloudfunctions":
+		if syncService == "" {
+			return nil, "", fmt.Errorf("sync %s expects --service <name>", target)
+		}
+		if target == "cloudrun" {
+			return &cloudStore{api: "run", collection: "services"}, syncService, nil
+		}
+		return &cloudStore{api: "cloudfunctions", collection: "functions"}, syncService, nil
+	}
+	return nil, "", fmt.Errorf("unsupported sync target %q, expecting vault, github, gitlab, cloudrun or cloudfunctions", target)
+}
+
+func syncSecrets(target string, files []string) error {
+	store, ref, err := syncStore(target)
+	if err != nil {
+		return err
+	}
+	if syncPull {
+		return pullSecrets(store, target+" "+ref, ref, files)
+	}
+	return pushSecrets(store, target+" "+ref, ref, files)
+}
+
+// jsonRequest calls a JSON HTTP API, decoding the response into result.
+func jsonRequest(method string, url string, headers map[string]string, body interface{}, result interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, url, payload)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	start := time.Now()
+	response, err := httpClient.Do(request)
+	printDebugln("%s %s took %s", method, url, formatDuration(time.Since(start)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		printDebugln("%s %s failed: %s", method, url, data)
+		return &httpError{StatusCode: response.StatusCode, Status: response.Status, Body: strings.TrimSpace(string(data))}
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+type httpError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *httpError) Error() string {
+	if e.Body == "" {
+		return e.Status
+	}
+	return fmt.Sprintf("%s: %s", e.Status, e.Body)
+}
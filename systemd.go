@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// systemd-creds hands sealed files to services through systemd credentials
+// instead of environment variables. By default each file is encrypted again
+// with systemd-creds, for the host's TPM or credential key, into a drop-in of
+// SetCredentialEncrypted= lines, so plaintext is never written. With
+// --out-dir, the plaintext is written there instead, e.g. to /run/credstore,
+// and the drop-in loads it with LoadCredential=.
+
+var credentialNameCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func credentialName(ciphertextFile string) string {
+	return credentialNameCharacters.ReplaceAllString(filepath.Base(strings.TrimSuffix(ciphertextFile, ".enc")), "_")
+}
+
+func systemdCredentials(files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no sealed files to turn into credentials")
+	}
+	var b strings.Builder
+	b.WriteString("# Written by secrets, install as /etc/systemd/system/<unit>.d/secrets.conf\n[Service]\n")
+	for _, file := range files {
+		name := credentialName(file)
+		if dryRun {
+			printProgress("would add credential %s from %s", name, file)
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		plaintext, _, err := openBytes(file, data)
+		recordAudit(commandName, file, err)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if outDir != "" {
+			path := filepath.Join(outDir, name)
+			if err := os.MkdirAll(outDir, 0700); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, plaintext, 0600); err != nil {
+				return err
+			}
+			fmt.Fprintf(&b, "LoadCredential=%s:%s\n", name, path)
+			continue
+		}
+		_, stdOut, stdErr, err := runCommandWithInput(plaintext, "systemd-creds", "encrypt", "--pretty", "--name="+name, "-", "-")
+		if err != nil {
+			return fmt.Errorf("systemd-creds encrypt failed for %s: %s", file, strings.TrimSpace(stdErr))
+		}
+		b.WriteString(stdOut)
+		if !strings.HasSuffix(stdOut, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	if outPath == "" {
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, []byte(b.String()), 0600)
+}
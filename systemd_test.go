@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCredentialName(t *testing.T) {
+	for file, name := range map[string]string{
+		"/app/db-secret.yaml.enc":     "db-secret.yaml",
+		"/app/api key.json.enc":       "api_key.json",
+		"/app/config/smtp:prod.enc":   "smtp_prod",
+		"/app/tls.d/server.pem.enc":   "server.pem",
+		"/app/weird/na$me!.yaml.enc":  "na_me_.yaml",
+		"/app/already_fine-1.0.0.enc": "already_fine-1.0.0",
+	} {
+		if got := credentialName(file); got != name {
+			t.Errorf("%s: expecting %s, got %s", file, name, got)
+		}
+	}
+}
+
+func sealTestFiles(t *testing.T, root string, names ...string) []string {
+	t.Helper()
+	files := []string{}
+	for _, name := range names {
+		file := filepath.Join(root, name)
+		writeTestFile(t, file, []byte("password: "+name+"\n"), 0600)
+		if err := encrypt(testKey, file); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file+".enc")
+	}
+	return files
+}
+
+func TestSystemdCredentialsToOutDir(t *testing.T) {
+	root := useFakeBackend(t)
+	files := sealTestFiles(t, root, "db-secret.yaml", "api-secret.yaml")
+	credstore := filepath.Join(t.TempDir(), "credstore")
+	outDir, outPath = credstore, filepath.Join(t.TempDir(), "app.service.d", "secrets.conf")
+	defer func() { outDir, outPath = "", "" }()
+	if err := systemdCredentials(files); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Written by secrets, install as /etc/systemd/system/<unit>.d/secrets.conf\n[Service]\n" +
+		"LoadCredential=db-secret.yaml:" + filepath.Join(credstore, "db-secret.yaml") + "\n" +
+		"LoadCredential=api-secret.yaml:" + filepath.Join(credstore, "api-secret.yaml") + "\n"
+	if data, err := os.ReadFile(outPath); err != nil || string(data) != expected {
+		t.Errorf("expecting %q, got %q (%v)", expected, data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(credstore, "db-secret.yaml")); err != nil || string(data) != "password: db-secret.yaml\n" {
+		t.Errorf("expecting the plaintext in the credential store, got %q (%v)", data, err)
+	}
+}
+
+func TestSystemdCredentialsEncrypted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake systemd-creds is a shell script")
+	}
+	root := useFakeBackend(t)
+	files := sealTestFiles(t, root, "db-secret.yaml")
+	bin := t.TempDir()
+	// Like systemd-creds encrypt --pretty, without a trailing newline.
+	script := "#!/bin/sh\nname=\"${3#--name=}\"\nprintf 'SetCredentialEncrypted=%s: %s' \"$name\" \"$(cat | tr -d '\\n')\"\n"
+	writeTestFile(t, filepath.Join(bin, "systemd-creds"), []byte(script), 0755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	output := captureStdout(t, func() {
+		if err := systemdCredentials(files); err != nil {
+			t.Error(err)
+		}
+	})
+	expected := "# Written by secrets, install as /etc/systemd/system/<unit>.d/secrets.conf\n[Service]\n" +
+		"SetCredentialEncrypted=db-secret.yaml: password: db-secret.yaml\n"
+	if output != expected {
+		t.Errorf("expecting %q, got %q", expected, output)
+	}
+}
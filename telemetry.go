@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With OTEL_EXPORTER_OTLP_ENDPOINT, or the endpoint of traces or metrics,
+// set as for any OpenTelemetry SDK, a run is exported as a trace with a span
+// per KMS call, external command and file walk under a span for the command,
+// along with metrics of their counts and durations. They are sent with OTLP
+// over HTTP as JSON when the run exits, and a TRACEPARENT from the pipeline
+// makes the run part of the pipeline's trace. Exporting never fails a run.
+
+type telemetrySpan struct {
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	category   string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+var telemetryMutex sync.Mutex
+var telemetrySpans []*telemetrySpan
+var commandSpan *telemetrySpan
+var telemetryStart = time.Now()
+
+// durationBounds are the bucket bounds, in seconds, of the duration
+// histogram.
+var durationBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func telemetryEndpoint(signal string) string {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_"+strings.ToUpper(signal)+"_EXPORTER") == "none" {
+		return ""
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/" + signal
+	}
+	return ""
+}
+
+func telemetryEnabled() bool {
+	return telemetryEndpoint("traces") != "" || telemetryEndpoint("metrics") != ""
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		return strings.Repeat("0", 2*size)
+	}
+	return hex.EncodeToString(id)
+}
+
+// startCommandSpan starts the span of the whole run, in the trace of
+// TRACEPARENT when it's set.
+func startCommandSpan(name string) {
+	if !telemetryEnabled() {
+		return
+	}
+	commandSpan = &telemetrySpan{traceID: randomID(16), spanID: randomID(8), name: name, category: "command", start: telemetryStart}
+	// version-traceid-parentid-flags
+	if parts := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		commandSpan.traceID, commandSpan.parentID = parts[1], parts[2]
+	}
+}
+
+// startSpan starts a span under the command's, or returns nil when nothing
+// is exported. Spans are ended with endSpan.
+func startSpan(name string, category string, attributes map[string]string) *telemetrySpan {
+	if commandSpan == nil {
+		return nil
+	}
+	return &telemetrySpan{
+		traceID:    commandSpan.traceID,
+		spanID:     randomID(8),
+		parentID:   commandSpan.spanID,
+		name:       name,
+		category:   category,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+}
+
+func endSpan(span *telemetrySpan, err error) {
+	if span == nil {
+		return
+	}
+	span.end = time.Now()
+	span.err = err
+	telemetryMutex.Lock()
+	defer telemetryMutex.Unlock()
+	telemetrySpans = append(telemetrySpans, span)
+}
+
+func flushTelemetry(code int) {
+	if commandSpan == nil {
+		return
+	}
+	commandSpan.end = time.Now()
+	commandSpan.attributes = map[string]string{"process.exit.code": strconv.Itoa(code)}
+	if code != 0 {
+		commandSpan.err = &exitError{code}
+	}
+	telemetryMutex.Lock()
+	spans := append([]*telemetrySpan{commandSpan}, telemetrySpans...)
+	telemetryMutex.Unlock()
+	commandSpan = nil
+	if endpoint := telemetryEndpoint("traces"); endpoint != "" {
+		exportTelemetry("traces", endpoint, tracesPayload(spans))
+	}
+	if endpoint := telemetryEndpoint("metrics"); endpoint != "" {
+		exportTelemetry("metrics", endpoint, metricsPayload(spans[1:]))
+	}
+}
+
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return "exit code " + strconv.Itoa(e.code)
+}
+
+// telemetryHeaders parses OTEL_EXPORTER_OTLP_HEADERS and the headers of
+// signal, name=value pairs separated by commas with URL-encoded values.
+func telemetryHeaders(signal string) map[string]string {
+	headers := map[string]string{}
+	for _, variable := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_HEADERS"} {
+		for _, pair := range strings.Split(os.Getenv(variable), ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				continue
+			}
+			if unescaped, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+				value = unescaped
+			}
+			headers[strings.TrimSpace(name)] = value
+		}
+	}
+	return headers
+}
+
+func exportTelemetry(signal string, endpoint string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		printDebugln("could not export %s: %s", signal, err)
+		return
+	}
+	request, err := http.NewRequest("POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		printDebugln("could not export %s: %s", signal, err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range telemetryHeaders(signal) {
+		request.Header.Set(name, value)
+	}
+	timeout := 10 * time.Second
+	if milliseconds, err := strconv.Atoi(os.Getenv("OTEL_EXPORTER_OTLP_TIMEOUT")); err == nil {
+		timeout = time.Duration(milliseconds) * time.Millisecond
+	}
+	response, err := (&http.Client{Timeout: timeout}).Do(request)
+	if err != nil {
+		errPrintln("Warning: could not export %s to %s: %s", signal, endpoint, err)
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		errPrintln("Warning: could not export %s to %s: %s", signal, endpoint, response.Status)
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]otlpAttribute, 0, len(names))
+	for _, name := range names {
+		result = append(result, otlpAttribute{name, otlpValue{attributes[name]}})
+	}
+	return result
+}
+
+// telemetryResource describes the process, with OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES.
+func telemetryResource() map[string]interface{} {
+	attributes := map[string]string{"service.name": "secrets", "service.version": version}
+	for _, pair := range strings.Split(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"), ",") {
+		if name, value, ok := strings.Cut(pair, "="); ok {
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
+			}
+			attributes[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		attributes["service.name"] = name
+	}
+	return map[string]interface{}{"attributes": otlpAttributes(attributes)}
+}
+
+func telemetryScope() map[string]string {
+	return map[string]string{"name": "secrets", "version": version}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func tracesPayload(spans []*telemetrySpan) interface{} {
+	otlpSpans := []map[string]interface{}{}
+	for _, span := range spans {
+		attributes := map[string]string{"secrets.category": span.category}
+		for name, value := range span.attributes {
+			attributes[name] = value
+		}
+		otlpSpan := map[string]interface{}{
+			"traceId":           span.traceID,
+			"spanId":            span.spanID,
+			"name":              span.name,
+			"kind":              1,
+			"startTimeUnixNano": unixNano(span.start),
+			"endTimeUnixNano":   unixNano(span.end),
+			"attributes":        otlpAttributes(attributes),
+		}
+		if span.parentID != "" {
+			otlpSpan["parentSpanId"] = span.parentID
+		}
+		// KMS calls and external commands are client spans.
+		if span.category != "command" && span.category != "walk" {
+			otlpSpan["kind"] = 3
+		}
+		if span.err != nil {
+			otlpSpan["status"] = map[string]interface{}{"code": 2, "message": span.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   telemetryResource(),
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": telemetryScope(), "spans": otlpSpans}},
+		}},
+	}
+}
+
+type durationHistogram struct {
+	attributes map[string]string
+	count      int
+	sum        float64
+	min        float64
+	max        float64
+	buckets    []int
+}
+
+func newDurationHistogram(attributes map[string]string) *durationHistogram {
+	return &durationHistogram{attributes: attributes, buckets: make([]int, len(durationBounds)+1)}
+}
+
+func (h *durationHistogram) observe(seconds float64) {
+	if h.count == 0 || seconds < h.min {
+		h.min = seconds
+	}
+	if seconds > h.max {
+		h.max = seconds
+	}
+	h.count++
+	h.sum += seconds
+	h.buckets[sort.SearchFloat64s(durationBounds, seconds)]++
+}
+
+// metricsPayload counts the spans of each category and outcome, with a
+// histogram of their durations.
+func metricsPayload(spans []*telemetrySpan) interface{} {
+	histograms := map[string]*durationHistogram{}
+	keys := []string{}
+	for _, span := range spans {
+		outcome := "ok"
+		if span.err != nil {
+			outcome = "error"
+		}
+		key := span.category + "\x00" + outcome
+		histogram, ok := histograms[key]
+		if !ok {
+			histogram = newDurationHistogram(map[string]string{"secrets.category": span.category, "secrets.outcome": outcome})
+			histograms[key] = histogram
+			keys = append(keys, key)
+		}
+		histogram.observe(span.end.Sub(span.start).Seconds())
+	}
+	sort.Strings(keys)
+	now := unixNano(time.Now())
+	start := unixNano(telemetryStart)
+	counts := []interface{}{}
+	durations := []interface{}{}
+	for _, key := range keys {
+		histogram := histograms[key]
+		attributes := otlpAttributes(histogram.attributes)
+		bucketCounts := make([]string, len(histogram.buckets))
+		for i, count := range histogram.buckets {
+			bucketCounts[i] = strconv.Itoa(count)
+		}
+		counts = append(counts, map[string]interface{}{
+			"attributes": attributes, "startTimeUnixNano": start, "timeUnixNano": now, "asInt": strconv.Itoa(histogram.count),
+		})
+		durations = append(durations, map[string]interface{}{
+			"attributes": attributes, "startTimeUnixNano": start, "timeUnixNano": now,
+			"count": strconv.Itoa(histogram.count), "sum": histogram.sum, "min": histogram.min, "max": histogram.max,
+			"bucketCounts": bucketCounts, "explicitBounds": durationBounds,
+		})
+	}
+	metrics := []interface{}{
+		map[string]interface{}{
+			"name": "secrets.operations", "unit": "{operation}", "description": "KMS calls, external commands and file walks",
+			"sum": map[string]interface{}{"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": counts},
+		},
+		map[string]interface{}{
+			"name": "secrets.operation.duration", "unit": "s", "description": "Duration of KMS calls, external commands and file walks",
+			"histogram": map[string]interface{}{"aggregationTemporality": 2, "dataPoints": durations},
+		},
+	}
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     telemetryResource(),
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": telemetryScope(), "metrics": metrics}},
+		}},
+	}
+}
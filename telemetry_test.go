@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTelemetryEndpoint(t *testing.T) {
+	for _, test := range []struct {
+		env     map[string]string
+		traces  string
+		metrics string
+	}{
+		{map[string]string{}, "", ""},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318/"}, "http://collector:4318/v1/traces", "http://collector:4318/v1/metrics"},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://tempo/otlp"}, "http://tempo/otlp", "http://collector:4318/v1/metrics"},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_METRICS_EXPORTER": "none"}, "http://collector:4318/v1/traces", ""},
+		{map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_SDK_DISABLED": "true"}, "", ""},
+	} {
+		for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_METRICS_EXPORTER", "OTEL_SDK_DISABLED"} {
+			t.Setenv(name, test.env[name])
+		}
+		if traces, metrics := telemetryEndpoint("traces"), telemetryEndpoint("metrics"); traces != test.traces || metrics != test.metrics {
+			t.Errorf("%v: expecting %q and %q, got %q and %q", test.env, test.traces, test.metrics, traces, metrics)
+		}
+	}
+}
+
+func TestTelemetryHeaders(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=abc%3D%3D, x-team = payments,broken")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS", "x-team=platform")
+	expected := map[string]string{"api-key": "abc==", "x-team": "platform"}
+	if headers := telemetryHeaders("traces"); !reflect.DeepEqual(headers, expected) {
+		t.Errorf("expecting %v, got %v", expected, headers)
+	}
+}
+
+func TestDurationHistogram(t *testing.T) {
+	h := newDurationHistogram(nil)
+	for _, seconds := range []float64{0.2, 0.003, 0.25, 30} {
+		h.observe(seconds)
+	}
+	if h.count != 4 || h.min != 0.003 || h.max != 30 {
+		t.Errorf("expecting 4 durations from 0.003 to 30, got %+v", h)
+	}
+	expected := []int{1, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 1}
+	if !reflect.DeepEqual(h.buckets, expected) {
+		t.Errorf("expecting buckets %v, got %v", expected, h.buckets)
+	}
+}
+
+func TestFlushTelemetry(t *testing.T) {
+	var mutex sync.Mutex
+	received := map[string]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		payload := map[string]interface{}{}
+		json.Unmarshal(data, &payload)
+		mutex.Lock()
+		received[r.URL.Path] = payload
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	t.Setenv("TRACEPARENT", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	defer func() { commandSpan, telemetrySpans = nil, nil }()
+	startCommandSpan("secrets seal")
+	endSpan(startSpan("gcloud kms encrypt", "kms", map[string]string{"secrets.key": "test"}), nil)
+	endSpan(startSpan("gcloud kms decrypt", "kms", nil), errors.New("PERMISSION_DENIED"))
+	flushTelemetry(1)
+	if commandSpan != nil {
+		t.Error("expecting the command span ended")
+	}
+	spans := received["/v1/traces"]["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 3 {
+		t.Fatalf("expecting 3 spans, got %d", len(spans))
+	}
+	command := spans[0].(map[string]interface{})
+	if command["traceId"] != "0af7651916cd43dd8448eb211c80319c" || command["parentSpanId"] != "b7ad6b7169203331" || command["kind"] != 1.0 || command["status"] == nil {
+		t.Errorf("expecting the failed command span in the pipeline's trace, got %v", command)
+	}
+	for _, item := range spans[1:] {
+		span := item.(map[string]interface{})
+		if span["traceId"] != command["traceId"] || span["parentSpanId"] != command["spanId"] || span["kind"] != 3.0 {
+			t.Errorf("expecting a client span under the command, got %v", span)
+		}
+	}
+	if spans[1].(map[string]interface{})["status"] != nil || spans[2].(map[string]interface{})["status"] == nil {
+		t.Error("expecting only the failed KMS call with an error status")
+	}
+	metrics := received["/v1/metrics"]["resourceMetrics"].([]interface{})[0].(map[string]interface{})["scopeMetrics"].([]interface{})[0].(map[string]interface{})["metrics"].([]interface{})
+	counts := metrics[0].(map[string]interface{})["sum"].(map[string]interface{})["dataPoints"].([]interface{})
+	if len(counts) != 2 {
+		t.Errorf("expecting counts of failed and successful KMS calls, got %v", counts)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type timingStats struct {
+	calls int
+	total time.Duration
+	max   time.Duration
+}
+
+var timingsMutex sync.Mutex
+var timings = map[string]*timingStats{}
+
+func recordTiming(category string, elapsed time.Duration) {
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+	stats, ok := timings[category]
+	if !ok {
+		stats = &timingStats{}
+		timings[category] = stats
+	}
+	stats.calls++
+	stats.total += elapsed
+	if elapsed > stats.max {
+		stats.max = elapsed
+	}
+}
+
+// commandLabel names an external command by its subcommands, e.g.
+// "gcloud kms encrypt" or "git check-ignore", skipping flags and their values.
+func commandLabel(name string, args []string) string {
+	words := []string{name}
+	for i := 0; i < len(args) && len(words) < 3; i++ {
+		if strings.HasPrefix(args[i], "-") {
+			if args[i] == "-C" {
+				i++
+			}
+			continue
+		}
+		words = append(words, args[i])
+		if name == "git" {
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// timingCategory groups calls for the summary: KMS, git or anything else.
+func timingCategory(name string, args []string) string {
+	if name == "gcloud" && len(args) > 0 && args[0] == "kms" {
+		return "kms"
+	}
+	return name
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func printTimings() {
+	if !verbose {
+		return
+	}
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+	categories := make([]string, 0, len(timings))
+	for category := range timings {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		stats := timings[category]
+		printDebugln(
+			"timing: %s %d call(s), %s total, %s average, %s max",
+			category, stats.calls, formatDuration(stats.total),
+			formatDuration(stats.total/time.Duration(stats.calls)), formatDuration(stats.max),
+		)
+	}
+}
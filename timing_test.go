@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommandLabel(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		args     []string
+		label    string
+		category string
+	}{
+		{"gcloud", []string{"kms", "encrypt", "--key", "test"}, "gcloud kms encrypt", "kms"},
+		{"gcloud", []string{"config", "get-value", "project"}, "gcloud config get-value", "gcloud"},
+		{"git", []string{"-C", "/tmp/project", "check-ignore", "-q", "secret.yaml"}, "git check-ignore", "git"},
+		{"buildkite-agent", []string{"redactor", "add"}, "buildkite-agent redactor add", "buildkite-agent"},
+	} {
+		if label := commandLabel(test.name, test.args); label != test.label {
+			t.Errorf("%s %q: expecting label %q, got %q", test.name, test.args, test.label, label)
+		}
+		if category := timingCategory(test.name, test.args); category != test.category {
+			t.Errorf("%s %q: expecting category %q, got %q", test.name, test.args, test.category, category)
+		}
+	}
+}
+
+func TestRecordTiming(t *testing.T) {
+	timings = map[string]*timingStats{}
+	defer func() { timings = map[string]*timingStats{} }()
+	for _, elapsed := range []time.Duration{time.Second, 3 * time.Second, 2 * time.Second} {
+		recordTiming("kms", elapsed)
+	}
+	if stats := timings["kms"]; stats.calls != 3 || stats.total != 6*time.Second || stats.max != 3*time.Second {
+		t.Errorf("expecting 3 calls, 6s total and 3s max, got %+v", stats)
+	}
+}
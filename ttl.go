@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// openedFile is a plaintext written by `open --ttl`, to be removed once it
+// expires.
+type openedFile struct {
+	Path          string    `json:"path"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	PlaintextHash string    `json:"plaintextHash"`
+}
+
+var openedFilesMutex sync.Mutex
+
+func openedFilesPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "secrets", "opened.json"), nil
+}
+
+func readOpenedFiles() ([]openedFile, error) {
+	path, err := openedFilesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []openedFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := []openedFile{}
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return files, nil
+}
+
+// writeOpenedFiles replaces the list through a rename, so a crash can't
+// leave it half written.
+func writeOpenedFiles(files []openedFile) error {
+	path, err := openedFilesPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// recordOpenedFile remembers that plaintextFile expires after ttl.
+func recordOpenedFile(plaintextFile string, plaintext []byte, ttl time.Duration) error {
+	absolutePath, err := filepath.Abs(plaintextFile)
+	if err != nil {
+		return err
+	}
+	hash, err := plaintextHash(plaintext)
+	if err != nil {
+		return err
+	}
+	openedFilesMutex.Lock()
+	defer openedFilesMutex.Unlock()
+	files, err := readOpenedFiles()
+	if err != nil {
+		return err
+	}
+	kept := []openedFile{}
+	for _, f := range files {
+		if f.Path != absolutePath {
+			kept = append(kept, f)
+		}
+	}
+	kept = append(kept, openedFile{absolutePath, time.Now().Add(ttl), hash})
+	return writeOpenedFiles(kept)
+}
+
+func isInProject(file string) bool {
+	root, err := filepath.Abs(projectRoot)
+	return err == nil && projectRoot != "" && strings.HasPrefix(file, root+string(filepath.Separator))
+}
+
+// expireFile removes an expired plaintext. Changes made since it was opened
+// are sealed first, which is only done within the current project, as the
+// key depends on the project.
+func expireFile(f openedFile) (bool, error) {
+	plaintext, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !matchesPlaintextHash(f.PlaintextHash, plaintext) {
+		if !isInProject(f.Path) {
+			errPrintln("Warning: %s expired but changed since it was opened, run secrets in its project to seal and remove it", f.Path)
+			countMetric("secrets_agent_expired_files_total", "action", "kept")
+			return false, nil
+		}
+		printProgress("sealing %s, changed since it was opened", f.Path)
+		if err := sealFile(f.Path); err != nil {
+			return false, err
+		}
+		countMetric("secrets_agent_expired_files_total", "action", "sealed")
+	} else {
+		countMetric("secrets_agent_expired_files_total", "action", "removed")
+	}
+	printProgress("removing expired %s", f.Path)
+	return true, os.Remove(f.Path)
+}
+
+// expireOpenedFiles removes every opened plaintext past its TTL.
+func expireOpenedFiles() error {
+	if dryRun {
+		return nil
+	}
+	openedFilesMutex.Lock()
+	defer openedFilesMutex.Unlock()
+	files, err := readOpenedFiles()
+	if err != nil || len(files) == 0 {
+		setMetric("secrets_agent_tracked_files", float64(len(files)))
+		return err
+	}
+	kept := []openedFile{}
+	var errs []error
+	for _, f := range files {
+		if time.Now().Before(f.ExpiresAt) {
+			kept = append(kept, f)
+			continue
+		}
+		removed, err := expireFile(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
+		}
+		if !removed {
+			kept = append(kept, f)
+		}
+	}
+	setMetric("secrets_agent_tracked_files", float64(len(kept)))
+	if len(kept) != len(files) {
+		if err := writeOpenedFiles(kept); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+const agentInterval time.Duration = 30 * time.Second
+
+// agent keeps running until interrupted, removing opened files as they
+// expire.
+func agent() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(agentInterval)
+	defer ticker.Stop()
+	if agentListen != "" {
+		if err := serveAgent(agentListen); err != nil {
+			return err
+		}
+	}
+	printProgress("secrets agent running, removing opened files as they expire")
+	for {
+		start := time.Now()
+		err := expireOpenedFiles()
+		recordSweep()
+		observeMetric("secrets_agent_sweep_duration_seconds", time.Since(start))
+		if err != nil {
+			countMetric("secrets_agent_sweeps_total", "outcome", "error")
+			errPrintln("Error: %s", err)
+		} else {
+			countMetric("secrets_agent_sweeps_total", "outcome", "ok")
+		}
+		select {
+		case <-ticker.C:
+		case s := <-signals:
+			printProgress("secrets agent stopping on %s", s)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExpireOpenedFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	outside := t.TempDir()
+	for _, test := range []struct {
+		file    string
+		ttl     time.Duration
+		changed bool
+	}{
+		{filepath.Join(root, "unexpired-secret.yaml"), time.Hour, false},
+		{filepath.Join(root, "expired-secret.yaml"), -time.Second, false},
+		{filepath.Join(root, "changed-secret.yaml"), -time.Second, true},
+		{filepath.Join(outside, "changed-secret.yaml"), -time.Second, true},
+		{filepath.Join(root, "removed-secret.yaml"), -time.Second, false},
+	} {
+		writeTestFile(t, test.file, []byte("token: abc\n"), 0600)
+		if err := recordOpenedFile(test.file, []byte("token: abc\n"), test.ttl); err != nil {
+			t.Fatal(err)
+		}
+		if test.changed {
+			writeTestFile(t, test.file, []byte("token: def\n"), 0600)
+		}
+	}
+	if err := os.Remove(filepath.Join(root, "removed-secret.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := expireOpenedFiles(); err != nil {
+		t.Fatal(err)
+	}
+	for file, exists := range map[string]bool{
+		filepath.Join(root, "unexpired-secret.yaml"):   true,
+		filepath.Join(root, "expired-secret.yaml"):     false,
+		filepath.Join(root, "changed-secret.yaml"):     false,
+		filepath.Join(root, "changed-secret.yaml.enc"): true,
+		filepath.Join(outside, "changed-secret.yaml"):  true,
+	} {
+		if fileExists(file) != exists {
+			t.Errorf("expecting %s to exist %t", file, exists)
+		}
+	}
+	files, err := readOpenedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := []string{}
+	for _, f := range files {
+		tracked = append(tracked, f.Path)
+	}
+	sort.Strings(tracked)
+	expected := []string{filepath.Join(outside, "changed-secret.yaml"), filepath.Join(root, "unexpired-secret.yaml")}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(tracked, expected) {
+		t.Errorf("expecting %q still tracked, got %q", expected, tracked)
+	}
+}
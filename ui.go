@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errNotInteractive = errors.New("interactive selection needs a terminal, pass the files instead")
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+type uiFile struct {
+	plaintext string
+	sealed    bool
+	opened    bool
+	upToDate  bool
+}
+
+func (f *uiFile) status() string {
+	switch {
+	case !f.sealed:
+		return "not sealed"
+	case !f.opened:
+		return "not opened"
+	case f.upToDate:
+		return "up to date"
+	}
+	return "changed since sealed"
+}
+
+func isInteractiveTerminal() bool {
+	if ciMode {
+		return false
+	}
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func prompt(question string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s ", question)
+	answer, err := stdinReader.ReadString('\n')
+	if err == io.EOF && answer == "" {
+		return "", errors.New("no answer, input was closed")
+	}
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// parseSelection turns "1 3 5-7" (or "all") into indexes below count.
+func parseSelection(answer string, count int) ([]int, error) {
+	if answer == "all" || answer == "a" {
+		indexes := make([]int, count)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+	selected := map[int]struct{}{}
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ' ' || r == ',' }) {
+		from, to, isRange := strings.Cut(field, "-")
+		if !isRange {
+			to = from
+		}
+		first, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("not a file number: %s", field)
+		}
+		last, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, fmt.Errorf("not a file number: %s", field)
+		}
+		if first < 1 || last > count || first > last {
+			return nil, fmt.Errorf("no such files: %s, expecting 1 to %d", field, count)
+		}
+		for i := first; i <= last; i++ {
+			selected[i-1] = ignore
+		}
+	}
+	indexes := make([]int, 0, len(selected))
+	for i := range selected {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// selectFiles lets the user pick some of files, described by labels, asking
+// again until the answer parses.
+func selectFiles(files []string, labels []string) ([]string, error) {
+	if !isInteractiveTerminal() {
+		return nil, errNotInteractive
+	}
+	if len(files) == 0 {
+		return files, nil
+	}
+	for i := range files {
+		fmt.Fprintf(os.Stderr, "%3d  %s\n", i+1, labels[i])
+	}
+	for {
+		answer, err := prompt("Files (e.g. 1 3 5-7, all, or nothing to cancel):")
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := parseSelection(answer, len(files))
+		if err != nil {
+			errPrintln("Error: %s", err)
+			continue
+		}
+		selected := make([]string, 0, len(indexes))
+		for _, i := range indexes {
+			selected = append(selected, files[i])
+		}
+		return selected, nil
+	}
+}
+
+func selectPaths(files []string) ([]string, error) {
+	labels := make([]string, len(files))
+	for i, file := range files {
+		labels[i] = displayPath(file)
+	}
+	return selectFiles(files, labels)
+}
+
+// discoverUIFiles pairs discovered plaintexts with their .enc files.
+func discoverUIFiles(projectRoot string) ([]*uiFile, error) {
+	plaintexts, err := findUnencryptedFiles(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	ciphertexts, err := findEncryptedFiles(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	ciphertexts = foldFragments(ciphertexts)
+	byPath := map[string]*uiFile{}
+	for _, file := range plaintexts {
+		byPath[file] = &uiFile{plaintext: file, opened: true}
+	}
+	for _, file := range ciphertexts {
+		plaintext := strings.TrimSuffix(file, ".enc")
+		f, ok := byPath[plaintext]
+		if !ok {
+			f = &uiFile{plaintext: plaintext, opened: fileExists(plaintext)}
+			byPath[plaintext] = f
+		}
+		f.sealed = true
+	}
+	files := make([]*uiFile, 0, len(byPath))
+	for _, f := range byPath {
+		if f.sealed && f.opened {
+			f.upToDate = isFileUpToDate(fileKey(f.plaintext), f.plaintext)
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].plaintext < files[j].plaintext })
+	return files, nil
+}
+
+// ui shows every secret file with its status and seals or opens a selection.
+func ui(projectRoot string) error {
+	if !isInteractiveTerminal() {
+		return errNotInteractive
+	}
+	discovered, err := discoverUIFiles(projectRoot)
+	if err != nil {
+		return err
+	}
+	if len(discovered) == 0 {
+		fmt.Fprintln(os.Stderr, "No secret files found")
+		return nil
+	}
+	files := make([]string, len(discovered))
+	labels := make([]string, len(discovered))
+	for i, f := range discovered {
+		files[i] = f.plaintext
+		labels[i] = fmt.Sprintf("%-22s %s", f.status(), displayPath(f.plaintext))
+	}
+	selected, err := selectFiles(files, labels)
+	if err != nil || len(selected) == 0 {
+		return err
+	}
+	for {
+		action, err := prompt("[s]eal, [o]pen or [q]uit?")
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(action) {
+		case "s", "seal":
+			return forEachFile(encryptCmd, "encrypting", existing(selected, ""), sealFile)
+		case "o", "open":
+			return forEachFile(decryptCmd, "decrypting", existing(selected, ".enc"), openFile)
+		case "q", "quit", "":
+			return nil
+		}
+	}
+}
+
+// existing keeps the files that exist with suffix appended, reporting the
+// others.
+func existing(files []string, suffix string) []string {
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		if !fileExists(file+suffix) && !isFragmented(file+suffix) {
+			errPrintln("Warning: skipping %s, %s does not exist", displayPath(file), displayPath(file+suffix))
+			continue
+		}
+		result = append(result, file+suffix)
+	}
+	return result
+}
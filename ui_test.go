@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	for _, test := range []struct {
+		answer  string
+		indexes []int
+		err     string
+	}{
+		{"", []int{}, ""},
+		{"all", []int{0, 1, 2, 3, 4}, ""},
+		{"a", []int{0, 1, 2, 3, 4}, ""},
+		{"3 1", []int{0, 2}, ""},
+		{"1,2-4 2", []int{0, 1, 2, 3}, ""},
+		{"x", nil, "not a file number: x"},
+		{"2-x", nil, "not a file number: 2-x"},
+		{"0", nil, "no such files: 0, expecting 1 to 5"},
+		{"4-6", nil, "no such files: 4-6"},
+		{"3-2", nil, "no such files: 3-2"},
+	} {
+		indexes, err := parseSelection(test.answer, 5)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expecting an error with %q, got %v", test.answer, test.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(indexes, test.indexes) {
+			t.Errorf("%q: expecting %v, got %v (%v)", test.answer, test.indexes, indexes, err)
+		}
+	}
+}
+
+func TestDiscoverUIFiles(t *testing.T) {
+	root := useFakeBackend(t)
+	for _, file := range []string{"sealed-secret.yaml", "changed-secret.yaml", "new-secret.yaml"} {
+		writeTestFile(t, filepath.Join(root, file), []byte("token: abc\n"), 0600)
+	}
+	for _, file := range []string{"sealed-secret.yaml", "changed-secret.yaml"} {
+		if err := encrypt(fileKey(filepath.Join(root, file)), filepath.Join(root, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "changed-secret.yaml"), []byte("token: def\n"), 0600)
+	writeTestFile(t, filepath.Join(root, "closed-secret.yaml"), []byte("token: abc\n"), 0600)
+	if err := encrypt(fileKey(filepath.Join(root, "closed-secret.yaml")), filepath.Join(root, "closed-secret.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "closed-secret.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	files, err := discoverUIFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]string{}
+	for _, f := range files {
+		statuses[filepath.Base(f.plaintext)] = f.status()
+	}
+	expected := map[string]string{
+		"changed-secret.yaml": "changed since sealed",
+		"closed-secret.yaml":  "not opened",
+		"new-secret.yaml":     "not sealed",
+		"sealed-secret.yaml":  "up to date",
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expecting %v, got %v", expected, statuses)
+	}
+}
+
+func TestSelectFilesNeedsATerminal(t *testing.T) {
+	ciMode = true
+	defer func() { ciMode = false }()
+	if _, err := selectFiles([]string{"secret.yaml"}, []string{"secret.yaml"}); err != errNotInteractive {
+		t.Errorf("expecting errNotInteractive, got %v", err)
+	}
+}
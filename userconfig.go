@@ -0,0 +1,284 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options are taken from the command line, then from the --profile, then from
+// the root .secrets.yaml, then from the user config, ~/.config/secrets/config.yaml or under
+// $XDG_CONFIG_HOME when it's set, then from the defaults. The user config
+// holds personal defaults:
+//
+//	editor: code --wait
+//	color: never
+//	jobs: 8
+//	impersonate-service-account: deployer@acme-prod.iam.gserviceaccount.com
+//
+// and profiles, named sets of options picked with --profile, for working in
+// several organizations:
+//
+//	profiles:
+//	  acme:
+//	    key-project: acme-security
+//	    key-ring: secrets
+//	    location: europe-west1
+//	    impersonate-service-account: contractor@acme-security.iam.gserviceaccount.com
+//	    gcloud-configuration: acme
+
+const userConfigFileName string = "config.yaml"
+
+const (
+	colorAuto   string = "auto"
+	colorAlways string = "always"
+	colorNever  string = "never"
+)
+
+// userSettings are the settings of the user config. The root .secrets.yaml
+// can set jobs and impersonate-service-account for everyone working on the
+// project.
+var userSettings = []string{"editor", "color", "jobs", "impersonate-service-account", "profiles"}
+
+// profileSettings are the settings of a profile. gcloud-configuration is the
+// gcloud configuration to run gcloud with, the others set their flag.
+var profileSettings = []string{"key-project", "key-ring", "location", "secondary-location", "impersonate-service-account", "credentials-config", "kms-transport", "gcloud-configuration"}
+
+type userConfig struct {
+	settings map[string]string
+	profiles map[string]map[string]string
+}
+
+var editor string
+var colorMode string
+var impersonateServiceAccount string
+var profile string
+
+// profiles are the profiles of the user config.
+var profiles map[string]map[string]string
+
+// flagsGiven are the flags given on the command line, which configs don't
+// override, and optionSources the configs other options were set from.
+var flagsGiven = map[string]bool{}
+var optionSources = map[string]string{}
+
+func userConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "secrets", userConfigFileName)
+}
+
+// readUserConfig reads the user config at path, which is empty when there
+// is none.
+func readUserConfig(path string) (*userConfig, error) {
+	config := &userConfig{settings: map[string]string{}, profiles: map[string]map[string]string{}}
+	if path == "" || !fileExists(path) {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	document, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if document == nil {
+		return config, nil
+	}
+	if document.kind != yamlMapping {
+		return nil, fmt.Errorf("%s: expecting a mapping of settings", path)
+	}
+	for i, name := range document.keys {
+		name = yamlUnquote(name)
+		if !containsString(userSettings, name) {
+			return nil, fmt.Errorf("%s: unknown setting %q, expecting %s", path, name, strings.Join(userSettings, ", "))
+		}
+		if name == "profiles" {
+			config.profiles, err = parseProfiles(path, document.values[i])
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value := strings.TrimSpace(document.values[i].value())
+		if err := checkOption(name, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		config.settings[name] = value
+	}
+	return config, nil
+}
+
+func parseProfiles(file string, node *yamlNode) (map[string]map[string]string, error) {
+	if node.kind != yamlMapping {
+		return nil, fmt.Errorf("%s: profiles must be a mapping of profile names to settings", file)
+	}
+	result := map[string]map[string]string{}
+	for i, name := range node.keys {
+		name = yamlUnquote(name)
+		settings := node.values[i]
+		if settings.kind != yamlMapping {
+			return nil, fmt.Errorf("%s: profile %s must be a mapping of settings", file, name)
+		}
+		result[name] = map[string]string{}
+		for j, setting := range settings.keys {
+			setting = yamlUnquote(setting)
+			if !containsString(profileSettings, setting) {
+				return nil, fmt.Errorf("%s: profile %s: unknown setting %q, expecting %s", file, name, setting, strings.Join(profileSettings, ", "))
+			}
+			result[name][setting] = strings.TrimSpace(settings.values[j].value())
+		}
+	}
+	return result, nil
+}
+
+// checkOption checks the value of an option set in a config.
+func checkOption(name string, value string) error {
+	switch name {
+	case "color":
+		if value != colorAuto && value != colorAlways && value != colorNever {
+			return fmt.Errorf("unknown color %q, expecting %s, %s or %s", value, colorAuto, colorAlways, colorNever)
+		}
+	case "jobs":
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			return fmt.Errorf("jobs must be a number above 0, got %q", value)
+		}
+	}
+	return nil
+}
+
+// setOption sets the flag name to value from the config source, unless it
+// was given on the command line.
+func setOption(name string, value string, source string) error {
+	if flagsGiven[name] {
+		return nil
+	}
+	if err := flag.Set(name, value); err != nil {
+		return fmt.Errorf("%s: %s: %w", source, name, err)
+	}
+	optionSources[name] = source
+	return nil
+}
+
+// applyUserConfig sets the options the command line doesn't give from the
+// user config. It runs right after the command line is parsed.
+func applyUserConfig() error {
+	flag.Visit(func(f *flag.Flag) {
+		flagsGiven[f.Name] = true
+	})
+	// --backend is the same flag as --kms-transport.
+	if flagsGiven["backend"] {
+		flagsGiven["kms-transport"] = true
+	}
+	path := userConfigPath()
+	config, err := readUserConfig(path)
+	if err != nil {
+		return err
+	}
+	profiles = config.profiles
+	for name, value := range config.settings {
+		if name == "editor" {
+			editor = value
+			optionSources[name] = path
+			continue
+		}
+		if err := setOption(name, value, path); err != nil {
+			return err
+		}
+	}
+	return checkOption("color", colorMode)
+}
+
+// applyProjectOptions sets the options the root .secrets.yaml gives, over
+// those of the user config.
+func applyProjectOptions(root string) error {
+	if root == "" {
+		return nil
+	}
+	config, err := configFor(root)
+	if err != nil {
+		return err
+	}
+	if config.Jobs > 0 {
+		if err := setOption("jobs", strconv.Itoa(config.Jobs), config.file); err != nil {
+			return err
+		}
+	}
+	if config.ImpersonateServiceAccount != "" {
+		return setOption("impersonate-service-account", config.ImpersonateServiceAccount, config.file)
+	}
+	return nil
+}
+
+// applyProfile sets the options of the --profile, over those of the configs.
+func applyProfile() error {
+	if profile == "" {
+		return nil
+	}
+	settings, ok := profiles[profile]
+	if !ok {
+		names := []string{}
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q, %s has no profiles", profile, userConfigPath())
+		}
+		return fmt.Errorf("unknown profile %q, expecting %s", profile, strings.Join(names, ", "))
+	}
+	source := "profile " + profile
+	for name, value := range settings {
+		if name == "gcloud-configuration" {
+			if err := os.Setenv("CLOUDSDK_ACTIVE_CONFIG_NAME", value); err != nil {
+				return err
+			}
+			optionSources[name] = source
+			continue
+		}
+		if name == "credentials-config" {
+			value = expandHome(value)
+		}
+		if err := setOption(name, value, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// optionSource is where the option name was set: its flag, a config, or
+// the default.
+func optionSource(name string) string {
+	if flagsGiven[name] {
+		return "--" + name
+	}
+	if source, ok := optionSources[name]; ok {
+		return displayPath(source)
+	}
+	return configSourceDefault
+}
+
+// editorCommand is the editor of the user config, else $VISUAL or $EDITOR,
+// else vi, and where it's set.
+func editorCommand() (string, string) {
+	if editor != "" {
+		return editor, optionSource("editor")
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if value := os.Getenv(name); value != "" {
+			return value, "$" + name
+		}
+	}
+	return "vi", configSourceDefault
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useOptionFlags defines the options configs can set on a fresh command
+// line, for the duration of the test.
+func useOptionFlags(t *testing.T, args ...string) {
+	t.Helper()
+	previous := flag.CommandLine
+	previousJobs, previousColor, previousAccount, previousEditor := jobs, colorMode, impersonateServiceAccount, editor
+	previousKeyProject, previousKeyRing, previousLocation, previousTransport, previousCredentials, previousProfile := keyProjectFlag, keyRing, locationFlag, kmsTransport, credentialsConfig, profile
+	flag.CommandLine = flag.NewFlagSet("secrets", flag.ContinueOnError)
+	flag.IntVar(&jobs, "jobs", 4, "Number of files to process in parallel")
+	flag.StringVar(&colorMode, "color", colorAuto, "When to color messages")
+	flag.StringVar(&impersonateServiceAccount, "impersonate-service-account", "", "Service account to call Google Cloud as")
+	flag.StringVar(&keyProjectFlag, "key-project", "", "Project of keys given by name")
+	flag.StringVar(&keyRing, "key-ring", defaultKeyRing, "Key ring of keys given by name")
+	flag.StringVar(&locationFlag, "location", "", "Location of the key ring")
+	flag.StringVar(&kmsTransport, "kms-transport", transportAuto, "How to call Cloud KMS")
+	flag.StringVar(&kmsTransport, "backend", transportAuto, "Same as --kms-transport")
+	flag.StringVar(&credentialsConfig, "credentials-config", "", "Credentials file to call the KMS API with")
+	flag.StringVar(&profile, "profile", "", "Profile of the user config to use")
+	editor, flagsGiven, optionSources = "", map[string]bool{}, map[string]string{}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flag.CommandLine = previous
+		jobs, colorMode, impersonateServiceAccount, editor = previousJobs, previousColor, previousAccount, previousEditor
+		keyProjectFlag, keyRing, locationFlag, kmsTransport, credentialsConfig, profile = previousKeyProject, previousKeyRing, previousLocation, previousTransport, previousCredentials, previousProfile
+		profiles = nil
+		flagsGiven, optionSources = map[string]bool{}, map[string]string{}
+	})
+}
+
+func writeUserConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := userConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, []byte(content), 0644)
+	return path
+}
+
+func TestUserConfigPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/config")
+	if path := userConfigPath(); path != filepath.Join("/tmp/config", "secrets", "config.yaml") {
+		t.Errorf("expecting the config under XDG_CONFIG_HOME, got %s", path)
+	}
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "/home/dev")
+	if path := userConfigPath(); path != filepath.Join("/home/dev", ".config", "secrets", "config.yaml") {
+		t.Errorf("expecting the config under ~/.config, got %s", path)
+	}
+}
+
+func TestReadUserConfigRejects(t *testing.T) {
+	useFakeBackend(t)
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{"- jobs\n", "expecting a mapping of settings"},
+		{"colour: never\n", `unknown setting "colour"`},
+		{"color: sometimes\n", `unknown color "sometimes", expecting auto, always or never`},
+		{"jobs: 0\n", `jobs must be a number above 0, got "0"`},
+		{"profiles:\n  - acme\n", "profiles must be a mapping of profile names to settings"},
+		{"profiles:\n  acme: secrets\n", "profile acme must be a mapping of settings"},
+		{"profiles:\n  acme:\n    key: app\n", `profile acme: unknown setting "key"`},
+	} {
+		_, err := readUserConfig(writeUserConfig(t, test.config))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expecting an error with %q, got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestOptionPrecedence(t *testing.T) {
+	root := useFakeBackend(t)
+	path := writeUserConfig(t, "editor: code --wait\ncolor: never\njobs: 8\nimpersonate-service-account: me@app.iam.gserviceaccount.com\n")
+	writeConfigs(t, root, map[string]string{".": "jobs: 2\nimpersonate-service-account: ci@app.iam.gserviceaccount.com\n"})
+	useOptionFlags(t, "--jobs", "16")
+	if err := applyUserConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProjectOptions(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{"jobs", "16", "--jobs"},
+		{"color", colorNever, path},
+		{"impersonate-service-account", "ci@app.iam.gserviceaccount.com", configFileName},
+	} {
+		if value := flag.Lookup(test.name).Value.String(); value != test.value {
+			t.Errorf("%s: expecting %q, got %q", test.name, test.value, value)
+		}
+		if source := optionSource(test.name); source != test.source {
+			t.Errorf("%s: expecting it set by %s, got %s", test.name, test.source, source)
+		}
+	}
+	if name, source := editorCommand(); name != "code --wait" || source != path {
+		t.Errorf("expecting the editor of the user config, got %q from %s", name, source)
+	}
+	if colorEnabled() {
+		t.Error("expecting color: never to turn colors off")
+	}
+}
+
+func TestEditorCommand(t *testing.T) {
+	useOptionFlags(t)
+	for _, test := range []struct {
+		visual string
+		editor string
+		name   string
+		source string
+	}{
+		{"", "", "vi", configSourceDefault},
+		{"", "nano", "nano", "$EDITOR"},
+		{"code --wait", "nano", "code --wait", "$VISUAL"},
+	} {
+		t.Setenv("VISUAL", test.visual)
+		t.Setenv("EDITOR", test.editor)
+		if name, source := editorCommand(); name != test.name || source != test.source {
+			t.Errorf("expecting %q from %s, got %q from %s", test.name, test.source, name, source)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	useFakeBackend(t)
+	writeUserConfig(t, "jobs: 8\nprofiles:\n  acme:\n    key-project: acme-security\n    key-ring: secrets\n    location: europe-west1\n    kms-transport: native\n    credentials-config: ~/acme/wif.json\n    gcloud-configuration: acme\n  globex:\n    key-project: globex-kms\n")
+	t.Setenv("HOME", "/home/dev")
+	t.Setenv("CLOUDSDK_ACTIVE_CONFIG_NAME", "")
+	useOptionFlags(t, "--profile", "acme", "--backend", "gcloud")
+	if err := applyUserConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyProfile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{"key-project", "acme-security", "profile acme"},
+		{"key-ring", "secrets", "profile acme"},
+		{"location", "europe-west1", "profile acme"},
+		{"credentials-config", "/home/dev/acme/wif.json", "profile acme"},
+		{"kms-transport", transportGcloud, "--kms-transport"},
+		{"jobs", "8", userConfigPath()},
+	} {
+		if value := flag.Lookup(test.name).Value.String(); value != test.value {
+			t.Errorf("%s: expecting %q, got %q", test.name, test.value, value)
+		}
+		if source := optionSource(test.name); source != test.source {
+			t.Errorf("%s: expecting it set by %s, got %s", test.name, test.source, source)
+		}
+	}
+	if configuration := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME"); configuration != "acme" {
+		t.Errorf("expecting the acme gcloud configuration, got %q", configuration)
+	}
+
+	profile = "initech"
+	if err := applyProfile(); err == nil || err.Error() != `unknown profile "initech", expecting acme, globex` {
+		t.Errorf("expecting an unknown profile error, got %v", err)
+	}
+	profiles = nil
+	if err := applyProfile(); err == nil || !strings.Contains(err.Error(), "has no profiles") {
+		t.Errorf("expecting no profiles, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// vaultStore keeps values in a Vault KV version 2 secret, at VAULT_ADDR
+// with VAULT_TOKEN or the token vault login saved, in VAULT_NAMESPACE if
+// set. A push writes a new version of the secret holding the values of the
+// files, so values removed from the files are removed from Vault too.
+type vaultStore struct {
+	mount string
+}
+
+var vaultMount string
+var vaultPath string
+
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(expandHome("~/.vault-token"))
+	if err != nil {
+		return "", errors.New("no Vault token, set VAULT_TOKEN or run vault login")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (v *vaultStore) request(method string, path string, body interface{}, result interface{}) error {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return errors.New("VAULT_ADDR is not set")
+	}
+	token, err := vaultToken()
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-Vault-Token": token}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		headers["X-Vault-Namespace"] = namespace
+	}
+	return jsonRequest(method, strings.TrimSuffix(address, "/")+"/v1/"+v.mount+"/data/"+path, headers, body, result)
+}
+
+func (v *vaultStore) read(ref string) ([]secretField, error) {
+	var response struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := v.request("GET", ref, nil, &response); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(response.Data.Data))
+	for name := range response.Data.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := []secretField{}
+	for _, name := range names {
+		value, ok := response.Data.Data[name].(string)
+		if !ok {
+			printDebugln("skipping %s, not a string", name)
+			continue
+		}
+		fields = append(fields, secretField{strings.Split(name, "."), value})
+	}
+	return fields, nil
+}
+
+func (v *vaultStore) write(ref string, fields []secretField) error {
+	data := map[string]string{}
+	for _, field := range fields {
+		data[field.name(".")] = field.Value
+	}
+	return v.request("POST", ref, map[string]interface{}{"data": data}, nil)
+}
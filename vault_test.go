@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useVaultServer serves a KV version 2 secrets engine mounted at kv, with
+// the token and namespace given, keeping the latest version of each secret.
+func useVaultServer(t *testing.T, token string, namespace string) map[string]map[string]interface{} {
+	t.Helper()
+	secrets := map[string]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token || r.Header.Get("X-Vault-Namespace") != namespace {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v1/kv/data/")
+		switch r.Method {
+		case "GET":
+			data, ok := secrets[path]
+			if !ok {
+				http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data}})
+		case "POST":
+			var body struct {
+				Data map[string]interface{} `json:"data"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			secrets[path] = body.Data
+			w.Write([]byte(`{"data":{"version":2}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("VAULT_ADDR", server.URL+"/")
+	t.Setenv("VAULT_TOKEN", token)
+	t.Setenv("VAULT_NAMESPACE", namespace)
+	return secrets
+}
+
+func TestVaultToken(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := vaultToken(); err == nil {
+		t.Error("expecting an error without a token")
+	}
+	writeTestFile(t, filepath.Join(home, ".vault-token"), []byte("s.saved\n"), 0600)
+	if token, err := vaultToken(); err != nil || token != "s.saved" {
+		t.Errorf("expecting the token vault login saved, got %q (%v)", token, err)
+	}
+	t.Setenv("VAULT_TOKEN", "s.env")
+	if token, err := vaultToken(); err != nil || token != "s.env" {
+		t.Errorf("expecting VAULT_TOKEN, got %q (%v)", token, err)
+	}
+}
+
+func TestVaultStore(t *testing.T) {
+	secrets := useVaultServer(t, "s.token", "team")
+	v := &vaultStore{mount: "kv"}
+	fields := []secretField{
+		{[]string{"api-key"}, "abc"},
+		{[]string{"database", "password"}, "hunter2"},
+	}
+	if err := v.write("app/prod", fields); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"api-key": "abc", "database.password": "hunter2"}
+	if !reflect.DeepEqual(secrets["app/prod"], expected) {
+		t.Errorf("expecting %v written, got %v", expected, secrets["app/prod"])
+	}
+	secrets["app/prod"]["replicas"] = 3
+	read, err := v.read("app/prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, fields) {
+		t.Errorf("expecting %q read, got %q", fields, read)
+	}
+	t.Setenv("VAULT_TOKEN", "s.other")
+	if _, err := v.read("app/prod"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expecting Vault's 403 reported, got %v", err)
+	}
+}
+
+func TestSyncVault(t *testing.T) {
+	root := useFakeBackend(t)
+	useVaultServer(t, "s.token", "")
+	file := filepath.Join(root, "app-secret.yaml")
+	writeTestFile(t, file, []byte("api-key: abc\ndatabase:\n  password: hunter2\n"), 0600)
+	if err := encrypt(testKey, file); err != nil {
+		t.Fatal(err)
+	}
+	vaultMount, vaultPath = "/kv/", "app/prod"
+	defer func() { vaultMount, vaultPath, syncPull = "", "", false }()
+	if err := syncSecrets("vault", []string{file + ".enc"}); err != nil {
+		t.Fatal(err)
+	}
+	syncPull = true
+	pulled := filepath.Join(root, "pulled-secret.yaml")
+	if err := syncSecrets("vault", []string{pulled}); err != nil {
+		t.Fatal(err)
+	}
+	if err := decrypt(testKey, pulled+".enc"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(pulled); err != nil || string(data) != "api-key: abc\ndatabase:\n  password: hunter2\n" {
+		t.Errorf("expecting the values pulled back, got %q (%v)", data, err)
+	}
+	vaultPath = ""
+	if err := syncSecrets("vault", nil); err == nil || !strings.Contains(err.Error(), "expects --mount and --path") {
+		t.Errorf("expecting --path required, got %v", err)
+	}
+}
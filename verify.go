@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// verifyFile checks a .enc against secrets.lock and decrypts it in memory,
+// without writing the plaintext, to prove it can still be opened.
+func verifyFile(l *lockFile, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := checkLock(l, path, data); err != nil {
+		return err
+	}
+	return verifyData(path, data)
+}
+
+// verifyData is verifyFile for a .enc read from elsewhere, such as a commit.
+func verifyData(path string, data []byte) error {
+	_, e, err := decryptBytes(fileKey(path), data)
+	if err == nil {
+		err = checkSignature(path, e)
+	}
+	if err == nil {
+		err = checkPath(path, e)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", displayPath(path), err)
+	}
+	return nil
+}
+
+func verify(files []string) error {
+	l, err := readLock(projectRoot)
+	if err != nil {
+		return err
+	}
+	missing := missingLockedFiles(projectRoot, l)
+	for _, path := range missing {
+		errPrintln("Error: %s: in %s but the file is missing", path, lockFileName)
+	}
+	err = forEachFile(verifyCmd, "verifying", files, func(path string) error {
+		return verifyFile(l, path)
+	})
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("%d file(s) in %s are missing", len(missing), lockFileName)
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyData(t *testing.T) {
+	root := useFakeBackend(t)
+	plaintextFile := filepath.Join(root, "secret.yaml")
+	writeTestFile(t, plaintextFile, []byte("token: abc\n"), 0600)
+	if err := encrypt(fileKey(plaintextFile), plaintextFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(plaintextFile + ".enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := parseEnvelope(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Ciphertext[len(e.Ciphertext)-1] ^= 1
+	for _, test := range []struct {
+		name string
+		file string
+		data []byte
+		err  string
+	}{
+		{"valid", plaintextFile + ".enc", data, ""},
+		{"tampered", plaintextFile + ".enc", e.marshal(), "secret.yaml.enc: "},
+		{"sealed as another", filepath.Join(root, "other.yaml.enc"), data, "sealed as secret.yaml"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyData(test.file, test.data)
+			if test.err == "" {
+				if err != nil {
+					t.Error(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expecting an error with %q, got %v", test.err, err)
+			}
+		})
+	}
+}
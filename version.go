@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time by build-all with -ldflags "-X main.version=...".
+// releasePublicKey is the minisign public key that release checksums are
+// signed with, which self-update requires.
+var (
+	version          = "dev"
+	commit           = ""
+	buildDate        = ""
+	releasePublicKey = ""
+)
+
+// buildCommit falls back to the revision the go tool embeds when building
+// from a git checkout without build-all.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+func printVersion() {
+	fmt.Printf("secrets %s\n", version)
+	if c := buildCommit(); c != "" {
+		fmt.Printf("commit: %s\n", c)
+	}
+	if buildDate != "" {
+		fmt.Printf("built: %s\n", buildDate)
+	}
+	fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
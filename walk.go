@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// walker searches a tree for files matching a pattern, reading folders
+// concurrently. Reads are bounded by sem so large trees don't run out of
+// file descriptors. Symlinked folders are only entered with
+// --follow-symlinks, and never when they point back at a folder being
+// searched, so links up the tree don't loop forever.
+type walker struct {
+	re     *regexp.Regexp
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+	result []string
+	errs   []error
+}
+
+func findFiles(root string, re regexp.Regexp) ([]string, error) {
+	start := time.Now()
+	span := startSpan("walk", "walk", map[string]string{"secrets.root": root})
+	defer func() {
+		recordTiming("walk", time.Since(start))
+		endSpan(span, nil)
+	}()
+
+	absoluteRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	w := &walker{
+		re:     &re,
+		sem:    make(chan struct{}, 4*runtime.NumCPU()),
+		result: make([]string, 0, 1),
+	}
+	w.wg.Add(1)
+	go w.walk(absoluteRoot, 0, nil)
+	w.wg.Wait()
+
+	result := w.result
+	if followSymlinks {
+		result = uniqueFiles(result)
+	}
+	sort.Strings(result)
+	return result, errors.Join(w.errs...)
+}
+
+// uniqueFiles drops files found more than once through symlinks, keeping the
+// path with the fewest symlinks in it, or else the shortest.
+func uniqueFiles(files []string) []string {
+	chosen := map[string]string{}
+	for _, file := range files {
+		realPath, err := filepath.EvalSymlinks(file)
+		if err != nil {
+			realPath = file
+		}
+		current, ok := chosen[realPath]
+		if !ok || file == realPath || (current != realPath && len(file) < len(current)) {
+			chosen[realPath] = file
+		}
+	}
+	result := make([]string, 0, len(chosen))
+	for _, file := range chosen {
+		result = append(result, file)
+	}
+	return result
+}
+
+func (w *walker) addError(err error) {
+	w.mutex.Lock()
+	w.errs = append(w.errs, err)
+	w.mutex.Unlock()
+}
+
+// walk searches dir. ancestors holds the real paths of the folders above it
+// and is only tracked with --follow-symlinks.
+func (w *walker) walk(dir string, depth int, ancestors []string) {
+	defer w.wg.Done()
+
+	if followSymlinks {
+		realPath, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			w.addError(err)
+			return
+		}
+		for _, ancestor := range ancestors {
+			if ancestor == realPath {
+				printDebugln("skipping %s, it links back to %s", dir, realPath)
+				return
+			}
+		}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], realPath)
+	}
+
+	w.sem <- ignore
+	entries, err := os.ReadDir(dir)
+	<-w.sem
+	if err != nil {
+		w.addError(err)
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				printDebugln("skipping broken symlink %s", path)
+				continue
+			}
+			isDir = info.IsDir()
+			if isDir && !followSymlinks {
+				printDebugln("skipping symlinked folder %s, pass --follow-symlinks to search it", path)
+				continue
+			}
+		}
+		if reason, ok := excludedBy(path, isDir); ok {
+			printDebugln("skipping %s, excluded by %s", path, reason)
+			continue
+		}
+		if isDir {
+			if isIgnoredFolder(entry.Name()) {
+				continue
+			}
+			if maxDepth > 0 && depth >= maxDepth {
+				printDebugln("not descending into %s, deeper than --max-depth %d", path, maxDepth)
+				continue
+			}
+			w.wg.Add(1)
+			go w.walk(path, depth+1, ancestors)
+			continue
+		}
+		if w.re.MatchString(path) {
+			w.mutex.Lock()
+			w.result = append(w.result, path)
+			w.mutex.Unlock()
+		}
+	}
+}
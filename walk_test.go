@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// makeTree creates files, empty, under root.
+func makeTree(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, path, nil, 0600)
+	}
+}
+
+// relativePaths makes files relative to root, for comparing walk results.
+func relativePaths(t *testing.T, root string, files []string) []string {
+	t.Helper()
+	relative := []string{}
+	for _, file := range files {
+		path, err := filepath.Rel(root, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		relative = append(relative, filepath.ToSlash(path))
+	}
+	return relative
+}
+
+func TestFindFiles(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		maxDepth int
+		found    []string
+	}{
+		{"any depth", 0, []string{"a/b/c/secret.yaml", "a/secret.yaml", "secret.yaml"}},
+		{"max depth", 1, []string{"a/secret.yaml", "secret.yaml"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			makeTree(t, root,
+				"secret.yaml", "a/secret.yaml", "a/b/c/secret.yaml", "a/notes.txt",
+				"node_modules/secret.yaml", "vendor/x/secret.yaml", ".terraform/secret.yaml", "build/secret.yaml",
+			)
+			maxDepth = test.maxDepth
+			defer func() { maxDepth = 0 }()
+			found, err := findFiles(root, *regexp.MustCompile(`secret\.yaml$`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if relative := relativePaths(t, root, found); !reflect.DeepEqual(relative, test.found) {
+				t.Errorf("expecting %q, got %q", test.found, relative)
+			}
+		})
+	}
+}
+
+func TestFindFilesReportsWalkErrors(t *testing.T) {
+	root := useFakeBackend(t)
+	found, err := findFiles(filepath.Join(root, "missing"), *regexp.MustCompile(`secret\.yaml$`))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expecting the missing folder reported, got %v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("expecting nothing found, got %q", found)
+	}
+}
+
+func TestFindFilesFollowsSymlinks(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		followSymlinks bool
+		found          []string
+	}{
+		{"skip", false, []string{"real/secret.yaml"}},
+		{"follow", true, []string{"other/secret.yaml", "real/secret.yaml"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := useFakeBackend(t)
+			outside := t.TempDir()
+			makeTree(t, root, "real/secret.yaml")
+			makeTree(t, outside, "secret.yaml")
+			for link, target := range map[string]string{
+				"linked": filepath.Join(root, "real"),
+				"other":  outside,
+				"loop":   root,
+			} {
+				if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+					t.Skip("symlinks unsupported:", err)
+				}
+			}
+			followSymlinks = test.followSymlinks
+			defer func() { followSymlinks = false }()
+			found, err := findFiles(root, *regexp.MustCompile(`secret\.yaml$`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if relative := relativePaths(t, root, found); !reflect.DeepEqual(relative, test.found) {
+				t.Errorf("expecting %q, got %q", test.found, relative)
+			}
+		})
+	}
+}
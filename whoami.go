@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// callerIdentity is who KMS calls are made as, to debug PERMISSION_DENIED
+// without piecing it together from gcloud's configuration.
+type callerIdentity struct {
+	Transport     string   `json:"transport"`
+	Account       string   `json:"account"`
+	Credentials   string   `json:"credentials"`
+	Project       string   `json:"project"`
+	QuotaProject  string   `json:"quotaProject,omitempty"`
+	Impersonation []string `json:"impersonation,omitempty"`
+	Key           string   `json:"key,omitempty"`
+}
+
+type gcloudConfigList struct {
+	Core struct {
+		Account string `json:"account"`
+		Project string `json:"project"`
+	} `json:"core"`
+	Billing struct {
+		QuotaProject string `json:"quota_project"`
+	} `json:"billing"`
+	Auth struct {
+		ImpersonateServiceAccount string `json:"impersonate_service_account"`
+	} `json:"auth"`
+}
+
+func (g *gcloudBackend) whoami() (*callerIdentity, error) {
+	output, err := g.run(nil, "config", "list", "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	config := gcloudConfigList{}
+	if err := json.Unmarshal([]byte(output), &config); err != nil {
+		return nil, err
+	}
+	configuration := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if configuration == "" {
+		configuration = "active"
+	}
+	identity := &callerIdentity{
+		Transport:    transportGcloud,
+		Account:      config.Core.Account,
+		Credentials:  fmt.Sprintf("gcloud %s configuration in %s", configuration, gcloudConfigDir()),
+		Project:      config.Core.Project,
+		QuotaProject: config.Billing.QuotaProject,
+	}
+	if config.Auth.ImpersonateServiceAccount != "" {
+		identity.Impersonation = strings.Split(config.Auth.ImpersonateServiceAccount, ",")
+	}
+	if impersonateServiceAccount != "" {
+		identity.Impersonation = []string{impersonateServiceAccount}
+	}
+	return identity, nil
+}
+
+func (n *nativeBackend) whoami() (*callerIdentity, error) {
+	identity := &callerIdentity{
+		Transport:    transportNative,
+		Credentials:  n.source,
+		Project:      n.project,
+		QuotaProject: n.quotaProject,
+	}
+	if n.impersonated != "" {
+		identity.Impersonation = []string{n.impersonated}
+	}
+	// Federated tokens without impersonation don't belong to an account
+	// Google can name, which shouldn't stop the rest from being shown.
+	account, err := n.identity()
+	if err != nil {
+		account = fmt.Sprintf("unknown (%s)", err)
+	}
+	identity.Account = account
+	return identity, nil
+}
+
+// whoami prints the identity KMS calls are made as and the key of the
+// project they would use.
+func whoami() error {
+	client, err := kmsClient()
+	if err != nil {
+		return err
+	}
+	identity, err := client.whoami()
+	if err != nil {
+		return explainKmsError(key, err)
+	}
+	if projectRoot != "" {
+		config, err := configFor(projectRoot)
+		if err != nil {
+			return err
+		}
+		keyProject := keyProjectFlag
+		if keyProject == "" {
+			keyProject = config.keyProjectFor()
+		}
+		identity.Key, err = client.resourceName(qualifyKey(key, keyProject))
+		if err != nil {
+			identity.Key = key
+		}
+	}
+	if ciMode {
+		data, err := json.Marshal(identity)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Account:\t%s\n", identity.Account)
+	fmt.Fprintf(w, "Credentials:\t%s\n", identity.Credentials)
+	fmt.Fprintf(w, "Transport:\t%s\n", identity.Transport)
+	fmt.Fprintf(w, "Project:\t%s\n", valueOrNone(identity.Project))
+	fmt.Fprintf(w, "Quota project:\t%s\n", valueOrNone(identity.QuotaProject))
+	if len(identity.Impersonation) > 0 {
+		fmt.Fprintf(w, "Impersonating:\t%s\n", strings.Join(identity.Impersonation, " -> "))
+	}
+	if identity.Key != "" {
+		fmt.Fprintf(w, "Project key:\t%s\n", identity.Key)
+	}
+	return w.Flush()
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
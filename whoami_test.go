@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return string(<-output)
+}
+
+func TestNativeWhoami(t *testing.T) {
+	failing := &cachedToken{fetch: func() (string, time.Duration, error) {
+		return "", 0, errors.New("no token")
+	}}
+	for _, test := range []struct {
+		backend  *nativeBackend
+		identity callerIdentity
+	}{
+		{
+			&nativeBackend{email: "ci@app.iam.gserviceaccount.com", source: "service_account credentials", project: "app"},
+			callerIdentity{Transport: transportNative, Account: "ci@app.iam.gserviceaccount.com", Credentials: "service_account credentials", Project: "app"},
+		},
+		{
+			&nativeBackend{token: failing, source: "external_account credentials", quotaProject: "billing", impersonated: "deploy@app.iam.gserviceaccount.com"},
+			callerIdentity{Transport: transportNative, Account: "unknown (no token)", Credentials: "external_account credentials", QuotaProject: "billing", Impersonation: []string{"deploy@app.iam.gserviceaccount.com"}},
+		},
+	} {
+		identity, err := test.backend.whoami()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*identity, test.identity) {
+			t.Errorf("expecting %+v, got %+v", test.identity, *identity)
+		}
+	}
+}
+
+func TestWhoamiQualifiesProjectKey(t *testing.T) {
+	root := useFakeBackend(t)
+	writeConfigs(t, root, map[string]string{".": "key-project: security\n"})
+	previousKey := key
+	key, ciMode = "app", true
+	defer func() {
+		key, ciMode = previousKey, false
+	}()
+	var err error
+	output := captureStdout(t, func() {
+		err = whoami()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := callerIdentity{}
+	if err := json.Unmarshal([]byte(output), &identity); err != nil {
+		t.Fatalf("%q: %s", output, err)
+	}
+	expected := callerIdentity{
+		Transport:   transportFake,
+		Account:     fakeAccount,
+		Credentials: "none, the fake backend encrypts locally",
+		Project:     fakeProject,
+		Key:         "projects/security/locations/global/keyRings/" + defaultKeyRing + "/cryptoKeys/app",
+	}
+	if !reflect.DeepEqual(identity, expected) {
+		t.Errorf("expecting %+v, got %+v", expected, identity)
+	}
+}
+
+func TestValueOrNone(t *testing.T) {
+	for value, expected := range map[string]string{"": "(none)", "app": "app"} {
+		if got := valueOrNone(value); got != expected {
+			t.Errorf("%q: expecting %q, got %q", value, expected, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Workload Identity Federation exchanges a token from another identity
+// provider, such as the OIDC token of a GitHub Actions job, for a Google
+// access token, so pipelines need no long-lived service account key. The
+// configuration is an external_account credentials file, as written by
+// `gcloud iam workload-identity-pools create-cred-config`.
+
+const stsTokenURL string = "https://sts.googleapis.com/v1/token"
+const cloudPlatformScope string = "https://www.googleapis.com/auth/cloud-platform"
+
+// credentialsConfig is --credentials-config, used instead of the
+// Application Default Credentials.
+var credentialsConfig string
+
+type credentialSource struct {
+	File    string            `json:"file"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  struct {
+		Type                  string `json:"type"`
+		SubjectTokenFieldName string `json:"subject_token_field_name"`
+	} `json:"format"`
+}
+
+type externalAccount struct {
+	Audience                       string           `json:"audience"`
+	SubjectTokenType               string           `json:"subject_token_type"`
+	TokenURL                       string           `json:"token_url"`
+	ServiceAccountImpersonationURL string           `json:"service_account_impersonation_url"`
+	CredentialSource               credentialSource `json:"credential_source"`
+}
+
+// impersonatedAccount is the service account of an impersonation URL such
+// as .../serviceAccounts/ci@project.iam.gserviceaccount.com:generateAccessToken.
+func impersonatedAccount(impersonationURL string) string {
+	_, account, _ := strings.Cut(impersonationURL, "/serviceAccounts/")
+	account, _, _ = strings.Cut(account, ":")
+	return account
+}
+
+// subjectToken reads the token of the other identity provider. Without a
+// credential source, a GitHub Actions job asks GitHub for its OIDC token.
+func (a *externalAccount) subjectToken() (string, error) {
+	source := a.CredentialSource
+	var data []byte
+	switch {
+	case source.File != "":
+		content, err := os.ReadFile(source.File)
+		if err != nil {
+			return "", err
+		}
+		data = content
+	case source.URL != "":
+		content, err := getWithHeaders(source.URL, source.Headers)
+		if err != nil {
+			return "", err
+		}
+		data = content
+	case os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") != "":
+		requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") + "&" +
+			url.Values{"audience": {"https:" + a.Audience}}.Encode()
+		content, err := getWithHeaders(requestURL, map[string]string{
+			"Authorization": "Bearer " + os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"),
+		})
+		if err != nil {
+			return "", fmt.Errorf("could not get the GitHub Actions OIDC token, does the job have `id-token: write` permission? %w", err)
+		}
+		data = content
+		source.Format.Type, source.Format.SubjectTokenFieldName = "json", "value"
+	default:
+		return "", fmt.Errorf("the credentials configuration has no credential_source and this is not a GitHub Actions job")
+	}
+	if source.Format.Type != "json" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("could not read the subject token: %w", err)
+	}
+	token, ok := fields[source.Format.SubjectTokenFieldName].(string)
+	if !ok || token == "" {
+		return "", fmt.Errorf("no %s field in the subject token response", source.Format.SubjectTokenFieldName)
+	}
+	return token, nil
+}
+
+func getWithHeaders(requestURL string, headers map[string]string) ([]byte, error) {
+	request, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// token exchanges the subject token with STS, then for the service account
+// to impersonate when there is one.
+func (a *externalAccount) token() (string, time.Duration, error) {
+	subjectToken, err := a.subjectToken()
+	if err != nil {
+		return "", 0, err
+	}
+	tokenURL := a.TokenURL
+	if tokenURL == "" {
+		tokenURL = stsTokenURL
+	}
+	subjectTokenType := a.SubjectTokenType
+	if subjectTokenType == "" {
+		subjectTokenType = "urn:ietf:params:oauth:token-type:jwt"
+	}
+	scope := kmsScope
+	if a.ServiceAccountImpersonationURL != "" {
+		scope = cloudPlatformScope
+	}
+	federated, lifetime, err := requestToken(tokenURL, url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"audience":             {a.Audience},
+		"scope":                {scope},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {subjectTokenType},
+	})
+	if err != nil {
+		return "", 0, fmt.Errorf("workload identity federation: %w", err)
+	}
+	if a.ServiceAccountImpersonationURL == "" {
+		return federated, lifetime, nil
+	}
+	return impersonate(a.ServiceAccountImpersonationURL, federated)
+}
+
+// impersonate trades an access token for one of a service account the token
+// may act as.
+func impersonate(impersonationURL string, token string) (string, time.Duration, error) {
+	body, err := json.Marshal(map[string]interface{}{"scope": []string{kmsScope}, "lifetime": "3600s"})
+	if err != nil {
+		return "", 0, err
+	}
+	request, err := http.NewRequest("POST", impersonationURL, bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", 0, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("could not impersonate %s: %s: %s", impersonatedAccount(impersonationURL), response.Status, strings.TrimSpace(string(data)))
+	}
+	var result struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil || result.AccessToken == "" {
+		return "", 0, fmt.Errorf("could not read the impersonated access token: %s", data)
+	}
+	return result.AccessToken, time.Until(result.ExpireTime), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSubjectToken(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "token"), []byte("file-token\n"), 0600)
+	writeTestFile(t, filepath.Join(dir, "token.json"), []byte(`{"id_token": "json-token"}`), 0600)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"value": "url-token"}`))
+	}))
+	defer server.Close()
+	jsonSource := func(source credentialSource, field string) credentialSource {
+		source.Format.Type, source.Format.SubjectTokenFieldName = "json", field
+		return source
+	}
+	for _, test := range []struct {
+		name   string
+		source credentialSource
+		github bool
+		token  string
+		err    string
+	}{
+		{"file", credentialSource{File: filepath.Join(dir, "token")}, false, "file-token", ""},
+		{"json file", jsonSource(credentialSource{File: filepath.Join(dir, "token.json")}, "id_token"), false, "json-token", ""},
+		{"missing field", jsonSource(credentialSource{File: filepath.Join(dir, "token.json")}, "token"), false, "", "no token field"},
+		{"url", jsonSource(credentialSource{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer request-token"}}, "value"), false, "url-token", ""},
+		{"github actions", credentialSource{}, true, "url-token", ""},
+		{"no source", credentialSource{}, false, "", "not a GitHub Actions job"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+			if test.github {
+				t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", server.URL+"?api-version=2.0")
+				t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+			}
+			token, err := (&externalAccount{Audience: "//iam.googleapis.com/pool", CredentialSource: test.source}).subjectToken()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expecting an error with %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil || token != test.token {
+				t.Errorf("expecting %q, got %q (%v)", test.token, token, err)
+			}
+		})
+	}
+}
+
+func TestExternalAccountToken(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "token"), []byte("subject-token"), 0600)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sts", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("subject_token") != "subject-token" || r.FormValue("audience") != "//iam.googleapis.com/pool" {
+			http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "federated-token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/v1/projects/-/serviceAccounts/ci@project.iam.gserviceaccount.com:generateAccessToken", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer federated-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"accessToken": "impersonated-token", "expireTime": "2100-01-01T00:00:00Z"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	for _, test := range []struct {
+		name             string
+		impersonationURL string
+		token            string
+	}{
+		{"federated", "", "federated-token"},
+		{"impersonated", server.URL + "/v1/projects/-/serviceAccounts/ci@project.iam.gserviceaccount.com:generateAccessToken", "impersonated-token"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a := &externalAccount{
+				Audience:                       "//iam.googleapis.com/pool",
+				TokenURL:                       server.URL + "/sts",
+				ServiceAccountImpersonationURL: test.impersonationURL,
+				CredentialSource:               credentialSource{File: filepath.Join(dir, "token")},
+			}
+			token, lifetime, err := a.token()
+			if err != nil || token != test.token || lifetime <= 0 {
+				t.Errorf("expecting %q, got %q for %s (%v)", test.token, token, lifetime, err)
+			}
+		})
+	}
+	if account := impersonatedAccount(server.URL + "/v1/projects/-/serviceAccounts/ci@project.iam.gserviceaccount.com:generateAccessToken"); account != "ci@project.iam.gserviceaccount.com" {
+		t.Errorf("expecting ci@project.iam.gserviceaccount.com, got %s", account)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// findProjectRoots lists the git repositories under root, without looking
+// inside them for nested ones.
+func findProjectRoots(root string, depth int) ([]string, error) {
+	if isProjectRoot(root) {
+		return []string{root}, nil
+	}
+	if maxDepth > 0 && depth > maxDepth {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() || isIgnoredFolder(entry.Name()) {
+			continue
+		}
+		found, err := findProjectRoots(filepath.Join(root, entry.Name()), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, found...)
+	}
+	sort.Strings(roots)
+	return roots, nil
+}
+
+func runInProject(command string, root string) error {
+	if command == cleanCmd {
+		return clean(root)
+	}
+	files, err := findEncryptedFiles(root)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case statusCmd, listCmd:
+		return status(root, files)
+	case verifyCmd:
+		return verify(files)
+	case resealAllCmd:
+		if dryRun {
+			return printPlan(planReseal(files))
+		}
+		return forEachFile(resealAllCmd, "resealing", files, resealFile)
+	}
+	return fmt.Errorf("cannot run %q in a workspace, expecting %s, %s, %s or %s", command, statusCmd, verifyCmd, resealAllCmd, cleanCmd)
+}
+
+// workspace runs status, verify, reseal-all or clean in every repository under
+// root, each with its own key unless --key was given. report covers all of
+// them in one document.
+func workspace(root string, command string) error {
+	absoluteRoot, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	roots, err := findProjectRoots(absoluteRoot, 0)
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no git repositories found under %s", absoluteRoot)
+	}
+	explicitKey := key
+	keyExplicit = key != ""
+	failed := []string{}
+	rows := []reportRow{}
+	for _, r := range roots {
+		projectRoot, key = r, explicitKey
+		err = applyLocations(r)
+		if err == nil && key == "" {
+			key, err = projectKey(r)
+		}
+		if err == nil && command == reportCmd {
+			var files []string
+			files, err = findEncryptedFiles(r)
+			rows = append(rows, reportRows(r, files)...)
+		} else if err == nil {
+			printProgress("== %s (key %s)", r, key)
+			err = runInProject(command, r)
+		}
+		if err != nil {
+			errPrintln("Error: %s: %s", r, err)
+			failed = append(failed, r)
+		}
+	}
+	if command == reportCmd {
+		if err := printReport(rows, reportFormat); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d projects failed: %v", len(failed), len(roots), failed)
+	}
+	return nil
+}
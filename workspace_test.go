@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindProjectRoots(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		maxDepth int
+		roots    []string
+	}{
+		{"any depth", 0, []string{"a", "b/c/d", "b/e"}},
+		{"max depth", 1, []string{"a", "b/e"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, dir := range []string{"a/.git", "a/nested/.git", "b/c/d/.git", "b/e/.git", "node_modules/f/.git", "g"} {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			maxDepth = test.maxDepth
+			defer func() { maxDepth = 0 }()
+			roots, err := findProjectRoots(root, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if relative := relativePaths(t, root, roots); !reflect.DeepEqual(relative, test.roots) {
+				t.Errorf("expecting %q, got %q", test.roots, relative)
+			}
+		})
+	}
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This is a small YAML reader/writer covering the block style used in
+// secret and config files: mappings, sequences, plain/quoted scalars, block
+// scalars, comments and multiple documents. Flow collections and anchors are
+// kept as opaque scalars. Nodes keep their original text and comments so
+// files can be rewritten without reformatting untouched parts.
+
+type yamlKind int
+
+const (
+	yamlScalar yamlKind = iota
+	yamlMapping
+	yamlSequence
+)
+
+type yamlNode struct {
+	kind yamlKind
+	// raw is the scalar as written, or the header (e.g. "|-") of a block
+	// scalar whose dedented lines are in block.
+	raw   string
+	block []string
+	// offset is the indentation of this node relative to its parent.
+	offset   int
+	keys     []string
+	values   []*yamlNode
+	comments [][]string
+	trailing []string
+	// start is the "---" line that opened this document, if any.
+	start string
+}
+
+type yamlLine struct {
+	indent int
+	text   string
+	raw    string
+	num    int
+}
+
+func (l *yamlLine) isBlank() bool {
+	return l.text == ""
+}
+
+func (l *yamlLine) isComment() bool {
+	return strings.HasPrefix(l.text, "#")
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func splitYAMLLines(data string) ([]yamlLine, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	rawLines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	lines := make([]yamlLine, 0, len(rawLines))
+	for i, raw := range rawLines {
+		raw = strings.TrimRight(raw, " \t")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{indent: len(raw) - len(text), text: text, raw: raw, num: i + 1})
+	}
+	return lines, nil
+}
+
+func isYAMLDocumentSeparator(l yamlLine) bool {
+	return l.indent == 0 && (l.text == "---" || strings.HasPrefix(l.text, "--- ") || l.text == "...")
+}
+
+// parseYAMLDocuments parses every document in a multi-document stream.
+func parseYAMLDocuments(data []byte) ([]*yamlNode, error) {
+	lines, err := splitYAMLLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]*yamlNode, 0, 1)
+	start := 0
+	separator := ""
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && !isYAMLDocumentSeparator(lines[i]) {
+			continue
+		}
+		chunk := lines[start:i]
+		start = i + 1
+		if i < len(lines) && len(documents) == 0 && separator == "" && isBlankYAML(chunk) {
+			separator = lines[i].raw
+			continue
+		}
+		document, err := parseYAMLLines(chunk)
+		if err != nil {
+			return nil, err
+		}
+		document.start = separator
+		documents = append(documents, document)
+		if i < len(lines) {
+			separator = lines[i].raw
+		}
+	}
+	return documents, nil
+}
+
+func isBlankYAML(lines []yamlLine) bool {
+	for _, l := range lines {
+		if !l.isBlank() {
+			return false
+		}
+	}
+	return true
+}
+
+func parseYAML(data []byte) (*yamlNode, error) {
+	lines, err := splitYAMLLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return parseYAMLLines(lines)
+}
+
+func parseYAMLLines(lines []yamlLine) (*yamlNode, error) {
+	p := &yamlParser{lines: lines}
+	l := p.peekContent()
+	if l == nil {
+		return &yamlNode{kind: yamlMapping, trailing: p.takeComments()}, nil
+	}
+	n, err := p.parseNode(l.indent)
+	if err != nil {
+		return nil, err
+	}
+	n.offset = l.indent
+	if l := p.peekContent(); l != nil {
+		return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.num)
+	}
+	n.trailing = p.takeComments()
+	return n, nil
+}
+
+func (p *yamlParser) peekContent() *yamlLine {
+	for i := p.pos; i < len(p.lines); i++ {
+		l := &p.lines[i]
+		if !l.isBlank() && !l.isComment() {
+			return l
+		}
+	}
+	return nil
+}
+
+// takeComments consumes the comment lines (and blank lines between them)
+// before the next content line.
+func (p *yamlParser) takeComments() []string {
+	var comments []string
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if !l.isBlank() && !l.isComment() {
+			break
+		}
+		if l.isComment() {
+			comments = append(comments, l.raw)
+		}
+		p.pos++
+	}
+	return comments
+}
+
+func isYAMLSequenceLine(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLMappingEntry splits "key: value" outside of quotes.
+func splitYAMLMappingEntry(text string) (string, string, bool) {
+	if text == "" || strings.ContainsAny(text[:1], "[{&*!|>%@`") || isYAMLSequenceLine(text) {
+		return "", "", false
+	}
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 {
+			return "", "", false
+		}
+		start = end + 1
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] != ':' {
+			continue
+		}
+		if i == len(text)-1 {
+			return text[:i], "", true
+		}
+		if text[i+1] == ' ' {
+			return text[:i], strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		if quote == '"' && text[i] == '\\' {
+			i++
+			continue
+		}
+		if text[i] == quote {
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func isYAMLBlockScalarHeader(text string) bool {
+	if text == "" || (text[0] != '|' && text[0] != '>') {
+		return false
+	}
+	header := strings.TrimSpace(strings.SplitN(text, " #", 2)[0])
+	return strings.Trim(header[1:], "+-0123456789") == ""
+}
+
+func (p *yamlParser) parseNode(indent int) (*yamlNode, error) {
+	l := p.peekContent()
+	if isYAMLSequenceLine(l.text) {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok := splitYAMLMappingEntry(l.text); ok {
+		return p.parseMapping(indent)
+	}
+	p.takeComments()
+	p.pos++
+	return p.parseScalar(indent-1, l.text)
+}
+
+func (p *yamlParser) parseMapping(indent int) (*yamlNode, error) {
+	n := &yamlNode{kind: yamlMapping}
+	for {
+		l := p.peekContent()
+		if l == nil || l.indent < indent || (l.indent == indent && isYAMLSequenceLine(l.text)) {
+			return n, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.num)
+		}
+		key, rest, ok := splitYAMLMappingEntry(l.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml: line %d: expected a mapping entry", l.num)
+		}
+		comments := p.takeComments()
+		p.pos++
+		value, err := p.parseValue(indent, rest, true)
+		if err != nil {
+			return nil, err
+		}
+		n.keys = append(n.keys, key)
+		n.values = append(n.values, value)
+		n.comments = append(n.comments, comments)
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) (*yamlNode, error) {
+	n := &yamlNode{kind: yamlSequence}
+	for {
+		l := p.peekContent()
+		if l == nil || l.indent < indent {
+			return n, nil
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.num)
+		}
+		if !isYAMLSequenceLine(l.text) {
+			return n, nil
+		}
+		comments := p.takeComments()
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		itemIndent := indent + len(l.text) - len(rest)
+		var item *yamlNode
+		var err error
+		_, _, isMapping := splitYAMLMappingEntry(rest)
+		if isMapping || isYAMLSequenceLine(rest) {
+			// Parse the rest of the line as if it started on its own line
+			// at the indentation of the item.
+			p.lines[p.pos] = yamlLine{indent: itemIndent, text: rest, raw: strings.Repeat(" ", itemIndent) + rest, num: l.num}
+			item, err = p.parseNode(itemIndent)
+			if item != nil {
+				item.offset = itemIndent - indent
+			}
+		} else {
+			p.pos++
+			item, err = p.parseValue(indent, rest, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		n.values = append(n.values, item)
+		n.comments = append(n.comments, comments)
+	}
+}
+
+// parseValue parses what follows "key:" or "-". A sequence may sit at the
+// same indentation as its mapping key.
+func (p *yamlParser) parseValue(indent int, rest string, allowSequence bool) (*yamlNode, error) {
+	if rest == "" || strings.HasPrefix(rest, "#") {
+		l := p.peekContent()
+		if l != nil && (l.indent > indent || (allowSequence && l.indent == indent && isYAMLSequenceLine(l.text))) {
+			// Parsing a sequence rewrites the line l points to.
+			childIndent := l.indent
+			child, err := p.parseNode(childIndent)
+			if err != nil {
+				return nil, err
+			}
+			child.offset = childIndent - indent
+			return child, nil
+		}
+		return &yamlNode{kind: yamlScalar, raw: rest}, nil
+	}
+	if isYAMLBlockScalarHeader(rest) {
+		return p.parseBlockScalar(indent, rest), nil
+	}
+	return p.parseScalar(indent, rest)
+}
+
+func (p *yamlParser) parseBlockScalar(indent int, header string) *yamlNode {
+	n := &yamlNode{kind: yamlScalar, raw: header, block: []string{}}
+	contentIndent := -1
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if !l.isBlank() && l.indent <= indent {
+			break
+		}
+		if !l.isBlank() && (contentIndent < 0 || l.indent < contentIndent) {
+			contentIndent = l.indent
+		}
+		n.block = append(n.block, l.raw)
+		p.pos++
+	}
+	for len(n.block) > 0 && strings.TrimSpace(n.block[len(n.block)-1]) == "" {
+		n.block = n.block[:len(n.block)-1]
+	}
+	for i, line := range n.block {
+		if len(line) >= contentIndent {
+			n.block[i] = line[contentIndent:]
+		} else {
+			n.block[i] = ""
+		}
+	}
+	return n
+}
+
+// parseScalar reads a plain or quoted scalar, including continuation lines
+// indented deeper than its key.
+func (p *yamlParser) parseScalar(indent int, rest string) (*yamlNode, error) {
+	parts := []string{rest}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.isBlank() || l.indent <= indent || l.isComment() {
+			break
+		}
+		parts = append(parts, l.text)
+		p.pos++
+	}
+	return &yamlNode{kind: yamlScalar, raw: strings.Join(parts, "\n")}, nil
+}
+
+func (n *yamlNode) get(key string) *yamlNode {
+	if n == nil || n.kind != yamlMapping {
+		return nil
+	}
+	for i, k := range n.keys {
+		if yamlUnquote(k) == key {
+			return n.values[i]
+		}
+	}
+	return nil
+}
+
+func (n *yamlNode) set(key string, value *yamlNode) {
+	for i, k := range n.keys {
+		if yamlUnquote(k) == key {
+			value.offset = n.values[i].offset
+			n.values[i] = value
+			return
+		}
+	}
+	n.keys = append(n.keys, key)
+	n.values = append(n.values, value)
+	n.comments = append(n.comments, nil)
+}
+
+func (n *yamlNode) delete(key string) bool {
+	for i, k := range n.keys {
+		if yamlUnquote(k) == key {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			n.values = append(n.values[:i], n.values[i+1:]...)
+			n.comments = append(n.comments[:i], n.comments[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func newYAMLScalar(value string) *yamlNode {
+	return &yamlNode{kind: yamlScalar, raw: yamlQuote(value)}
+}
+
+// value returns the scalar's string value with quotes and comments removed.
+func (n *yamlNode) value() string {
+	if n == nil || n.kind != yamlScalar {
+		return ""
+	}
+	if n.block != nil {
+		separator := "\n"
+		if n.raw[0] == '>' {
+			separator = " "
+		}
+		text := strings.Join(n.block, separator)
+		if !strings.Contains(n.raw, "-") {
+			text += "\n"
+		}
+		return text
+	}
+	raw := n.raw
+	if raw != "" && (raw[0] == '"' || raw[0] == '\'') {
+		if end := closingQuote(raw); end > 0 {
+			raw = raw[:end+1]
+		}
+		return yamlUnquote(strings.ReplaceAll(raw, "\n", " "))
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	if strings.HasPrefix(raw, "#") || raw == "~" || raw == "null" {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(raw, "\n", " "))
+}
+
+func (n *yamlNode) strings() []string {
+	if n == nil {
+		return nil
+	}
+	if n.kind == yamlScalar {
+		raw := strings.TrimSpace(n.raw)
+		if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+			values := []string{}
+			for _, item := range strings.Split(raw[1:len(raw)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					values = append(values, yamlUnquote(item))
+				}
+			}
+			return values
+		}
+		if v := n.value(); v != "" {
+			return []string{v}
+		}
+		return nil
+	}
+	values := make([]string, 0, len(n.values))
+	for _, item := range n.values {
+		values = append(values, item.value())
+	}
+	return values
+}
+
+func yamlUnquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+		return s[1 : len(s)-1]
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// yamlQuote quotes a string only when it would not round trip as a plain
+// scalar.
+func yamlQuote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	plain := !strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@` ") &&
+		!strings.ContainsAny(s, "\n\t") &&
+		!strings.Contains(s, ": ") &&
+		!strings.Contains(s, " #") &&
+		!strings.HasSuffix(s, ":") &&
+		!strings.HasSuffix(s, " ")
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		plain = false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		plain = false
+	}
+	if plain {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+func (n *yamlNode) childOffset(child *yamlNode) int {
+	if child.offset > 0 {
+		return child.offset
+	}
+	if n.kind == yamlMapping && child.kind == yamlSequence {
+		return 0
+	}
+	return 2
+}
+
+func writeYAMLScalar(b *strings.Builder, n *yamlNode, indent int) {
+	if n.block != nil {
+		b.WriteString(" " + n.raw + "\n")
+		for _, line := range n.block {
+			if line == "" {
+				b.WriteString("\n")
+				continue
+			}
+			b.WriteString(strings.Repeat(" ", indent+2) + line + "\n")
+		}
+		return
+	}
+	if n.raw != "" {
+		lines := strings.Split(n.raw, "\n")
+		b.WriteString(" " + lines[0])
+		for _, line := range lines[1:] {
+			b.WriteString("\n" + strings.Repeat(" ", indent+2) + line)
+		}
+	}
+	b.WriteString("\n")
+}
+
+func (n *yamlNode) write(b *strings.Builder, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch n.kind {
+	case yamlScalar:
+		if n.block != nil {
+			writeYAMLScalar(b, n, indent-2)
+			return
+		}
+		b.WriteString(pad + strings.ReplaceAll(n.raw, "\n", "\n"+pad) + "\n")
+	case yamlMapping:
+		for i, key := range n.keys {
+			for _, comment := range n.comments[i] {
+				b.WriteString(comment + "\n")
+			}
+			b.WriteString(pad + key + ":")
+			n.writeChild(b, n.values[i], indent)
+		}
+	case yamlSequence:
+		for i, item := range n.values {
+			for _, comment := range n.comments[i] {
+				b.WriteString(comment + "\n")
+			}
+			b.WriteString(pad + "-")
+			if item.kind == yamlScalar {
+				writeYAMLScalar(b, item, indent)
+				continue
+			}
+			if isEmptyYAMLCollection(item) {
+				b.WriteString(" " + emptyYAMLCollection(item) + "\n")
+				continue
+			}
+			offset := n.childOffset(item)
+			var nested strings.Builder
+			item.write(&nested, indent+offset)
+			b.WriteString(strings.Repeat(" ", offset-1) + strings.TrimLeft(nested.String(), " "))
+		}
+	}
+	for _, comment := range n.trailing {
+		b.WriteString(comment + "\n")
+	}
+}
+
+func (n *yamlNode) writeChild(b *strings.Builder, child *yamlNode, indent int) {
+	if child.kind == yamlScalar {
+		writeYAMLScalar(b, child, indent)
+		return
+	}
+	if isEmptyYAMLCollection(child) {
+		b.WriteString(" " + emptyYAMLCollection(child) + "\n")
+		return
+	}
+	b.WriteString("\n")
+	child.write(b, indent+n.childOffset(child))
+}
+
+func isEmptyYAMLCollection(n *yamlNode) bool {
+	return n.kind != yamlScalar && len(n.values) == 0
+}
+
+func emptyYAMLCollection(n *yamlNode) string {
+	if n.kind == yamlSequence {
+		return "[]"
+	}
+	return "{}"
+}
+
+func (n *yamlNode) String() string {
+	var b strings.Builder
+	if n.kind == yamlMapping && len(n.keys) == 0 {
+		for _, comment := range n.trailing {
+			b.WriteString(comment + "\n")
+		}
+		return b.String()
+	}
+	n.write(&b, n.offset)
+	return b.String()
+}
+
+func marshalYAMLDocuments(documents []*yamlNode) []byte {
+	var b strings.Builder
+	for i, document := range documents {
+		start := document.start
+		if start == "" && i > 0 {
+			start = "---"
+		}
+		if start != "" {
+			b.WriteString(start + "\n")
+		}
+		b.WriteString(document.String())
+	}
+	return []byte(b.String())
+}